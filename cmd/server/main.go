@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"errors"
+	"flag"
 	"log/slog"
 	"os"
 	"os/signal"
@@ -13,14 +14,29 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "validate" {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		status := validate(ctx, os.Args[2:], os.Stdout)
+		stop()
+		os.Exit(status)
+	}
+
+	flags := config.RegisterFlags(flag.CommandLine)
+	configFile := flag.String("config", "", "YAML config `file`; environment variables and flags override its values")
+	flag.Parse()
+
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
-	cfg, err := config.Load()
+	sources := config.Sources{File: *configFile, Flags: flags}
+	cfg, err := config.LoadFrom(sources)
 	if err != nil {
 		slog.Error("load config", "error", err)
 		os.Exit(1)
 	}
+	for _, problem := range cfg.InvalidSettings {
+		slog.Warn("setting ignored", "problem", problem)
+	}
 
 	srv, err := server.New(ctx, cfg)
 	if err != nil {
@@ -28,8 +44,34 @@ func main() {
 		os.Exit(1)
 	}
 
+	if *configFile != "" {
+		go rotateOnHangup(ctx, sources, srv)
+	}
+
 	if err := srv.ListenAndServe(ctx); err != nil && !errors.Is(err, context.Canceled) {
 		slog.Error("server exit", "error", err)
 		os.Exit(1)
 	}
 }
+
+// rotateOnHangup reads the configuration again on every SIGHUP and switches
+// the origins to the S3 keys in it, so that keys rotated in the config file
+// are picked up without a restart.
+func rotateOnHangup(ctx context.Context, sources config.Sources, srv *server.Server) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			cfg, err := config.LoadFrom(sources)
+			if err != nil {
+				slog.Error("reload config", "error", err)
+				continue
+			}
+			srv.RotateCredentials(ctx, cfg)
+		}
+	}
+}
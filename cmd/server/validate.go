@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/joeychilson/s3-proxy/internal/config"
+	"github.com/joeychilson/s3-proxy/internal/origin"
+)
+
+// validate loads the configuration as the server would, prints the settings
+// in effect with secrets redacted and, with -check-origin, checks that every
+// configured bucket is reachable. It returns the exit status: 1 when
+// anything is wrong, including settings that only warn at startup.
+func validate(ctx context.Context, args []string, out io.Writer) int {
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+	flags := config.RegisterFlags(fs)
+	configFile := fs.String("config", "", "YAML config `file`; environment variables and flags override its values")
+	checkOrigin := fs.Bool("check-origin", false, "send HeadBucket to every configured bucket")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	cfg, settings, err := config.Describe(config.Sources{File: *configFile, Flags: flags})
+	for _, s := range settings {
+		fmt.Fprintf(out, "%s=%s # %s\n", s.Name, s.Redacted(), s.Source)
+	}
+	if err != nil {
+		fmt.Fprintf(out, "error: %v\n", err)
+		return 1
+	}
+	status := 0
+	for _, problem := range cfg.InvalidSettings {
+		fmt.Fprintf(out, "error: %s\n", problem)
+		status = 1
+	}
+	if *checkOrigin {
+		for _, b := range originBuckets(cfg) {
+			client, err := origin.New(ctx, b.endpoint, b.region, b.accessKey, b.secretKey, b.name, cfg.RequestTimeout)
+			if err == nil {
+				err = client.HeadBucket(ctx)
+			}
+			if err != nil {
+				fmt.Fprintf(out, "error: bucket %s (%s): %v\n", b.name, b.setting, err)
+				status = 1
+				continue
+			}
+			fmt.Fprintf(out, "ok: bucket %s (%s)\n", b.name, b.setting)
+		}
+	}
+	return status
+}
+
+type originBucket struct {
+	setting                                string
+	endpoint, region, accessKey, secretKey string
+	name                                   string
+}
+
+// originBuckets lists the buckets the server would read or write, each once.
+func originBuckets(cfg *config.Config) []originBucket {
+	buckets := []originBucket{{"S3_BUCKET", cfg.Endpoint, cfg.Region, cfg.AccessKey, cfg.SecretKey, cfg.Bucket}}
+	for _, r := range cfg.BucketRoutes {
+		buckets = append(buckets, originBucket{"BUCKET_ROUTES", cfg.Endpoint, cfg.Region, cfg.AccessKey, cfg.SecretKey, r.Bucket})
+	}
+	for _, h := range cfg.HostBuckets {
+		buckets = append(buckets, originBucket{"HOST_BUCKETS", cfg.Endpoint, cfg.Region, cfg.AccessKey, cfg.SecretKey, h.Bucket})
+	}
+	if cfg.SecondaryBucket != "" {
+		buckets = append(buckets, originBucket{"S3_SECONDARY_BUCKET", cfg.SecondaryEndpoint, cfg.SecondaryRegion, cfg.SecondaryAccessKey, cfg.SecondarySecretKey, cfg.SecondaryBucket})
+	}
+	if cfg.ReadBucket != "" {
+		buckets = append(buckets, originBucket{"S3_READ_BUCKET", cfg.ReadEndpoint, cfg.ReadRegion, cfg.ReadAccessKey, cfg.ReadSecretKey, cfg.ReadBucket})
+	}
+	seen := make(map[originBucket]bool)
+	unique := buckets[:0]
+	for _, b := range buckets {
+		key := b
+		key.setting = ""
+		if !seen[key] {
+			seen[key] = true
+			unique = append(unique, b)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	env := func(t *testing.T, extra map[string]string) {
+		t.Setenv("AUTH_TOKEN", "token")
+		t.Setenv("S3_ENDPOINT", "https://example.com")
+		t.Setenv("S3_BUCKET", "bucket")
+		t.Setenv("S3_ACCESS_KEY", "AKIA")
+		t.Setenv("S3_SECRET_KEY", "hunter2")
+		for name, value := range extra {
+			t.Setenv(name, value)
+		}
+	}
+	tests := []struct {
+		name   string
+		env    map[string]string
+		args   []string
+		status int
+		want   []string
+	}{
+		{
+			name:   "valid",
+			args:   []string{"-cache-ttl", "10m"},
+			status: 0,
+			want:   []string{"S3_BUCKET=bucket # env", "S3_SECRET_KEY=REDACTED # env", "CACHE_TTL=10m # flag"},
+		},
+		{
+			name:   "unknown prefixed variable",
+			env:    map[string]string{"S3PROXY_CACHE_TLL": "5m"},
+			status: 1,
+			want:   []string{"S3_BUCKET=bucket # env", "error: unknown settings", "S3PROXY_CACHE_TLL is not a known setting (did you mean S3PROXY_CACHE_TTL?)"},
+		},
+		{
+			name:   "bad duration",
+			env:    map[string]string{"CACHE_TTL": "soon"},
+			status: 1,
+			want:   []string{"CACHE_TTL=soon # env", `error: invalid settings`, `CACHE_TTL="soon" is not a duration`},
+		},
+		{
+			name:   "bad duration without strict mode",
+			env:    map[string]string{"CACHE_TTL": "soon", "CONFIG_STRICT": "false"},
+			status: 1,
+			want:   []string{`error: CACHE_TTL="soon" is not a duration`},
+		},
+		{
+			name:   "bad flag",
+			args:   []string{"-no-such-flag"},
+			status: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env(t, tt.env)
+			var out bytes.Buffer
+			if status := validate(context.Background(), tt.args, &out); status != tt.status {
+				t.Fatalf("expected status %d, got %d:\n%s", tt.status, status, out.String())
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(out.String(), want) {
+					t.Errorf("expected %q in the report:\n%s", want, out.String())
+				}
+			}
+			if strings.Contains(out.String(), "hunter2") {
+				t.Errorf("expected the secret key to be redacted:\n%s", out.String())
+			}
+			if tt.status == 0 && strings.Contains(out.String(), "error:") {
+				t.Errorf("expected no errors for a valid configuration:\n%s", out.String())
+			}
+		})
+	}
+}
@@ -28,6 +28,12 @@ func (e *Entry) StaleButValid(now time.Time) bool {
 	return now.Before(e.StoredAt.Add(e.TTL + e.StaleTTL))
 }
 
+// StaleIfError reports whether the entry may still stand in for an origin
+// failure, up to window past its freshness lifetime.
+func (e *Entry) StaleIfError(now time.Time, window time.Duration) bool {
+	return window > 0 && now.Before(e.StoredAt.Add(e.TTL+window))
+}
+
 func (e *Entry) Age(now time.Time) int {
 	if now.Before(e.StoredAt) {
 		return 0
@@ -36,19 +42,41 @@ func (e *Entry) Age(now time.Time) int {
 }
 
 type Cache struct {
-	mu    sync.RWMutex
-	lru   *lru.Cache[string, *Entry]
-	ttl   time.Duration
-	stale time.Duration
-	cap   int
+	mu       sync.RWMutex
+	lru      *lru.Cache[string, *Entry]
+	ttl      time.Duration
+	stale    time.Duration
+	cap      int
+	maxBytes int64
+	bytes    int64
+	removing bool
+	onEvict  func(key string, size int64)
 }
 
-func New(capacity int, ttl, stale time.Duration) (*Cache, error) {
-	l, err := lru.New[string, *Entry](capacity)
+// New creates a cache holding at most capacity entries and, when maxBytes is
+// positive, at most maxBytes of entry bodies.
+func New(capacity int, maxBytes int64, ttl, stale time.Duration) (*Cache, error) {
+	c := &Cache{ttl: ttl, stale: stale, cap: capacity, maxBytes: maxBytes}
+	l, err := lru.NewWithEvict(capacity, func(key string, entry *Entry) {
+		c.bytes -= entry.Size
+		if !c.removing && c.onEvict != nil {
+			c.onEvict(key, entry.Size)
+		}
+	})
 	if err != nil {
 		return nil, err
 	}
-	return &Cache{lru: l, ttl: ttl, stale: stale, cap: capacity}, nil
+	c.lru = l
+	return c, nil
+}
+
+// OnEvict registers a callback for entries dropped to stay within the
+// capacity or byte limit. It is not called for deletions and runs with the
+// cache locked, so it must not call back into the cache.
+func (c *Cache) OnEvict(fn func(key string, size int64)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onEvict = fn
 }
 
 func (c *Cache) Get(key string) (*Entry, bool) {
@@ -70,17 +98,44 @@ func (c *Cache) Set(key string, entry *Entry) {
 	if entry.StaleTTL == 0 {
 		entry.StaleTTL = c.stale
 	}
+	if c.maxBytes > 0 && entry.Size > c.maxBytes {
+		c.remove(key)
+		return
+	}
+	if old, ok := c.lru.Peek(key); ok {
+		c.bytes -= old.Size
+	}
 	c.lru.Add(key, entry)
+	c.bytes += entry.Size
+	for c.maxBytes > 0 && c.bytes > c.maxBytes {
+		if _, _, ok := c.lru.RemoveOldest(); !ok {
+			break
+		}
+	}
 }
 
 func (c *Cache) Delete(key string) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	c.remove(key)
+}
+
+// remove deletes key without reporting it as an eviction. c.mu must be held.
+func (c *Cache) remove(key string) {
+	c.removing = true
 	c.lru.Remove(key)
+	c.removing = false
+}
+
+// Keys returns the keys currently cached, from least to most recently used.
+func (c *Cache) Keys() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.lru.Keys()
 }
 
-func (c *Cache) Stats() (size int, capacity int) {
+func (c *Cache) Stats() (size int, capacity int, bytes int64) {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
-	return c.lru.Len(), c.cap
+	return c.lru.Len(), c.cap, c.bytes
 }
@@ -7,7 +7,7 @@ import (
 )
 
 func TestCacheSetGet(t *testing.T) {
-	c, err := New(4, time.Second, time.Second)
+	c, err := New(4, 0, time.Second, time.Second)
 	if err != nil {
 		t.Fatalf("new cache: %v", err)
 	}
@@ -51,3 +51,57 @@ func TestFreshness(t *testing.T) {
 		t.Fatalf("entry should be expired")
 	}
 }
+
+func TestByteCapacity(t *testing.T) {
+	c, err := New(16, 10, time.Second, time.Second)
+	if err != nil {
+		t.Fatalf("new cache: %v", err)
+	}
+
+	var evicted []string
+	c.OnEvict(func(key string, _ int64) {
+		evicted = append(evicted, key)
+	})
+
+	c.Set("a", &Entry{Body: []byte("aaaa"), Size: 4})
+	c.Set("b", &Entry{Body: []byte("bbbb"), Size: 4})
+	if _, _, bytes := c.Stats(); bytes != 8 {
+		t.Fatalf("expected 8 bytes, got %d", bytes)
+	}
+
+	c.Set("c", &Entry{Body: []byte("cccc"), Size: 4})
+	if _, ok := c.Get("a"); ok {
+		t.Fatalf("expected oldest entry to be evicted")
+	}
+	if size, _, bytes := c.Stats(); size != 2 || bytes != 8 {
+		t.Fatalf("expected 2 entries and 8 bytes, got %d and %d", size, bytes)
+	}
+
+	c.Set("b", &Entry{Body: []byte("bb"), Size: 2})
+	c.Delete("c")
+	if _, _, bytes := c.Stats(); bytes != 2 {
+		t.Fatalf("expected 2 bytes after replace and delete, got %d", bytes)
+	}
+
+	c.Set("huge", &Entry{Body: make([]byte, 11), Size: 11})
+	if _, ok := c.Get("huge"); ok {
+		t.Fatalf("entries larger than the byte limit should not be stored")
+	}
+	if len(evicted) != 1 || evicted[0] != "a" {
+		t.Fatalf("expected only the capacity eviction to be reported, got %v", evicted)
+	}
+}
+
+func TestStaleIfError(t *testing.T) {
+	now := time.Now()
+	entry := &Entry{StoredAt: now.Add(-2 * time.Minute), TTL: time.Minute, StaleTTL: 30 * time.Second}
+	if entry.StaleButValid(now) {
+		t.Fatalf("entry should be past its stale window")
+	}
+	if !entry.StaleIfError(now, 5*time.Minute) {
+		t.Fatalf("entry should be usable within the error window")
+	}
+	if entry.StaleIfError(now, 30*time.Second) || entry.StaleIfError(now, 0) {
+		t.Fatalf("entry should not be usable outside the error window")
+	}
+}
@@ -0,0 +1,285 @@
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type RedisOptions struct {
+	Addr     string
+	Password string
+	DB       int
+	Prefix   string
+	PoolSize int
+	Timeout  time.Duration
+	TTL      time.Duration
+	StaleTTL time.Duration
+	// Retain keeps entries in Redis for this long past their freshness
+	// lifetime when it exceeds StaleTTL, e.g. to serve stale on origin errors.
+	Retain time.Duration
+}
+
+// Redis stores entries in a Redis server so that several proxy replicas
+// share one cache. Entries expire in Redis once they can no longer be served.
+type Redis struct {
+	opts  RedisOptions
+	pool  chan *redisConn
+	onErr func(error)
+}
+
+type redisConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	w    *bufio.Writer
+}
+
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+func NewRedis(opts RedisOptions) (*Redis, error) {
+	if opts.PoolSize <= 0 {
+		opts.PoolSize = 16
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = time.Second
+	}
+	r := &Redis{opts: opts, pool: make(chan *redisConn, opts.PoolSize)}
+	if _, err := r.do("PING"); err != nil {
+		return nil, fmt.Errorf("connect to redis at %s: %w", opts.Addr, err)
+	}
+	return r, nil
+}
+
+// OnError registers a callback for backend failures, which are otherwise
+// reported to callers as misses.
+func (r *Redis) OnError(fn func(error)) {
+	r.onErr = fn
+}
+
+func (r *Redis) Get(key string) (*Entry, bool) {
+	reply, err := r.do("GET", r.opts.Prefix+key)
+	if err != nil {
+		r.fail(err)
+		return nil, false
+	}
+	data, ok := reply.([]byte)
+	if !ok {
+		return nil, false
+	}
+	var entry Entry
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&entry); err != nil {
+		r.fail(fmt.Errorf("decode entry %q: %w", key, err))
+		return nil, false
+	}
+	return &entry, true
+}
+
+func (r *Redis) Set(key string, entry *Entry) {
+	if entry.TTL == 0 {
+		entry.TTL = r.opts.TTL
+	}
+	if entry.StaleTTL == 0 {
+		entry.StaleTTL = r.opts.StaleTTL
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(entry); err != nil {
+		r.fail(fmt.Errorf("encode entry %q: %w", key, err))
+		return
+	}
+	expiry := time.Until(entry.StoredAt.Add(entry.TTL + max(entry.StaleTTL, r.opts.Retain)))
+	if expiry < time.Millisecond {
+		r.Delete(key)
+		return
+	}
+	if _, err := r.do("SET", r.opts.Prefix+key, buf.String(), "PX", strconv.FormatInt(expiry.Milliseconds(), 10)); err != nil {
+		r.fail(err)
+	}
+}
+
+func (r *Redis) Delete(key string) {
+	if _, err := r.do("DEL", r.opts.Prefix+key); err != nil {
+		r.fail(err)
+	}
+}
+
+// Keys scans the keys stored under the configured prefix.
+func (r *Redis) Keys() []string {
+	var keys []string
+	match := globEscape(r.opts.Prefix) + "*"
+	cursor := "0"
+	for {
+		reply, err := r.do("SCAN", cursor, "MATCH", match, "COUNT", "1000")
+		if err != nil {
+			r.fail(err)
+			return keys
+		}
+		parts, ok := reply.([]any)
+		if !ok || len(parts) != 2 {
+			r.fail(errors.New("redis: unexpected SCAN reply"))
+			return keys
+		}
+		next, _ := parts[0].([]byte)
+		batch, _ := parts[1].([]any)
+		for _, k := range batch {
+			if b, ok := k.([]byte); ok {
+				keys = append(keys, strings.TrimPrefix(string(b), r.opts.Prefix))
+			}
+		}
+		cursor = string(next)
+		if cursor == "0" || cursor == "" {
+			return keys
+		}
+	}
+}
+
+// Stats reports the number of keys in the Redis database and its memory use.
+// Capacity is not bounded by the proxy and is reported as zero.
+func (r *Redis) Stats() (size int, capacity int, bytes int64) {
+	if reply, err := r.do("DBSIZE"); err == nil {
+		if n, ok := reply.(int64); ok {
+			size = int(n)
+		}
+	}
+	if reply, err := r.do("INFO", "memory"); err == nil {
+		if info, ok := reply.([]byte); ok {
+			for line := range strings.SplitSeq(string(info), "\r\n") {
+				if value, found := strings.CutPrefix(line, "used_memory:"); found {
+					bytes, _ = strconv.ParseInt(value, 10, 64)
+				}
+			}
+		}
+	}
+	return size, 0, bytes
+}
+
+func (r *Redis) fail(err error) {
+	if r.onErr != nil {
+		r.onErr(err)
+	}
+}
+
+func (r *Redis) do(args ...string) (any, error) {
+	c, err := r.conn()
+	if err != nil {
+		return nil, err
+	}
+	c.conn.SetDeadline(time.Now().Add(r.opts.Timeout))
+	reply, err := c.roundTrip(args)
+	// Only an error reply read whole leaves the connection in step; after
+	// any other failure part of the reply may still be on the wire.
+	var replyErr redisError
+	if err != nil && !errors.As(err, &replyErr) {
+		c.conn.Close()
+		return nil, err
+	}
+	select {
+	case r.pool <- c:
+	default:
+		c.conn.Close()
+	}
+	return reply, err
+}
+
+func (r *Redis) conn() (*redisConn, error) {
+	select {
+	case c := <-r.pool:
+		return c, nil
+	default:
+	}
+	conn, err := net.DialTimeout("tcp", r.opts.Addr, r.opts.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	c := &redisConn{conn: conn, r: bufio.NewReader(conn), w: bufio.NewWriter(conn)}
+	conn.SetDeadline(time.Now().Add(r.opts.Timeout))
+	if r.opts.Password != "" {
+		if _, err := c.roundTrip([]string{"AUTH", r.opts.Password}); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if r.opts.DB != 0 {
+		if _, err := c.roundTrip([]string{"SELECT", strconv.Itoa(r.opts.DB)}); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (c *redisConn) roundTrip(args []string) (any, error) {
+	fmt.Fprintf(c.w, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(c.w, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if err := c.w.Flush(); err != nil {
+		return nil, err
+	}
+	return readReply(c.r)
+}
+
+func readReply(r *bufio.Reader) (any, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]any, n)
+		for i := range items {
+			if items[i], err = readReply(r); err != nil {
+				// The rest of the array is left unread, so even an error
+				// reply in it must not pass for a whole one.
+				return nil, fmt.Errorf("redis: array element %d: %v", i, err)
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
+
+func globEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(`*?[]\`, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,124 @@
+package cache
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis serves the handful of commands the Redis store uses.
+func fakeRedis(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	var mu sync.Mutex
+	data := make(map[string]string)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					req, err := readReply(r)
+					if err != nil {
+						return
+					}
+					parts := req.([]any)
+					args := make([]string, len(parts))
+					for i, p := range parts {
+						args[i] = string(p.([]byte))
+					}
+					mu.Lock()
+					switch strings.ToUpper(args[0]) {
+					case "PING":
+						fmt.Fprint(conn, "+PONG\r\n")
+					case "SET":
+						data[args[1]] = args[2]
+						fmt.Fprint(conn, "+OK\r\n")
+					case "GET":
+						if v, ok := data[args[1]]; ok {
+							fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(v), v)
+						} else {
+							fmt.Fprint(conn, "$-1\r\n")
+						}
+					case "DEL":
+						delete(data, args[1])
+						fmt.Fprint(conn, ":1\r\n")
+					case "SCAN":
+						fmt.Fprintf(conn, "*2\r\n$1\r\n0\r\n*%d\r\n", len(data))
+						for k := range data {
+							fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(k), k)
+						}
+					case "DBSIZE":
+						fmt.Fprintf(conn, ":%d\r\n", len(data))
+					case "EXEC":
+						fmt.Fprint(conn, "*2\r\n-ERR oops\r\n:1\r\n")
+					default:
+						fmt.Fprint(conn, "-ERR unknown command\r\n")
+					}
+					mu.Unlock()
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestRedisStore(t *testing.T) {
+	store, err := NewRedis(RedisOptions{Addr: fakeRedis(t), Prefix: "test:", TTL: time.Minute})
+	if err != nil {
+		t.Fatalf("new redis: %v", err)
+	}
+
+	store.Set("a/b.txt", &Entry{Body: []byte("hello\r\nworld"), Status: 200, StoredAt: time.Now(), ETag: `"abc"`, Size: 12})
+	entry, ok := store.Get("a/b.txt")
+	if !ok {
+		t.Fatalf("expected entry to be stored")
+	}
+	if string(entry.Body) != "hello\r\nworld" || entry.ETag != `"abc"` || entry.TTL != time.Minute {
+		t.Fatalf("unexpected entry: %+v", entry)
+	}
+	if keys := store.Keys(); len(keys) != 1 || keys[0] != "a/b.txt" {
+		t.Fatalf("unexpected keys: %v", keys)
+	}
+	if size, _, _ := store.Stats(); size != 1 {
+		t.Fatalf("expected 1 key, got %d", size)
+	}
+
+	store.Delete("a/b.txt")
+	if _, ok := store.Get("a/b.txt"); ok {
+		t.Fatalf("expected entry to be deleted")
+	}
+}
+
+func TestRedisBrokenReply(t *testing.T) {
+	store, err := NewRedis(RedisOptions{Addr: fakeRedis(t), PoolSize: 1})
+	if err != nil {
+		t.Fatalf("new redis: %v", err)
+	}
+	if _, err := store.do("EXEC"); err == nil {
+		t.Fatalf("expected an error for an array holding an error reply")
+	}
+	// The connection holding the rest of that reply is not reused.
+	if reply, err := store.do("PING"); err != nil || reply != "PONG" {
+		t.Fatalf("expected PONG on a fresh connection, got %v %v", reply, err)
+	}
+	if _, err := store.do("NOPE"); err == nil {
+		t.Fatalf("expected an error reply")
+	}
+	if reply, err := store.do("PING"); err != nil || reply != "PONG" {
+		t.Fatalf("expected PONG after a whole error reply, got %v %v", reply, err)
+	}
+}
@@ -0,0 +1,16 @@
+package cache
+
+// Store is implemented by the cache backends. Backend failures are treated
+// as misses so that a cache outage degrades to proxying from the origin.
+type Store interface {
+	Get(key string) (*Entry, bool)
+	Set(key string, entry *Entry)
+	Delete(key string)
+	Keys() []string
+	Stats() (size int, capacity int, bytes int64)
+}
+
+var (
+	_ Store = (*Cache)(nil)
+	_ Store = (*Redis)(nil)
+)
@@ -0,0 +1,105 @@
+package cdn
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/config"
+)
+
+const cloudFrontAPI = "https://cloudfront.amazonaws.com"
+
+// CloudFront invalidates paths in an Amazon CloudFront distribution. The
+// API is global, signed for us-east-1 with credentials from the AWS default
+// chain.
+type CloudFront struct {
+	api            string
+	distributionID string
+	creds          aws.CredentialsProvider
+	signer         *v4.Signer
+	timeout        time.Duration
+	client         *http.Client
+}
+
+func NewCloudFront(ctx context.Context, distributionID string, timeout time.Duration) (*CloudFront, error) {
+	awsConfig, err := config.LoadDefaultConfig(ctx, config.WithRegion("us-east-1"))
+	if err != nil {
+		return nil, err
+	}
+	return &CloudFront{
+		api:            cloudFrontAPI,
+		distributionID: distributionID,
+		creds:          awsConfig.Credentials,
+		signer:         v4.NewSigner(),
+		timeout:        timeout,
+		client:         &http.Client{},
+	}, nil
+}
+
+type invalidationBatch struct {
+	XMLName         xml.Name `xml:"http://cloudfront.amazonaws.com/doc/2020-05-31/ InvalidationBatch"`
+	Quantity        int      `xml:"Paths>Quantity"`
+	Paths           []string `xml:"Paths>Items>Path"`
+	CallerReference string
+}
+
+// Invalidate creates an invalidation for paths, which start with "/"; a
+// trailing "*" invalidates every path that starts with the rest. It returns
+// once CloudFront has accepted the invalidation, not once it has completed.
+func (c *CloudFront) Invalidate(ctx context.Context, paths []string) error {
+	if len(paths) == 0 {
+		return nil
+	}
+	body, err := xml.Marshal(invalidationBatch{
+		Quantity:        len(paths),
+		Paths:           paths,
+		CallerReference: "s3-proxy-" + strconv.FormatInt(time.Now().UnixNano(), 10),
+	})
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	u := c.api + "/2020-05-31/distribution/" + url.PathEscape(c.distributionID) + "/invalidation"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/xml")
+	creds, err := c.creds.Retrieve(ctx)
+	if err != nil {
+		return fmt.Errorf("invalidate cloudfront: %w", err)
+	}
+	hash := sha256.Sum256(body)
+	if err := c.signer.SignHTTP(ctx, creds, req, hex.EncodeToString(hash[:]), "cloudfront", "us-east-1", time.Now()); err != nil {
+		return fmt.Errorf("invalidate cloudfront: %w", err)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("invalidate cloudfront: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		var apiErr struct {
+			Code    string `xml:"Error>Code"`
+			Message string `xml:"Error>Message"`
+		}
+		xml.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&apiErr)
+		if apiErr.Code != "" {
+			return fmt.Errorf("invalidate cloudfront: unexpected status %s: %s: %s", resp.Status, apiErr.Code, apiErr.Message)
+		}
+		return fmt.Errorf("invalidate cloudfront: unexpected status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package cdn
+
+import (
+	"context"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+)
+
+func TestCloudFrontInvalidate(t *testing.T) {
+	var batch invalidationBatch
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/2020-05-31/distribution/E123/invalidation" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if !strings.Contains(r.Header.Get("Authorization"), "/us-east-1/cloudfront/aws4_request") {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`<ErrorResponse><Error><Code>AccessDenied</Code><Message>Not signed.</Message></Error></ErrorResponse>`))
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		xml.Unmarshal(body, &batch)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+
+	c := &CloudFront{
+		api:            srv.URL,
+		distributionID: "E123",
+		creds:          credentials.NewStaticCredentialsProvider("key", "secret", ""),
+		signer:         v4.NewSigner(),
+		timeout:        time.Second,
+		client:         &http.Client{},
+	}
+	if err := c.Invalidate(context.Background(), []string{"/a.jpg", "/images/*"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if batch.Quantity != 2 || !slices.Equal(batch.Paths, []string{"/a.jpg", "/images/*"}) || batch.CallerReference == "" {
+		t.Fatalf("unexpected invalidation batch %+v", batch)
+	}
+
+	c.distributionID = "E999"
+	if err := c.Invalidate(context.Background(), []string{"/a.jpg"}); err == nil {
+		t.Fatal("expected an error for an unknown distribution")
+	}
+}
@@ -0,0 +1,78 @@
+package cdn
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const fastlyAPI = "https://api.fastly.com"
+
+// fastlyBatch is the most surrogate keys Fastly purges in one request.
+const fastlyBatch = 256
+
+// Fastly purges a Fastly service by surrogate key through the Fastly API.
+type Fastly struct {
+	api       string
+	serviceID string
+	token     string
+	timeout   time.Duration
+	client    *http.Client
+}
+
+func NewFastly(serviceID, token string, timeout time.Duration) *Fastly {
+	return &Fastly{api: fastlyAPI, serviceID: serviceID, token: token, timeout: timeout, client: &http.Client{}}
+}
+
+// PurgeKeys purges every object tagged with one of keys.
+func (f *Fastly) PurgeKeys(ctx context.Context, keys []string) error {
+	for len(keys) > 0 {
+		batch := keys[:min(len(keys), fastlyBatch)]
+		keys = keys[len(batch):]
+		if err := f.post(ctx, "/purge", strings.Join(batch, " ")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PurgeAll purges everything the service has cached.
+func (f *Fastly) PurgeAll(ctx context.Context) error {
+	return f.post(ctx, "/purge_all", "")
+}
+
+func (f *Fastly) post(ctx context.Context, path, keys string) error {
+	ctx, cancel := context.WithTimeout(ctx, f.timeout)
+	defer cancel()
+	u := f.api + "/service/" + url.PathEscape(f.serviceID) + path
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Fastly-Key", f.token)
+	req.Header.Set("Accept", "application/json")
+	if keys != "" {
+		req.Header.Set("Surrogate-Key", keys)
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("purge fastly: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Msg string `json:"msg"`
+		}
+		json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&apiErr)
+		if apiErr.Msg != "" {
+			return fmt.Errorf("purge fastly: unexpected status %s: %s", resp.Status, apiErr.Msg)
+		}
+		return fmt.Errorf("purge fastly: unexpected status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package cdn
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFastlyPurge(t *testing.T) {
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Fastly-Key") != "token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"msg": "Provided credentials are missing or invalid"}`))
+			return
+		}
+		requests = append(requests, r.URL.Path+" "+r.Header.Get("Surrogate-Key"))
+		w.Write([]byte(`{"status": "ok"}`))
+	}))
+	defer srv.Close()
+
+	f := NewFastly("svc", "token", time.Second)
+	f.api = srv.URL
+	keys := make([]string, fastlyBatch+1)
+	for i := range keys {
+		keys[i] = "k"
+	}
+	if err := f.PurgeKeys(context.Background(), keys); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(requests) != 2 || requests[1] != "/service/svc/purge k" {
+		t.Fatalf("expected the keys to be purged in batches, got %q", requests)
+	}
+
+	requests = nil
+	if err := f.PurgeAll(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(requests) != 1 || requests[0] != "/service/svc/purge_all " {
+		t.Fatalf("expected a purge of everything, got %q", requests)
+	}
+
+	f = NewFastly("svc", "wrong", time.Second)
+	f.api = srv.URL
+	if err := f.PurgeAll(context.Background()); err == nil || !strings.Contains(err.Error(), "missing or invalid") {
+		t.Fatalf("expected the API error, got %v", err)
+	}
+}
@@ -0,0 +1,68 @@
+package cdn
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const spacesAPI = "https://api.digitalocean.com"
+
+// Spaces purges files from the cache of a DigitalOcean Spaces CDN endpoint
+// through the DigitalOcean API.
+type Spaces struct {
+	api        string
+	endpointID string
+	token      string
+	timeout    time.Duration
+	client     *http.Client
+}
+
+func NewSpaces(endpointID, token string, timeout time.Duration) *Spaces {
+	return &Spaces{api: spacesAPI, endpointID: endpointID, token: token, timeout: timeout, client: &http.Client{}}
+}
+
+// Purge removes files from the CDN cache. Files are paths relative to the
+// endpoint's origin; a trailing "*" purges every path that starts with the
+// rest, and "*" alone purges everything.
+func (s *Spaces) Purge(ctx context.Context, files []string) error {
+	if len(files) == 0 {
+		return nil
+	}
+	body, err := json.Marshal(struct {
+		Files []string `json:"files"`
+	}{files})
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	u := s.api + "/v2/cdn/endpoints/" + url.PathEscape(s.endpointID) + "/cache"
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+s.token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("purge cdn: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Message string `json:"message"`
+		}
+		json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&apiErr)
+		if apiErr.Message != "" {
+			return fmt.Errorf("purge cdn: unexpected status %s: %s", resp.Status, apiErr.Message)
+		}
+		return fmt.Errorf("purge cdn: unexpected status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package cdn
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSpacesPurge(t *testing.T) {
+	var files []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete || r.URL.Path != "/v2/cdn/endpoints/ep-1/cache" || r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"id": "unauthorized", "message": "Unable to authenticate you."}`))
+			return
+		}
+		var body struct {
+			Files []string `json:"files"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		files = body.Files
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	s := NewSpaces("ep-1", "token", time.Second)
+	s.api = srv.URL
+	if err := s.Purge(context.Background(), []string{"a.jpg", "images/*"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !slices.Equal(files, []string{"a.jpg", "images/*"}) {
+		t.Fatalf("expected the files to be sent, got %q", files)
+	}
+
+	files = nil
+	if err := s.Purge(context.Background(), nil); err != nil || files != nil {
+		t.Fatalf("expected no request without files, got %v %q", err, files)
+	}
+
+	s = NewSpaces("ep-1", "wrong", time.Second)
+	s.api = srv.URL
+	if err := s.Purge(context.Background(), []string{"a.jpg"}); err == nil || !strings.Contains(err.Error(), "Unable to authenticate you.") {
+		t.Fatalf("expected the API error, got %v", err)
+	}
+}
@@ -0,0 +1,60 @@
+package cdn
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// SignatureHeader carries the hex HMAC-SHA256 of a webhook's body under its
+// secret, as "sha256=<hex>".
+const SignatureHeader = "X-S3-Proxy-Signature"
+
+// Webhook posts purges as JSON to a URL, for caches without a purge API of
+// their own here.
+type Webhook struct {
+	url     string
+	secret  string
+	timeout time.Duration
+	client  *http.Client
+}
+
+func NewWebhook(url, secret string, timeout time.Duration) *Webhook {
+	return &Webhook{url: url, secret: secret, timeout: timeout, client: &http.Client{}}
+}
+
+// Send posts v as JSON, signed when the webhook has a secret. Any 2xx
+// status is success.
+func (h *Webhook) Send(ctx context.Context, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if h.secret != "" {
+		mac := hmac.New(sha256.New, []byte(h.secret))
+		mac.Write(body)
+		req.Header.Set(SignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("purge webhook: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("purge webhook: unexpected status %s", resp.Status)
+	}
+	return nil
+}
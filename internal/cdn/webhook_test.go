@@ -0,0 +1,49 @@
+package cdn
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWebhookSend(t *testing.T) {
+	var body []byte
+	var signature string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		body, _ = io.ReadAll(r.Body)
+		signature = r.Header.Get(SignatureHeader)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	h := NewWebhook(srv.URL+"/purge", "secret", time.Second)
+	if err := h.Send(context.Background(), map[string][]string{"keys": {"a.jpg"}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(body) != `{"keys":["a.jpg"]}` {
+		t.Fatalf("unexpected body %s", body)
+	}
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write(body)
+	if signature != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+		t.Fatalf("unexpected signature %q", signature)
+	}
+
+	signature = ""
+	if err := NewWebhook(srv.URL+"/purge", "", time.Second).Send(context.Background(), nil); err != nil || signature != "" {
+		t.Fatalf("expected an unsigned delivery, got %v %q", err, signature)
+	}
+	if err := NewWebhook(srv.URL+"/fail", "", time.Second).Send(context.Background(), nil); err == nil {
+		t.Fatal("expected an error for a failed delivery")
+	}
+}
@@ -0,0 +1,82 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Access modes name what a request must present to read a key.
+const (
+	AccessPublic = "public"
+	AccessToken  = "token"
+	AccessSigned = "signed-url"
+	AccessJWT    = "jwt"
+	AccessDenied = "denied"
+)
+
+// AccessPolicy maps key prefixes to access modes. The longest matching prefix
+// applies, and Default covers keys under none of them.
+type AccessPolicy struct {
+	Default  string            `json:"default"`
+	Prefixes map[string]string `json:"prefixes"`
+}
+
+func loadAccessPolicy(path string) (AccessPolicy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return AccessPolicy{}, fmt.Errorf("read ACCESS_POLICY_FILE: %w", err)
+	}
+	var policy AccessPolicy
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return AccessPolicy{}, fmt.Errorf("parse ACCESS_POLICY_FILE: %w", err)
+	}
+	if policy.Default == "" {
+		policy.Default = AccessPublic
+	}
+	prefixes := make(map[string]string, len(policy.Prefixes))
+	for prefix, mode := range policy.Prefixes {
+		prefixes[strings.TrimLeft(prefix, "/")] = mode
+	}
+	policy.Prefixes = prefixes
+	return policy, nil
+}
+
+// envAccessPolicy builds the policy described by SIGNED_PREFIXES and
+// JWT_PREFIXES. An empty list puts every key behind its mode, with JWTs
+// taking precedence when both lists are empty.
+func (c *Config) envAccessPolicy() AccessPolicy {
+	policy := AccessPolicy{Default: AccessPublic, Prefixes: map[string]string{}}
+	if c.SigningSecret != "" && len(c.SignedPrefixes) == 0 {
+		policy.Default = AccessSigned
+	}
+	if c.JWTEnabled() && len(c.JWTPrefixes) == 0 {
+		policy.Default = AccessJWT
+	}
+	for _, prefix := range c.SignedPrefixes {
+		policy.Prefixes[prefix] = AccessSigned
+	}
+	for _, prefix := range c.JWTPrefixes {
+		policy.Prefixes[prefix] = AccessJWT
+	}
+	return policy
+}
+
+func (c *Config) checkAccessMode(mode string) error {
+	switch mode {
+	case AccessPublic, AccessToken, AccessDenied:
+		return nil
+	case AccessSigned:
+		if c.SigningSecret == "" {
+			return fmt.Errorf("access mode %q requires SIGNING_SECRET", mode)
+		}
+		return nil
+	case AccessJWT:
+		if !c.JWTEnabled() {
+			return fmt.Errorf("access mode %q requires JWT_SECRET or JWT_JWKS_URL", mode)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown access mode %q", mode)
+}
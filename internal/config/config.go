@@ -1,136 +1,1412 @@
 package config
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"net/netip"
 	"os"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
+
+	"github.com/joeychilson/s3-proxy/internal/cron"
 )
 
 type Config struct {
-	Addr           string
-	Bucket         string
-	Region         string
-	Endpoint       string
-	AccessKey      string
-	SecretKey      string
-	CacheCapacity  int
-	CacheTTL       time.Duration
-	CacheStaleTTL  time.Duration
-	MaxObjectSize  int64
-	AuthToken      string
-	RequestTimeout time.Duration
-	ReadTimeout    time.Duration
-	WriteTimeout   time.Duration
-	IdleTimeout    time.Duration
-	RateLimitRPS   float64
+	Addr          string
+	PathPrefix    string
+	Bucket        string
+	BucketRoutes  []BucketRoute
+	HostBuckets   []HostBucket
+	NetworkGroups []NetworkGroup
+	Region        string
+	Endpoint      string
+	AccessKey     string
+	SecretKey     string
+	CacheBackend  string
+	CacheCapacity int
+	CacheMaxBytes int64
+	CacheTTL      time.Duration
+	CacheStaleTTL time.Duration
+	ErrorCacheTTL time.Duration
+
+	AllowCIDRs     []netip.Prefix
+	DenyCIDRs      []netip.Prefix
+	TrustedProxies []netip.Prefix
+
+	TLSCertFile string
+	TLSKeyFile  string
+	// TLSClientCA is a PEM file of CAs whose client certificates
+	// authenticate admin requests in place of AUTH_TOKEN.
+	TLSClientCA string
+	H2C         bool
+
+	SecondaryEndpoint  string
+	SecondaryRegion    string
+	SecondaryAccessKey string
+	SecondarySecretKey string
+	SecondaryBucket    string
+	FailoverThreshold  int
+	FailoverCooldown   time.Duration
+
+	CacheStaleIfError  time.Duration
+	HeuristicFreshness float64
+	HeuristicMaxTTL    time.Duration
+	CacheFillMode      string
+	FillQueueSize      int
+	FillWorkers        int
+	FillWaitTimeout    time.Duration
+	// FanoutBufferSize is how far, in bytes, concurrent clients of an
+	// object too large to cache may drift apart while sharing one origin
+	// stream; 0 disables sharing.
+	FanoutBufferSize   int64
+	VaryHeaders        []string
+	CacheKeyStrict     bool
+	CacheKeyHeaders    []string
+	Compression        bool
+	CompressionMinSize int64
+	ChecksumHeaders    bool
+	ContentDigest      bool
+	ArtifactMode       bool
+	ArtifactPrefixes   []string
+	MicroCachePrefixes []string
+	MicroCacheTTL      time.Duration
+	MaxObjectSize      int64
+	MaxRequestBody     int64
+	AuthToken          string
+	RequestTimeout     time.Duration
+	ReadTimeout        time.Duration
+	WriteTimeout       time.Duration
+	IdleTimeout        time.Duration
+	// Listeners is how many sockets accept connections on Addr, sharing it
+	// through SO_REUSEPORT when more than one.
+	Listeners          int
+	TCPNoDelay         bool
+	TCPWriteBuffer     int
+	ResponseBufferSize int
+	RateLimitRPS       float64
+	Tracing            bool
+
+	AuthLockoutThreshold int
+	AuthLockoutWindow    time.Duration
+	AuthLockoutDuration  time.Duration
+	AuthLockoutMax       time.Duration
+
+	// AdminDryRun makes mutating admin endpoints report what they would do
+	// instead of doing it.
+	AdminDryRun bool
+
+	IndexDocument      string
+	SPAFallbackKey     string
+	SPAAssetExtensions []string
+
+	// ErrorPages maps a status ("404") or status class ("5xx") to the key
+	// of the page served as the body of such responses.
+	ErrorPages map[string]string
+	// ErrorTemplate is an html/template file, or "s3:" and a key, rendered
+	// for error responses that have no page of their own.
+	ErrorTemplate string
+
+	RobotsTxt    string
+	RobotsTxtKey string
+	FaviconFile  string
+	FaviconKey   string
+
+	ProtocolShims []ProtocolShim
+
+	UserAgentDeny  []string
+	UserAgentAllow []UserAgentRule
+
+	// CacheControlRules replace the origin's Cache-Control on objects under
+	// their prefix, for responses and cache freshness alike.
+	CacheControlRules []CacheControlRule
+
+	SigningSecret  string
+	SignedPrefixes []string
+
+	JWTSecret      string
+	JWTJWKSURL     string
+	JWTJWKSRefresh time.Duration
+	JWTCookie      string
+	JWTPathClaim   string
+	JWTPrefixes    []string
+
+	AccessPolicyFile string
+	AccessPolicy     AccessPolicy
+
+	CORSAllowedOrigins   []string
+	CORSAllowedMethods   []string
+	CORSAllowedHeaders   []string
+	CORSExposedHeaders   []string
+	CORSMaxAge           time.Duration
+	CORSAllowCredentials bool
+
+	CrawlerUserAgents   []string
+	CrawlerRateLimitRPS float64
+	CrawlerCacheOnly    bool
+
+	MaxConcurrentPerIP    int
+	MaxConcurrentPerToken int
+
+	MinClientThroughput int64
+	MinThroughputGrace  time.Duration
+	MaxConnLifetime     time.Duration
+
+	MetricsPushURL      string
+	MetricsPushInterval time.Duration
+	MetricsPushJob      string
+	MetricsPushInstance string
+
+	EventLog    string
+	SecurityLog string
+
+	// ConfigStrict refuses to start with settings that do not parse or
+	// prefixed variables that name no setting. Without it the former fall
+	// back to their defaults, the latter are ignored, and both are listed
+	// in InvalidSettings.
+	ConfigStrict    bool
+	InvalidSettings []string
+	// Settings are every setting Load read, with where its value came
+	// from, for the schema.
+	Settings []Setting
+
+	OriginUserAgent    string
+	OriginTags         []OriginTag
+	OriginRequestPayer bool
+	OriginCompat       string
+
+	// OriginMetaHeaders and OriginMetaBytes cap the x-amz-meta-* headers
+	// taken from an object.
+	OriginMetaHeaders int
+	OriginMetaBytes   int
+
+	// OpaqueErrors hides why the origin failed: access denied is answered
+	// like a missing key, and every other failure with 503.
+	OpaqueErrors bool
+
+	RulesURL      string
+	RulesInterval time.Duration
+
+	RewriteRulesFile string
+	Rewrites         []RewriteRule
+
+	WriteThrough   bool
+	MaxUploadSize  int64
+	UploadTimeout  time.Duration
+	UploadPartSize int64
+
+	// ReadBucket is a replica of Bucket that reads go to while writes go to
+	// Bucket, with ReadReplicaLag for replication to catch up after one.
+	ReadEndpoint   string
+	ReadRegion     string
+	ReadAccessKey  string
+	ReadSecretKey  string
+	ReadBucket     string
+	ReadReplicaLag time.Duration
+
+	// OriginTLS, SecondaryTLS and ReadTLS are for origins that require
+	// mutual TLS or use a private CA.
+	OriginTLS    OriginTLS
+	SecondaryTLS OriginTLS
+	ReadTLS      OriginTLS
+
+	RevalidateBackoffBase time.Duration
+	RevalidateBackoffMax  time.Duration
+
+	VideoProbeBytes int64
+	VideoProbeTTL   time.Duration
+	VideoExtensions []string
+
+	TarConcurrency int
+
+	ChunkSize int64
+	ChunkMode string
+
+	PrefetchMaxBytes    int64
+	PrefetchConcurrency int
+
+	WarmJobs            []WarmJob
+	CacheWarmupManifest string
+
+	Peers              []string
+	PeerSelfURL        string
+	PeerGossipInterval time.Duration
+
+	PeerDiscoveryDNS      string
+	PeerDiscoveryInterval time.Duration
+
+	HandoffEntries int
+	HandoffPeer    string
+	HandoffTimeout time.Duration
+
+	RedisAddr     string
+	RedisPassword string
+	RedisDB       int
+	RedisPrefix   string
+	RedisPoolSize int
+
+	// SpacesCDNEndpointID names a DigitalOcean Spaces CDN endpoint whose
+	// cache is purged along with the proxy's, using SpacesCDNToken.
+	SpacesCDNEndpointID string
+	SpacesCDNToken      string
+
+	// CloudFrontDistributionID, FastlyServiceID and PurgeWebhookURL name
+	// further caches in front of the proxy that purges are passed on to.
+	CloudFrontDistributionID string
+	FastlyServiceID          string
+	FastlyAPIToken           string
+	PurgeWebhookURL          string
+	PurgeWebhookSecret       string
+
+	// SurrogateControl and SurrogateKeyHeader drive a CDN in front of the
+	// proxy: a Surrogate-Control value, and the header carrying each
+	// object's purge tags.
+	SurrogateControl   string
+	SurrogateKeyHeader string
+}
+
+// BucketRoute serves keys under Prefix from Bucket instead of S3_BUCKET.
+type BucketRoute struct {
+	Prefix string
+	Bucket string
+}
+
+// HostBucket serves requests for Host from Bucket, in a cache namespace of
+// their own.
+type HostBucket struct {
+	Host   string
+	Bucket string
+}
+
+// UserAgentRule admits only user agents matching the glob Pattern to keys
+// under Prefix.
+type UserAgentRule struct {
+	Prefix  string
+	Pattern string
+}
+
+// CacheControlRule sets the Cache-Control of objects under Prefix to Value.
+type CacheControlRule struct {
+	Prefix string
+	Value  string
+}
+
+// NetworkGroup labels clients whose address falls within Prefix.
+type NetworkGroup struct {
+	Prefix netip.Prefix
+	Label  string
 }
 
+// OriginTag is a key/value pair added to the User-Agent of origin requests.
+type OriginTag struct {
+	Key   string
+	Value string
+}
+
+// OriginTLS is the client certificate presented to an origin and the CAs
+// its certificate is verified against. Empty fields are not used.
+type OriginTLS struct {
+	CertFile string
+	KeyFile  string
+	CAFile   string
+}
+
+type WarmJob struct {
+	Name     string   `json:"name"`
+	Schedule string   `json:"schedule"`
+	Prefix   string   `json:"prefix"`
+	Keys     []string `json:"keys"`
+}
+
+// Origin servers for ORIGIN_COMPAT.
+const (
+	OriginAWS   = "aws"
+	OriginMinIO = "minio"
+	OriginCeph  = "ceph"
+	OriginR2    = "r2"
+	OriginB2    = "b2"
+)
+
+// Cache backends.
+const (
+	BackendMemory = "memory"
+	BackendRedis  = "redis"
+)
+
+// Cache fill modes. Inline fills store the object while streaming it to the
+// client that missed; background fills serve the miss straight from the
+// origin and cache the object from a queue afterwards.
+const (
+	FillModeInline     = "inline"
+	FillModeBackground = "background"
+)
+
+// Range caching modes. Aligned mode caches fixed CHUNK_SIZE chunks; sparse
+// mode caches the ranges clients actually asked for and fetches only the
+// gaps between them.
+const (
+	ChunkModeAligned = "aligned"
+	ChunkModeSparse  = "sparse"
+)
+
 const (
 	defaultAddr           = ":8080"
 	defaultCacheCapacity  = 2048
+	defaultCacheMaxBytes  = 512 * 1024 * 1024 // 512 MiB
 	defaultCacheTTL       = 5 * time.Minute
 	defaultCacheStaleTTL  = 2 * time.Minute
+	defaultErrorCacheTTL  = 0                // disabled by default
 	defaultMaxObjectSize  = 16 * 1024 * 1024 // 16 MiB
+	defaultMaxRequestBody = 1024 * 1024      // 1 MiB
 	defaultRequestTimeout = 15 * time.Second
 	defaultReadTimeout    = 5 * time.Second
 	defaultWriteTimeout   = 15 * time.Second
 	defaultIdleTimeout    = 60 * time.Second
+	defaultResponseBuffer = 32 * 1024
 	defaultRateLimitRPS   = 0 // disabled by default
+
+	defaultThroughputGrace = 10 * time.Second
+
+	defaultLockoutWindow   = 10 * time.Minute
+	defaultLockoutDuration = time.Minute
+	defaultLockoutMax      = time.Hour
+
+	defaultStaleIfError  = 10 * time.Minute
+	defaultHeuristic     = 0.1
+	defaultHeuristicMax  = 24 * time.Hour
+	defaultFillQueueSize = 1024
+	defaultFillWorkers   = 4
+	defaultFillWait      = 10 * time.Second
+	defaultCompressMin   = 1024
+	defaultMicroCacheTTL = 5 * time.Second
+
+	defaultRevalidateBackoffBase = time.Second
+	defaultRevalidateBackoffMax  = 5 * time.Minute
+
+	defaultVideoProbeBytes = 0 // disabled by default
+	defaultVideoProbeTTL   = time.Hour
+
+	defaultTarConcurrency = 4
+
+	defaultChunkSize = 0 // disabled by default
+
+	defaultPrefetchMaxBytes    = 1024 * 1024 * 1024 // 1 GiB
+	defaultPrefetchConcurrency = 8
+
+	defaultPeerGossipInterval    = 2 * time.Second
+	defaultPeerDiscoveryInterval = 10 * time.Second
+
+	defaultHandoffTimeout = 10 * time.Second
+
+	defaultRulesInterval = 30 * time.Second
+
+	defaultJWKSRefresh = time.Hour
+
+	defaultCORSMaxAge = 10 * time.Minute
+
+	defaultMetricsPushInterval = 15 * time.Second
+	defaultMetricsPushJob      = "s3-proxy"
+
+	defaultMaxUploadSize = 5 * 1024 * 1024 * 1024 // 5 GiB
+	defaultUploadTimeout = 10 * time.Minute
+	defaultPartSize      = 16 * 1024 * 1024 // 16 MiB
+	minPartSize          = 5 * 1024 * 1024  // S3 minimum for all but the last part
+	maxUploadParts       = 10000
+
+	defaultFailoverThreshold = 3
+	defaultFailoverCooldown  = 30 * time.Second
+	defaultReadReplicaLag    = 15 * time.Minute
+
+	defaultOriginMetaHeaders = 64
+	defaultOriginMetaBytes   = 8192
+
+	defaultRedisAddr     = "localhost:6379"
+	defaultRedisPrefix   = "s3-proxy:"
+	defaultRedisPoolSize = 16
 )
 
+var (
+	defaultCORSMethods = []string{"GET", "HEAD"}
+	defaultCORSHeaders = []string{"Authorization", "Range", "If-Match", "If-None-Match", "If-Modified-Since", "If-Range", "If-Unmodified-Since"}
+	defaultCORSExposed = []string{"Accept-Ranges", "Content-Length", "Content-Range", "ETag", "Last-Modified", "X-Cache"}
+)
+
+var defaultVideoExtensions = []string{".mp4", ".m4v", ".mov"}
+
+var defaultAssetExtensions = []string{
+	".js", ".mjs", ".css", ".map", ".json", ".wasm",
+	".png", ".jpg", ".jpeg", ".gif", ".svg", ".webp", ".avif", ".ico",
+	".woff", ".woff2", ".ttf", ".otf", ".eot",
+	".txt", ".xml", ".pdf", ".mp4", ".webm", ".mp3",
+}
+
+// Sources are where settings come from besides the environment: a YAML
+// file, which the environment overrides, and command-line flags by setting
+// name, which override the environment.
+type Sources struct {
+	File  string
+	Flags map[string]string
+}
+
+// Load reads the configuration from the environment.
 func Load() (*Config, error) {
+	return LoadFrom(Sources{})
+}
+
+// LoadFrom reads the configuration from the environment and src.
+func LoadFrom(src Sources) (*Config, error) {
+	cfg, _, err := Describe(src)
+	return cfg, err
+}
+
+// Describe reads the configuration as LoadFrom does, and also returns every
+// setting it read, with the value in effect, whether or not the
+// configuration is valid.
+func Describe(src Sources) (*Config, []Setting, error) {
+	var entries []fileEntry
+	if src.File != "" {
+		var err error
+		if entries, err = readConfigFile(src.File); err != nil {
+			return nil, nil, err
+		}
+	}
+	l := newLoader(entries, src.Flags)
+	cfg, err := l.load(entries)
+	settings := l.settings()
+	if err != nil {
+		return nil, settings, err
+	}
+	cfg.Settings = settings
+	return cfg, settings, nil
+}
+
+// load reads every setting through l and checks the result.
+func (l *loader) load(entries []fileEntry) (*Config, error) {
 	cfg := &Config{
-		Addr:           getString("SERVER_ADDR", defaultAddr),
-		AuthToken:      os.Getenv("AUTH_TOKEN"),
-		Endpoint:       os.Getenv("S3_ENDPOINT"),
-		Region:         getString("S3_REGION", "auto"),
-		AccessKey:      os.Getenv("S3_ACCESS_KEY"),
-		SecretKey:      os.Getenv("S3_SECRET_KEY"),
-		Bucket:         os.Getenv("S3_BUCKET"),
-		CacheCapacity:  getInt("CACHE_CAPACITY", defaultCacheCapacity),
-		CacheTTL:       getDuration("CACHE_TTL", defaultCacheTTL),
-		CacheStaleTTL:  getDuration("CACHE_STALE_TTL", defaultCacheStaleTTL),
-		MaxObjectSize:  getInt64("MAX_OBJECT_SIZE", defaultMaxObjectSize),
-		RequestTimeout: getDuration("REQUEST_TIMEOUT", defaultRequestTimeout),
-		ReadTimeout:    getDuration("READ_TIMEOUT", defaultReadTimeout),
-		WriteTimeout:   getDuration("WRITE_TIMEOUT", defaultWriteTimeout),
-		IdleTimeout:    getDuration("IDLE_TIMEOUT", defaultIdleTimeout),
-		RateLimitRPS:   getFloat("RATE_LIMIT_RPS", defaultRateLimitRPS),
-	}
-
-	if cfg.AuthToken == "" {
-		return nil, fmt.Errorf("AUTH_TOKEN must be provided")
+		Addr:          l.getString("SERVER_ADDR", defaultAddr),
+		PathPrefix:    strings.TrimRight(l.getString("PATH_PREFIX", ""), "/"),
+		TLSCertFile:   l.getString("TLS_CERT_FILE", ""),
+		TLSKeyFile:    l.getString("TLS_KEY_FILE", ""),
+		TLSClientCA:   l.getString("TLS_CLIENT_CA_FILE", ""),
+		H2C:           l.getBool("H2C", false),
+		AuthToken:     l.getString("AUTH_TOKEN", ""),
+		Endpoint:      l.getString("S3_ENDPOINT", ""),
+		Region:        l.getString("S3_REGION", "auto"),
+		AccessKey:     l.getString("S3_ACCESS_KEY", ""),
+		SecretKey:     l.getString("S3_SECRET_KEY", ""),
+		Bucket:        l.getString("S3_BUCKET", ""),
+		CacheBackend:  l.getString("CACHE_BACKEND", BackendMemory),
+		CacheCapacity: l.getInt("CACHE_CAPACITY", defaultCacheCapacity),
+		CacheMaxBytes: l.getInt64("CACHE_MAX_BYTES", defaultCacheMaxBytes),
+		CacheTTL:      l.getDuration("CACHE_TTL", defaultCacheTTL),
+		CacheStaleTTL: l.getDuration("CACHE_STALE_TTL", defaultCacheStaleTTL),
+		ErrorCacheTTL: l.getDuration("ERROR_CACHE_TTL", defaultErrorCacheTTL),
+
+		SecondaryBucket:   l.getString("S3_SECONDARY_BUCKET", ""),
+		FailoverThreshold: l.getInt("ORIGIN_FAILOVER_THRESHOLD", defaultFailoverThreshold),
+		FailoverCooldown:  l.getDuration("ORIGIN_FAILOVER_COOLDOWN", defaultFailoverCooldown),
+
+		CacheStaleIfError:  l.getDuration("CACHE_STALE_IF_ERROR", defaultStaleIfError),
+		HeuristicFreshness: l.getFloat("HEURISTIC_FRESHNESS", defaultHeuristic),
+		HeuristicMaxTTL:    l.getDuration("HEURISTIC_MAX_TTL", defaultHeuristicMax),
+		CacheFillMode:      l.getString("CACHE_FILL_MODE", FillModeInline),
+		FillQueueSize:      l.getInt("FILL_QUEUE_SIZE", defaultFillQueueSize),
+		FillWorkers:        l.getInt("FILL_WORKERS", defaultFillWorkers),
+		FillWaitTimeout:    l.getDuration("FILL_WAIT_TIMEOUT", defaultFillWait),
+		FanoutBufferSize:   l.getInt64("FANOUT_BUFFER_SIZE", 0),
+		VaryHeaders:        l.getList("CACHE_VARY_HEADERS", nil),
+		CacheKeyStrict:     l.getBool("CACHE_KEY_STRICT", false),
+		CacheKeyHeaders:    l.getList("CACHE_KEY_HEADERS", nil),
+		Compression:        l.getBool("COMPRESSION", false),
+		CompressionMinSize: l.getInt64("COMPRESSION_MIN_SIZE", defaultCompressMin),
+		ChecksumHeaders:    l.getBool("CHECKSUM_HEADERS", false),
+		ContentDigest:      l.getBool("CONTENT_DIGEST", false),
+		ArtifactMode:       l.getBool("ARTIFACT_MODE", false),
+		MicroCachePrefixes: l.getList("MICRO_CACHE_PREFIXES", nil),
+		MicroCacheTTL:      l.getDuration("MICRO_CACHE_TTL", defaultMicroCacheTTL),
+		MaxObjectSize:      l.getInt64("MAX_OBJECT_SIZE", defaultMaxObjectSize),
+		MaxRequestBody:     l.getInt64("MAX_REQUEST_BODY", defaultMaxRequestBody),
+		RequestTimeout:     l.getDuration("REQUEST_TIMEOUT", defaultRequestTimeout),
+		ReadTimeout:        l.getDuration("READ_TIMEOUT", defaultReadTimeout),
+		WriteTimeout:       l.getDuration("WRITE_TIMEOUT", defaultWriteTimeout),
+		IdleTimeout:        l.getDuration("IDLE_TIMEOUT", defaultIdleTimeout),
+		Listeners:          l.getInt("SERVER_LISTENERS", 1),
+		TCPNoDelay:         l.getBool("TCP_NODELAY", true),
+		TCPWriteBuffer:     l.getInt("TCP_WRITE_BUFFER", 0),
+		ResponseBufferSize: l.getInt("RESPONSE_BUFFER_SIZE", defaultResponseBuffer),
+		RateLimitRPS:       l.getFloat("RATE_LIMIT_RPS", defaultRateLimitRPS),
+		Tracing:            l.getBool("TRACING", false),
+
+		AuthLockoutThreshold: l.getInt("AUTH_LOCKOUT_THRESHOLD", 0),
+		AuthLockoutWindow:    l.getDuration("AUTH_LOCKOUT_WINDOW", defaultLockoutWindow),
+		AuthLockoutDuration:  l.getDuration("AUTH_LOCKOUT_DURATION", defaultLockoutDuration),
+		AuthLockoutMax:       l.getDuration("AUTH_LOCKOUT_MAX", defaultLockoutMax),
+
+		AdminDryRun: l.getBool("ADMIN_DRY_RUN", false),
+
+		IndexDocument:      strings.TrimLeft(l.getString("INDEX_DOCUMENT", ""), "/"),
+		SPAFallbackKey:     strings.TrimLeft(l.getString("SPA_FALLBACK_KEY", ""), "/"),
+		SPAAssetExtensions: l.getList("SPA_ASSET_EXTENSIONS", defaultAssetExtensions),
+
+		RobotsTxt:    strings.ReplaceAll(l.getString("ROBOTS_TXT", ""), `\n`, "\n"),
+		RobotsTxtKey: strings.TrimLeft(l.getString("ROBOTS_TXT_KEY", ""), "/"),
+		FaviconFile:  l.getString("FAVICON_FILE", ""),
+		FaviconKey:   strings.TrimLeft(l.getString("FAVICON_KEY", ""), "/"),
+
+		UserAgentDeny: l.getList("USER_AGENT_DENY", nil),
+
+		SigningSecret: l.getString("SIGNING_SECRET", ""),
+
+		JWTSecret:      l.getString("JWT_SECRET", ""),
+		JWTJWKSURL:     l.getString("JWT_JWKS_URL", ""),
+		JWTJWKSRefresh: l.getDuration("JWT_JWKS_REFRESH", defaultJWKSRefresh),
+		JWTCookie:      l.getString("JWT_COOKIE", ""),
+		JWTPathClaim:   l.getString("JWT_PATH_CLAIM", ""),
+
+		CORSAllowedOrigins:   l.getList("CORS_ALLOWED_ORIGINS", nil),
+		CORSAllowedMethods:   l.getList("CORS_ALLOWED_METHODS", defaultCORSMethods),
+		CORSAllowedHeaders:   l.getList("CORS_ALLOWED_HEADERS", defaultCORSHeaders),
+		CORSExposedHeaders:   l.getList("CORS_EXPOSED_HEADERS", defaultCORSExposed),
+		CORSMaxAge:           l.getDuration("CORS_MAX_AGE", defaultCORSMaxAge),
+		CORSAllowCredentials: l.getBool("CORS_ALLOW_CREDENTIALS", false),
+
+		CrawlerUserAgents:   l.getList("CRAWLER_USER_AGENTS", nil),
+		CrawlerRateLimitRPS: l.getFloat("CRAWLER_RATE_LIMIT_RPS", 0),
+		CrawlerCacheOnly:    l.getBool("CRAWLER_CACHE_ONLY", true),
+
+		MaxConcurrentPerIP:    l.getInt("MAX_CONCURRENT_PER_IP", 0),
+		MaxConcurrentPerToken: l.getInt("MAX_CONCURRENT_PER_TOKEN", 0),
+
+		MinClientThroughput: l.getInt64("MIN_CLIENT_THROUGHPUT", 0),
+		MinThroughputGrace:  l.getDuration("MIN_THROUGHPUT_GRACE", defaultThroughputGrace),
+		MaxConnLifetime:     l.getDuration("MAX_CONNECTION_LIFETIME", 0),
+
+		MetricsPushURL:      l.getString("METRICS_PUSH_URL", ""),
+		MetricsPushInterval: l.getDuration("METRICS_PUSH_INTERVAL", defaultMetricsPushInterval),
+		MetricsPushJob:      l.getString("METRICS_PUSH_JOB", defaultMetricsPushJob),
+		MetricsPushInstance: l.getString("METRICS_PUSH_INSTANCE", hostname()),
+
+		EventLog:    l.getString("CACHE_EVENT_LOG", ""),
+		SecurityLog: l.getString("SECURITY_LOG", ""),
+
+		OriginUserAgent:    l.getString("ORIGIN_USER_AGENT", ""),
+		OriginRequestPayer: l.getBool("ORIGIN_REQUEST_PAYER", false),
+
+		OriginMetaHeaders: l.getInt("ORIGIN_MAX_META_HEADERS", defaultOriginMetaHeaders),
+		OriginMetaBytes:   l.getInt("ORIGIN_MAX_META_BYTES", defaultOriginMetaBytes),
+
+		OpaqueErrors: l.getBool("OPAQUE_ERRORS", false),
+
+		ConfigStrict: l.getBool("CONFIG_STRICT", true),
+
+		RulesURL:      l.getString("RULES_URL", ""),
+		RulesInterval: l.getDuration("RULES_INTERVAL", defaultRulesInterval),
+
+		RewriteRulesFile: l.getString("REWRITE_RULES_FILE", ""),
+
+		WriteThrough:   l.getBool("WRITE_THROUGH", false),
+		MaxUploadSize:  l.getInt64("MAX_UPLOAD_SIZE", defaultMaxUploadSize),
+		UploadTimeout:  l.getDuration("UPLOAD_TIMEOUT", defaultUploadTimeout),
+		UploadPartSize: l.getInt64("UPLOAD_PART_SIZE", defaultPartSize),
+
+		ReadBucket:     l.getString("S3_READ_BUCKET", ""),
+		ReadReplicaLag: l.getDuration("READ_REPLICA_LAG", defaultReadReplicaLag),
+
+		RevalidateBackoffBase: l.getDuration("REVALIDATE_BACKOFF_BASE", defaultRevalidateBackoffBase),
+		RevalidateBackoffMax:  l.getDuration("REVALIDATE_BACKOFF_MAX", defaultRevalidateBackoffMax),
+
+		VideoProbeBytes: l.getInt64("VIDEO_PROBE_BYTES", defaultVideoProbeBytes),
+		VideoProbeTTL:   l.getDuration("VIDEO_PROBE_TTL", defaultVideoProbeTTL),
+		VideoExtensions: l.getList("VIDEO_EXTENSIONS", defaultVideoExtensions),
+
+		TarConcurrency: l.getInt("TAR_CONCURRENCY", defaultTarConcurrency),
+
+		ChunkSize: l.getInt64("CHUNK_SIZE", defaultChunkSize),
+		ChunkMode: l.getString("CHUNK_MODE", ChunkModeAligned),
+
+		PrefetchMaxBytes:    l.getInt64("PREFETCH_MAX_BYTES", defaultPrefetchMaxBytes),
+		PrefetchConcurrency: l.getInt("PREFETCH_CONCURRENCY", defaultPrefetchConcurrency),
+		CacheWarmupManifest: l.getString("CACHE_WARMUP_MANIFEST", ""),
+
+		Peers:              l.getList("PEERS", nil),
+		PeerSelfURL:        l.getString("PEER_SELF_URL", ""),
+		PeerGossipInterval: l.getDuration("PEER_GOSSIP_INTERVAL", defaultPeerGossipInterval),
+
+		PeerDiscoveryDNS:      l.getString("PEER_DISCOVERY_DNS", ""),
+		PeerDiscoveryInterval: l.getDuration("PEER_DISCOVERY_INTERVAL", defaultPeerDiscoveryInterval),
+
+		HandoffEntries: l.getInt("HANDOFF_ENTRIES", 0),
+		HandoffPeer:    strings.TrimSuffix(l.getString("HANDOFF_PEER", ""), "/"),
+		HandoffTimeout: l.getDuration("HANDOFF_TIMEOUT", defaultHandoffTimeout),
+
+		RedisAddr:     l.getString("REDIS_ADDR", defaultRedisAddr),
+		RedisPassword: l.getString("REDIS_PASSWORD", ""),
+		RedisDB:       l.getInt("REDIS_DB", 0),
+		RedisPrefix:   l.getString("REDIS_KEY_PREFIX", defaultRedisPrefix),
+		RedisPoolSize: l.getInt("REDIS_POOL_SIZE", defaultRedisPoolSize),
+
+		SpacesCDNEndpointID: l.getString("SPACES_CDN_ENDPOINT_ID", ""),
+		SpacesCDNToken:      l.getString("SPACES_CDN_TOKEN", ""),
+
+		CloudFrontDistributionID: l.getString("CLOUDFRONT_DISTRIBUTION_ID", ""),
+		FastlyServiceID:          l.getString("FASTLY_SERVICE_ID", ""),
+		FastlyAPIToken:           l.getString("FASTLY_API_TOKEN", ""),
+		PurgeWebhookURL:          l.getString("PURGE_WEBHOOK_URL", ""),
+		PurgeWebhookSecret:       l.getString("PURGE_WEBHOOK_SECRET", ""),
+
+		SurrogateControl:   l.getString("SURROGATE_CONTROL", ""),
+		SurrogateKeyHeader: l.getString("SURROGATE_KEY_HEADER", ""),
+	}
+
+	// Everything is read before anything is checked, so that every setting is
+	// declared, for the schema, flags and unknown-name checks, even when
+	// Load fails.
+	bucketRoutes := l.getList("BUCKET_ROUTES", nil)
+	hostBuckets := l.getList("HOST_BUCKETS", nil)
+	cfg.ErrorTemplate = l.getString("ERROR_TEMPLATE", "")
+	networkGroups := l.getList("NETWORK_GROUPS", nil)
+	allowCIDRs := l.getList("ALLOW_CIDRS", nil)
+	denyCIDRs := l.getList("DENY_CIDRS", nil)
+	trustedProxies := l.getList("TRUSTED_PROXIES", nil)
+	userAgentAllow := l.getList("USER_AGENT_ALLOW", nil)
+	cacheControlRules := l.getString("CACHE_CONTROL_RULES", "")
+	originTags := l.getList("ORIGIN_TAGS", nil)
+	protocolShims := l.getList("PROTOCOL_SHIMS", nil)
+	artifactPrefixes := l.getList("ARTIFACT_PREFIXES", nil)
+	signedPrefixes := l.getList("SIGNED_PREFIXES", nil)
+	jwtPrefixes := l.getList("JWT_PREFIXES", nil)
+	cfg.AccessPolicyFile = l.getString("ACCESS_POLICY_FILE", "")
+	warmJobsFile := l.getString("WARM_JOBS_FILE", "")
+	// R2 settings fill in the endpoint and credentials, so they come before
+	// the replicas, which default to those.
+	r2Account := l.getString("R2_ACCOUNT_ID", "")
+	r2Jurisdiction := l.getString("R2_JURISDICTION", "")
+	r2TokenID := l.getString("R2_TOKEN_ID", "")
+	r2Token := l.getString("R2_TOKEN", "")
+	staticKeys := cfg.AccessKey != "" || cfg.SecretKey != ""
+	compat := OriginAWS
+	if r2Account != "" {
+		compat = OriginR2
+		if cfg.Endpoint == "" {
+			cfg.Endpoint = r2Endpoint(r2Account, r2Jurisdiction)
+		}
+	}
+	cfg.OriginCompat = l.getString("ORIGIN_COMPAT", compat)
+	if r2Token != "" && !staticKeys {
+		cfg.AccessKey, cfg.SecretKey = r2TokenID, r2SecretKey(r2Token)
+	}
+	// The replica defaults to the primary's endpoint and credentials, which
+	// suits a second bucket in the same account.
+	cfg.SecondaryEndpoint = l.getString("S3_SECONDARY_ENDPOINT", cfg.Endpoint)
+	cfg.SecondaryRegion = l.getString("S3_SECONDARY_REGION", cfg.Region)
+	cfg.SecondaryAccessKey = l.getString("S3_SECONDARY_ACCESS_KEY", cfg.AccessKey)
+	cfg.SecondarySecretKey = l.getString("S3_SECONDARY_SECRET_KEY", cfg.SecretKey)
+	cfg.ReadEndpoint = l.getString("S3_READ_ENDPOINT", cfg.Endpoint)
+	cfg.ReadRegion = l.getString("S3_READ_REGION", cfg.Region)
+	cfg.ReadAccessKey = l.getString("S3_READ_ACCESS_KEY", cfg.AccessKey)
+	cfg.ReadSecretKey = l.getString("S3_READ_SECRET_KEY", cfg.SecretKey)
+	cfg.OriginTLS = l.getOriginTLS("S3_", OriginTLS{})
+	cfg.SecondaryTLS = l.getOriginTLS("S3_SECONDARY_", cfg.OriginTLS)
+	cfg.ReadTLS = l.getOriginTLS("S3_READ_", cfg.OriginTLS)
+
+	if cfg.InvalidSettings = slices.Clone(l.invalid); len(cfg.InvalidSettings) > 0 && cfg.ConfigStrict {
+		return nil, fmt.Errorf("invalid settings (set CONFIG_STRICT=false to use defaults instead): %s", strings.Join(cfg.InvalidSettings, "; "))
+	}
+	if cfg.AuthToken == "" && cfg.TLSClientCA == "" {
+		return nil, fmt.Errorf("AUTH_TOKEN or TLS_CLIENT_CA_FILE must be provided")
+	}
+	if r2Jurisdiction != "" && r2Jurisdiction != "eu" && r2Jurisdiction != "fedramp" {
+		return nil, fmt.Errorf("R2_JURISDICTION must be \"eu\" or \"fedramp\"")
+	}
+	if r2Jurisdiction != "" && r2Account == "" {
+		return nil, fmt.Errorf("R2_JURISDICTION requires R2_ACCOUNT_ID")
+	}
+	if (r2TokenID == "") != (r2Token == "") {
+		return nil, fmt.Errorf("R2_TOKEN_ID and R2_TOKEN must be set together")
+	}
+	if r2Token != "" && staticKeys {
+		return nil, fmt.Errorf("R2_TOKEN and S3_ACCESS_KEY/S3_SECRET_KEY cannot both be set")
 	}
 	if cfg.Endpoint == "" {
-		return nil, fmt.Errorf("S3_ENDPOINT must be provided")
+		return nil, fmt.Errorf("S3_ENDPOINT or R2_ACCOUNT_ID must be provided")
 	}
-	if cfg.AccessKey == "" || cfg.SecretKey == "" {
-		return nil, fmt.Errorf("S3_ACCESS_KEY and S3_SECRET_KEY must be provided")
+	if (cfg.AccessKey == "") != (cfg.SecretKey == "") {
+		return nil, fmt.Errorf("S3_ACCESS_KEY and S3_SECRET_KEY must be set together")
 	}
 	if cfg.Bucket == "" {
 		return nil, fmt.Errorf("S3_BUCKET must be provided")
 	}
+	routes, err := parseBucketRoutes(bucketRoutes)
+	if err != nil {
+		return nil, err
+	}
+	cfg.BucketRoutes = routes
+	hosts, err := parseHostBuckets(hostBuckets)
+	if err != nil {
+		return nil, err
+	}
+	cfg.HostBuckets = hosts
+	pages, err := parseErrorPages(l.environ())
+	if err != nil {
+		return nil, err
+	}
+	cfg.ErrorPages = pages
+	networks, err := parseNetworkGroups(networkGroups)
+	if err != nil {
+		return nil, err
+	}
+	cfg.NetworkGroups = networks
+	if cfg.AllowCIDRs, err = parsePrefixes("ALLOW_CIDRS", allowCIDRs); err != nil {
+		return nil, err
+	}
+	if cfg.DenyCIDRs, err = parsePrefixes("DENY_CIDRS", denyCIDRs); err != nil {
+		return nil, err
+	}
+	if cfg.TrustedProxies, err = parsePrefixes("TRUSTED_PROXIES", trustedProxies); err != nil {
+		return nil, err
+	}
+	agents, err := parseUserAgentRules(userAgentAllow)
+	if err != nil {
+		return nil, err
+	}
+	cfg.UserAgentAllow = agents
+	controls, err := parseCacheControlRules(cacheControlRules)
+	if err != nil {
+		return nil, err
+	}
+	cfg.CacheControlRules = controls
+	if cfg.OriginTags, err = parseOriginTags(originTags); err != nil {
+		return nil, err
+	}
+	if cfg.ProtocolShims, err = parseProtocolShims(protocolShims); err != nil {
+		return nil, err
+	}
+	for _, prefix := range artifactPrefixes {
+		cfg.ArtifactPrefixes = append(cfg.ArtifactPrefixes, strings.TrimLeft(prefix, "/"))
+	}
+	if len(cfg.ArtifactPrefixes) > 0 && !cfg.ArtifactMode {
+		return nil, fmt.Errorf("ARTIFACT_PREFIXES requires ARTIFACT_MODE")
+	}
+	if cfg.ArtifactMode {
+		cfg.ChecksumHeaders = true
+		cfg.ContentDigest = true
+	}
+	for _, prefix := range signedPrefixes {
+		cfg.SignedPrefixes = append(cfg.SignedPrefixes, strings.TrimLeft(prefix, "/"))
+	}
+	if len(cfg.SignedPrefixes) > 0 && cfg.SigningSecret == "" {
+		return nil, fmt.Errorf("SIGNED_PREFIXES requires SIGNING_SECRET")
+	}
+	for _, prefix := range jwtPrefixes {
+		cfg.JWTPrefixes = append(cfg.JWTPrefixes, strings.TrimLeft(prefix, "/"))
+	}
+	if !cfg.JWTEnabled() && (len(cfg.JWTPrefixes) > 0 || cfg.JWTCookie != "" || cfg.JWTPathClaim != "") {
+		return nil, fmt.Errorf("JWT settings require JWT_SECRET or JWT_JWKS_URL")
+	}
+	if cfg.JWTJWKSURL != "" && cfg.JWTJWKSRefresh <= 0 {
+		return nil, fmt.Errorf("JWT_JWKS_REFRESH must be greater than zero")
+	}
+	if cfg.AccessPolicyFile != "" {
+		if len(cfg.SignedPrefixes) > 0 || len(cfg.JWTPrefixes) > 0 {
+			return nil, fmt.Errorf("ACCESS_POLICY_FILE replaces SIGNED_PREFIXES and JWT_PREFIXES")
+		}
+		if cfg.AccessPolicy, err = loadAccessPolicy(cfg.AccessPolicyFile); err != nil {
+			return nil, err
+		}
+	} else {
+		cfg.AccessPolicy = cfg.envAccessPolicy()
+	}
+	if err := cfg.checkAccessMode(cfg.AccessPolicy.Default); err != nil {
+		return nil, err
+	}
+	for prefix, mode := range cfg.AccessPolicy.Prefixes {
+		if err := cfg.checkAccessMode(mode); err != nil {
+			return nil, fmt.Errorf("access policy %q: %w", prefix, err)
+		}
+	}
+	methods := cfg.CORSAllowedMethods
+	cfg.CORSAllowedMethods = nil
+	for _, method := range methods {
+		cfg.CORSAllowedMethods = append(cfg.CORSAllowedMethods, strings.ToUpper(method))
+	}
+	for _, origin := range cfg.CORSAllowedOrigins {
+		if origin != "*" && (strings.Count(origin, "*") > 1 || !strings.Contains(origin, "://")) {
+			return nil, fmt.Errorf("CORS_ALLOWED_ORIGINS entry %q must be *, or an origin such as https://app.example.com or https://*.example.com", origin)
+		}
+	}
+	if cfg.CORSMaxAge < 0 {
+		return nil, fmt.Errorf("CORS_MAX_AGE must not be negative")
+	}
+	if cfg.RewriteRulesFile != "" {
+		if cfg.Rewrites, err = LoadRewriteRules(cfg.RewriteRulesFile); err != nil {
+			return nil, err
+		}
+	}
 
+	if cfg.PathPrefix != "" && !strings.HasPrefix(cfg.PathPrefix, "/") {
+		return nil, fmt.Errorf("PATH_PREFIX must start with /")
+	}
+	if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
+		return nil, fmt.Errorf("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	for _, o := range []struct {
+		prefix string
+		tls    OriginTLS
+	}{{"S3_", cfg.OriginTLS}, {"S3_SECONDARY_", cfg.SecondaryTLS}, {"S3_READ_", cfg.ReadTLS}} {
+		if (o.tls.CertFile == "") != (o.tls.KeyFile == "") {
+			return nil, fmt.Errorf("%sTLS_CERT_FILE and %sTLS_KEY_FILE must be set together", o.prefix, o.prefix)
+		}
+	}
+	if cfg.TLSClientCA != "" && cfg.TLSCertFile == "" {
+		return nil, fmt.Errorf("TLS_CLIENT_CA_FILE requires TLS_CERT_FILE")
+	}
+	if cfg.Listeners < 1 {
+		return nil, fmt.Errorf("SERVER_LISTENERS must be at least 1")
+	}
+	if cfg.TCPWriteBuffer < 0 {
+		return nil, fmt.Errorf("TCP_WRITE_BUFFER must be zero or greater")
+	}
+	if cfg.ResponseBufferSize < 512 {
+		return nil, fmt.Errorf("RESPONSE_BUFFER_SIZE must be at least 512")
+	}
+	if cfg.H2C && cfg.TLSCertFile != "" {
+		return nil, fmt.Errorf("H2C applies to plain HTTP; with TLS, HTTP/2 is negotiated already")
+	}
+	if cfg.RulesURL != "" || cfg.RewriteRulesFile != "" {
+		if cfg.RulesInterval <= 0 {
+			return nil, fmt.Errorf("RULES_INTERVAL must be greater than zero")
+		}
+	}
+	if cfg.RulesURL != "" {
+		if !strings.HasPrefix(cfg.RulesURL, "http://") && !strings.HasPrefix(cfg.RulesURL, "https://") && !strings.HasPrefix(cfg.RulesURL, "s3:") {
+			return nil, fmt.Errorf("RULES_URL must be an http(s) URL or an s3: key")
+		}
+	}
+	if cfg.MetricsPushURL != "" && cfg.MetricsPushInterval <= 0 {
+		return nil, fmt.Errorf("METRICS_PUSH_INTERVAL must be greater than zero")
+	}
+	if cfg.FailoverThreshold <= 0 {
+		return nil, fmt.Errorf("ORIGIN_FAILOVER_THRESHOLD must be greater than zero")
+	}
+	if cfg.FailoverCooldown <= 0 {
+		return nil, fmt.Errorf("ORIGIN_FAILOVER_COOLDOWN must be greater than zero")
+	}
+	if cfg.ReadBucket != "" && !cfg.WriteThrough {
+		return nil, fmt.Errorf("S3_READ_BUCKET requires WRITE_THROUGH; without writes, point S3_BUCKET at the replica instead")
+	}
+	switch cfg.OriginCompat {
+	case OriginAWS, OriginMinIO, OriginCeph, OriginR2, OriginB2:
+	default:
+		return nil, fmt.Errorf("ORIGIN_COMPAT must be %q, %q, %q, %q or %q", OriginAWS, OriginMinIO, OriginCeph, OriginR2, OriginB2)
+	}
+	if cfg.OriginMetaHeaders < 0 || cfg.OriginMetaBytes < 0 {
+		return nil, fmt.Errorf("ORIGIN_MAX_META_HEADERS and ORIGIN_MAX_META_BYTES must not be negative")
+	}
+	if cfg.ReadReplicaLag < 0 {
+		return nil, fmt.Errorf("READ_REPLICA_LAG must not be negative")
+	}
+
+	if cfg.CacheBackend != BackendMemory && cfg.CacheBackend != BackendRedis {
+		return nil, fmt.Errorf("CACHE_BACKEND must be %q or %q", BackendMemory, BackendRedis)
+	}
+	if cfg.RedisPoolSize <= 0 {
+		return nil, fmt.Errorf("REDIS_POOL_SIZE must be greater than zero")
+	}
 	if cfg.CacheCapacity <= 0 {
 		return nil, fmt.Errorf("CACHE_CAPACITY must be greater than zero")
 	}
+	if cfg.CacheMaxBytes < 0 {
+		return nil, fmt.Errorf("CACHE_MAX_BYTES must be zero or positive")
+	}
 	if cfg.CacheTTL <= 0 {
 		return nil, fmt.Errorf("CACHE_TTL must be greater than zero")
 	}
 	if cfg.CacheStaleTTL < 0 {
 		return nil, fmt.Errorf("CACHE_STALE_TTL must be zero or positive")
 	}
+	if cfg.ErrorCacheTTL < 0 || cfg.ErrorCacheTTL > time.Minute {
+		return nil, fmt.Errorf("ERROR_CACHE_TTL must be between zero and 1m")
+	}
+	if cfg.CacheStaleIfError < 0 {
+		return nil, fmt.Errorf("CACHE_STALE_IF_ERROR must be zero or positive")
+	}
+	if cfg.HeuristicFreshness < 0 || cfg.HeuristicFreshness > 1 {
+		return nil, fmt.Errorf("HEURISTIC_FRESHNESS must be between 0 and 1")
+	}
+	if cfg.HeuristicMaxTTL <= 0 {
+		return nil, fmt.Errorf("HEURISTIC_MAX_TTL must be greater than zero")
+	}
+	if cfg.CacheFillMode != FillModeInline && cfg.CacheFillMode != FillModeBackground {
+		return nil, fmt.Errorf("CACHE_FILL_MODE must be %q or %q", FillModeInline, FillModeBackground)
+	}
+	if cfg.FillQueueSize <= 0 {
+		return nil, fmt.Errorf("FILL_QUEUE_SIZE must be greater than zero")
+	}
+	if cfg.FillWorkers <= 0 {
+		return nil, fmt.Errorf("FILL_WORKERS must be greater than zero")
+	}
+	if cfg.FillWaitTimeout <= 0 {
+		return nil, fmt.Errorf("FILL_WAIT_TIMEOUT must be greater than zero")
+	}
+	if cfg.FanoutBufferSize < 0 {
+		return nil, fmt.Errorf("FANOUT_BUFFER_SIZE must be zero or greater")
+	}
+	if cfg.CompressionMinSize < 0 {
+		return nil, fmt.Errorf("COMPRESSION_MIN_SIZE must be zero or greater")
+	}
+	if cfg.MicroCacheTTL < time.Second || cfg.MicroCacheTTL > 10*time.Second {
+		return nil, fmt.Errorf("MICRO_CACHE_TTL must be between 1s and 10s")
+	}
 	if cfg.MaxObjectSize <= 0 {
 		return nil, fmt.Errorf("MAX_OBJECT_SIZE must be greater than zero")
 	}
+	if cfg.MaxRequestBody <= 0 {
+		return nil, fmt.Errorf("MAX_REQUEST_BODY must be greater than zero")
+	}
+	if cfg.MaxUploadSize <= 0 {
+		return nil, fmt.Errorf("MAX_UPLOAD_SIZE must be greater than zero")
+	}
+	if cfg.UploadTimeout <= 0 {
+		return nil, fmt.Errorf("UPLOAD_TIMEOUT must be greater than zero")
+	}
+	if cfg.UploadPartSize < minPartSize {
+		return nil, fmt.Errorf("UPLOAD_PART_SIZE must be at least %d", minPartSize)
+	}
+	if cfg.MaxUploadSize > cfg.UploadPartSize*maxUploadParts {
+		return nil, fmt.Errorf("MAX_UPLOAD_SIZE must not exceed %d parts of UPLOAD_PART_SIZE", maxUploadParts)
+	}
+	if cfg.AuthLockoutThreshold < 0 {
+		return nil, fmt.Errorf("AUTH_LOCKOUT_THRESHOLD must be zero or positive")
+	}
+	if cfg.AuthLockoutThreshold > 0 && (cfg.AuthLockoutWindow <= 0 || cfg.AuthLockoutDuration <= 0 || cfg.AuthLockoutMax < cfg.AuthLockoutDuration) {
+		return nil, fmt.Errorf("AUTH_LOCKOUT_WINDOW and AUTH_LOCKOUT_DURATION must be positive, and AUTH_LOCKOUT_MAX at least AUTH_LOCKOUT_DURATION")
+	}
 	if cfg.RateLimitRPS < 0 {
 		return nil, fmt.Errorf("RATE_LIMIT_RPS must be zero or positive")
 	}
+	if cfg.RobotsTxt != "" && cfg.RobotsTxtKey != "" {
+		return nil, fmt.Errorf("ROBOTS_TXT and ROBOTS_TXT_KEY are mutually exclusive")
+	}
+	if cfg.FaviconFile != "" && cfg.FaviconKey != "" {
+		return nil, fmt.Errorf("FAVICON_FILE and FAVICON_KEY are mutually exclusive")
+	}
+	if cfg.CrawlerRateLimitRPS < 0 {
+		return nil, fmt.Errorf("CRAWLER_RATE_LIMIT_RPS must be zero or positive")
+	}
+	if cfg.MaxConcurrentPerIP < 0 || cfg.MaxConcurrentPerToken < 0 {
+		return nil, fmt.Errorf("MAX_CONCURRENT_PER_IP and MAX_CONCURRENT_PER_TOKEN must be zero or positive")
+	}
+	if cfg.MinClientThroughput < 0 {
+		return nil, fmt.Errorf("MIN_CLIENT_THROUGHPUT must be zero or positive")
+	}
+	if cfg.MinThroughputGrace < 0 {
+		return nil, fmt.Errorf("MIN_THROUGHPUT_GRACE must be zero or positive")
+	}
+	if cfg.MaxConnLifetime < 0 {
+		return nil, fmt.Errorf("MAX_CONNECTION_LIFETIME must be zero or positive")
+	}
+	if cfg.RevalidateBackoffBase <= 0 {
+		return nil, fmt.Errorf("REVALIDATE_BACKOFF_BASE must be greater than zero")
+	}
+	if cfg.RevalidateBackoffMax < cfg.RevalidateBackoffBase {
+		return nil, fmt.Errorf("REVALIDATE_BACKOFF_MAX must not be less than REVALIDATE_BACKOFF_BASE")
+	}
+	if cfg.VideoProbeBytes < 0 {
+		return nil, fmt.Errorf("VIDEO_PROBE_BYTES must be zero or positive")
+	}
+	if cfg.VideoProbeBytes > 0 && cfg.VideoProbeTTL <= 0 {
+		return nil, fmt.Errorf("VIDEO_PROBE_TTL must be greater than zero")
+	}
+	if cfg.TarConcurrency <= 0 {
+		return nil, fmt.Errorf("TAR_CONCURRENCY must be greater than zero")
+	}
+	if cfg.ChunkSize < 0 {
+		return nil, fmt.Errorf("CHUNK_SIZE must be zero or positive")
+	}
+	if cfg.ChunkSize > cfg.MaxObjectSize {
+		return nil, fmt.Errorf("CHUNK_SIZE must not exceed MAX_OBJECT_SIZE")
+	}
+	if cfg.ChunkMode != ChunkModeAligned && cfg.ChunkMode != ChunkModeSparse {
+		return nil, fmt.Errorf("CHUNK_MODE must be %q or %q", ChunkModeAligned, ChunkModeSparse)
+	}
+	if cfg.PrefetchMaxBytes <= 0 {
+		return nil, fmt.Errorf("PREFETCH_MAX_BYTES must be greater than zero")
+	}
+	if cfg.PrefetchConcurrency <= 0 {
+		return nil, fmt.Errorf("PREFETCH_CONCURRENCY must be greater than zero")
+	}
+
+	if cfg.PeersEnabled() && cfg.PeerSelfURL == "" {
+		return nil, fmt.Errorf("PEER_SELF_URL must be provided when PEERS or PEER_DISCOVERY_DNS is set")
+	}
+	if cfg.PeersEnabled() && cfg.AuthToken == "" {
+		return nil, fmt.Errorf("peers authenticate to each other with AUTH_TOKEN, so it must be provided when PEERS or PEER_DISCOVERY_DNS is set")
+	}
+	if cfg.PeerGossipInterval <= 0 {
+		return nil, fmt.Errorf("PEER_GOSSIP_INTERVAL must be greater than zero")
+	}
+	if cfg.PeerDiscoveryInterval <= 0 {
+		return nil, fmt.Errorf("PEER_DISCOVERY_INTERVAL must be greater than zero")
+	}
+	if cfg.HandoffEntries < 0 {
+		return nil, fmt.Errorf("HANDOFF_ENTRIES must be zero or positive")
+	}
+	if cfg.HandoffTimeout <= 0 {
+		return nil, fmt.Errorf("HANDOFF_TIMEOUT must be greater than zero")
+	}
+	if (cfg.SpacesCDNEndpointID == "") != (cfg.SpacesCDNToken == "") {
+		return nil, fmt.Errorf("SPACES_CDN_ENDPOINT_ID and SPACES_CDN_TOKEN must be set together")
+	}
+	if (cfg.FastlyServiceID == "") != (cfg.FastlyAPIToken == "") {
+		return nil, fmt.Errorf("FASTLY_SERVICE_ID and FASTLY_API_TOKEN must be set together")
+	}
+	if cfg.FastlyServiceID != "" && !strings.EqualFold(cfg.SurrogateKeyHeader, "Surrogate-Key") {
+		return nil, fmt.Errorf("FASTLY_SERVICE_ID purges by surrogate key and requires SURROGATE_KEY_HEADER=Surrogate-Key")
+	}
+	if cfg.PurgeWebhookURL != "" && !strings.HasPrefix(cfg.PurgeWebhookURL, "http://") && !strings.HasPrefix(cfg.PurgeWebhookURL, "https://") {
+		return nil, fmt.Errorf("PURGE_WEBHOOK_URL must be an http:// or https:// URL")
+	}
+	if cfg.PurgeWebhookSecret != "" && cfg.PurgeWebhookURL == "" {
+		return nil, fmt.Errorf("PURGE_WEBHOOK_SECRET requires PURGE_WEBHOOK_URL")
+	}
+	if strings.ContainsAny(cfg.SurrogateControl, "\r\n") {
+		return nil, fmt.Errorf("SURROGATE_CONTROL must be a single line")
+	}
+	if strings.ContainsFunc(cfg.SurrogateKeyHeader, func(r rune) bool { return r <= ' ' || r > '~' || r == ':' }) {
+		return nil, fmt.Errorf("SURROGATE_KEY_HEADER must be a header name, e.g. Surrogate-Key")
+	}
+
+	if warmJobsFile != "" {
+		jobs, err := loadWarmJobs(warmJobsFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.WarmJobs = jobs
+	}
+
+	if unknown := append(l.unknownSettings(), l.unknownFileSettings(entries)...); len(unknown) > 0 {
+		if cfg.ConfigStrict {
+			return nil, fmt.Errorf("unknown settings (set CONFIG_STRICT=false to ignore them): %s", strings.Join(unknown, "; "))
+		}
+		cfg.InvalidSettings = append(cfg.InvalidSettings, unknown...)
+	}
 
 	return cfg, nil
 }
 
-func getString(key, def string) string {
-	if v := os.Getenv(key); v != "" {
+// PeersEnabled reports whether the proxy runs as part of a peer cluster.
+func (c *Config) PeersEnabled() bool {
+	return len(c.Peers) > 0 || c.PeerDiscoveryDNS != ""
+}
+
+func (c *Config) JWTEnabled() bool {
+	return c.JWTSecret != "" || c.JWTJWKSURL != ""
+}
+
+// parseBucketRoutes parses entries of the form "assets/=bucket-a". Prefixes
+// may also be written as paths, e.g. "/assets/*".
+func parseBucketRoutes(entries []string) ([]BucketRoute, error) {
+	var routes []BucketRoute
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		prefix, bucket, ok := strings.Cut(entry, "=")
+		prefix = strings.TrimSuffix(strings.TrimLeft(strings.TrimSpace(prefix), "/"), "*")
+		bucket = strings.TrimSpace(bucket)
+		if !ok || prefix == "" || bucket == "" {
+			return nil, fmt.Errorf("BUCKET_ROUTES entry %q must be prefix=bucket", entry)
+		}
+		if seen[prefix] {
+			return nil, fmt.Errorf("BUCKET_ROUTES has duplicate prefix %q", prefix)
+		}
+		seen[prefix] = true
+		routes = append(routes, BucketRoute{Prefix: prefix, Bucket: bucket})
+	}
+	return routes, nil
+}
+
+// parseHostBuckets parses entries of the form "cdn.example.com=bucket-cdn".
+// Host names are matched case-insensitively and without a port.
+func parseHostBuckets(entries []string) ([]HostBucket, error) {
+	var hosts []HostBucket
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		host, bucket, ok := strings.Cut(entry, "=")
+		host = strings.ToLower(strings.TrimSpace(host))
+		bucket = strings.TrimSpace(bucket)
+		if !ok || host == "" || bucket == "" || strings.ContainsAny(host, "/:") {
+			return nil, fmt.Errorf("HOST_BUCKETS entry %q must be host=bucket", entry)
+		}
+		if seen[host] {
+			return nil, fmt.Errorf("HOST_BUCKETS has duplicate host %q", host)
+		}
+		seen[host] = true
+		hosts = append(hosts, HostBucket{Host: host, Bucket: bucket})
+	}
+	return hosts, nil
+}
+
+// parseErrorPages collects ERROR_<status>_KEY settings, such as ERROR_404_KEY
+// or ERROR_5XX_KEY, from the environment.
+func parseErrorPages(environ []string) (map[string]string, error) {
+	pages := make(map[string]string)
+	for _, kv := range environ {
+		name, value, _ := strings.Cut(kv, "=")
+		status, ok := strings.CutPrefix(name, "ERROR_")
+		if !ok {
+			continue
+		}
+		if status, ok = strings.CutSuffix(status, "_KEY"); !ok {
+			continue
+		}
+		status = strings.ToLower(status)
+		code, err := strconv.Atoi(status)
+		valid := (err == nil && code >= 400 && code <= 599) || status == "4xx" || status == "5xx"
+		if !valid {
+			return nil, fmt.Errorf("%s must name a 4xx or 5xx status", name)
+		}
+		if value = strings.TrimLeft(strings.TrimSpace(value), "/"); value != "" {
+			pages[status] = value
+		}
+	}
+	return pages, nil
+}
+
+// r2Endpoint returns the S3 endpoint of an R2 account, in a jurisdiction
+// if one is given.
+func r2Endpoint(account, jurisdiction string) string {
+	if jurisdiction != "" {
+		return fmt.Sprintf("https://%s.%s.r2.cloudflarestorage.com", account, jurisdiction)
+	}
+	return fmt.Sprintf("https://%s.r2.cloudflarestorage.com", account)
+}
+
+// r2SecretKey derives the S3 secret key of an R2 API token, which is the
+// SHA-256 of the token's value; the access key is the token's ID.
+func r2SecretKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// getOriginTLS reads the TLS files of the origin whose settings start with
+// prefix, falling back to def.
+func (l *loader) getOriginTLS(prefix string, def OriginTLS) OriginTLS {
+	return OriginTLS{
+		CertFile: l.getString(prefix+"TLS_CERT_FILE", def.CertFile),
+		KeyFile:  l.getString(prefix+"TLS_KEY_FILE", def.KeyFile),
+		CAFile:   l.getString(prefix+"TLS_CA_FILE", def.CAFile),
+	}
+}
+
+// parseOriginTags parses entries of the form "tenant=acme".
+func parseOriginTags(entries []string) ([]OriginTag, error) {
+	var tags []OriginTag
+	for _, entry := range entries {
+		key, value, ok := strings.Cut(entry, "=")
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if !ok || key == "" || value == "" {
+			return nil, fmt.Errorf("ORIGIN_TAGS entry %q must be key=value", entry)
+		}
+		tags = append(tags, OriginTag{Key: key, Value: value})
+	}
+	return tags, nil
+}
+
+// parseNetworkGroups parses entries of the form "10.0.0.0/8=internal". A bare
+// address is taken as a single-host prefix.
+func parseNetworkGroups(entries []string) ([]NetworkGroup, error) {
+	var groups []NetworkGroup
+	for _, entry := range entries {
+		cidr, label, ok := strings.Cut(entry, "=")
+		cidr = strings.TrimSpace(cidr)
+		label = strings.TrimSpace(label)
+		if !ok || label == "" {
+			return nil, fmt.Errorf("NETWORK_GROUPS entry %q must be cidr=label", entry)
+		}
+		prefix, err := parsePrefix(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("NETWORK_GROUPS entry %q: %w", entry, err)
+		}
+		groups = append(groups, NetworkGroup{Prefix: prefix, Label: label})
+	}
+	return groups, nil
+}
+
+// parsePrefixes parses the CIDR list entries read from the setting key.
+func parsePrefixes(key string, entries []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, entry := range entries {
+		prefix, err := parsePrefix(entry)
+		if err != nil {
+			return nil, fmt.Errorf("%s entry %q: %w", key, entry, err)
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes, nil
+}
+
+// parsePrefix parses a CIDR, taking a bare address as a single-host prefix.
+func parsePrefix(cidr string) (netip.Prefix, error) {
+	prefix, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		addr, addrErr := netip.ParseAddr(cidr)
+		if addrErr != nil {
+			return netip.Prefix{}, err
+		}
+		prefix = netip.PrefixFrom(addr, addr.BitLen())
+	}
+	return prefix.Masked(), nil
+}
+
+// parseUserAgentRules parses entries of the form "internal/=*my-service*".
+// A prefix of "/" applies the pattern to every key.
+func parseUserAgentRules(entries []string) ([]UserAgentRule, error) {
+	var rules []UserAgentRule
+	for _, entry := range entries {
+		prefix, pattern, ok := strings.Cut(entry, "=")
+		prefix = strings.TrimSuffix(strings.TrimLeft(strings.TrimSpace(prefix), "/"), "*")
+		pattern = strings.TrimSpace(pattern)
+		if !ok || pattern == "" {
+			return nil, fmt.Errorf("USER_AGENT_ALLOW entry %q must be prefix=pattern", entry)
+		}
+		rules = append(rules, UserAgentRule{Prefix: prefix, Pattern: pattern})
+	}
+	return rules, nil
+}
+
+// parseCacheControlRules parses semicolon-separated entries of the form
+// "assets/**=public, max-age=31536000, immutable", since Cache-Control
+// values contain commas. A prefix of "/" applies to every key.
+func parseCacheControlRules(value string) ([]CacheControlRule, error) {
+	var rules []CacheControlRule
+	seen := make(map[string]bool)
+	for entry := range strings.SplitSeq(value, ";") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		prefix, control, ok := strings.Cut(entry, "=")
+		prefix = strings.TrimRight(strings.TrimLeft(strings.TrimSpace(prefix), "/"), "*")
+		control = strings.TrimSpace(control)
+		if !ok || control == "" {
+			return nil, fmt.Errorf("CACHE_CONTROL_RULES entry %q must be prefix=cache-control", entry)
+		}
+		if seen[prefix] {
+			return nil, fmt.Errorf("CACHE_CONTROL_RULES has duplicate prefix %q", prefix)
+		}
+		seen[prefix] = true
+		rules = append(rules, CacheControlRule{Prefix: prefix, Value: control})
+	}
+	return rules, nil
+}
+
+func loadWarmJobs(path string) ([]WarmJob, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read WARM_JOBS_FILE: %w", err)
+	}
+	var jobs []WarmJob
+	if err := json.Unmarshal(data, &jobs); err != nil {
+		return nil, fmt.Errorf("parse WARM_JOBS_FILE: %w", err)
+	}
+	for i, job := range jobs {
+		if job.Name == "" {
+			jobs[i].Name = fmt.Sprintf("job-%d", i+1)
+		}
+		if job.Prefix == "" && len(job.Keys) == 0 {
+			return nil, fmt.Errorf("warm job %q must define a prefix or keys", jobs[i].Name)
+		}
+		if _, err := cron.Parse(job.Schedule); err != nil {
+			return nil, fmt.Errorf("warm job %q: %w", jobs[i].Name, err)
+		}
+	}
+	return jobs, nil
+}
+
+func (l *loader) getString(key, def string) string {
+	l.declare(key, "string", def)
+	if v := l.lookupSetting(key); v != "" {
 		return v
 	}
 	return def
 }
 
-func getInt(key string, def int) int {
-	if v := os.Getenv(key); v != "" {
+func (l *loader) getInt(key string, def int) int {
+	l.declare(key, "integer", def)
+	if v := l.lookupSetting(key); v != "" {
 		if parsed, err := strconv.Atoi(v); err == nil {
 			return parsed
 		}
+		l.invalidSetting(key, v, "an integer")
 	}
 	return def
 }
 
-func getInt64(key string, def int64) int64 {
-	if v := os.Getenv(key); v != "" {
+func (l *loader) getInt64(key string, def int64) int64 {
+	l.declare(key, "integer", def)
+	if v := l.lookupSetting(key); v != "" {
 		if parsed, err := strconv.ParseInt(v, 10, 64); err == nil {
 			return parsed
 		}
+		l.invalidSetting(key, v, "an integer")
 	}
 	return def
 }
 
-func getFloat(key string, def float64) float64 {
-	if v := os.Getenv(key); v != "" {
+func (l *loader) getBool(key string, def bool) bool {
+	l.declare(key, "boolean", def)
+	if v := l.lookupSetting(key); v != "" {
+		if parsed, err := strconv.ParseBool(v); err == nil {
+			return parsed
+		}
+		l.invalidSetting(key, v, "a boolean")
+	}
+	return def
+}
+
+func (l *loader) getFloat(key string, def float64) float64 {
+	l.declare(key, "number", def)
+	if v := l.lookupSetting(key); v != "" {
 		if parsed, err := strconv.ParseFloat(v, 64); err == nil {
 			return parsed
 		}
+		l.invalidSetting(key, v, "a number")
 	}
 	return def
 }
 
-func getDuration(key string, def time.Duration) time.Duration {
-	if v := os.Getenv(key); v != "" {
+func (l *loader) getDuration(key string, def time.Duration) time.Duration {
+	l.declare(key, "duration", def)
+	if v := l.lookupSetting(key); v != "" {
 		dur, err := time.ParseDuration(v)
 		if err == nil {
 			return dur
 		}
+		l.invalidSetting(key, v, "a duration")
 	}
 	return def
 }
+
+func (l *loader) getList(key string, def []string) []string {
+	l.declare(key, "list", def)
+	v := l.lookupSetting(key)
+	if v == "" {
+		return def
+	}
+	var out []string
+	for part := range strings.SplitSeq(v, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+func hostname() string {
+	name, err := os.Hostname()
+	if err != nil {
+		return "unknown"
+	}
+	return name
+}
@@ -0,0 +1,125 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// EnvPrefix may be put in front of any setting, as in S3PROXY_CACHE_TTL, to
+// keep the proxy's variables apart from others in a shared environment. A
+// prefixed variable wins over the bare one.
+const EnvPrefix = "S3PROXY_"
+
+// lookupSetting returns the value of a setting from the flags, the
+// environment or the config file, in that order.
+func (l *loader) lookupSetting(key string) string {
+	if v, ok := l.flags[key]; ok {
+		return v
+	}
+	if v := os.Getenv(EnvPrefix + key); v != "" {
+		return v
+	}
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return l.file[key]
+}
+
+func (l *loader) settingSource(key string) string {
+	if _, ok := l.flags[key]; ok {
+		return "flag"
+	}
+	if os.Getenv(EnvPrefix+key) != "" || os.Getenv(key) != "" {
+		return "env"
+	}
+	if l.file[key] != "" {
+		return "file"
+	}
+	return "default"
+}
+
+// environ returns the config file's values and the environment, with
+// EnvPrefix removed from names. Entries come in increasing precedence, so
+// that later ones replace earlier ones when collected in order.
+func (l *loader) environ() []string {
+	var file, bare, prefixed []string
+	for name, value := range l.file {
+		file = append(file, name+"="+value)
+	}
+	sort.Strings(file)
+	for _, kv := range os.Environ() {
+		if name, ok := strings.CutPrefix(kv, EnvPrefix); ok {
+			prefixed = append(prefixed, name)
+		} else {
+			bare = append(bare, kv)
+		}
+	}
+	return slices.Concat(file, bare, prefixed)
+}
+
+// unknownSettings lists the prefixed variables in the environment that name
+// no setting Load has read. Since only the proxy uses the prefix, these are
+// most likely misspelled.
+func (l *loader) unknownSettings() []string {
+	known := make(map[string]bool)
+	for name := range l.byName {
+		known[name] = true
+	}
+	var unknown []string
+	for _, kv := range os.Environ() {
+		name, _, _ := strings.Cut(kv, "=")
+		key, ok := strings.CutPrefix(name, EnvPrefix)
+		if !ok || known[key] || errorPageSetting(key) {
+			continue
+		}
+		problem := fmt.Sprintf("%s is not a known setting", name)
+		if guess := closestSetting(key, known); guess != "" {
+			problem += fmt.Sprintf(" (did you mean %s%s?)", EnvPrefix, guess)
+		}
+		unknown = append(unknown, problem)
+	}
+	return unknown
+}
+
+func errorPageSetting(key string) bool {
+	status, ok := strings.CutPrefix(key, "ERROR_")
+	if !ok {
+		return false
+	}
+	_, ok = strings.CutSuffix(status, "_KEY")
+	return ok
+}
+
+// closestSetting returns the known setting within two edits of key, if any.
+func closestSetting(key string, known map[string]bool) string {
+	best, bestDist := "", 3
+	for name := range known {
+		if d := editDistance(key, name); d < bestDist || (d == bestDist && name < best) {
+			best, bestDist = name, d
+		}
+	}
+	return best
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
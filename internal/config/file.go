@@ -0,0 +1,155 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.yaml.in/yaml/v2"
+)
+
+// sectionPrefixes are the environment prefixes a file section's keys may
+// stand for besides SECTION_. Keys are also tried without any prefix, so
+// that server.path_prefix is PATH_PREFIX and auth.signing_secret is
+// SIGNING_SECRET.
+var sectionPrefixes = map[string]string{
+	"origin": "S3_",
+}
+
+// fileEntry is a value from the config file with the environment names it
+// may stand for, most specific first.
+type fileEntry struct {
+	path  string
+	value string
+	names []string
+}
+
+// readConfigFile reads a YAML (or JSON) file of settings. Keys are setting
+// names in lower case, at the top level or nested in sections:
+//
+//	server:
+//	  addr: ":8080"
+//	cache:
+//	  ttl: 5m
+//	origin:
+//	  endpoint: https://s3.amazonaws.com
+//	  bucket: files
+//	auth:
+//	  token: secret
+//
+// Lists are joined with commas, as they would be in the environment.
+func readConfigFile(path string) ([]fileEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read config file: %w", err)
+	}
+	var doc map[string]any
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("parse config file %s: %w", path, err)
+	}
+	var entries []fileEntry
+	if err := flattenFile(&entries, nil, doc); err != nil {
+		return nil, fmt.Errorf("config file %s: %w", path, err)
+	}
+	return entries, nil
+}
+
+func flattenFile(entries *[]fileEntry, parents []string, value any) error {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, child := range v {
+			if err := flattenFile(entries, append(slices.Clip(parents), key), child); err != nil {
+				return err
+			}
+		}
+		return nil
+	case map[any]any:
+		for key, child := range v {
+			if err := flattenFile(entries, append(slices.Clip(parents), fmt.Sprint(key)), child); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	path := strings.Join(parents, ".")
+	s, err := fileValue(value)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	*entries = append(*entries, fileEntry{path: path, value: s, names: fileNames(parents)})
+	return nil
+}
+
+func fileValue(value any) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case uint64:
+		return strconv.FormatUint(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case []any:
+		parts := make([]string, len(v))
+		for i, item := range v {
+			s, err := fileValue(item)
+			if err != nil {
+				return "", err
+			}
+			if strings.Contains(s, ",") {
+				return "", fmt.Errorf("list item %q contains a comma", s)
+			}
+			parts[i] = s
+		}
+		return strings.Join(parts, ","), nil
+	}
+	return "", fmt.Errorf("unsupported value %v", value)
+}
+
+func fileNames(parents []string) []string {
+	name := strings.ToUpper(strings.Join(parents, "_"))
+	if len(parents) < 2 {
+		return []string{name}
+	}
+	rest := strings.ToUpper(strings.Join(parents[1:], "_"))
+	names := []string{name}
+	if prefix, ok := sectionPrefixes[parents[0]]; ok {
+		names = append(names, prefix+rest)
+	}
+	return append(names, rest)
+}
+
+// unknownFileSettings lists file entries that stand for no setting Load has
+// read.
+func (l *loader) unknownFileSettings(entries []fileEntry) []string {
+	known := make(map[string]bool)
+	for name := range l.byName {
+		known[name] = true
+	}
+	var unknown []string
+	for _, e := range entries {
+		found := false
+		for _, name := range e.names {
+			found = found || known[name] || errorPageSetting(name)
+		}
+		if !found {
+			problem := fmt.Sprintf("config file key %s is not a known setting", e.path)
+			if guess := closestSetting(e.names[0], known); guess != "" {
+				problem += fmt.Sprintf(" (did you mean %s?)", strings.ToLower(guess))
+			}
+			unknown = append(unknown, problem)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
@@ -0,0 +1,50 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+)
+
+// RegisterFlags defines a flag for every setting on fs, named after it in
+// lower case with dashes (-cache-ttl for CACHE_TTL), and returns the map the
+// flags given fill in, for Sources.Flags. Settings starting with S3_ or
+// SERVER_ can also be given without that prefix, as -bucket or -addr.
+func RegisterFlags(fs *flag.FlagSet) map[string]string {
+	values := make(map[string]string)
+	_, all, _ := Describe(Sources{})
+	taken := make(map[string]bool, len(all))
+	for _, s := range all {
+		taken[flagName(s.Name)] = true
+	}
+	for _, s := range all {
+		name := flagName(s.Name)
+		usage := fmt.Sprintf("`%s` setting %s", s.Type, s.Name)
+		if s.Default != "" {
+			usage += fmt.Sprintf(" (default %q)", redact(s.Name, s.Default))
+		}
+		define(fs, s, name, usage, values)
+		for _, prefix := range []string{"S3_", "SERVER_"} {
+			if short, ok := strings.CutPrefix(s.Name, prefix); ok && !taken[flagName(short)] {
+				define(fs, s, flagName(short), fmt.Sprintf("`%s` alias for -%s", s.Type, name), values)
+			}
+		}
+	}
+	return values
+}
+
+func define(fs *flag.FlagSet, s Setting, name, usage string, values map[string]string) {
+	set := func(v string) error {
+		values[s.Name] = v
+		return nil
+	}
+	if s.Type == "boolean" {
+		fs.BoolFunc(name, usage, set)
+		return
+	}
+	fs.Func(name, usage, set)
+}
+
+func flagName(setting string) string {
+	return strings.ReplaceAll(strings.ToLower(setting), "_", "-")
+}
@@ -0,0 +1,111 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+)
+
+// Rules are the settings that can be reloaded at runtime from RULES_URL.
+type Rules struct {
+	BucketRoutes       []BucketRoute
+	MicroCachePrefixes []string
+	UserAgentDeny      []string
+	UserAgentAllow     []UserAgentRule
+	Rewrites           []RewriteRule
+}
+
+// RewriteRule maps request paths matching Pattern, a regular expression
+// matched against the whole path, to the object key Replacement, which may
+// refer to capture groups as $1 or ${name}.
+type RewriteRule struct {
+	Pattern     string `json:"pattern"`
+	Replacement string `json:"replacement"`
+}
+
+// rulesDocument is the JSON form of Rules. Entries use the same syntax as
+// the corresponding environment variables.
+type rulesDocument struct {
+	BucketRoutes       *[]string      `json:"bucket_routes"`
+	MicroCachePrefixes *[]string      `json:"micro_cache_prefixes"`
+	UserAgentDeny      *[]string      `json:"user_agent_deny"`
+	UserAgentAllow     *[]string      `json:"user_agent_allow"`
+	Rewrites           *[]RewriteRule `json:"rewrites"`
+}
+
+// Rules returns the reloadable settings as configured by the environment.
+func (c *Config) Rules() Rules {
+	return Rules{
+		BucketRoutes:       c.BucketRoutes,
+		MicroCachePrefixes: c.MicroCachePrefixes,
+		UserAgentDeny:      c.UserAgentDeny,
+		UserAgentAllow:     c.UserAgentAllow,
+		Rewrites:           c.Rewrites,
+	}
+}
+
+// ParseRules parses a rules document. Settings the document leaves out keep
+// their value in base.
+func ParseRules(data []byte, base Rules) (Rules, error) {
+	var doc rulesDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return Rules{}, fmt.Errorf("parse rules: %w", err)
+	}
+	rules := base
+	if doc.BucketRoutes != nil {
+		routes, err := parseBucketRoutes(*doc.BucketRoutes)
+		if err != nil {
+			return Rules{}, err
+		}
+		rules.BucketRoutes = routes
+	}
+	if doc.MicroCachePrefixes != nil {
+		rules.MicroCachePrefixes = *doc.MicroCachePrefixes
+	}
+	if doc.UserAgentDeny != nil {
+		rules.UserAgentDeny = *doc.UserAgentDeny
+	}
+	if doc.UserAgentAllow != nil {
+		agents, err := parseUserAgentRules(*doc.UserAgentAllow)
+		if err != nil {
+			return Rules{}, err
+		}
+		rules.UserAgentAllow = agents
+	}
+	if doc.Rewrites != nil {
+		if err := checkRewriteRules(*doc.Rewrites); err != nil {
+			return Rules{}, err
+		}
+		rules.Rewrites = *doc.Rewrites
+	}
+	return rules, nil
+}
+
+// LoadRewriteRules reads a JSON array of rewrite rules from path.
+func LoadRewriteRules(path string) ([]RewriteRule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read REWRITE_RULES_FILE: %w", err)
+	}
+	var rules []RewriteRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("parse REWRITE_RULES_FILE: %w", err)
+	}
+	if err := checkRewriteRules(rules); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+func checkRewriteRules(rules []RewriteRule) error {
+	for _, rule := range rules {
+		if rule.Pattern == "" || rule.Replacement == "" {
+			return fmt.Errorf("rewrite rule %q must define a pattern and a replacement", rule.Pattern)
+		}
+		if _, err := regexp.Compile(rule.Pattern); err != nil {
+			return fmt.Errorf("rewrite rule %q: %w", rule.Pattern, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Setting describes an environment variable read by Load.
+type Setting struct {
+	Name string
+	// Type is "string", "integer", "number", "boolean", "duration" or
+	// "list" (comma-separated).
+	Type string
+	// Default is the value used when the variable is unset, in the form it
+	// would be set in.
+	Default string
+	// Source is "flag", "env" or "file" for where the value came from, or
+	// "default".
+	Source string
+	// Value is what Load read, before parsing, or Default. It may be a
+	// secret; see Redacted.
+	Value string
+}
+
+// secretSuffixes mark the settings whose values are credentials.
+var secretSuffixes = []string{"_TOKEN", "_SECRET", "_SECRET_KEY", "_ACCESS_KEY", "_PASSWORD"}
+
+// Redacted returns Value with credentials hidden: whole values of secret
+// settings, and passwords in URLs.
+func (s Setting) Redacted() string {
+	return redact(s.Name, s.Value)
+}
+
+// redact hides credentials in a value of the setting name. Defaults need it
+// too, since a replica's credentials default to the primary's.
+func redact(name, value string) string {
+	if value == "" {
+		return ""
+	}
+	for _, suffix := range secretSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return "REDACTED"
+		}
+	}
+	parts := strings.Split(value, ",")
+	for i, part := range parts {
+		if u, err := url.Parse(strings.TrimSpace(part)); err == nil && u.User != nil {
+			parts[i] = u.Redacted()
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// loader holds the state of one Load: the values from the config file and
+// flags, and every setting as it is read. Each Load has its own, so that a
+// reload on SIGHUP shares nothing with the configuration in use.
+type loader struct {
+	byName  map[string]Setting
+	invalid []string
+	file    map[string]string
+	flags   map[string]string
+}
+
+func newLoader(entries []fileEntry, flags map[string]string) *loader {
+	l := &loader{byName: make(map[string]Setting), file: make(map[string]string), flags: flags}
+	for _, e := range entries {
+		for _, name := range e.names {
+			l.file[name] = e.value
+		}
+	}
+	return l
+}
+
+// invalidSetting records a value that did not parse, for Load to report.
+func (l *loader) invalidSetting(key, value, want string) {
+	l.invalid = append(l.invalid, fmt.Sprintf("%s=%q is not %s", key, value, want))
+}
+
+// declare records a setting as Load reads it, so that the schema follows the
+// code instead of a list kept next to it.
+func (l *loader) declare(key, typ string, def any) {
+	source := l.settingSource(key)
+	var value string
+	switch v := def.(type) {
+	case string:
+		value = v
+	case int:
+		value = strconv.Itoa(v)
+	case int64:
+		value = strconv.FormatInt(v, 10)
+	case bool:
+		value = strconv.FormatBool(v)
+	case float64:
+		value = strconv.FormatFloat(v, 'f', -1, 64)
+	case time.Duration:
+		value = v.String()
+	case []string:
+		value = strings.Join(v, ",")
+	}
+	current := value
+	if source != "default" {
+		current = l.lookupSetting(key)
+	}
+	l.byName[key] = Setting{Name: key, Type: typ, Default: value, Source: source, Value: current}
+}
+
+// settings returns the settings read so far, sorted by name.
+func (l *loader) settings() []Setting {
+	out := make([]Setting, 0, len(l.byName))
+	for _, s := range l.byName {
+		out = append(out, s)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// valuePatterns constrain the string an environment variable holds to what
+// its type parses.
+var valuePatterns = map[string]string{
+	"integer":  `^-?[0-9]+$`,
+	"number":   `^-?([0-9]+(\.[0-9]*)?|\.[0-9]+)$`,
+	"boolean":  `^(1|t|T|TRUE|true|True|0|f|F|FALSE|false|False)$`,
+	"duration": `^(0|-?(([0-9]+(\.[0-9]*)?|\.[0-9]+)(ns|us|µs|ms|s|m|h))+)$`,
+}
+
+// Schema returns a JSON Schema for the environment of a deployment with the
+// given settings, as Load returns them in Config.Settings. Values are
+// described as the strings they are set as, with the type they parse to and
+// the source of the running value under "x-type" and "x-source". Variables
+// the proxy does not read are allowed.
+func Schema(settings []Setting) map[string]any {
+	properties := make(map[string]any)
+	for _, s := range settings {
+		prop := map[string]any{
+			"type":     "string",
+			"default":  redact(s.Name, s.Default),
+			"x-type":   s.Type,
+			"x-source": s.Source,
+		}
+		if pattern, ok := valuePatterns[s.Type]; ok {
+			prop["pattern"] = pattern
+		}
+		properties[s.Name] = prop
+	}
+	return map[string]any{
+		"$schema":    "https://json-schema.org/draft/2020-12/schema",
+		"title":      "s3-proxy environment",
+		"type":       "object",
+		"properties": properties,
+		"patternProperties": map[string]any{
+			`^ERROR_([45][0-9][0-9]|[45]XX|[45]xx)_KEY$`: map[string]any{"type": "string", "x-type": "string"},
+		},
+		"additionalProperties": true,
+	}
+}
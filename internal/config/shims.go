@@ -0,0 +1,53 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Protocol shims understood by PROTOCOL_SHIMS.
+const (
+	ShimGo  = "go"
+	ShimNPM = "npm"
+)
+
+// ProtocolShim serves the package protocol Kind under the URL path Mount,
+// from keys under Prefix.
+type ProtocolShim struct {
+	Kind   string
+	Mount  string
+	Prefix string
+}
+
+// parseProtocolShims parses entries of the form "go=/go/" or
+// "npm=/npm/=mirrors/npm/". The key prefix defaults to the mount path.
+func parseProtocolShims(entries []string) ([]ProtocolShim, error) {
+	var shims []ProtocolShim
+	mounts := map[string]bool{}
+	for _, entry := range entries {
+		parts := strings.SplitN(entry, "=", 3)
+		if len(parts) < 2 {
+			return nil, fmt.Errorf("PROTOCOL_SHIMS entry %q must be kind=/mount/", entry)
+		}
+		kind := strings.TrimSpace(parts[0])
+		if kind != ShimGo && kind != ShimNPM {
+			return nil, fmt.Errorf("PROTOCOL_SHIMS entry %q: unknown protocol %q", entry, kind)
+		}
+		mount := "/" + strings.Trim(strings.TrimSpace(parts[1]), "/") + "/"
+		if mount == "//" {
+			return nil, fmt.Errorf("PROTOCOL_SHIMS entry %q: mount must not be /", entry)
+		}
+		if mounts[mount] {
+			return nil, fmt.Errorf("PROTOCOL_SHIMS mount %q is used twice", mount)
+		}
+		mounts[mount] = true
+		prefix := strings.TrimPrefix(mount, "/")
+		if len(parts) == 3 {
+			if prefix = strings.Trim(strings.TrimSpace(parts[2]), "/"); prefix != "" {
+				prefix += "/"
+			}
+		}
+		shims = append(shims, ProtocolShim{Kind: kind, Mount: mount, Prefix: prefix})
+	}
+	return shims, nil
+}
@@ -0,0 +1,180 @@
+package jwt
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// minRefresh bounds how often a token with an unknown key ID can make the
+// key set be fetched again.
+const minRefresh = time.Minute
+
+// JWKS is a JSON Web Key Set fetched from a URL. Keys are refreshed
+// periodically by Run, and on demand when a token names a key the set does
+// not have, so signing keys can be rotated without restarting.
+type JWKS struct {
+	url     string
+	timeout time.Duration
+	client  *http.Client
+	keys    atomic.Pointer[map[string]any]
+
+	mu      sync.Mutex
+	fetched time.Time
+}
+
+func NewJWKS(url string, timeout time.Duration) *JWKS {
+	return &JWKS{url: url, timeout: timeout, client: &http.Client{}}
+}
+
+// Run refreshes the key set every interval until ctx is done.
+func (j *JWKS) Run(ctx context.Context, interval time.Duration, onError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := j.Refresh(ctx); err != nil {
+				onError(err)
+			}
+		}
+	}
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// Refresh fetches the key set. Keys of unsupported types are skipped.
+func (j *JWKS) Refresh(ctx context.Context) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.refresh(ctx)
+}
+
+func (j *JWKS) refresh(ctx context.Context) error {
+	j.fetched = time.Now()
+	ctx, cancel := context.WithTimeout(ctx, j.timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, j.url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := j.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("fetch jwks: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetch jwks: unexpected status %s", resp.Status)
+	}
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return fmt.Errorf("parse jwks: %w", err)
+	}
+	keys := make(map[string]any, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if key, err := k.publicKey(); err == nil {
+			keys[k.Kid] = key
+		}
+	}
+	j.keys.Store(&keys)
+	return nil
+}
+
+// key returns the key with ID kid, refetching the set once if it is not
+// known and the set was not fetched within minRefresh.
+func (j *JWKS) key(kid string) (any, error) {
+	if keys := j.keys.Load(); keys != nil {
+		if key, ok := (*keys)[kid]; ok {
+			return key, nil
+		}
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if keys := j.keys.Load(); keys != nil {
+		if key, ok := (*keys)[kid]; ok {
+			return key, nil
+		}
+	}
+	if time.Since(j.fetched) >= minRefresh {
+		if err := j.refresh(context.Background()); err != nil {
+			return nil, err
+		}
+		if key, ok := (*j.keys.Load())[kid]; ok {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: unknown key %q", ErrSignature, kid)
+}
+
+func (k jsonWebKey) publicKey() (any, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeInt(k.E)
+		if err != nil || !e.IsInt64() {
+			return nil, fmt.Errorf("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("EC point not on curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+func decodeInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, fmt.Errorf("invalid key parameter")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
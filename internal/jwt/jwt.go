@@ -0,0 +1,187 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	_ "crypto/sha256" // registers SHA-256 for crypto.Hash
+	_ "crypto/sha512" // registers SHA-384 and SHA-512
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"math/big"
+	"strings"
+	"time"
+)
+
+var (
+	ErrMalformed = errors.New("malformed token")
+	ErrSignature = errors.New("invalid token signature")
+	ErrExpired   = errors.New("token expired")
+)
+
+// Leeway is the clock skew allowed when checking exp and nbf.
+const Leeway = 30 * time.Second
+
+type Claims map[string]any
+
+// Strings returns the claim name as a list, accepting a single string or an
+// array of strings.
+func (c Claims) Strings(name string) []string {
+	switch v := c[name].(type) {
+	case string:
+		return []string{v}
+	case []any:
+		var out []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+func (c Claims) time(name string) (time.Time, bool) {
+	n, ok := c[name].(json.Number)
+	if !ok {
+		return time.Time{}, false
+	}
+	secs, err := n.Float64()
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(secs), 0), true
+}
+
+// Verifier checks tokens signed with a shared secret (HS256, HS384, HS512)
+// or with a key from a JWKS (RS256, RS384, RS512, ES256, ES384, ES512). Each
+// kind of key only verifies its own algorithms, so a public key can never be
+// used as an HMAC secret.
+type Verifier struct {
+	secret []byte
+	jwks   *JWKS
+}
+
+func NewVerifier(secret []byte, jwks *JWKS) *Verifier {
+	return &Verifier{secret: secret, jwks: jwks}
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// Verify checks the signature of token and that it has an exp claim that
+// has not passed, and an nbf claim, if any, that has.
+func (v *Verifier) Verify(token string, now time.Time) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformed
+	}
+	var h header
+	if err := decodeSegment(parts[0], &h); err != nil {
+		return nil, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	if err := v.verifySignature(h, parts[0]+"."+parts[1], sig); err != nil {
+		return nil, err
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	exp, ok := claims.time("exp")
+	if !ok {
+		return nil, fmt.Errorf("%w: missing exp", ErrMalformed)
+	}
+	if now.After(exp.Add(Leeway)) {
+		return nil, ErrExpired
+	}
+	if nbf, ok := claims.time("nbf"); ok && now.Add(Leeway).Before(nbf) {
+		return nil, fmt.Errorf("%w: not valid yet", ErrExpired)
+	}
+	return claims, nil
+}
+
+func (v *Verifier) verifySignature(h header, signed string, sig []byte) error {
+	hashFunc, err := algorithmHash(h.Alg)
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(h.Alg, "HS") {
+		if len(v.secret) == 0 {
+			return fmt.Errorf("%w: %s not accepted", ErrSignature, h.Alg)
+		}
+		mac := hmac.New(hashFunc.New, v.secret)
+		mac.Write([]byte(signed))
+		if !hmac.Equal(sig, mac.Sum(nil)) {
+			return ErrSignature
+		}
+		return nil
+	}
+	if v.jwks == nil {
+		return fmt.Errorf("%w: %s not accepted", ErrSignature, h.Alg)
+	}
+	key, err := v.jwks.key(h.Kid)
+	if err != nil {
+		return err
+	}
+	digest := hashSum(hashFunc.New(), signed)
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(h.Alg, "RS") || rsa.VerifyPKCS1v15(key, hashFunc, digest, sig) != nil {
+			return ErrSignature
+		}
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if !strings.HasPrefix(h.Alg, "ES") || len(sig) != 2*size {
+			return ErrSignature
+		}
+		r, s := new(big.Int).SetBytes(sig[:size]), new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(key, digest, r, s) {
+			return ErrSignature
+		}
+	default:
+		return ErrSignature
+	}
+	return nil
+}
+
+func algorithmHash(alg string) (crypto.Hash, error) {
+	switch alg {
+	case "HS256", "RS256", "ES256":
+		return crypto.SHA256, nil
+	case "HS384", "RS384", "ES384":
+		return crypto.SHA384, nil
+	case "HS512", "RS512", "ES512":
+		return crypto.SHA512, nil
+	}
+	return 0, fmt.Errorf("%w: unsupported algorithm %q", ErrSignature, alg)
+}
+
+func hashSum(h hash.Hash, s string) []byte {
+	h.Write([]byte(s))
+	return h.Sum(nil)
+}
+
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return ErrMalformed
+	}
+	dec := json.NewDecoder(strings.NewReader(string(data)))
+	dec.UseNumber()
+	if err := dec.Decode(v); err != nil {
+		return ErrMalformed
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func segment(v any) string {
+	data, _ := json.Marshal(v)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func signHS256(secret string, claims map[string]any) string {
+	signed := segment(map[string]string{"alg": "HS256", "typ": "JWT"}) + "." + segment(claims)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifyHMAC(t *testing.T) {
+	now := time.Now()
+	v := NewVerifier([]byte("secret"), nil)
+	claims, err := v.Verify(signHS256("secret", map[string]any{"sub": "ada", "exp": now.Add(time.Minute).Unix()}), now)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if claims.Strings("sub")[0] != "ada" {
+		t.Fatalf("unexpected claims %v", claims)
+	}
+	if _, err := v.Verify(signHS256("other", map[string]any{"exp": now.Add(time.Minute).Unix()}), now); !errors.Is(err, ErrSignature) {
+		t.Fatalf("expected a signature error, got %v", err)
+	}
+	if _, err := v.Verify(signHS256("secret", map[string]any{"exp": now.Add(-time.Hour).Unix()}), now); !errors.Is(err, ErrExpired) {
+		t.Fatalf("expected an expired token, got %v", err)
+	}
+	if _, err := v.Verify(signHS256("secret", map[string]any{"sub": "ada"}), now); !errors.Is(err, ErrMalformed) {
+		t.Fatalf("expected a token without exp to be rejected, got %v", err)
+	}
+	unsigned := segment(map[string]string{"alg": "none"}) + "." + segment(map[string]any{"exp": now.Add(time.Minute).Unix()}) + "."
+	if _, err := v.Verify(unsigned, now); err == nil {
+		t.Fatalf("expected an unsigned token to be rejected")
+	}
+}
+
+func TestVerifyJWKS(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	b64 := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+	fetches := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		fmt.Fprintf(w, `{"keys": [
+			{"kty": "RSA", "kid": "rsa", "n": %q, "e": %q},
+			{"kty": "EC", "kid": "ec", "crv": "P-256", "x": %q, "y": %q}
+		]}`, b64(rsaKey.N.Bytes()), b64(big.NewInt(int64(rsaKey.E)).Bytes()), b64(ecKey.X.FillBytes(make([]byte, 32))), b64(ecKey.Y.FillBytes(make([]byte, 32))))
+	}))
+	defer srv.Close()
+
+	now := time.Now()
+	claims := segment(map[string]any{"exp": now.Add(time.Minute).Unix()})
+	digest := func(signed string) []byte {
+		sum := sha256.Sum256([]byte(signed))
+		return sum[:]
+	}
+
+	rsaSigned := segment(map[string]string{"alg": "RS256", "kid": "rsa"}) + "." + claims
+	rsaSig, _ := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest(rsaSigned))
+	ecSigned := segment(map[string]string{"alg": "ES256", "kid": "ec"}) + "." + claims
+	r, s, _ := ecdsa.Sign(rand.Reader, ecKey, digest(ecSigned))
+	ecSig := append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+
+	v := NewVerifier(nil, NewJWKS(srv.URL, time.Second))
+	for _, token := range []string{rsaSigned + "." + b64(rsaSig), ecSigned + "." + b64(ecSig)} {
+		if _, err := v.Verify(token, now); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if fetches != 1 {
+		t.Fatalf("expected the key set to be fetched once, got %d", fetches)
+	}
+
+	// The RSA modulus must not be usable as an HMAC secret.
+	confused := segment(map[string]string{"alg": "HS256", "kid": "rsa"}) + "." + claims
+	mac := hmac.New(sha256.New, rsaKey.N.Bytes())
+	mac.Write([]byte(confused))
+	if _, err := v.Verify(confused+"."+b64(mac.Sum(nil)), now); !errors.Is(err, ErrSignature) {
+		t.Fatalf("expected HS256 to be refused without a secret, got %v", err)
+	}
+	if _, err := v.Verify(segment(map[string]string{"alg": "RS256", "kid": "missing"})+"."+claims+"."+b64(rsaSig), now); !errors.Is(err, ErrSignature) {
+		t.Fatalf("expected an unknown key to be refused, got %v", err)
+	}
+	if fetches != 1 {
+		t.Fatalf("expected unknown keys not to refetch within a minute, got %d fetches", fetches)
+	}
+}
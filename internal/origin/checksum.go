@@ -0,0 +1,56 @@
+package origin
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// EnableChecksums makes the client request the object checksums S3 stores
+// and return them as response headers: x-amz-checksum-* as S3 reports them,
+// plus the standard Digest and Repr-Digest fields for algorithms those
+// fields define. Clients derived from c for routed buckets inherit it.
+func (c *Client) EnableChecksums() {
+	c.checksums = true
+}
+
+type checksums struct {
+	typ       types.ChecksumType
+	crc32     *string
+	crc32c    *string
+	crc64nvme *string
+	sha1      *string
+	sha256    *string
+}
+
+func (cs checksums) setHeaders(h http.Header) {
+	setHeader(h, "x-amz-checksum-crc32", aws.ToString(cs.crc32))
+	setHeader(h, "x-amz-checksum-crc32c", aws.ToString(cs.crc32c))
+	setHeader(h, "x-amz-checksum-crc64nvme", aws.ToString(cs.crc64nvme))
+	setHeader(h, "x-amz-checksum-sha1", aws.ToString(cs.sha1))
+	setHeader(h, "x-amz-checksum-sha256", aws.ToString(cs.sha256))
+	setHeader(h, "x-amz-checksum-type", string(cs.typ))
+
+	// Checksums of multipart uploads are checksums of the part checksums,
+	// not of the object, and cannot be offered as a digest.
+	if cs.typ == types.ChecksumTypeComposite {
+		return
+	}
+	var digests []string
+	for _, d := range []struct {
+		name  string
+		value *string
+	}{{"SHA-256", cs.sha256}, {"SHA", cs.sha1}, {"CRC32c", cs.crc32c}} {
+		if v := aws.ToString(d.value); v != "" && !strings.Contains(v, "-") {
+			digests = append(digests, d.name+"="+v)
+		}
+	}
+	setHeader(h, "Digest", strings.Join(digests, ","))
+	// RFC 9530 retires SHA-1 and CRC32c, leaving SHA-256 as the only
+	// algorithm S3 and Repr-Digest share.
+	if v := aws.ToString(cs.sha256); v != "" && !strings.Contains(v, "-") {
+		h.Set("Repr-Digest", "sha-256=:"+v+":")
+	}
+}
@@ -0,0 +1,57 @@
+package origin
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestChecksumHeaders(t *testing.T) {
+	const sha256 = "n4bQgYhMfWWaL+qgxVrQFaO/TxsrC4Is0V1sFbDwCgg="
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("x-amz-checksum-mode") != "ENABLED" {
+			http.Error(w, "checksum mode not requested", http.StatusBadRequest)
+			return
+		}
+		switch r.URL.Path {
+		case "/b/whole":
+			w.Header().Set("x-amz-checksum-sha256", sha256)
+			w.Header().Set("x-amz-checksum-crc64nvme", "AAAAAAAAAAA=")
+			w.Header().Set("x-amz-checksum-type", "FULL_OBJECT")
+		case "/b/parts":
+			w.Header().Set("x-amz-checksum-sha256", sha256+"-3")
+			w.Header().Set("x-amz-checksum-type", "COMPOSITE")
+		}
+	}))
+	defer srv.Close()
+
+	c, err := New(context.Background(), srv.URL, "us-east-1", "key", "secret", "b", time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	c.EnableChecksums()
+
+	obj, err := c.HeadObject(context.Background(), "whole", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := obj.Headers.Get("Repr-Digest"); got != "sha-256=:"+sha256+":" {
+		t.Fatalf("unexpected Repr-Digest %q", got)
+	}
+	if got := obj.Headers.Get("Digest"); got != "SHA-256="+sha256 {
+		t.Fatalf("unexpected Digest %q", got)
+	}
+	if obj.Headers.Get("x-amz-checksum-crc64nvme") == "" || obj.Headers.Get("x-amz-checksum-type") != "FULL_OBJECT" {
+		t.Fatalf("expected the S3 checksum headers to pass through, got %v", obj.Headers)
+	}
+
+	obj, err = c.withBucket("b").HeadObject(context.Background(), "parts", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if obj.Headers.Get("x-amz-checksum-sha256") == "" || obj.Headers.Get("Digest") != "" || obj.Headers.Get("Repr-Digest") != "" {
+		t.Fatalf("expected a composite checksum without digest fields, got %v", obj.Headers)
+	}
+}
@@ -0,0 +1,264 @@
+package origin
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	smithymiddleware "github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// Compat names the kind of server an origin runs, for behaviour that
+// differs from AWS S3.
+type Compat string
+
+const (
+	CompatAWS   Compat = "aws"
+	CompatMinIO Compat = "minio"
+	CompatCeph  Compat = "ceph"
+	CompatR2    Compat = "r2"
+	CompatB2    Compat = "b2"
+)
+
+type compatProfile struct {
+	// errorCodes translate error codes of the server's own.
+	errorCodes map[string]error
+	// headerPrefix marks the response headers of the server's extensions,
+	// which are passed on with objects.
+	headerPrefix string
+	// quoteETags puts quotes around ETags sent without them, as some
+	// versions and gateways do, so that they compare equal to the quoted
+	// ETags clients send back in If-None-Match.
+	quoteETags bool
+	// sha1Header names a header with the hex SHA-1 of the object, offered
+	// as x-amz-checksum-sha1 when checksums are enabled and the server
+	// reports none of its own.
+	sha1Header string
+	// resumeRanges reads the rest of a range the server ends early, as
+	// servers that cap the size of a range response do.
+	resumeRanges bool
+}
+
+var compatProfiles = map[Compat]compatProfile{
+	CompatAWS: {},
+	CompatMinIO: {
+		errorCodes: map[string]error{
+			// Keys MinIO cannot store, such as ones with "//", cannot
+			// exist either.
+			"XMinioInvalidObjectName": ErrNotFound,
+		},
+		headerPrefix: "X-Minio-",
+		quoteETags:   true,
+	},
+	CompatCeph: {
+		headerPrefix: "X-Rgw-",
+		quoteETags:   true,
+	},
+	CompatR2: {
+		quoteETags: true,
+	},
+	CompatB2: {
+		headerPrefix: "X-Bz-",
+		quoteETags:   true,
+		sha1Header:   "X-Bz-Content-Sha1",
+		resumeRanges: true,
+	},
+}
+
+// SetCompat adapts c to the server named by compat. Unknown names are
+// treated as AWS. Clients derived from c for routed buckets inherit it.
+func (c *Client) SetCompat(compat Compat) {
+	c.compat = compatProfiles[compat]
+}
+
+// originError translates err, with the server's own error codes first.
+func (c *Client) originError(err error) error {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		if mapped, ok := c.compat.errorCodes[apiErr.ErrorCode()]; ok {
+			return mapped
+		}
+	}
+	return translateError(err)
+}
+
+// adapt applies the profile to an object read from the server.
+func (c *Client) adapt(obj *Object, md smithymiddleware.Metadata) {
+	if c.compat.quoteETags && obj.ETag != "" {
+		obj.ETag = c.etag(obj.ETag)
+		obj.Headers.Set("ETag", obj.ETag)
+	}
+	if c.compat.headerPrefix == "" && c.compat.sha1Header == "" {
+		return
+	}
+	raw, ok := awsmiddleware.GetRawResponse(md).(*smithyhttp.Response)
+	if !ok || raw.Response == nil {
+		return
+	}
+	if c.compat.headerPrefix != "" {
+		for name, values := range raw.Header {
+			if strings.HasPrefix(http.CanonicalHeaderKey(name), c.compat.headerPrefix) && len(values) > 0 && validHeaderValue(values[0]) {
+				obj.Headers.Set(name, values[0])
+			}
+		}
+	}
+	// The SHA-1 is of the whole object, not of a range of it.
+	if c.checksums && c.compat.sha1Header != "" && obj.StatusCode == http.StatusOK {
+		setSHA1(obj.Headers, raw.Header.Get(c.compat.sha1Header))
+	}
+}
+
+// setSHA1 offers the hex SHA-1 value as a checksum and digest, unless the
+// server reported an S3 SHA-1 checksum already. Values that are not a plain
+// SHA-1, such as B2's "none" for large files and "unverified:" for ones it
+// did not check itself, are ignored.
+func setSHA1(h http.Header, value string) {
+	if h.Get("x-amz-checksum-sha1") != "" || h.Get("x-amz-checksum-type") == string(types.ChecksumTypeComposite) {
+		return
+	}
+	sum, err := hex.DecodeString(value)
+	if err != nil || len(sum) != sha1.Size {
+		return
+	}
+	v := base64.StdEncoding.EncodeToString(sum)
+	h.Set("x-amz-checksum-sha1", v)
+	if d := h.Get("Digest"); d != "" {
+		h.Set("Digest", d+",SHA="+v)
+	} else {
+		h.Set("Digest", "SHA="+v)
+	}
+}
+
+// resumeRange makes obj cover all of the range cond asked for when the
+// server sent less, by reading the rest of it with further requests for the
+// same ETag.
+func (c *Client) resumeRange(ctx context.Context, key string, cond *Conditional, obj *Object) {
+	first, last, ok := parseRange(cond.Range)
+	if !ok || obj.ETag == "" {
+		return
+	}
+	start, end, size, ok := parseContentRange(obj.ContentRange)
+	if !ok || start != first {
+		return
+	}
+	if last < 0 || last >= size {
+		last = size - 1
+	}
+	if end >= last {
+		return
+	}
+	obj.Body = &resumingBody{ReadCloser: obj.Body, c: c, ctx: ctx, key: key, etag: obj.ETag, next: start, last: last}
+	obj.ContentLength = last - start + 1
+	obj.ContentRange = fmt.Sprintf("bytes %d-%d/%d", start, last, size)
+	obj.Headers.Set("Content-Length", strconv.FormatInt(obj.ContentLength, 10))
+	obj.Headers.Set("Content-Range", obj.ContentRange)
+}
+
+// resumingBody reads bytes next through last of an object, requesting what
+// is left whenever the current response ends.
+type resumingBody struct {
+	io.ReadCloser
+	c          *Client
+	ctx        context.Context
+	key, etag  string
+	next, last int64
+	// stalled is set while a new response has given no bytes yet.
+	stalled bool
+}
+
+func (b *resumingBody) Read(p []byte) (int, error) {
+	for {
+		n, err := b.ReadCloser.Read(p)
+		b.next += int64(n)
+		if n > 0 {
+			b.stalled = false
+		}
+		if err != io.EOF || b.next > b.last {
+			return n, err
+		}
+		if n > 0 {
+			return n, nil
+		}
+		if b.stalled {
+			return 0, io.ErrUnexpectedEOF
+		}
+		b.ReadCloser.Close()
+		obj, err := b.c.GetObject(b.ctx, b.key, &Conditional{IfMatch: b.etag, Range: fmt.Sprintf("bytes=%d-%d", b.next, b.last)})
+		if err != nil {
+			return 0, fmt.Errorf("resuming range at byte %d: %w", b.next, err)
+		}
+		if start, _, _, ok := parseContentRange(obj.ContentRange); !ok || start != b.next {
+			obj.Body.Close()
+			return 0, fmt.Errorf("resuming range at byte %d: got %q", b.next, obj.ContentRange)
+		}
+		b.ReadCloser = obj.Body
+		b.stalled = true
+	}
+}
+
+// parseRange parses a single "bytes=first-last" range, with last -1 when
+// it is open-ended. Suffix and multiple ranges are not parsed.
+func parseRange(s string) (first, last int64, ok bool) {
+	spec, ok := strings.CutPrefix(s, "bytes=")
+	if !ok {
+		return 0, 0, false
+	}
+	a, b, ok := strings.Cut(spec, "-")
+	if !ok || a == "" {
+		return 0, 0, false
+	}
+	first, err := strconv.ParseInt(a, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	if b == "" {
+		return first, -1, true
+	}
+	last, err = strconv.ParseInt(b, 10, 64)
+	if err != nil || last < first {
+		return 0, 0, false
+	}
+	return first, last, true
+}
+
+// parseContentRange parses "bytes start-end/size" with a known size.
+func parseContentRange(s string) (start, end, size int64, ok bool) {
+	spec, ok := strings.CutPrefix(s, "bytes ")
+	if !ok {
+		return 0, 0, 0, false
+	}
+	span, total, ok := strings.Cut(spec, "/")
+	if !ok {
+		return 0, 0, 0, false
+	}
+	a, b, ok := strings.Cut(span, "-")
+	if !ok {
+		return 0, 0, 0, false
+	}
+	var errs [3]error
+	start, errs[0] = strconv.ParseInt(a, 10, 64)
+	end, errs[1] = strconv.ParseInt(b, 10, 64)
+	size, errs[2] = strconv.ParseInt(total, 10, 64)
+	if errors.Join(errs[:]...) != nil || end < start || end >= size {
+		return 0, 0, 0, false
+	}
+	return start, end, size, true
+}
+
+func (c *Client) etag(etag string) string {
+	if c.compat.quoteETags && etag != "" && !strings.HasPrefix(etag, `"`) && !strings.HasPrefix(etag, "W/") {
+		return `"` + etag + `"`
+	}
+	return etag
+}
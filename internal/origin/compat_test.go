@@ -0,0 +1,132 @@
+package origin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCompat(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/b/bad//name" {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`<Error><Code>XMinioInvalidObjectName</Code><Message>Object name contains unsupported characters.</Message></Error>`))
+			return
+		}
+		w.Header().Set("ETag", "d41d8cd98f00b204e9800998ecf8427e")
+		w.Header().Set("X-Minio-Deployment-Id", "abc")
+		w.Header().Set("X-Amz-Request-Id", "123")
+	}))
+	defer srv.Close()
+
+	c, err := New(context.Background(), srv.URL, "us-east-1", "key", "secret", "b", time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	obj, err := c.GetObject(context.Background(), "k", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	obj.Body.Close()
+	if obj.ETag != "d41d8cd98f00b204e9800998ecf8427e" || obj.Headers.Get("X-Minio-Deployment-Id") != "" {
+		t.Fatalf("expected AWS behaviour by default, got %q %v", obj.ETag, obj.Headers)
+	}
+	if _, err := c.GetObject(context.Background(), "bad//name", nil); errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected MinIO error codes to be unknown by default")
+	}
+
+	c.SetCompat(CompatMinIO)
+	obj, err = c.HeadObject(context.Background(), "k", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if obj.ETag != `"d41d8cd98f00b204e9800998ecf8427e"` || obj.Headers.Get("ETag") != obj.ETag {
+		t.Fatalf("expected a quoted ETag, got %q", obj.ETag)
+	}
+	if obj.Headers.Get("X-Minio-Deployment-Id") != "abc" || obj.Headers.Get("X-Amz-Request-Id") != "" {
+		t.Fatalf("expected only MinIO's headers to be passed on, got %v", obj.Headers)
+	}
+	if _, err := c.GetObject(context.Background(), "bad//name", nil); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected an invalid object name to be not found, got %v", err)
+	}
+}
+
+func TestB2(t *testing.T) {
+	const data = "0123456789"
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("ETag", "abc")
+		w.Header().Set("X-Bz-Content-Sha1", "87acec17cd9dcd20a716cc2cf67417b71c8a7016")
+		first, last, ok := parseRange(r.Header.Get("Range"))
+		if !ok {
+			w.Write([]byte(data))
+			return
+		}
+		if m := r.Header.Get("If-Match"); m != "" && m != `"abc"` {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		// Ranges are capped to three bytes.
+		if last < 0 || last > first+2 {
+			last = first + 2
+		}
+		last = min(last, int64(len(data)-1))
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", first, last, len(data)))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write([]byte(data[first : last+1]))
+	}))
+	defer srv.Close()
+
+	c, err := New(context.Background(), srv.URL, "us-east-1", "key", "secret", "b", time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	c.SetCompat(CompatB2)
+	c.EnableChecksums()
+
+	obj, err := c.GetObject(context.Background(), "k", &Conditional{Range: "bytes=2-8"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	body, err := io.ReadAll(obj.Body)
+	obj.Body.Close()
+	if err != nil || string(body) != "2345678" {
+		t.Fatalf("expected the whole range, got %q %v", body, err)
+	}
+	if obj.ContentLength != 7 || obj.Headers.Get("Content-Range") != "bytes 2-8/10" || requests != 3 {
+		t.Fatalf("expected the range to be resumed, got %d %q after %d requests", obj.ContentLength, obj.Headers.Get("Content-Range"), requests)
+	}
+	if obj.Headers.Get("x-amz-checksum-sha1") != "" {
+		t.Fatalf("expected no whole-object checksum on a range")
+	}
+
+	obj, err = c.HeadObject(context.Background(), "k", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if obj.Headers.Get("x-amz-checksum-sha1") != "h6zsF82dzSCnFsws9nQXtxyKcBY=" || obj.Headers.Get("Digest") != "SHA=h6zsF82dzSCnFsws9nQXtxyKcBY=" {
+		t.Fatalf("expected B2's SHA-1 as a checksum, got %v", obj.Headers)
+	}
+}
+
+func TestSetSHA1(t *testing.T) {
+	for _, v := range []string{"none", "unverified:87acec17cd9dcd20a716cc2cf67417b71c8a7016", "87acec"} {
+		h := http.Header{}
+		setSHA1(h, v)
+		if len(h) != 0 {
+			t.Fatalf("expected %q to be ignored, got %v", v, h)
+		}
+	}
+	h := http.Header{"Digest": {"CRC32c=AAAAAA=="}}
+	setSHA1(h, "87acec17cd9dcd20a716cc2cf67417b71c8a7016")
+	if h.Get("Digest") != "CRC32c=AAAAAA==,SHA=h6zsF82dzSCnFsws9nQXtxyKcBY=" {
+		t.Fatalf("expected the SHA-1 to join the digest, got %v", h)
+	}
+}
@@ -0,0 +1,66 @@
+package origin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// ErrDefaultChain rejects rotating the keys of a client whose credentials
+// come from the SDK's default chain, which rotates them itself.
+var ErrDefaultChain = errors.New("origin credentials come from the default chain")
+
+// staticKeys are the static keys of a client, which can be replaced while
+// requests are signed with them. The SDK caches what a provider returns, so
+// the cache is invalidated on every change.
+type staticKeys struct {
+	value atomic.Pointer[aws.Credentials]
+	cache *aws.CredentialsCache
+}
+
+func newStaticKeys(accessKey, secretKey string) *staticKeys {
+	k := &staticKeys{}
+	k.value.Store(&aws.Credentials{AccessKeyID: accessKey, SecretAccessKey: secretKey, Source: "s3-proxy"})
+	k.cache = aws.NewCredentialsCache(k)
+	return k
+}
+
+func (k *staticKeys) Retrieve(context.Context) (aws.Credentials, error) {
+	return *k.value.Load(), nil
+}
+
+// CheckCredentials reports whether the bucket can be reached with the given
+// keys, without using them for anything else.
+func (c *Client) CheckCredentials(ctx context.Context, accessKey, secretKey string) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	_, err := c.s3.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String(c.bucket)}, func(o *s3.Options) {
+		o.Credentials = credentials.NewStaticCredentialsProvider(accessKey, secretKey, "")
+	})
+	if err != nil {
+		return fmt.Errorf("check credentials: %w", c.originError(err))
+	}
+	return nil
+}
+
+// SetCredentials signs requests made from now on, including those for
+// routed buckets, with the given keys.
+func (c *Client) SetCredentials(accessKey, secretKey string) error {
+	if c.keys == nil {
+		return ErrDefaultChain
+	}
+	c.keys.value.Store(&aws.Credentials{AccessKeyID: accessKey, SecretAccessKey: secretKey, Source: "s3-proxy"})
+	c.keys.cache.Invalidate()
+	return nil
+}
+
+// HasStaticKeys reports whether c signs with keys SetCredentials can
+// replace.
+func (c *Client) HasStaticKeys() bool {
+	return c.keys != nil
+}
@@ -0,0 +1,115 @@
+package origin
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Names reported for the origin that served a request.
+const (
+	Primary   = "primary"
+	Secondary = "secondary"
+)
+
+type store interface {
+	GetObject(ctx context.Context, key string, cond *Conditional) (*Object, error)
+	HeadObject(ctx context.Context, key string, cond *Conditional) (*Object, error)
+	ListObjects(ctx context.Context, prefix string) ([]ObjectInfo, error)
+	PutObject(ctx context.Context, key string, in *PutInput) (string, error)
+	DeleteObject(ctx context.Context, key string) error
+}
+
+// Failover sends requests to a primary bucket and retries them against a
+// replica when the primary fails or times out. After Threshold consecutive
+// failures the primary is skipped for Cooldown before it is tried again.
+type Failover struct {
+	primary   *Client
+	secondary *Client
+	threshold int
+	cooldown  time.Duration
+	onServe   func(origin string)
+
+	mu        sync.Mutex
+	failures  int
+	downUntil time.Time
+}
+
+func NewFailover(primary, secondary *Client, threshold int, cooldown time.Duration) *Failover {
+	return &Failover{primary: primary, secondary: secondary, threshold: threshold, cooldown: cooldown}
+}
+
+// OnServe registers a callback invoked with Primary or Secondary for every
+// request, naming the origin whose response was returned.
+func (f *Failover) OnServe(fn func(origin string)) {
+	f.onServe = fn
+}
+
+// PrimaryHealthy reports whether requests currently go to the primary.
+func (f *Failover) PrimaryHealthy() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return !time.Now().Before(f.downUntil)
+}
+
+func (f *Failover) GetObject(ctx context.Context, key string, cond *Conditional) (*Object, error) {
+	return failover(ctx, f, func(c *Client) (*Object, error) { return c.GetObject(ctx, key, cond) })
+}
+
+func (f *Failover) HeadObject(ctx context.Context, key string, cond *Conditional) (*Object, error) {
+	return failover(ctx, f, func(c *Client) (*Object, error) { return c.HeadObject(ctx, key, cond) })
+}
+
+func (f *Failover) ListObjects(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	return failover(ctx, f, func(c *Client) ([]ObjectInfo, error) { return c.ListObjects(ctx, prefix) })
+}
+
+// PutObject writes to the primary only; the secondary is expected to be a
+// replica of it, so writes are not failed over.
+func (f *Failover) PutObject(ctx context.Context, key string, in *PutInput) (string, error) {
+	return f.primary.PutObject(ctx, key, in)
+}
+
+func (f *Failover) DeleteObject(ctx context.Context, key string) error {
+	return f.primary.DeleteObject(ctx, key)
+}
+
+func failover[T any](ctx context.Context, f *Failover, call func(*Client) (T, error)) (T, error) {
+	if f.PrimaryHealthy() {
+		v, err := call(f.primary)
+		if !f.record(ctx, err) {
+			f.served(Primary)
+			return v, err
+		}
+	}
+	v, err := call(f.secondary)
+	f.served(Secondary)
+	return v, err
+}
+
+// record tracks the primary's health and reports whether err should be
+// retried against the secondary. Answers such as not found or not modified
+// are successes; so is a request the caller gave up on.
+func (f *Failover) record(ctx context.Context, err error) bool {
+	failed := err != nil && ctx.Err() == nil &&
+		!errors.Is(err, ErrNotFound) && !errors.Is(err, ErrNotModified) && !errors.Is(err, ErrPrecondition)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !failed {
+		f.failures = 0
+		return false
+	}
+	f.failures++
+	if f.failures >= f.threshold {
+		f.failures = 0
+		f.downUntil = time.Now().Add(f.cooldown)
+	}
+	return true
+}
+
+func (f *Failover) served(origin string) {
+	if f.onServe != nil {
+		f.onServe(origin)
+	}
+}
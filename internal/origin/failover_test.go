@@ -0,0 +1,29 @@
+package origin
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFailoverHealth(t *testing.T) {
+	f := NewFailover(nil, nil, 2, time.Minute)
+	ctx := context.Background()
+
+	if f.record(ctx, ErrNotFound) || f.record(ctx, nil) {
+		t.Fatalf("expected origin answers not to trigger failover")
+	}
+	if !f.record(ctx, errors.New("timeout")) || !f.PrimaryHealthy() {
+		t.Fatalf("expected a single failure to retry but keep the primary")
+	}
+	if !f.record(ctx, errors.New("timeout")) || f.PrimaryHealthy() {
+		t.Fatalf("expected the threshold to take the primary out of rotation")
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if f.record(canceled, context.Canceled) {
+		t.Fatalf("expected a canceled request not to fail over")
+	}
+}
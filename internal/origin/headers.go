@@ -0,0 +1,69 @@
+package origin
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// HeaderLimits cap the x-amz-meta-* headers objects are served with. Whoever
+// uploads an object sets its metadata, and it is copied into every cache
+// entry and response for the object. Zero means no limit.
+type HeaderLimits struct {
+	MetaCount int
+	MetaBytes int
+}
+
+// SetHeaderLimits applies l to objects fetched after the call. Clients
+// derived from c for routed buckets inherit it.
+func (c *Client) SetHeaderLimits(l HeaderLimits) {
+	c.limits = l
+}
+
+// setMetadata adds meta to h as x-amz-meta-* headers. Entries that are not
+// valid in an HTTP header are dropped, and so are those, in name order, past
+// the limits, with sizes counted as header name plus value.
+func (l HeaderLimits) setMetadata(h http.Header, meta map[string]string) {
+	names := make([]string, 0, len(meta))
+	for name := range meta {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	count, size := 0, 0
+	for _, name := range names {
+		header, value := "x-amz-meta-"+name, meta[name]
+		if !validHeaderName(header) || !validHeaderValue(value) {
+			continue
+		}
+		if l.MetaCount > 0 && count+1 > l.MetaCount {
+			break
+		}
+		if l.MetaBytes > 0 && size+len(header)+len(value) > l.MetaBytes {
+			continue
+		}
+		count++
+		size += len(header) + len(value)
+		h.Set(header, value)
+	}
+}
+
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if r > '~' || r <= ' ' || strings.ContainsRune(`"(),/:;<=>?@[\]{}`, r) {
+			return false
+		}
+	}
+	return true
+}
+
+func validHeaderValue(value string) bool {
+	for _, r := range value {
+		if (r < ' ' && r != '\t') || r == 0x7f {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,34 @@
+package origin
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestHeaderLimits(t *testing.T) {
+	meta := map[string]string{
+		"a":        "1",
+		"b":        "2",
+		"c":        "3",
+		"bad name": "x",
+		"d":        "split\r\nSet-Cookie: x=1",
+	}
+
+	h := http.Header{}
+	HeaderLimits{}.setMetadata(h, meta)
+	if len(h) != 3 || h.Get("x-amz-meta-c") != "3" {
+		t.Fatalf("expected only valid metadata without limits, got %v", h)
+	}
+
+	h = http.Header{}
+	HeaderLimits{MetaCount: 2}.setMetadata(h, meta)
+	if len(h) != 2 || h.Get("x-amz-meta-c") != "" {
+		t.Fatalf("expected the first two entries by name, got %v", h)
+	}
+
+	h = http.Header{}
+	HeaderLimits{MetaBytes: len("x-amz-meta-a1")}.setMetadata(h, meta)
+	if len(h) != 1 || h.Get("x-amz-meta-a") != "1" {
+		t.Fatalf("expected the byte limit to stop at one entry, got %v", h)
+	}
+}
@@ -0,0 +1,99 @@
+package origin
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// putMultipart uploads in.Body in parts of in.PartSize, holding a single part
+// in memory at a time. An upload that fails part way is aborted so that its
+// parts do not linger in the bucket. S3 keeps only a checksum of the part
+// checksums for multipart objects, so in.ChecksumSHA256 is verified here
+// before the upload is completed.
+func (c *Client) putMultipart(ctx context.Context, put *s3.PutObjectInput, in *PutInput) (string, error) {
+	created, err := c.s3.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:             put.Bucket,
+		Key:                put.Key,
+		ContentType:        put.ContentType,
+		CacheControl:       put.CacheControl,
+		ContentEncoding:    put.ContentEncoding,
+		ContentDisposition: put.ContentDisposition,
+		ContentLanguage:    put.ContentLanguage,
+		Metadata:           put.Metadata,
+	})
+	if err != nil {
+		return "", c.originError(err)
+	}
+	uploadID := created.UploadId
+
+	etag, err := c.uploadParts(ctx, put, uploadID, in)
+	if err != nil {
+		abortCtx, cancel := c.withTimeout(context.WithoutCancel(ctx))
+		defer cancel()
+		c.s3.AbortMultipartUpload(abortCtx, &s3.AbortMultipartUploadInput{
+			Bucket:   put.Bucket,
+			Key:      put.Key,
+			UploadId: uploadID,
+		})
+		return "", err
+	}
+	return etag, nil
+}
+
+func (c *Client) uploadParts(ctx context.Context, put *s3.PutObjectInput, uploadID *string, in *PutInput) (string, error) {
+	buf := make([]byte, in.PartSize)
+	sum := sha256.New()
+	var parts []types.CompletedPart
+	for number := int32(1); ; number++ {
+		n, readErr := io.ReadFull(in.Body, buf)
+		last := errors.Is(readErr, io.EOF) || errors.Is(readErr, io.ErrUnexpectedEOF)
+		if readErr != nil && !last {
+			return "", fmt.Errorf("read upload: %w", readErr)
+		}
+		// The final read of a body that ends on a part boundary is empty and
+		// needs no part of its own.
+		if n == 0 && len(parts) > 0 {
+			break
+		}
+		sum.Write(buf[:n])
+		resp, err := c.s3.UploadPart(ctx, &s3.UploadPartInput{
+			Bucket:        put.Bucket,
+			Key:           put.Key,
+			UploadId:      uploadID,
+			PartNumber:    aws.Int32(number),
+			Body:          bytes.NewReader(buf[:n]),
+			ContentLength: aws.Int64(int64(n)),
+		})
+		if err != nil {
+			return "", c.originError(err)
+		}
+		parts = append(parts, types.CompletedPart{ETag: resp.ETag, PartNumber: aws.Int32(number)})
+		if last {
+			break
+		}
+	}
+
+	if in.ChecksumSHA256 != "" && base64.StdEncoding.EncodeToString(sum.Sum(nil)) != in.ChecksumSHA256 {
+		return "", ErrChecksum
+	}
+	resp, err := c.s3.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          put.Bucket,
+		Key:             put.Key,
+		UploadId:        uploadID,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+		IfNoneMatch:     put.IfNoneMatch,
+	})
+	if err != nil {
+		return "", c.originError(err)
+	}
+	return aws.ToString(resp.ETag), nil
+}
@@ -0,0 +1,117 @@
+package origin
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPutMultipart(t *testing.T) {
+	var mu sync.Mutex
+	var parts []int
+	completed := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case r.Method == http.MethodPost && q.Has("uploads"):
+			fmt.Fprint(w, `<InitiateMultipartUploadResult><Bucket>b</Bucket><Key>k</Key><UploadId>u1</UploadId></InitiateMultipartUploadResult>`)
+		case r.Method == http.MethodPut && q.Get("uploadId") == "u1":
+			body, _ := io.ReadAll(r.Body)
+			parts = append(parts, len(body))
+			w.Header().Set("ETag", fmt.Sprintf(`"part-%s"`, q.Get("partNumber")))
+		case r.Method == http.MethodPost && q.Get("uploadId") == "u1":
+			body, _ := io.ReadAll(r.Body)
+			completed = strings.Count(string(body), "<Part>") == len(parts)
+			fmt.Fprint(w, `<CompleteMultipartUploadResult><Bucket>b</Bucket><Key>k</Key><ETag>"whole-3"</ETag></CompleteMultipartUploadResult>`)
+		default:
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+
+	c, err := New(context.Background(), srv.URL, "us-east-1", "key", "secret", "b", time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	etag, err := c.PutObject(context.Background(), "k", &PutInput{
+		Body:          strings.NewReader(strings.Repeat("x", 25)),
+		ContentLength: -1,
+		Headers:       http.Header{},
+		PartSize:      10,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if etag != `"whole-3"` || !completed {
+		t.Fatalf("expected a completed upload, got etag %q", etag)
+	}
+	if fmt.Sprint(parts) != "[10 10 5]" {
+		t.Fatalf("unexpected part sizes %v", parts)
+	}
+}
+
+func TestPutMultipartChecksum(t *testing.T) {
+	body := strings.Repeat("x", 25)
+	var mu sync.Mutex
+	var aborted bool
+	var ifNoneMatch string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case r.Method == http.MethodPost && q.Has("uploads"):
+			fmt.Fprint(w, `<InitiateMultipartUploadResult><Bucket>b</Bucket><Key>k</Key><UploadId>u1</UploadId></InitiateMultipartUploadResult>`)
+		case r.Method == http.MethodPut && q.Get("uploadId") == "u1":
+			io.Copy(io.Discard, r.Body)
+			w.Header().Set("ETag", fmt.Sprintf(`"part-%s"`, q.Get("partNumber")))
+		case r.Method == http.MethodPost && q.Get("uploadId") == "u1":
+			ifNoneMatch = r.Header.Get("If-None-Match")
+			fmt.Fprint(w, `<CompleteMultipartUploadResult><Bucket>b</Bucket><Key>k</Key><ETag>"whole-3"</ETag></CompleteMultipartUploadResult>`)
+		case r.Method == http.MethodDelete && q.Get("uploadId") == "u1":
+			aborted = true
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+
+	c, err := New(context.Background(), srv.URL, "us-east-1", "key", "secret", "b", time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	put := func(checksum string) error {
+		_, err := c.PutObject(context.Background(), "k", &PutInput{
+			Body:           strings.NewReader(body),
+			ContentLength:  -1,
+			Headers:        http.Header{},
+			PartSize:       10,
+			IfNoneMatch:    "*",
+			ChecksumSHA256: checksum,
+		})
+		return err
+	}
+	sum := sha256.Sum256([]byte(body))
+	if err := put(base64.StdEncoding.EncodeToString(sum[:])); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ifNoneMatch != "*" {
+		t.Fatalf("expected If-None-Match on completion, got %q", ifNoneMatch)
+	}
+	wrong := sha256.Sum256([]byte("other"))
+	if err := put(base64.StdEncoding.EncodeToString(wrong[:])); !errors.Is(err, ErrChecksum) || !aborted {
+		t.Fatalf("expected a mismatched upload to be aborted with ErrChecksum, got %v (aborted %v)", err, aborted)
+	}
+}
@@ -0,0 +1,84 @@
+package origin
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// readReplica sends reads to a replica of the primary bucket, such as one in
+// the readers' region, and writes to the primary. Keys written through it are
+// read from the primary for lag afterwards, until replication has caught up,
+// so that a cache refilled right after a write does not get the old object.
+type readReplica struct {
+	primary store
+	replica store
+	lag     time.Duration
+
+	mu      sync.Mutex
+	written map[string]time.Time
+}
+
+// ReadReplica makes the default bucket serve reads from replica. Reads that
+// fail against the replica, other than with not found or not modified, are
+// retried against the primary. Routed prefixes are not affected.
+func (r *Router) ReadReplica(replica *Client, lag time.Duration) {
+	r.def = &readReplica{primary: r.def, replica: replica, lag: lag, written: make(map[string]time.Time)}
+}
+
+func (rr *readReplica) GetObject(ctx context.Context, key string, cond *Conditional) (*Object, error) {
+	return replicaRead(ctx, rr, key, func(s store) (*Object, error) { return s.GetObject(ctx, key, cond) })
+}
+
+func (rr *readReplica) HeadObject(ctx context.Context, key string, cond *Conditional) (*Object, error) {
+	return replicaRead(ctx, rr, key, func(s store) (*Object, error) { return s.HeadObject(ctx, key, cond) })
+}
+
+// ListObjects lists the replica, so keys written within the lag may be
+// missing or still listed.
+func (rr *readReplica) ListObjects(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	return replicaRead(ctx, rr, "", func(s store) ([]ObjectInfo, error) { return s.ListObjects(ctx, prefix) })
+}
+
+func (rr *readReplica) PutObject(ctx context.Context, key string, in *PutInput) (string, error) {
+	defer rr.wrote(key)
+	return rr.primary.PutObject(ctx, key, in)
+}
+
+func (rr *readReplica) DeleteObject(ctx context.Context, key string) error {
+	defer rr.wrote(key)
+	return rr.primary.DeleteObject(ctx, key)
+}
+
+func replicaRead[T any](ctx context.Context, rr *readReplica, key string, call func(store) (T, error)) (T, error) {
+	if key != "" && rr.recent(key) {
+		return call(rr.primary)
+	}
+	v, err := call(rr.replica)
+	if err == nil || ctx.Err() != nil || errors.Is(err, ErrNotFound) || errors.Is(err, ErrNotModified) || errors.Is(err, ErrPrecondition) {
+		return v, err
+	}
+	return call(rr.primary)
+}
+
+// wrote marks key as written, even when the write failed part way, and
+// forgets keys whose lag has passed.
+func (rr *readReplica) wrote(key string) {
+	now := time.Now()
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	for k, until := range rr.written {
+		if !now.Before(until) {
+			delete(rr.written, k)
+		}
+	}
+	rr.written[key] = now.Add(rr.lag)
+}
+
+func (rr *readReplica) recent(key string) bool {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	until, ok := rr.written[key]
+	return ok && time.Now().Before(until)
+}
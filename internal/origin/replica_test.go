@@ -0,0 +1,22 @@
+package origin
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReadReplicaLag(t *testing.T) {
+	rr := &readReplica{lag: 20 * time.Millisecond, written: make(map[string]time.Time)}
+	if rr.recent("a.txt") {
+		t.Fatalf("expected unwritten keys to be read from the replica")
+	}
+	rr.wrote("a.txt")
+	if !rr.recent("a.txt") || rr.recent("b.txt") {
+		t.Fatalf("expected only the written key to be read from the primary")
+	}
+	time.Sleep(30 * time.Millisecond)
+	rr.wrote("b.txt")
+	if rr.recent("a.txt") || len(rr.written) != 1 {
+		t.Fatalf("expected the lag to pass and the key to be forgotten, got %v", rr.written)
+	}
+}
@@ -0,0 +1,100 @@
+package origin
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"strings"
+	"sync/atomic"
+)
+
+// Route serves keys under Prefix from Bucket, with the prefix removed from
+// the key sent to S3.
+type Route struct {
+	Prefix string
+	Bucket string
+}
+
+type route struct {
+	prefix string
+	client store
+}
+
+// Router presents several buckets as one key space. Keys are served by the
+// route with the longest matching prefix, and by the default client
+// otherwise.
+type Router struct {
+	base   *Client
+	def    store
+	routes atomic.Pointer[[]route]
+}
+
+func NewRouter(def *Client, routes []Route) *Router {
+	r := &Router{base: def, def: def}
+	r.SetRoutes(routes)
+	return r
+}
+
+// SetRoutes replaces the routed prefixes. Requests already resolved keep
+// the bucket they were routed to.
+func (r *Router) SetRoutes(routes []Route) {
+	resolved := make([]route, 0, len(routes))
+	for _, rt := range routes {
+		resolved = append(resolved, route{prefix: rt.Prefix, client: r.base.withBucket(rt.Bucket)})
+	}
+	slices.SortFunc(resolved, func(a, b route) int {
+		return cmp.Compare(len(b.prefix), len(a.prefix))
+	})
+	r.routes.Store(&resolved)
+}
+
+// Failover makes the default bucket fail over to f's secondary. Routed
+// prefixes are not affected.
+func (r *Router) Failover(f *Failover) {
+	r.def = f
+}
+
+func (r *Router) resolve(key string) (store, string) {
+	for _, rt := range *r.routes.Load() {
+		if rest, ok := strings.CutPrefix(key, rt.prefix); ok {
+			return rt.client, rest
+		}
+	}
+	return r.def, key
+}
+
+func (r *Router) GetObject(ctx context.Context, key string, cond *Conditional) (*Object, error) {
+	client, key := r.resolve(key)
+	return client.GetObject(ctx, key, cond)
+}
+
+func (r *Router) HeadObject(ctx context.Context, key string, cond *Conditional) (*Object, error) {
+	client, key := r.resolve(key)
+	return client.HeadObject(ctx, key, cond)
+}
+
+func (r *Router) PutObject(ctx context.Context, key string, in *PutInput) (string, error) {
+	client, key := r.resolve(key)
+	return client.PutObject(ctx, key, in)
+}
+
+func (r *Router) DeleteObject(ctx context.Context, key string) error {
+	client, key := r.resolve(key)
+	return client.DeleteObject(ctx, key)
+}
+
+// ListObjects lists the bucket that serves prefix and returns keys in the
+// router's key space. A prefix spanning several routes lists only the
+// default bucket.
+func (r *Router) ListObjects(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	for _, rt := range *r.routes.Load() {
+		if rest, ok := strings.CutPrefix(prefix, rt.prefix); ok {
+			objects, err := rt.client.ListObjects(ctx, rest)
+			for i := range objects {
+				objects[i].Key = rt.prefix + objects[i].Key
+			}
+			return objects, err
+		}
+	}
+	return r.def.ListObjects(ctx, prefix)
+}
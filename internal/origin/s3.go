@@ -7,31 +7,52 @@ import (
 	"io"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
 	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/aws/smithy-go"
+	smithymiddleware "github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
 )
 
 var (
 	ErrNotFound     = errors.New("object not found")
 	ErrNotModified  = errors.New("object not modified")
 	ErrPrecondition = errors.New("precondition failed")
+	ErrChecksum     = errors.New("checksum mismatch")
+	ErrAccessDenied = errors.New("access denied")
 )
 
 type Client struct {
-	s3      *s3.Client
-	bucket  string
-	timeout time.Duration
+	s3        *s3.Client
+	bucket    string
+	timeout   time.Duration
+	checksums bool
+	limits    HeaderLimits
+	compat    compatProfile
+	keys      *staticKeys
+	// plainHTTP is set for http:// endpoints, where uploads cannot carry a
+	// trailing checksum.
+	plainHTTP bool
 }
 
 type Conditional struct {
+	IfMatch         string
 	IfNoneMatch     string
 	IfModifiedSince *time.Time
-	Range           string
+	// IfUnmodifiedSince fails the request with ErrPrecondition when the
+	// object was modified after it.
+	IfUnmodifiedSince *time.Time
+	Range             string
+	// VersionID selects a version of the object in a versioned bucket
+	// instead of the current one.
+	VersionID string
 }
 
 type Object struct {
@@ -45,17 +66,45 @@ type Object struct {
 	AcceptRanges  string
 	ContentType   string
 	ContentRange  string
+	// Date is the Date header of the origin response, zero if absent.
+	Date time.Time
+}
+
+// PutInput describes an object written through to the origin. Headers
+// carries the content headers and x-amz-meta-* metadata to store with it, and
+// PartSize, when set, is the part size used for multipart uploads.
+// IfNoneMatch "*" fails the upload with ErrPrecondition when the key exists,
+// and ChecksumSHA256, a base64 SHA-256, fails it with ErrChecksum when the
+// body does not match.
+type PutInput struct {
+	Body           io.Reader
+	ContentLength  int64 // -1 when unknown
+	Headers        http.Header
+	PartSize       int64
+	IfNoneMatch    string
+	ChecksumSHA256 string
+}
+
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	ETag         string
+	LastModified time.Time
 }
 
 func New(ctx context.Context, endpoint, region, accessKey, secretKey, bucket string, timeout time.Duration) (*Client, error) {
 	if bucket == "" {
 		return nil, fmt.Errorf("bucket is required")
 	}
-	awsConfig, err := config.LoadDefaultConfig(
-		ctx,
-		config.WithRegion(region),
-		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(accessKey, secretKey, "")),
-	)
+	options := []func(*config.LoadOptions) error{config.WithRegion(region)}
+	// Without static keys the SDK's default chain finds credentials: the
+	// AWS_* environment, shared config files, and EC2, ECS or EKS roles.
+	var keys *staticKeys
+	if accessKey != "" || secretKey != "" {
+		keys = newStaticKeys(accessKey, secretKey)
+		options = append(options, config.WithCredentialsProvider(keys.cache))
+	}
+	awsConfig, err := config.LoadDefaultConfig(ctx, options...)
 	if err != nil {
 		return nil, err
 	}
@@ -66,10 +115,17 @@ func New(ctx context.Context, endpoint, region, accessKey, secretKey, bucket str
 		}
 	})
 
-	return &Client{s3: client, bucket: bucket, timeout: timeout}, nil
+	return &Client{s3: client, bucket: bucket, timeout: timeout, keys: keys, plainHTTP: strings.HasPrefix(endpoint, "http://")}, nil
+}
+
+// withBucket returns a client for another bucket on the same endpoint and
+// credentials.
+func (c *Client) withBucket(bucket string) *Client {
+	return &Client{s3: c.s3, bucket: bucket, timeout: c.timeout, checksums: c.checksums, limits: c.limits, compat: c.compat, keys: c.keys, plainHTTP: c.plainHTTP}
 }
 
 func (c *Client) GetObject(ctx context.Context, key string, cond *Conditional) (*Object, error) {
+	parent := ctx
 	ctx, cancel := c.withTimeout(ctx)
 	input := &s3.GetObjectInput{
 		Bucket: aws.String(c.bucket),
@@ -77,25 +133,55 @@ func (c *Client) GetObject(ctx context.Context, key string, cond *Conditional) (
 	}
 
 	if cond != nil {
+		if cond.IfMatch != "" {
+			input.IfMatch = aws.String(cond.IfMatch)
+		}
 		if cond.IfNoneMatch != "" {
 			input.IfNoneMatch = aws.String(cond.IfNoneMatch)
 		}
 		if cond.IfModifiedSince != nil {
 			input.IfModifiedSince = cond.IfModifiedSince
 		}
+		if cond.IfUnmodifiedSince != nil {
+			input.IfUnmodifiedSince = cond.IfUnmodifiedSince
+		}
 		if cond.Range != "" {
 			input.Range = aws.String(cond.Range)
 		}
+		if cond.VersionID != "" {
+			input.VersionId = aws.String(cond.VersionID)
+		}
+	}
+
+	if c.checksums {
+		input.ChecksumMode = types.ChecksumModeEnabled
 	}
 
 	resp, err := c.s3.GetObject(ctx, input)
 	if err != nil {
 		cancel()
-		return nil, translateError(err)
+		return nil, c.originError(err)
 	}
 
-	obj := toObject(resp, http.StatusOK)
+	obj := toObject(resp, http.StatusOK, c.limits)
+	if cond != nil && cond.VersionID != "" {
+		setHeader(obj.Headers, "x-amz-version-id", aws.ToString(resp.VersionId))
+	}
+	if c.checksums {
+		checksums{
+			typ:       resp.ChecksumType,
+			crc32:     resp.ChecksumCRC32,
+			crc32c:    resp.ChecksumCRC32C,
+			crc64nvme: resp.ChecksumCRC64NVME,
+			sha1:      resp.ChecksumSHA1,
+			sha256:    resp.ChecksumSHA256,
+		}.setHeaders(obj.Headers)
+	}
+	c.adapt(obj, resp.ResultMetadata)
 	obj.Body = &cancelReadCloser{ReadCloser: resp.Body, cancel: cancel}
+	if c.compat.resumeRanges && cond != nil {
+		c.resumeRange(parent, key, cond, obj)
+	}
 	return obj, nil
 }
 
@@ -107,20 +193,163 @@ func (c *Client) HeadObject(ctx context.Context, key string, cond *Conditional)
 		Key:    aws.String(key),
 	}
 	if cond != nil {
+		if cond.IfMatch != "" {
+			input.IfMatch = aws.String(cond.IfMatch)
+		}
 		if cond.IfNoneMatch != "" {
 			input.IfNoneMatch = aws.String(cond.IfNoneMatch)
 		}
 		if cond.IfModifiedSince != nil {
 			input.IfModifiedSince = cond.IfModifiedSince
 		}
+		if cond.IfUnmodifiedSince != nil {
+			input.IfUnmodifiedSince = cond.IfUnmodifiedSince
+		}
+		if cond.VersionID != "" {
+			input.VersionId = aws.String(cond.VersionID)
+		}
+	}
+
+	if c.checksums {
+		input.ChecksumMode = types.ChecksumModeEnabled
 	}
 
 	resp, err := c.s3.HeadObject(ctx, input)
 	if err != nil {
-		return nil, translateError(err)
+		return nil, c.originError(err)
+	}
+
+	obj := toHeadObject(resp)
+	if cond != nil && cond.VersionID != "" {
+		setHeader(obj.Headers, "x-amz-version-id", aws.ToString(resp.VersionId))
 	}
+	if c.checksums {
+		checksums{
+			typ:       resp.ChecksumType,
+			crc32:     resp.ChecksumCRC32,
+			crc32c:    resp.ChecksumCRC32C,
+			crc64nvme: resp.ChecksumCRC64NVME,
+			sha1:      resp.ChecksumSHA1,
+			sha256:    resp.ChecksumSHA256,
+		}.setHeaders(obj.Headers)
+	}
+	c.adapt(obj, resp.ResultMetadata)
+	return obj, nil
+}
+
+func (c *Client) ListObjects(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	paginator := s3.NewListObjectsV2Paginator(c.s3, &s3.ListObjectsV2Input{
+		Bucket: aws.String(c.bucket),
+		Prefix: aws.String(prefix),
+	})
 
-	return toHeadObject(resp), nil
+	var objects []ObjectInfo
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, c.originError(err)
+		}
+		for _, item := range page.Contents {
+			objects = append(objects, ObjectInfo{
+				Key:          aws.ToString(item.Key),
+				Size:         aws.ToInt64(item.Size),
+				ETag:         c.etag(aws.ToString(item.ETag)),
+				LastModified: aws.ToTime(item.LastModified),
+			})
+		}
+	}
+	return objects, nil
+}
+
+// PutObject uploads an object and returns its new ETag. The body is streamed
+// with an unsigned payload, so it is not buffered to be hashed first. Bodies
+// of unknown length or longer than PartSize are sent as a multipart upload.
+// Uploads are bounded by ctx rather than the request timeout.
+func (c *Client) PutObject(ctx context.Context, key string, in *PutInput) (string, error) {
+	input := c.putInput(key, in.Headers)
+	if in.IfNoneMatch != "" {
+		input.IfNoneMatch = aws.String(in.IfNoneMatch)
+	}
+	if in.PartSize > 0 && (in.ContentLength < 0 || in.ContentLength > in.PartSize) {
+		return c.putMultipart(ctx, input, in)
+	}
+	input.Body = in.Body
+	input.ContentLength = aws.Int64(in.ContentLength)
+	if in.ChecksumSHA256 != "" {
+		input.ChecksumSHA256 = aws.String(in.ChecksumSHA256)
+	}
+
+	opts := []func(*s3.Options){s3.WithAPIOptions(v4.SwapComputePayloadSHA256ForUnsignedPayloadMiddleware)}
+	if c.plainHTTP {
+		// Without TLS the SDK would have to read the body ahead to put its
+		// checksum in a header, which a streamed body does not allow.
+		opts = append(opts, func(o *s3.Options) {
+			o.RequestChecksumCalculation = aws.RequestChecksumCalculationWhenRequired
+		})
+	}
+	resp, err := c.s3.PutObject(ctx, input, opts...)
+	if err != nil {
+		return "", c.originError(err)
+	}
+	return aws.ToString(resp.ETag), nil
+}
+
+// putInput maps the content headers and x-amz-meta-* metadata of an upload
+// onto a PutObject request.
+func (c *Client) putInput(key string, h http.Header) *s3.PutObjectInput {
+	input := &s3.PutObjectInput{
+		Bucket: aws.String(c.bucket),
+		Key:    aws.String(key),
+	}
+	if v := h.Get("Content-Type"); v != "" {
+		input.ContentType = aws.String(v)
+	}
+	if v := h.Get("Cache-Control"); v != "" {
+		input.CacheControl = aws.String(v)
+	}
+	if v := h.Get("Content-Encoding"); v != "" {
+		input.ContentEncoding = aws.String(v)
+	}
+	if v := h.Get("Content-Disposition"); v != "" {
+		input.ContentDisposition = aws.String(v)
+	}
+	if v := h.Get("Content-Language"); v != "" {
+		input.ContentLanguage = aws.String(v)
+	}
+	for name, values := range h {
+		if meta, ok := strings.CutPrefix(strings.ToLower(name), "x-amz-meta-"); ok && meta != "" && len(values) > 0 {
+			if input.Metadata == nil {
+				input.Metadata = map[string]string{}
+			}
+			input.Metadata[meta] = values[0]
+		}
+	}
+	return input
+}
+
+func (c *Client) DeleteObject(ctx context.Context, key string) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	_, err := c.s3.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(c.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return c.originError(err)
+	}
+	return nil
+}
+
+// HeadBucket checks that the bucket exists and the credentials can reach it.
+func (c *Client) HeadBucket(ctx context.Context) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	if _, err := c.s3.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String(c.bucket)}); err != nil {
+		return c.originError(err)
+	}
+	return nil
 }
 
 func (c *Client) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
@@ -143,7 +372,7 @@ func (c *cancelReadCloser) Close() error {
 	return c.ReadCloser.Close()
 }
 
-func toObject(resp *s3.GetObjectOutput, status int) *Object {
+func toObject(resp *s3.GetObjectOutput, status int, limits HeaderLimits) *Object {
 	headers := http.Header{}
 	setHeader(headers, "Content-Type", aws.ToString(resp.ContentType))
 	setHeader(headers, "Cache-Control", aws.ToString(resp.CacheControl))
@@ -159,11 +388,7 @@ func toObject(resp *s3.GetObjectOutput, status int) *Object {
 		setHeader(headers, "Expires", exp)
 	}
 
-	if resp.Metadata != nil {
-		for k, v := range resp.Metadata {
-			headers.Set("x-amz-meta-"+k, v)
-		}
-	}
+	limits.setMetadata(headers, resp.Metadata)
 
 	contentLength := aws.ToInt64(resp.ContentLength)
 	if resp.ContentLength != nil {
@@ -185,6 +410,7 @@ func toObject(resp *s3.GetObjectOutput, status int) *Object {
 		AcceptRanges:  aws.ToString(resp.AcceptRanges),
 		ContentType:   aws.ToString(resp.ContentType),
 		ContentRange:  aws.ToString(resp.ContentRange),
+		Date:          responseDate(resp.ResultMetadata),
 	}
 }
 
@@ -212,7 +438,17 @@ func toHeadObject(resp *s3.HeadObjectOutput) *Object {
 		CacheControl:  aws.ToString(resp.CacheControl),
 		AcceptRanges:  aws.ToString(resp.AcceptRanges),
 		ContentType:   aws.ToString(resp.ContentType),
+		Date:          responseDate(resp.ResultMetadata),
+	}
+}
+
+func responseDate(md smithymiddleware.Metadata) time.Time {
+	raw, ok := awsmiddleware.GetRawResponse(md).(*smithyhttp.Response)
+	if !ok || raw.Response == nil {
+		return time.Time{}
 	}
+	date, _ := http.ParseTime(raw.Header.Get("Date"))
+	return date
 }
 
 func setHeader(h http.Header, key string, value string) {
@@ -238,12 +474,16 @@ func translateError(err error) error {
 	var apiErr smithy.APIError
 	if errors.As(err, &apiErr) {
 		switch apiErr.ErrorCode() {
-		case "NotFound", "NoSuchKey", "NoSuchBucket", "404":
+		case "NotFound", "NoSuchKey", "NoSuchBucket", "NoSuchVersion", "404":
 			return ErrNotFound
 		case "NotModified":
 			return ErrNotModified
-		case "PreconditionFailed":
+		case "PreconditionFailed", "ConditionalRequestConflict", "412":
 			return ErrPrecondition
+		case "BadDigest", "InvalidDigest":
+			return ErrChecksum
+		case "AccessDenied", "Forbidden", "403":
+			return fmt.Errorf("%w: %w", ErrAccessDenied, err)
 		default:
 			return fmt.Errorf("s3 api: %w", err)
 		}
@@ -0,0 +1,124 @@
+package origin
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDefaultCredentials(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing")
+	t.Setenv("AWS_CONFIG_FILE", missing)
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", missing)
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIAENV")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+
+	var auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+	}))
+	defer srv.Close()
+
+	for _, tt := range []struct {
+		accessKey, secretKey string
+		want                 string
+	}{
+		{"", "", "Credential=AKIAENV/"},
+		{"AKIASTATIC", "secret", "Credential=AKIASTATIC/"},
+	} {
+		c, err := New(context.Background(), srv.URL, "us-east-1", tt.accessKey, tt.secretKey, "b", time.Second)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := c.HeadObject(context.Background(), "k", nil); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !strings.Contains(auth, tt.want) {
+			t.Fatalf("expected requests signed with %s, got %q", tt.want, auth)
+		}
+	}
+}
+
+func TestVersionID(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Amz-Version-Id", "current")
+		if v := r.URL.Query().Get("versionId"); v != "" {
+			if v != "v1" {
+				w.Header().Set("Content-Type", "application/xml")
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(`<Error><Code>NoSuchVersion</Code><Message>The specified version does not exist.</Message></Error>`))
+				return
+			}
+			w.Header().Set("X-Amz-Version-Id", v)
+		}
+		w.Write([]byte("body"))
+	}))
+	defer srv.Close()
+
+	c, err := New(context.Background(), srv.URL, "us-east-1", "key", "secret", "b", time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	obj, err := c.GetObject(context.Background(), "k", &Conditional{VersionID: "v1"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	obj.Body.Close()
+	if obj.Headers.Get("x-amz-version-id") != "v1" {
+		t.Fatalf("expected the requested version, got %v", obj.Headers)
+	}
+	obj, err = c.GetObject(context.Background(), "k", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	obj.Body.Close()
+	if obj.Headers.Get("x-amz-version-id") != "" {
+		t.Fatalf("expected no version header without a version, got %v", obj.Headers)
+	}
+	if _, err := c.GetObject(context.Background(), "k", &Conditional{VersionID: "v2"}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected a missing version to be not found, got %v", err)
+	}
+}
+
+func TestIfUnmodifiedSince(t *testing.T) {
+	lm := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if t, err := http.ParseTime(r.Header.Get("If-Unmodified-Since")); err == nil && lm.After(t) {
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusPreconditionFailed)
+			} else {
+				w.Header().Set("Content-Type", "application/xml")
+				w.WriteHeader(http.StatusPreconditionFailed)
+				w.Write([]byte(`<Error><Code>PreconditionFailed</Code><Message>At least one of the pre-conditions you specified did not hold</Message></Error>`))
+			}
+			return
+		}
+		w.Header().Set("Last-Modified", lm.Format(http.TimeFormat))
+		w.Write([]byte("body"))
+	}))
+	defer srv.Close()
+
+	c, err := New(context.Background(), srv.URL, "us-east-1", "key", "secret", "b", time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	since := lm
+	obj, err := c.GetObject(context.Background(), "k", &Conditional{IfUnmodifiedSince: &since})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	obj.Body.Close()
+	before := lm.Add(-time.Hour)
+	if _, err := c.GetObject(context.Background(), "k", &Conditional{IfUnmodifiedSince: &before}); !errors.Is(err, ErrPrecondition) {
+		t.Fatalf("expected a precondition failure, got %v", err)
+	}
+	if _, err := c.HeadObject(context.Background(), "k", &Conditional{IfUnmodifiedSince: &before}); !errors.Is(err, ErrPrecondition) {
+		t.Fatalf("expected a precondition failure for HEAD, got %v", err)
+	}
+}
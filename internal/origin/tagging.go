@@ -0,0 +1,44 @@
+package origin
+
+import (
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	smithymiddleware "github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// Tagging identifies the proxy's requests to S3. UserAgent and Tags, as
+// key/value pairs, are appended to the SDK's User-Agent, which S3 server
+// access logs and CloudTrail record. RequestPayer accepts the charges of
+// requester-pays buckets on the proxy's account.
+type Tagging struct {
+	UserAgent    string
+	Tags         []Tag
+	RequestPayer bool
+}
+
+type Tag struct {
+	Key   string
+	Value string
+}
+
+// SetTagging applies t to every request the client makes. Clients derived
+// from c for routed buckets inherit it.
+func (c *Client) SetTagging(t Tagging) {
+	var options []func(*smithymiddleware.Stack) error
+	if t.UserAgent != "" {
+		options = append(options, awsmiddleware.AddUserAgentKey(t.UserAgent))
+	}
+	for _, tag := range t.Tags {
+		options = append(options, awsmiddleware.AddUserAgentKeyValue(tag.Key, tag.Value))
+	}
+	if t.RequestPayer {
+		options = append(options, smithyhttp.SetHeaderValue("x-amz-request-payer", "requester"))
+	}
+	if len(options) == 0 {
+		return
+	}
+	c.s3 = s3.New(c.s3.Options(), func(o *s3.Options) {
+		o.APIOptions = append(o.APIOptions, options...)
+	})
+}
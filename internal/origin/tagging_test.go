@@ -0,0 +1,42 @@
+package origin
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTagging(t *testing.T) {
+	var userAgent, payer string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgent, payer = r.Header.Get("User-Agent"), r.Header.Get("x-amz-request-payer")
+	}))
+	defer srv.Close()
+
+	c, err := New(context.Background(), srv.URL, "us-east-1", "key", "secret", "b", time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := c.HeadObject(context.Background(), "k", nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Contains(userAgent, "edge-1") || payer != "" {
+		t.Fatalf("expected untagged requests by default, got %q %q", userAgent, payer)
+	}
+
+	c.SetTagging(Tagging{UserAgent: "s3-proxy", Tags: []Tag{{Key: "instance", Value: "edge-1"}, {Key: "tenant", Value: "acme"}}, RequestPayer: true})
+	if _, err := c.withBucket("other").HeadObject(context.Background(), "k", nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, want := range []string{"s3-proxy", "instance/edge-1", "tenant/acme"} {
+		if !strings.Contains(userAgent, want) {
+			t.Fatalf("expected %q in the User-Agent, got %q", want, userAgent)
+		}
+	}
+	if payer != "requester" {
+		t.Fatalf("expected the request payer header, got %q", payer)
+	}
+}
@@ -0,0 +1,80 @@
+package origin
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"sync/atomic"
+
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// ClientCert is a client certificate presented to origins that require
+// mutual TLS, such as Ceph RGW or MinIO set up for it. Reload picks up a
+// renewed certificate for new connections.
+type ClientCert struct {
+	certFile string
+	keyFile  string
+	cert     atomic.Pointer[tls.Certificate]
+}
+
+func LoadClientCert(certFile, keyFile string) (*ClientCert, error) {
+	c := &ClientCert{certFile: certFile, keyFile: keyFile}
+	if err := c.Reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Reload reads the files again. On error the previous certificate stays in
+// use.
+func (c *ClientCert) Reload() error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("load origin client certificate: %w", err)
+	}
+	c.cert.Store(&cert)
+	return nil
+}
+
+func (c *ClientCert) CertFile() string {
+	return c.certFile
+}
+
+// LoadRootCAs reads a PEM bundle of CAs to verify an origin's certificate
+// against, for origins whose certificates are not publicly trusted.
+func LoadRootCAs(file string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("load origin CAs: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("load origin CAs: no certificates in %s", file)
+	}
+	return pool, nil
+}
+
+// SetClientTLS makes c present cert, when set, and verify the origin against
+// rootCAs, when set, instead of the system roots. Clients derived from c for
+// routed buckets inherit it.
+func (c *Client) SetClientTLS(cert *ClientCert, rootCAs *x509.CertPool) {
+	if cert == nil && rootCAs == nil {
+		return
+	}
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12, RootCAs: rootCAs}
+	if cert != nil {
+		cfg.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return cert.cert.Load(), nil
+		}
+	}
+	httpClient := awshttp.NewBuildableClient().WithTransportOptions(func(tr *http.Transport) {
+		tr.TLSClientConfig = cfg
+	})
+	c.s3 = s3.New(c.s3.Options(), func(o *s3.Options) {
+		o.HTTPClient = httpClient
+	})
+}
@@ -0,0 +1,334 @@
+package peer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// A member is considered down once it has not been heard from for this
+	// many gossip intervals, and forgotten after forgetAfter intervals.
+	suspectAfter = 3
+	forgetAfter  = 20
+)
+
+type member struct {
+	added      time.Time
+	lastSeen   time.Time
+	seed       bool
+	discovered bool
+}
+
+type gossipMessage struct {
+	From    string   `json:"from"`
+	Members []string `json:"members"`
+}
+
+// Cluster tracks peer membership through periodic gossip and keeps a
+// consistent-hash ring of the healthy members, including this node.
+type Cluster struct {
+	self     string
+	token    string
+	interval time.Duration
+	client   *http.Client
+	logger   *slog.Logger
+
+	mu      sync.RWMutex
+	members map[string]*member
+	ring    *Ring
+}
+
+func NewCluster(self string, seeds []string, token string, interval time.Duration, logger *slog.Logger) *Cluster {
+	c := &Cluster{
+		self:     normalize(self),
+		token:    token,
+		interval: interval,
+		client:   &http.Client{},
+		logger:   logger,
+		members:  make(map[string]*member),
+	}
+	for _, seed := range seeds {
+		if seed = normalize(seed); seed != "" && seed != c.self {
+			c.members[seed] = &member{added: time.Now(), seed: true}
+		}
+	}
+	c.ring = NewRing([]string{c.self})
+	return c
+}
+
+func (c *Cluster) Self() string {
+	return c.self
+}
+
+// Owner returns the peer responsible for key. ok is false when this node owns
+// the key itself.
+func (c *Cluster) Owner(key string) (owner string, ok bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	owner = c.ring.Owner(key)
+	return owner, owner != "" && owner != c.self
+}
+
+// Members returns the healthy members, including this node.
+func (c *Cluster) Members() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.ring.Nodes()
+}
+
+// Peers returns every known member other than this node, healthy or not.
+func (c *Cluster) Peers() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	peers := make([]string, 0, len(c.members))
+	for addr := range c.members {
+		peers = append(peers, addr)
+	}
+	slices.Sort(peers)
+	return peers
+}
+
+// SetDiscovered replaces the set of members found through service discovery.
+// Members that are no longer discovered are forgotten once they stop
+// answering gossip.
+func (c *Cluster) SetDiscovered(addrs []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	found := make(map[string]bool, len(addrs))
+	for _, addr := range addrs {
+		if addr = normalize(addr); addr != "" && addr != c.self {
+			found[addr] = true
+		}
+	}
+	for addr, m := range c.members {
+		m.discovered = found[addr]
+		delete(found, addr)
+	}
+	for addr := range found {
+		c.members[addr] = &member{added: time.Now(), discovered: true}
+	}
+}
+
+// Run gossips with every known member each interval until ctx is done.
+func (c *Cluster) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	c.round(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.round(ctx)
+		}
+	}
+}
+
+func (c *Cluster) round(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, addr := range c.Peers() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			members, err := c.exchange(ctx, addr)
+			if err != nil {
+				c.logger.Debug("peer gossip failed", "peer", addr, "error", err)
+				return
+			}
+			c.observe(addr, members)
+		}()
+	}
+	wg.Wait()
+	c.rebuild(time.Now())
+}
+
+func (c *Cluster) exchange(ctx context.Context, addr string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.interval)
+	defer cancel()
+	body, err := json.Marshal(gossipMessage{From: c.self, Members: c.Members()})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, addr+"/_peer/gossip", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Auth-Token", c.token)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("gossip status %d", resp.StatusCode)
+	}
+	var msg gossipMessage
+	if err := json.NewDecoder(resp.Body).Decode(&msg); err != nil {
+		return nil, err
+	}
+	return msg.Members, nil
+}
+
+// HandleGossip records an incoming gossip message and replies with this
+// node's view of the cluster.
+func (c *Cluster) HandleGossip(w http.ResponseWriter, r *http.Request) {
+	var msg gossipMessage
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&msg); err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	c.observe(msg.From, msg.Members)
+	c.rebuild(time.Now())
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(gossipMessage{From: c.self, Members: c.Members()})
+}
+
+func (c *Cluster) observe(from string, members []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if from = normalize(from); from != "" && from != c.self {
+		m, ok := c.members[from]
+		if !ok {
+			m = &member{added: now}
+			c.members[from] = m
+		}
+		m.lastSeen = now
+	}
+	for _, addr := range members {
+		if addr = normalize(addr); addr != "" && addr != c.self {
+			if _, ok := c.members[addr]; !ok {
+				c.members[addr] = &member{added: now}
+			}
+		}
+	}
+}
+
+func (c *Cluster) rebuild(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	healthy := []string{c.self}
+	for addr, m := range c.members {
+		switch {
+		case now.Sub(m.lastSeen) <= suspectAfter*c.interval:
+			healthy = append(healthy, addr)
+		case !m.seed && !m.discovered && now.Sub(latest(m.added, m.lastSeen)) > forgetAfter*c.interval:
+			delete(c.members, addr)
+		}
+	}
+	slices.Sort(healthy)
+	previous := c.ring.Nodes()
+	if slices.Equal(previous, healthy) {
+		return
+	}
+	for _, addr := range healthy {
+		if !slices.Contains(previous, addr) {
+			c.logger.Info("peer joined", "peer", addr, "members", len(healthy))
+		}
+	}
+	for _, addr := range previous {
+		if !slices.Contains(healthy, addr) {
+			c.logger.Info("peer left", "peer", addr, "members", len(healthy))
+		}
+	}
+	c.ring = NewRing(healthy)
+}
+
+// Get issues an authenticated request for path to the given peer, with the
+// given extra request headers.
+func (c *Cluster) Get(ctx context.Context, addr, path string, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, addr+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("X-Auth-Token", c.token)
+	return c.client.Do(req)
+}
+
+// Post sends an authenticated POST of body to path on the given peer and
+// fails on an error status.
+func (c *Cluster) Post(ctx context.Context, addr, path, contentType string, body io.Reader) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, addr+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("X-Auth-Token", c.token)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s: %w", addr, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("%s: status %d", addr, resp.StatusCode)
+	}
+	return nil
+}
+
+// Broadcast posts body to path on every known member other than this node and
+// returns the failures joined together.
+func (c *Cluster) Broadcast(ctx context.Context, path string, body []byte) error {
+	peers := c.Peers()
+	errs := make([]error, len(peers))
+	var wg sync.WaitGroup
+	for i, addr := range peers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req, err := http.NewRequestWithContext(ctx, http.MethodPost, addr+path, bytes.NewReader(body))
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("X-Auth-Token", c.token)
+			resp, err := c.client.Do(req)
+			if err != nil {
+				errs[i] = fmt.Errorf("%s: %w", addr, err)
+				return
+			}
+			resp.Body.Close()
+			if resp.StatusCode >= http.StatusBadRequest {
+				errs[i] = fmt.Errorf("%s: status %d", addr, resp.StatusCode)
+			}
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+func latest(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+func normalize(addr string) string {
+	addr = strings.TrimRight(strings.TrimSpace(addr), "/")
+	if addr == "" {
+		return ""
+	}
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	if _, err := url.Parse(addr); err != nil {
+		return ""
+	}
+	return addr
+}
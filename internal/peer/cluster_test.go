@@ -0,0 +1,51 @@
+package peer
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestClusterGossipMembership(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	remote := NewCluster(srv.URL, nil, "token", time.Second, logger)
+	mux.HandleFunc("POST /_peer/gossip", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Auth-Token") != "token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		remote.HandleGossip(w, r)
+	})
+
+	local := NewCluster("http://local:8080", []string{srv.URL}, "token", time.Second, logger)
+	if got := local.Members(); len(got) != 1 {
+		t.Fatalf("expected only self before gossip, got %v", got)
+	}
+
+	local.round(context.Background())
+	want := []string{"http://local:8080", srv.URL}
+	slices.Sort(want)
+	if got := local.Members(); !slices.Equal(got, want) {
+		t.Fatalf("local members = %v, want %v", got, want)
+	}
+	if got := remote.Members(); !slices.Equal(got, want) {
+		t.Fatalf("remote members = %v, want %v", got, want)
+	}
+
+	local.rebuild(time.Now().Add(suspectAfter*time.Second + time.Second))
+	if got := local.Members(); len(got) != 1 {
+		t.Fatalf("expected silent peer to leave the ring, got %v", got)
+	}
+	if got := local.Peers(); !slices.Contains(got, srv.URL) {
+		t.Fatalf("seed peer should be kept for retries, got %v", got)
+	}
+}
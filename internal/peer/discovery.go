@@ -0,0 +1,63 @@
+package peer
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"slices"
+	"time"
+)
+
+// Resolver looks up the addresses behind a host name. *net.Resolver
+// implements it.
+type Resolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// Discover periodically resolves host with resolver, typically a Kubernetes
+// headless service through net.DefaultResolver, and registers every address
+// behind it as a member. Peers are reached with the scheme and port of this
+// node's own URL.
+func (c *Cluster) Discover(ctx context.Context, resolver Resolver, host string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		c.resolve(ctx, resolver, host)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *Cluster) resolve(ctx context.Context, resolver Resolver, host string) {
+	ctx, cancel := context.WithTimeout(ctx, c.interval)
+	defer cancel()
+	ips, err := resolver.LookupHost(ctx, host)
+	if err != nil {
+		c.logger.Warn("peer discovery failed", "host", host, "error", err)
+		return
+	}
+	self, err := url.Parse(c.self)
+	if err != nil {
+		return
+	}
+	addrs := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		addr := self.Scheme + "://" + net.JoinHostPort(ip, self.Port())
+		if self.Port() == "" {
+			addr = self.Scheme + "://" + hostLiteral(ip)
+		}
+		addrs = append(addrs, addr)
+	}
+	slices.Sort(addrs)
+	c.SetDiscovered(addrs)
+}
+
+func hostLiteral(ip string) string {
+	if parsed := net.ParseIP(ip); parsed != nil && parsed.To4() == nil {
+		return "[" + ip + "]"
+	}
+	return ip
+}
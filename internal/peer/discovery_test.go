@@ -0,0 +1,81 @@
+package peer
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeResolver answers lookups with whatever addresses it currently holds.
+type fakeResolver struct {
+	mu    sync.Mutex
+	addrs []string
+	err   error
+}
+
+func (r *fakeResolver) set(err error, addrs ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.addrs, r.err = addrs, err
+}
+
+func (r *fakeResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.addrs), r.err
+}
+
+func TestDiscoveryChurn(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	c := NewCluster("http://10.0.0.1:8080", nil, "token", time.Second, logger)
+	resolver := &fakeResolver{}
+	ctx := context.Background()
+
+	resolver.set(nil, "10.0.0.1", "10.0.0.2", "fd00::3")
+	c.resolve(ctx, resolver, "proxy.default.svc")
+	if got, want := c.Peers(), []string{"http://10.0.0.2:8080", "http://[fd00::3]:8080"}; !slices.Equal(got, want) {
+		t.Fatalf("peers = %v, want %v", got, want)
+	}
+
+	// A failed lookup leaves the members alone.
+	resolver.set(errors.New("no such host"))
+	c.resolve(ctx, resolver, "proxy.default.svc")
+	if got := c.Peers(); len(got) != 2 {
+		t.Fatalf("expected the members to survive a failed lookup, got %v", got)
+	}
+
+	// A pod that goes away is kept until it is forgotten; its replacement
+	// joins straight away.
+	resolver.set(nil, "10.0.0.1", "10.0.0.2", "10.0.0.4")
+	c.resolve(ctx, resolver, "proxy.default.svc")
+	if got := c.Peers(); !slices.Contains(got, "http://[fd00::3]:8080") || !slices.Contains(got, "http://10.0.0.4:8080") {
+		t.Fatalf("peers = %v", got)
+	}
+	c.rebuild(time.Now().Add(forgetAfter*time.Second + time.Second))
+	if got, want := c.Peers(), []string{"http://10.0.0.2:8080", "http://10.0.0.4:8080"}; !slices.Equal(got, want) {
+		t.Fatalf("peers after forgetting = %v, want %v", got, want)
+	}
+
+	// Discover resolves immediately and then on every tick.
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	resolver.set(nil, "10.0.0.5")
+	go func() {
+		c.Discover(ctx, resolver, "proxy.default.svc", 10*time.Millisecond)
+		close(done)
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for !slices.Contains(c.Peers(), "http://10.0.0.5:8080") {
+		if time.Now().After(deadline) {
+			t.Fatalf("discovered peer did not join: %v", c.Peers())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	<-done
+}
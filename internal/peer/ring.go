@@ -0,0 +1,51 @@
+package peer
+
+import (
+	"hash/crc32"
+	"slices"
+	"strconv"
+)
+
+const defaultReplicas = 128
+
+// Ring maps keys to nodes with consistent hashing so that membership changes
+// only move the keys owned by the nodes that joined or left.
+type Ring struct {
+	hashes []uint32
+	owners map[uint32]string
+	nodes  []string
+}
+
+func NewRing(nodes []string) *Ring {
+	r := &Ring{owners: make(map[uint32]string, len(nodes)*defaultReplicas)}
+	r.nodes = slices.Clone(nodes)
+	slices.Sort(r.nodes)
+	for _, node := range r.nodes {
+		for i := range defaultReplicas {
+			h := crc32.ChecksumIEEE([]byte(strconv.Itoa(i) + node))
+			if _, taken := r.owners[h]; taken {
+				continue
+			}
+			r.owners[h] = node
+			r.hashes = append(r.hashes, h)
+		}
+	}
+	slices.Sort(r.hashes)
+	return r
+}
+
+func (r *Ring) Owner(key string) string {
+	if len(r.hashes) == 0 {
+		return ""
+	}
+	h := crc32.ChecksumIEEE([]byte(key))
+	i, _ := slices.BinarySearch(r.hashes, h)
+	if i == len(r.hashes) {
+		i = 0
+	}
+	return r.owners[r.hashes[i]]
+}
+
+func (r *Ring) Nodes() []string {
+	return slices.Clone(r.nodes)
+}
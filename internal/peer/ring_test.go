@@ -0,0 +1,36 @@
+package peer
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRingOwnership(t *testing.T) {
+	nodes := []string{"http://a:8080", "http://b:8080", "http://c:8080"}
+	ring := NewRing(nodes)
+	grown := NewRing(append(nodes, "http://d:8080"))
+
+	moved := 0
+	for i := range 1000 {
+		key := fmt.Sprintf("objects/%d.jpg", i)
+		before, after := ring.Owner(key), grown.Owner(key)
+		if before == "" {
+			t.Fatalf("no owner for %q", key)
+		}
+		if before != after {
+			if after != "http://d:8080" {
+				t.Fatalf("key %q moved from %s to %s instead of the new node", key, before, after)
+			}
+			moved++
+		}
+	}
+	if moved == 0 || moved > 500 {
+		t.Fatalf("expected a share of keys to move to the new node, moved %d", moved)
+	}
+}
+
+func TestRingEmpty(t *testing.T) {
+	if owner := NewRing(nil).Owner("key"); owner != "" {
+		t.Fatalf("expected no owner, got %q", owner)
+	}
+}
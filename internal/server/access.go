@@ -0,0 +1,115 @@
+package server
+
+import (
+	"cmp"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/joeychilson/s3-proxy/internal/config"
+)
+
+// accessPolicy resolves the access mode of a key from the longest matching
+// prefix of the configured policy.
+type accessPolicy struct {
+	def   string
+	rules []accessRule
+}
+
+type accessRule struct {
+	prefix string
+	mode   string
+}
+
+func newAccessPolicy(p config.AccessPolicy) *accessPolicy {
+	policy := &accessPolicy{def: cmp.Or(p.Default, config.AccessPublic)}
+	for prefix, mode := range p.Prefixes {
+		policy.rules = append(policy.rules, accessRule{prefix: prefix, mode: mode})
+	}
+	slices.SortFunc(policy.rules, func(a, b accessRule) int {
+		return cmp.Compare(len(b.prefix), len(a.prefix))
+	})
+	return policy
+}
+
+func (p *accessPolicy) mode(key string) string {
+	for _, rule := range p.rules {
+		if strings.HasPrefix(key, rule.prefix) {
+			return rule.mode
+		}
+	}
+	return p.def
+}
+
+// public reports whether the policy lets every key be read freely, so the
+// check can be left out.
+func (p *accessPolicy) public() bool {
+	return p.def == config.AccessPublic && !slices.ContainsFunc(p.rules, func(r accessRule) bool {
+		return r.mode != config.AccessPublic
+	})
+}
+
+// checkAccess enforces the access policy on a read of key, the object key the
+// request resolved to after index documents, rewrites and virtual host
+// namespaces, so that a path rewritten into a protected prefix is checked as
+// a request for that prefix would be. It reports false once it has answered a
+// refused request. The admin token opens every key that is not denied, and
+// requests forwarded by a peer were checked by the replica that took them,
+// except that denied keys are refused to peers too, so none can pull them.
+func (s *Server) checkAccess(w http.ResponseWriter, r *http.Request, key string) bool {
+	if s.access == nil {
+		return true
+	}
+	mode := s.access.mode(key)
+	if mode != config.AccessDenied && isPeerRequest(r.Context()) {
+		return true
+	}
+	if mode != config.AccessPublic && mode != config.AccessDenied && s.lockedOut(w, r) {
+		return false
+	}
+	status, reason := http.StatusOK, ""
+	switch {
+	case mode == config.AccessPublic:
+	case mode == config.AccessDenied:
+		status, reason = http.StatusForbidden, "denied"
+	case s.authTok != "" && checkToken(r, s.authTok):
+	case mode == config.AccessToken:
+		status, reason = http.StatusUnauthorized, "invalid"
+		if requestToken(r) == "" {
+			reason = "missing"
+		}
+	case mode == config.AccessSigned:
+		if reason = verifySignature(s.cfg.SigningSecret, key, r.URL.Query(), time.Now()); reason != "" {
+			status = http.StatusForbidden
+		}
+	case mode == config.AccessJWT:
+		status, reason = s.checkJWT(r, key)
+	}
+	if reason == "" {
+		return true
+	}
+	s.metrics.accessRejected.WithLabelValues(mode, reason).Inc()
+	if mode != config.AccessDenied && adminTokenAttempt(r, mode) {
+		s.tokenFailed(r)
+	}
+	switch mode {
+	case config.AccessDenied:
+		s.securityEvent(r, securityAccessDenied, reason)
+	case config.AccessSigned:
+		s.securityEvent(r, securitySignatureFailure, reason)
+	default:
+		s.securityEvent(r, securityAuthFailure, reason)
+	}
+	if status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+	}
+	http.Error(w, http.StatusText(status), status)
+	return false
+}
+
+// denied reports whether the policy refuses key to everyone, the admin token
+// included.
+func (s *Server) denied(key string) bool {
+	return s.access != nil && s.access.mode(key) == config.AccessDenied
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"archive/tar"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/joeychilson/s3-proxy/internal/config"
+)
+
+func TestAccessPolicy(t *testing.T) {
+	s := &Server{
+		cfg: &config.Config{},
+		access: newAccessPolicy(config.AccessPolicy{Default: config.AccessToken, Prefixes: map[string]string{
+			"assets/":        config.AccessPublic,
+			"assets/drafts/": config.AccessDenied,
+		}}),
+		authTok: "admin",
+		metrics: newMetrics(prometheus.NewRegistry()),
+		logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.checkAccess(w, r, strings.TrimPrefix(r.URL.Path, "/"))
+	})
+	tests := []struct {
+		path   string
+		token  string
+		status int
+	}{
+		{"/assets/logo.png", "", http.StatusOK},
+		{"/assets/drafts/next.png", "admin", http.StatusForbidden},
+		{"/reports/q3.pdf", "", http.StatusUnauthorized},
+		{"/reports/q3.pdf", "wrong", http.StatusUnauthorized},
+		{"/reports/q3.pdf", "admin", http.StatusOK},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		if tt.token != "" {
+			req.Header.Set("X-Auth-Token", tt.token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tt.status {
+			t.Errorf("%s (token %q): expected status %d, got %d", tt.path, tt.token, tt.status, rec.Code)
+		}
+	}
+	if s.access.public() || !newAccessPolicy(config.AccessPolicy{}).public() {
+		t.Fatalf("expected only an all-public policy to be public")
+	}
+}
+
+func TestAccessPolicyResolvedKey(t *testing.T) {
+	bucket := newFakeBucket(t)
+	bucket.put("private/report.pdf", "secret")
+	bucket.put("docs/index.html", "index")
+	s := newBucketServer(t, bucket, &config.Config{
+		IndexDocument: "index.html",
+		Rewrites:      []config.RewriteRule{{Pattern: `/v2/(.*)`, Replacement: "private/$1"}},
+		AccessPolicy: config.AccessPolicy{Prefixes: map[string]string{
+			"private/":        config.AccessToken,
+			"docs/index.html": config.AccessDenied,
+		}},
+	})
+	s.authTok = "admin"
+
+	// A path rewritten into a protected prefix is checked against it.
+	tests := []struct {
+		path   string
+		token  string
+		status int
+	}{
+		{"/private/report.pdf", "", http.StatusUnauthorized},
+		{"/v2/report.pdf", "", http.StatusUnauthorized},
+		{"/v2/report.pdf", "admin", http.StatusOK},
+		{"/docs/", "", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		header := http.Header{}
+		if tt.token != "" {
+			header.Set("X-Auth-Token", tt.token)
+		}
+		if w := getObject(s, tt.path, header); w.Code != tt.status {
+			t.Errorf("%s (token %q): expected status %d, got %d", tt.path, tt.token, tt.status, w.Code)
+		}
+	}
+	if n := bucket.requests("private/report.pdf"); n != 1 {
+		t.Fatalf("expected only the authorized request to reach the origin, got %d", n)
+	}
+}
+
+func TestAccessPolicyDeniedExports(t *testing.T) {
+	bucket := newFakeBucket(t)
+	bucket.put("logs/a.txt", "first")
+	bucket.put("logs/private/b.txt", "second")
+	bucket.put("reports/q3.pdf", "report")
+	s := newBucketServer(t, bucket, &config.Config{TarConcurrency: 2, AccessPolicy: config.AccessPolicy{
+		Default:  config.AccessToken,
+		Prefixes: map[string]string{"logs/private/": config.AccessDenied},
+	}})
+	s.authTok = "admin"
+	router := chi.NewRouter()
+	router.Get("/_tar/*", s.tarHandler)
+	router.Get("/_peer/objects/*", s.peerObjectHandler)
+
+	// Tar downloads leave denied keys out of the archive.
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/_tar/logs/", nil))
+	var names []string
+	tr := tar.NewReader(w.Body)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		names = append(names, hdr.Name)
+	}
+	if w.Code != http.StatusOK || !slices.Equal(names, []string{"logs/a.txt"}) {
+		t.Fatalf("expected only the allowed key, got %d %v", w.Code, names)
+	}
+	if bucket.requests("logs/private/b.txt") != 0 {
+		t.Fatalf("expected the denied key not to be fetched")
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/_tar/logs/private/", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for a prefix with only denied keys, got %d", w.Code)
+	}
+
+	// Peers are trusted with token keys, but not with denied ones.
+	for path, want := range map[string]int{
+		"/_peer/objects/reports/q3.pdf":     http.StatusOK,
+		"/_peer/objects/logs/private/b.txt": http.StatusForbidden,
+	} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != want {
+			t.Errorf("%s: expected status %d, got %d", path, want, w.Code)
+		}
+	}
+	if bucket.requests("logs/private/b.txt") != 0 {
+		t.Fatalf("expected the denied key not to be fetched for a peer")
+	}
+}
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// statsWindow is the span, in seconds, of the rolling stats.
+	statsWindow   = 60
+	statsInterval = time.Second
+)
+
+type requestEvent struct {
+	Time       time.Time `json:"time"`
+	Key        string    `json:"key"`
+	Cache      string    `json:"cache"`
+	Status     int       `json:"status"`
+	Bytes      int64     `json:"bytes"`
+	DurationMs float64   `json:"duration_ms"`
+}
+
+type rollingStats struct {
+	WindowSeconds     int     `json:"window_seconds"`
+	Requests          int64   `json:"requests"`
+	Hits              int64   `json:"hits"`
+	Misses            int64   `json:"misses"`
+	Errors            int64   `json:"errors"`
+	Bytes             int64   `json:"bytes"`
+	HitRatio          float64 `json:"hit_ratio"`
+	RequestsPerSecond float64 `json:"requests_per_second"`
+}
+
+type statsBucket struct {
+	second                                int64
+	requests, hits, misses, errors, bytes int64
+}
+
+// activity records object requests for the live admin stream: each request
+// is fanned out to subscribers and counted in per-second buckets that make
+// up the rolling stats.
+type activity struct {
+	mu      sync.Mutex
+	subs    map[chan requestEvent]struct{}
+	buckets [statsWindow]statsBucket
+}
+
+func newActivity() *activity {
+	return &activity{subs: make(map[chan requestEvent]struct{})}
+}
+
+func (a *activity) record(e requestEvent) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	sec := e.Time.Unix()
+	b := &a.buckets[sec%statsWindow]
+	if b.second != sec {
+		*b = statsBucket{second: sec}
+	}
+	b.requests++
+	b.bytes += e.Bytes
+	switch {
+	case e.Status >= http.StatusInternalServerError:
+		b.errors++
+	case cacheMiss(e.Cache):
+		b.misses++
+	default:
+		b.hits++
+	}
+	for ch := range a.subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+func (a *activity) stats(now time.Time) rollingStats {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	st := rollingStats{WindowSeconds: statsWindow}
+	for _, b := range a.buckets {
+		if age := now.Unix() - b.second; age < 0 || age >= statsWindow {
+			continue
+		}
+		st.Requests += b.requests
+		st.Hits += b.hits
+		st.Misses += b.misses
+		st.Errors += b.errors
+		st.Bytes += b.bytes
+	}
+	if st.Hits+st.Misses > 0 {
+		st.HitRatio = float64(st.Hits) / float64(st.Hits+st.Misses)
+	}
+	st.RequestsPerSecond = float64(st.Requests) / statsWindow
+	return st
+}
+
+func (a *activity) subscribe() (<-chan requestEvent, func()) {
+	ch := make(chan requestEvent, 256)
+	a.mu.Lock()
+	a.subs[ch] = struct{}{}
+	a.mu.Unlock()
+	return ch, func() {
+		a.mu.Lock()
+		delete(a.subs, ch)
+		a.mu.Unlock()
+	}
+}
+
+// cacheMiss reports whether an X-Cache value, bare or layer-qualified, means
+// the response came from the origin.
+func cacheMiss(xcache string) bool {
+	return strings.HasSuffix(xcache, "MISS") || strings.HasSuffix(xcache, "BYPASS")
+}
+
+// adminEventsHandler streams served requests ("hit" and "miss" events),
+// purges and, every second, the rolling stats over Server-Sent Events.
+func (s *Server) adminEventsHandler(w http.ResponseWriter, r *http.Request) {
+	requests, unsubscribeRequests := s.activity.subscribe()
+	defer unsubscribeRequests()
+	events, unsubscribeEvents := s.events.subscribe()
+	defer unsubscribeEvents()
+
+	rc := startEventStream(w)
+	ticker := time.NewTicker(statsInterval)
+	defer ticker.Stop()
+	for {
+		var err error
+		select {
+		case <-r.Context().Done():
+			return
+		case now := <-ticker.C:
+			err = writeEvent(w, "stats", s.activity.stats(now))
+		case e := <-requests:
+			name := "hit"
+			if cacheMiss(e.Cache) {
+				name = "miss"
+			}
+			err = writeEvent(w, name, e)
+		case e := <-events:
+			if e.Type != eventPurge {
+				continue
+			}
+			err = writeEvent(w, e.Type, e)
+		}
+		if err != nil || rc.Flush() != nil {
+			return
+		}
+	}
+}
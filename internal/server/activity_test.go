@@ -0,0 +1,24 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestActivityStats(t *testing.T) {
+	a := newActivity()
+	now := time.Unix(1_700_000_000, 0)
+	a.record(requestEvent{Time: now.Add(-2 * statsWindow * time.Second), Cache: "HIT", Status: 200, Bytes: 100})
+	a.record(requestEvent{Time: now.Add(-time.Second), Cache: "MEM-HIT", Status: 200, Bytes: 10})
+	a.record(requestEvent{Time: now, Cache: "HIT", Status: 304})
+	a.record(requestEvent{Time: now, Cache: "MISS", Status: 200, Bytes: 5})
+	a.record(requestEvent{Time: now, Cache: "ERROR", Status: 502})
+
+	st := a.stats(now)
+	if st.Requests != 4 || st.Hits != 2 || st.Misses != 1 || st.Errors != 1 || st.Bytes != 15 {
+		t.Fatalf("unexpected stats %+v", st)
+	}
+	if st.HitRatio < 0.66 || st.HitRatio > 0.67 {
+		t.Fatalf("expected a hit ratio of 2/3, got %v", st.HitRatio)
+	}
+}
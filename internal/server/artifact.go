@@ -0,0 +1,55 @@
+package server
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strings"
+
+	"github.com/joeychilson/s3-proxy/internal/origin"
+)
+
+const (
+	checksumSHA256Header  = "X-Amz-Checksum-Sha256"
+	immutableCacheControl = "public, max-age=31536000, immutable"
+)
+
+// immutable reports whether key is an artifact under ARTIFACT_MODE: once
+// written it never changes, so it is cached for a year and cannot be
+// overwritten or deleted through the proxy.
+func (s *Server) immutable(key string) bool {
+	if !s.cfg.ArtifactMode {
+		return false
+	}
+	if len(s.cfg.ArtifactPrefixes) == 0 {
+		return true
+	}
+	for _, prefix := range s.cfg.ArtifactPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// markImmutable replaces the origin's Cache-Control on a successful read of
+// an artifact, which sets both the cache TTL and what clients are told.
+func markImmutable(obj *origin.Object) {
+	if obj.StatusCode != http.StatusOK {
+		return
+	}
+	if obj.Headers == nil {
+		obj.Headers = make(http.Header)
+	}
+	obj.Headers.Set("Cache-Control", immutableCacheControl)
+}
+
+// artifactChecksum returns the base64 SHA-256 an artifact upload must carry
+// in x-amz-checksum-sha256, or false when it is missing or malformed.
+func artifactChecksum(r *http.Request) (string, bool) {
+	value := strings.TrimSpace(r.Header.Get(checksumSHA256Header))
+	sum, err := base64.StdEncoding.DecodeString(value)
+	if err != nil || len(sum) != 32 {
+		return "", false
+	}
+	return value, true
+}
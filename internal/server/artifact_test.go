@@ -0,0 +1,56 @@
+package server
+
+import (
+	"encoding/base64"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/joeychilson/s3-proxy/internal/config"
+	"github.com/joeychilson/s3-proxy/internal/origin"
+)
+
+func TestArtifactMode(t *testing.T) {
+	s := &Server{
+		cfg:     &config.Config{ArtifactMode: true, ArtifactPrefixes: []string{"releases/"}, CacheTTL: time.Minute},
+		metrics: newMetrics(prometheus.NewRegistry()),
+		logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	if !s.immutable("releases/v1.0.0/app.tar.gz") || s.immutable("snapshots/app.tar.gz") {
+		t.Fatalf("expected only keys under ARTIFACT_PREFIXES to be immutable")
+	}
+
+	rec := httptest.NewRecorder()
+	s.putHandler(rec, httptest.NewRequest(http.MethodPut, "/releases/v1.0.0/app.tar.gz", strings.NewReader("data")))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected an upload without a checksum to be rejected with 400, got %d", rec.Code)
+	}
+	req := httptest.NewRequest(http.MethodPut, "/releases/v1.0.0/app.tar.gz", nil)
+	req.Header.Set(checksumSHA256Header, base64.StdEncoding.EncodeToString([]byte("short")))
+	if _, ok := artifactChecksum(req); ok {
+		t.Fatalf("expected a checksum that is not 32 bytes to be rejected")
+	}
+	rec = httptest.NewRecorder()
+	s.deleteHandler(rec, httptest.NewRequest(http.MethodDelete, "/releases/v1.0.0/app.tar.gz", nil))
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("expected deleting an artifact to fail with 409, got %d", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	s.writeFailed(rec, httptest.NewRequest(http.MethodPut, "/releases/x", nil), "releases/x", origin.ErrChecksum)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected a checksum mismatch to map to 400, got %d", rec.Code)
+	}
+
+	obj := &origin.Object{Headers: http.Header{"Cache-Control": {"max-age=60"}}, StatusCode: http.StatusOK}
+	markImmutable(obj)
+	entry := s.newEntry("releases/v1.0.0/app.tar.gz", obj, []byte("data"), time.Now())
+	if entry.TTL != 365*24*time.Hour || entry.Header.Get("Cache-Control") != immutableCacheControl {
+		t.Fatalf("expected artifacts to be cached for a year, got %s with %q", entry.TTL, entry.Header.Get("Cache-Control"))
+	}
+}
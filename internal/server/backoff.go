@@ -0,0 +1,79 @@
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+const maxBackoffKeys = 10000
+
+type backoffState struct {
+	failures int
+	retryAt  time.Time
+	inflight bool
+}
+
+// revalidations tracks in-flight background revalidations and applies
+// per-key exponential backoff after failures.
+type revalidations struct {
+	base time.Duration
+	max  time.Duration
+
+	mu    sync.Mutex
+	state map[string]*backoffState
+}
+
+func newRevalidations(base, max time.Duration) *revalidations {
+	return &revalidations{base: base, max: max, state: make(map[string]*backoffState)}
+}
+
+// begin reports whether a revalidation of key may start now. A true result
+// must be followed by a call to end.
+func (r *revalidations) begin(key string, now time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	st, ok := r.state[key]
+	if !ok {
+		r.state[key] = &backoffState{inflight: true}
+		return true
+	}
+	if st.inflight || now.Before(st.retryAt) {
+		return false
+	}
+	st.inflight = true
+	return true
+}
+
+// end records the outcome of a revalidation and returns the delay before the
+// next attempt is allowed.
+func (r *revalidations) end(key string, now time.Time, failed bool) time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	st, ok := r.state[key]
+	if !ok {
+		return 0
+	}
+	if !failed {
+		delete(r.state, key)
+		return 0
+	}
+	st.inflight = false
+	st.failures++
+	delay := r.base << min(st.failures-1, 30)
+	if delay <= 0 || delay > r.max {
+		delay = r.max
+	}
+	st.retryAt = now.Add(delay)
+	if len(r.state) > maxBackoffKeys {
+		r.prune(now)
+	}
+	return delay
+}
+
+func (r *revalidations) prune(now time.Time) {
+	for key, st := range r.state {
+		if !st.inflight && now.After(st.retryAt.Add(r.max)) {
+			delete(r.state, key)
+		}
+	}
+}
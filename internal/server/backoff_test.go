@@ -0,0 +1,40 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRevalidationBackoff(t *testing.T) {
+	r := newRevalidations(time.Second, 4*time.Second)
+	now := time.Now()
+	if !r.begin("k", now) {
+		t.Fatalf("first revalidation should start")
+	}
+	if r.begin("k", now) {
+		t.Fatalf("concurrent revalidation should be rejected")
+	}
+	if delay := r.end("k", now, true); delay != time.Second {
+		t.Fatalf("expected 1s backoff, got %v", delay)
+	}
+	if r.begin("k", now.Add(500*time.Millisecond)) {
+		t.Fatalf("revalidation should wait for backoff")
+	}
+	if !r.begin("k", now.Add(time.Second)) {
+		t.Fatalf("revalidation should resume after backoff")
+	}
+	if delay := r.end("k", now, true); delay != 2*time.Second {
+		t.Fatalf("expected 2s backoff, got %v", delay)
+	}
+	r.begin("k", now.Add(2*time.Second))
+	r.end("k", now, true)
+	r.begin("k", now.Add(4*time.Second))
+	if delay := r.end("k", now, true); delay != 4*time.Second {
+		t.Fatalf("expected backoff capped at 4s, got %v", delay)
+	}
+	r.begin("k", now.Add(4*time.Second))
+	r.end("k", now, false)
+	if !r.begin("k", now) {
+		t.Fatalf("success should reset backoff")
+	}
+}
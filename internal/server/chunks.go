@@ -0,0 +1,203 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/joeychilson/s3-proxy/internal/cache"
+	"github.com/joeychilson/s3-proxy/internal/config"
+	"github.com/joeychilson/s3-proxy/internal/origin"
+)
+
+// serveChunkedRange answers single-range GETs from fixed-size chunks stored in
+// the cache, fetching only the missing chunks from the origin with aligned
+// ranges. It reports false when the request should be proxied instead.
+func (s *Server) serveChunkedRange(w http.ResponseWriter, r *http.Request, key string) bool {
+	if s.cfg.ChunkSize <= 0 || r.Method != http.MethodGet || noCacheRequested(r) {
+		return false
+	}
+	rangeHeader := r.Header.Get("Range")
+	if rangeHeader == "" || hasPreconditions(r) {
+		return false
+	}
+
+	ctx := r.Context()
+	now := time.Now()
+	meta, err := s.chunkMeta(ctx, key, now)
+	if err != nil {
+		s.handleOriginError(w, r, err, nil, now, cacheKey(key))
+		return true
+	}
+	// Chunks fetched at different times may only be stitched together when
+	// the object has a strong validator.
+	if !strongETag(meta.ETag) || !ifRangeMatch(r, meta.ETag, meta.LastModified) {
+		return false
+	}
+	if s.writeNotModified(w, r, meta, now, layerMemory, "HIT") {
+		s.metrics.cacheHits.Inc()
+		return true
+	}
+	total := segmentTotal(meta)
+	start, end, ok := parseByteRange(rangeHeader, total)
+	if !ok || end-start+1 > s.cfg.MaxObjectSize {
+		return false
+	}
+	if s.cfg.ChunkMode == config.ChunkModeSparse {
+		return s.serveSparseRange(w, r, key, meta, start, end, now)
+	}
+
+	size := s.cfg.ChunkSize
+	first, last := start/size, end/size
+	chunks := make([]*cache.Entry, last-first+1)
+	state := "HIT"
+	for i := first; i <= last; i++ {
+		if entry, ok := s.cache.Get(chunkKey(key, i)); ok && entry.Fresh(now) && entry.ETag == meta.ETag {
+			chunks[i-first] = entry
+		}
+	}
+	for i := first; i <= last; i++ {
+		if chunks[i-first] != nil {
+			continue
+		}
+		state = "MISS"
+		runEnd := i
+		for runEnd < last && chunks[runEnd+1-first] == nil {
+			runEnd++
+		}
+		fetched, err := s.fetchChunks(ctx, key, meta, i, runEnd, now)
+		if err != nil {
+			s.cache.Delete(chunkMetaKey(key))
+			s.logger.Warn("chunk fetch", "error", err, "key", key)
+			return false
+		}
+		copy(chunks[i-first:], fetched)
+		i = runEnd
+	}
+	if state == "HIT" {
+		s.metrics.cacheHits.Inc()
+	} else {
+		s.metrics.cacheMisses.Inc()
+	}
+
+	copyHeaders(w.Header(), meta.Header)
+	w.Header().Del(objectSizeHeader)
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, total))
+	w.Header().Set("Content-Length", strconv.FormatInt(end-start+1, 10))
+	w.Header().Set("Age", strconv.Itoa(chunks[0].Age(now)))
+	s.setCacheStatus(w, stateLayer(state), state)
+	w.WriteHeader(http.StatusPartialContent)
+
+	for i, chunk := range chunks {
+		offset := (first + int64(i)) * size
+		lo := max(start-offset, 0)
+		hi := min(end-offset+1, int64(len(chunk.Body)))
+		if _, err := w.Write(chunk.Body[lo:hi]); err != nil {
+			break
+		}
+	}
+	return true
+}
+
+// chunkMeta returns a body-less entry describing the object's headers, size
+// and validator, issuing a HEAD request when it is not cached.
+func (s *Server) chunkMeta(ctx context.Context, key string, now time.Time) (*cache.Entry, error) {
+	if entry, ok := s.cache.Get(chunkMetaKey(key)); ok && entry.Fresh(now) {
+		return entry, nil
+	}
+	obj, err := s.fetchFromOrigin(ctx, key, &origin.Conditional{}, http.MethodHead)
+	if err != nil {
+		return nil, err
+	}
+	header := cloneHeader(obj.Headers)
+	header.Del("Content-Length")
+	header.Set(objectSizeHeader, strconv.FormatInt(obj.ContentLength, 10))
+	entry := &cache.Entry{
+		Header:       header,
+		Status:       http.StatusOK,
+		StoredAt:     originDate(obj, now),
+		TTL:          s.originTTL(obj, now),
+		StaleTTL:     s.cfg.CacheStaleTTL,
+		ETag:         obj.ETag,
+		LastModified: valueOrZero(obj.LastModified),
+	}
+	if entry.TTL <= 0 {
+		entry.TTL = s.cfg.CacheTTL
+	}
+	if !hasNoStore(obj.Headers) {
+		s.cache.Set(chunkMetaKey(key), entry)
+	}
+	return entry, nil
+}
+
+// fetchChunks fetches chunks first through last with a single aligned range
+// request and stores each one in the cache.
+func (s *Server) fetchChunks(ctx context.Context, key string, meta *cache.Entry, first, last int64, now time.Time) ([]*cache.Entry, error) {
+	size := s.cfg.ChunkSize
+	total := segmentTotal(meta)
+	start := first * size
+	end := min((last+1)*size, total) - 1
+
+	cond := &origin.Conditional{Range: fmt.Sprintf("bytes=%d-%d", start, end)}
+	obj, err := s.fetchFromOrigin(ctx, key, cond, http.MethodGet)
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Body.Close()
+	if obj.StatusCode != http.StatusPartialContent && start > 0 {
+		return nil, errRangeIgnored
+	}
+	if !etagsEqual(obj.ETag, meta.ETag, true) || objectTotal(obj) != total {
+		return nil, fmt.Errorf("object changed during chunked read")
+	}
+
+	noStore := hasNoStore(obj.Headers)
+	chunks := make([]*cache.Entry, 0, last-first+1)
+	for i := first; i <= last; i++ {
+		chunkLen := min(size, total-i*size)
+		body := make([]byte, chunkLen)
+		if _, err := io.ReadFull(obj.Body, body); err != nil {
+			return nil, err
+		}
+		entry := &cache.Entry{
+			Body:         body,
+			Status:       http.StatusPartialContent,
+			StoredAt:     now,
+			TTL:          meta.TTL,
+			StaleTTL:     meta.StaleTTL,
+			Size:         chunkLen,
+			ETag:         meta.ETag,
+			LastModified: meta.LastModified,
+		}
+		if !noStore {
+			s.cache.Set(chunkKey(key, i), entry)
+		}
+		chunks = append(chunks, entry)
+	}
+	return chunks, nil
+}
+
+func (s *Server) purgeChunks(key string) {
+	s.purgeSegments(key)
+	meta, ok := s.cache.Get(chunkMetaKey(key))
+	s.cache.Delete(chunkMetaKey(key))
+	if !ok || s.cfg.ChunkSize <= 0 {
+		return
+	}
+	total := segmentTotal(meta)
+	for i := int64(0); i*s.cfg.ChunkSize < total; i++ {
+		s.cache.Delete(chunkKey(key, i))
+	}
+}
+
+func chunkMetaKey(key string) string {
+	return cacheKey(key) + "#chunk-meta"
+}
+
+func chunkKey(key string, index int64) string {
+	return cacheKey(key) + "#chunk-" + strconv.FormatInt(index, 10)
+}
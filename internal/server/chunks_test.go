@@ -0,0 +1,97 @@
+package server
+
+import (
+	"net/http"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/joeychilson/s3-proxy/internal/config"
+)
+
+func TestChunkedRange(t *testing.T) {
+	bucket := newFakeBucket(t)
+	obj := bucket.put("a.bin", "0123456789abcdef")
+	var mu sync.Mutex
+	var ranges []string
+	bucket.intercept(func(w http.ResponseWriter, r *http.Request, key string) bool {
+		if r.Method == http.MethodGet {
+			mu.Lock()
+			ranges = append(ranges, r.Header.Get("Range"))
+			mu.Unlock()
+		}
+		return false
+	})
+	fetched := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		got := ranges
+		ranges = nil
+		return got
+	}
+	s := newBucketServer(t, bucket, &config.Config{ChunkSize: 4})
+
+	// A range inside chunks 1 and 2 is fetched with one aligned request.
+	w := getObject(s, "/a.bin", http.Header{"Range": {"bytes=5-10"}})
+	if w.Code != http.StatusPartialContent || w.Body.String() != "56789a" || w.Header().Get("Content-Range") != "bytes 5-10/16" || w.Header().Get("X-Cache") != "MISS" {
+		t.Fatalf("unexpected response %d %q %q %q", w.Code, w.Body.String(), w.Header().Get("Content-Range"), w.Header().Get("X-Cache"))
+	}
+	if got := fetched(); !slices.Equal(got, []string{"bytes=4-11"}) {
+		t.Fatalf("expected one aligned fetch, got %v", got)
+	}
+	for i, want := range []bool{false, true, true, false} {
+		if _, ok := s.cache.Get(chunkKey("a.bin", int64(i))); ok != want {
+			t.Fatalf("chunk %d: cached %v, want %v", i, ok, want)
+		}
+	}
+
+	// A range spanning cached and missing chunks fetches only the gaps.
+	w = getObject(s, "/a.bin", http.Header{"Range": {"bytes=2-13"}})
+	if w.Code != http.StatusPartialContent || w.Body.String() != "23456789abcd" {
+		t.Fatalf("unexpected response %d %q", w.Code, w.Body.String())
+	}
+	if got := fetched(); !slices.Equal(got, []string{"bytes=0-3", "bytes=12-15"}) {
+		t.Fatalf("expected the missing chunks to be fetched, got %v", got)
+	}
+	w = getObject(s, "/a.bin", http.Header{"Range": {"bytes=1-14"}})
+	if w.Body.String() != "123456789abcde" || w.Header().Get("X-Cache") != "HIT" {
+		t.Fatalf("unexpected response %q %q", w.Body.String(), w.Header().Get("X-Cache"))
+	}
+	if got := fetched(); len(got) != 0 {
+		t.Fatalf("expected a full hit, got fetches %v", got)
+	}
+
+	// Chunks of a changed object are not stitched onto the cached ones.
+	s.cache.Delete(chunkKey("a.bin", 0))
+	bucket.mu.Lock()
+	obj.body, obj.etag = "0123456789ABCDEF", `"v2"`
+	bucket.mu.Unlock()
+	w = getObject(s, "/a.bin", http.Header{"Range": {"bytes=0-7"}})
+	if w.Body.String() != "01234567" || w.Header().Get("X-Cache") == "HIT" {
+		t.Fatalf("unexpected response %q %q", w.Body.String(), w.Header().Get("X-Cache"))
+	}
+	if _, ok := s.cache.Get(chunkMetaKey("a.bin")); ok {
+		t.Fatalf("expected the stale chunk metadata to be dropped")
+	}
+	if _, ok := s.cache.Get(chunkKey("a.bin", 0)); ok {
+		t.Fatalf("expected the changed chunk not to be cached")
+	}
+	w = getObject(s, "/a.bin", http.Header{"Range": {"bytes=8-11"}})
+	if w.Body.String() != "89AB" {
+		t.Fatalf("expected the new object after the change, got %q", w.Body.String())
+	}
+	fetched()
+
+	// An origin that ignores ranges is proxied instead of chunked.
+	bucket.put("whole.bin", "0123456789").ignoreRange = true
+	w = getObject(s, "/whole.bin", http.Header{"Range": {"bytes=4-7"}})
+	if w.Code != http.StatusOK || w.Body.String() != "0123456789" {
+		t.Fatalf("expected the proxied full object, got %d %q", w.Code, w.Body.String())
+	}
+	if _, ok := s.cache.Get(chunkKey("whole.bin", 1)); ok {
+		t.Fatalf("expected no chunk for an origin that ignores ranges")
+	}
+	if _, ok := s.cache.Get(chunkMetaKey("whole.bin")); ok {
+		t.Fatalf("expected the chunk metadata to be dropped")
+	}
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Traffic classes a request can be assigned to.
+const (
+	classDefault = "default"
+	classCrawler = "crawler"
+)
+
+type classKey struct{}
+
+// crawlerClass holds the rules for requests classified as crawlers: their
+// own per-IP rate limit, and whether they may only be served from the cache.
+type crawlerClass struct {
+	patterns  []*regexp.Regexp
+	limiter   *rateLimiter
+	cacheOnly bool
+}
+
+func newCrawlerClass(patterns []string, rps float64, cacheOnly bool) (*crawlerClass, error) {
+	c := &crawlerClass{cacheOnly: cacheOnly}
+	for _, pattern := range patterns {
+		re, err := compileGlob(strings.ToLower(strings.TrimSpace(pattern)))
+		if err != nil {
+			return nil, err
+		}
+		c.patterns = append(c.patterns, re)
+	}
+	if rps > 0 {
+		c.limiter = newRateLimiter(rps, rps)
+	}
+	return c, nil
+}
+
+// classify treats requests without a user agent, or with one matching a
+// crawler pattern, as crawlers.
+func (c *crawlerClass) classify(r *http.Request) string {
+	ua := strings.ToLower(r.UserAgent())
+	if ua == "" || matchAny(c.patterns, ua) {
+		return classCrawler
+	}
+	return classDefault
+}
+
+func (s *Server) classMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		class := s.crawlers.classify(r)
+		s.metrics.classRequests.WithLabelValues(class).Inc()
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), classKey{}, class)))
+	})
+}
+
+func requestClass(ctx context.Context) string {
+	if class, ok := ctx.Value(classKey{}).(string); ok {
+		return class
+	}
+	return classDefault
+}
+
+func (s *Server) cacheOnly(r *http.Request) bool {
+	return s.crawlers != nil && s.crawlers.cacheOnly && requestClass(r.Context()) == classCrawler && !isPeerRequest(r.Context())
+}
+
+// serveCacheOnly answers from whatever copy of key is cached, fresh or stale,
+// and never contacts the origin. A miss gets 503 so that crawlers come back
+// later rather than treating the object as gone.
+func (s *Server) serveCacheOnly(w http.ResponseWriter, r *http.Request, key string) {
+	now := time.Now()
+	cKey := s.requestCacheKey(key, r)
+	if entry, ok := s.cache.Get(cKey); ok {
+		state := "HIT"
+		if entry.Fresh(now) {
+			s.metrics.cacheHits.Inc()
+		} else {
+			state = "STALE"
+			s.metrics.cacheStales.Inc()
+		}
+		s.writeCacheEntry(w, r, cKey, entry, now, state)
+		return
+	}
+	s.metrics.crawlerMisses.Inc()
+	w.Header().Set("Retry-After", "60")
+	http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+}
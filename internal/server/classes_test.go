@@ -0,0 +1,68 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/joeychilson/s3-proxy/internal/cache"
+	"github.com/joeychilson/s3-proxy/internal/config"
+)
+
+func TestCrawlerCacheOnly(t *testing.T) {
+	crawlers, err := newCrawlerClass([]string{"*Googlebot*", "*spider*"}, 0, true)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	store, err := cache.New(16, 1<<20, time.Minute, time.Minute)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s := &Server{
+		cfg:      &config.Config{},
+		cache:    store,
+		crawlers: crawlers,
+		metrics:  newMetrics(prometheus.NewRegistry()),
+		layers:   []string{layerMemory},
+	}
+	store.Set(cacheKey("cached.txt"), &cache.Entry{
+		Body:     []byte("hello"),
+		Header:   http.Header{"Content-Type": {"text/plain"}},
+		Status:   http.StatusOK,
+		StoredAt: time.Now().Add(-2 * time.Minute),
+		TTL:      time.Minute,
+		StaleTTL: time.Hour,
+		Size:     5,
+	})
+
+	tests := []struct {
+		ua, path string
+		want     int
+		class    string
+	}{
+		{"Mozilla/5.0 (compatible; Googlebot/2.1)", "/cached.txt", http.StatusOK, classCrawler},
+		{"", "/missing.txt", http.StatusServiceUnavailable, classCrawler},
+		{"Mozilla/5.0", "/cached.txt", 0, classDefault},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		r.Header.Set("User-Agent", tt.ua)
+		if class := crawlers.classify(r); class != tt.class {
+			t.Fatalf("%q: got class %q, want %q", tt.ua, class, tt.class)
+		}
+		r = r.WithContext(context.WithValue(r.Context(), classKey{}, tt.class))
+		if !s.cacheOnly(r) {
+			continue
+		}
+		w := httptest.NewRecorder()
+		s.serveCacheOnly(w, r, strings.TrimPrefix(tt.path, "/"))
+		if w.Code != tt.want {
+			t.Fatalf("%s: got status %d, want %d", tt.path, w.Code, tt.want)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/joeychilson/s3-proxy/internal/cache"
+)
+
+// Compressed copies of cached entries are stored under their own key so each
+// object is compressed once per cache fill. Only gzip is produced: brotli and
+// zstd have no standard library encoder.
+const gzipVariantSuffix = "#enc-gzip"
+
+// negotiateEncoding returns the gzip variant of entry, stored next to it under
+// cKey, when the client accepts it and the entry is worth compressing, and
+// entry itself otherwise.
+func (s *Server) negotiateEncoding(w http.ResponseWriter, r *http.Request, cKey string, entry *cache.Entry) *cache.Entry {
+	if !s.compressible(entry) {
+		return entry
+	}
+	addVary(w.Header(), "Accept-Encoding")
+	if !acceptsGzip(r) {
+		return entry
+	}
+	vKey := cKey + gzipVariantSuffix
+	if variant, ok := s.cache.Get(vKey); ok && variant.StoredAt.Equal(entry.StoredAt) && variant.ETag == weakETag(entry.ETag) {
+		return variant
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(entry.Body); err != nil || zw.Close() != nil {
+		return entry
+	}
+	header := cloneHeader(entry.Header)
+	header.Set("Content-Encoding", "gzip")
+	header.Set("Content-Length", strconv.Itoa(buf.Len()))
+	// Digest and Repr-Digest describe the uncompressed bytes; the
+	// x-amz-checksum-* headers are S3 metadata and still hold once decoded.
+	header.Del("Digest")
+	header.Del("Repr-Digest")
+	if etag := header.Get("ETag"); etag != "" {
+		header.Set("ETag", weakETag(etag))
+	}
+	addVary(header, "Accept-Encoding")
+	variant := &cache.Entry{
+		Body:         buf.Bytes(),
+		Header:       header,
+		Status:       entry.Status,
+		StoredAt:     entry.StoredAt,
+		TTL:          entry.TTL,
+		StaleTTL:     entry.StaleTTL,
+		Size:         int64(buf.Len()),
+		ETag:         weakETag(entry.ETag),
+		LastModified: entry.LastModified,
+	}
+	s.cache.Set(vKey, variant)
+	s.metrics.compressions.Inc()
+	return variant
+}
+
+func (s *Server) compressible(entry *cache.Entry) bool {
+	return s.compressibleResponse(entry.Status, entry.Size, entry.Header)
+}
+
+// compressibleResponse reports whether a response with the given status,
+// body size and headers gets a gzip variant once cached.
+func (s *Server) compressibleResponse(status int, size int64, header http.Header) bool {
+	if !s.cfg.Compression || status != http.StatusOK || size < s.cfg.CompressionMinSize {
+		return false
+	}
+	if header.Get("Content-Encoding") != "" || header.Get("Content-Range") != "" {
+		return false
+	}
+	contentType, _, _ := strings.Cut(header.Get("Content-Type"), ";")
+	contentType = strings.ToLower(strings.TrimSpace(contentType))
+	return strings.HasPrefix(contentType, "text/") || contentType == "application/json"
+}
+
+// acceptsGzip reports whether Accept-Encoding allows gzip with a non-zero
+// quality, either by name or through "*".
+func acceptsGzip(r *http.Request) bool {
+	for _, value := range r.Header.Values("Accept-Encoding") {
+		for part := range strings.SplitSeq(value, ",") {
+			coding, params, _ := strings.Cut(part, ";")
+			coding = strings.ToLower(strings.TrimSpace(coding))
+			if coding != "gzip" && coding != "*" {
+				continue
+			}
+			q := 1.0
+			if v, found := strings.CutPrefix(strings.ReplaceAll(params, " ", ""), "q="); found {
+				q, _ = strconv.ParseFloat(v, 64)
+			}
+			return q > 0
+		}
+	}
+	return false
+}
+
+func addVary(h http.Header, name string) {
+	for _, value := range h.Values("Vary") {
+		for existing := range strings.SplitSeq(value, ",") {
+			if existing = strings.TrimSpace(existing); existing == "*" || strings.EqualFold(existing, name) {
+				return
+			}
+		}
+	}
+	h.Add("Vary", name)
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/joeychilson/s3-proxy/internal/config"
+)
+
+func TestAcceptsGzip(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{"", false},
+		{"gzip", true},
+		{"br, gzip;q=0.5", true},
+		{"gzip;q=0", false},
+		{"*", true},
+		{"identity", false},
+	}
+	for _, tt := range tests {
+		r, _ := http.NewRequest(http.MethodGet, "/a.css", nil)
+		if tt.header != "" {
+			r.Header.Set("Accept-Encoding", tt.header)
+		}
+		if got := acceptsGzip(r); got != tt.want {
+			t.Fatalf("acceptsGzip(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestGzipVariantPurge(t *testing.T) {
+	bucket := newFakeBucket(t)
+	bucket.put("assets/site.css", strings.Repeat("body { color: red; }\n", 20)).header.Set("Content-Type", "text/css")
+	s := newBucketServer(t, bucket, &config.Config{
+		Compression: true,
+		Rewrites:    []config.RewriteRule{{Pattern: `/v2/(.*)`, Replacement: "assets/$1"}},
+	})
+
+	// The variant belongs to the rewritten key, not the request path.
+	gzip := http.Header{"Accept-Encoding": {"gzip"}}
+	getObject(s, "/v2/site.css", gzip)
+	if w := getObject(s, "/v2/site.css", gzip); w.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("expected a gzip variant, got %v", w.Header())
+	}
+	vKey := cacheKey("assets/site.css") + gzipVariantSuffix
+	if _, ok := s.cache.Get(vKey); !ok {
+		t.Fatalf("expected the variant under %q, got keys %v", vKey, s.cache.Keys())
+	}
+
+	s.purgeKey("assets/site.css", "api")
+	if _, ok := s.cache.Get(vKey); ok {
+		t.Fatalf("expected purging the object to drop its gzip variant")
+	}
+	if w := getObject(s, "/v2/site.css", gzip); w.Header().Get("X-Cache") != "MISS" {
+		t.Fatalf("expected a miss after the purge, got %q", w.Header().Get("X-Cache"))
+	}
+}
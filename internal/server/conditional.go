@@ -0,0 +1,176 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joeychilson/s3-proxy/internal/cache"
+	"github.com/joeychilson/s3-proxy/internal/origin"
+)
+
+// entryConditional builds origin validators from a cached entry.
+func entryConditional(entry *cache.Entry) *origin.Conditional {
+	cond := &origin.Conditional{}
+	if entry.ETag != "" {
+		cond.IfNoneMatch = entry.ETag
+	}
+	if !entry.LastModified.IsZero() {
+		lm := entry.LastModified
+		cond.IfModifiedSince = &lm
+	}
+	return cond
+}
+
+// notModified evaluates the request's If-None-Match, or If-Modified-Since
+// when there is none, against a cached entry (RFC 9110, section 13.2.2).
+func notModified(r *http.Request, entry *cache.Entry) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if inm := r.Header.Values("If-None-Match"); len(inm) > 0 {
+		return etagListMatch(inm, entry.ETag, false)
+	}
+	ims, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil || entry.LastModified.IsZero() {
+		return false
+	}
+	return !entry.LastModified.Truncate(time.Second).After(ims)
+}
+
+// writeNotModified answers 304 with the entry's validators when the
+// request's If-None-Match or If-Modified-Since matches it, reporting
+// whether it did.
+func (s *Server) writeNotModified(w http.ResponseWriter, r *http.Request, entry *cache.Entry, now time.Time, layer, state string) bool {
+	if !notModified(r, entry) {
+		return false
+	}
+	copyValidatorHeaders(w.Header(), entry.Header)
+	w.Header().Set("Age", strconv.Itoa(entry.Age(now)))
+	s.setCacheStatus(w, layer, state)
+	s.metrics.notModified.Inc()
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// ifMatch evaluates the request's If-Match against a cached entry. Only
+// strong entity tags can match (RFC 9110, section 13.1.1).
+func ifMatch(r *http.Request, entry *cache.Entry) bool {
+	im := r.Header.Values("If-Match")
+	return len(im) == 0 || etagListMatch(im, entry.ETag, true)
+}
+
+// ifUnmodifiedSince evaluates the request's If-Unmodified-Since against a
+// cached entry. It is ignored when If-Match is present, which takes its
+// place, or the entry has no Last-Modified (RFC 9110, section 13.1.4).
+func ifUnmodifiedSince(r *http.Request, entry *cache.Entry) bool {
+	if len(r.Header.Values("If-Match")) > 0 {
+		return true
+	}
+	ius, err := http.ParseTime(r.Header.Get("If-Unmodified-Since"))
+	if err != nil || entry.LastModified.IsZero() {
+		return true
+	}
+	return !entry.LastModified.Truncate(time.Second).After(ius)
+}
+
+// hasPreconditions reports whether the request carries If-Match or
+// If-Unmodified-Since, which are checked against the current object.
+func hasPreconditions(r *http.Request) bool {
+	return r.Header.Get("If-Match") != "" || r.Header.Get("If-Unmodified-Since") != ""
+}
+
+// ifRangeMatch reports whether a Range request's If-Range validator still
+// matches the object, so the range may be served. An entity tag needs a
+// strong match and a date must equal Last-Modified (RFC 9110, section 13.1.5).
+func ifRangeMatch(r *http.Request, etag string, lastModified time.Time) bool {
+	v := strings.TrimSpace(r.Header.Get("If-Range"))
+	if v == "" {
+		return true
+	}
+	if strings.HasPrefix(v, `"`) || strings.HasPrefix(v, "W/") {
+		return etag != "" && etagsEqual(v, etag, true)
+	}
+	t, err := http.ParseTime(v)
+	return err == nil && !lastModified.IsZero() && lastModified.Truncate(time.Second).Equal(t)
+}
+
+// strongETag reports whether etag can be used to combine byte ranges fetched
+// at different times.
+func strongETag(etag string) bool {
+	return etag != "" && !strings.HasPrefix(etag, "W/")
+}
+
+func weakETag(etag string) string {
+	if etag == "" || strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return "W/" + etag
+}
+
+// etagListMatch reports whether etag matches any entity tag in the header
+// values, using strong or weak comparison (RFC 9110, section 8.8.3.2).
+func etagListMatch(values []string, etag string, strong bool) bool {
+	if etag == "" {
+		return false
+	}
+	for _, value := range values {
+		for _, tag := range parseETags(value) {
+			if tag == "*" || etagsEqual(tag, etag, strong) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func etagsEqual(a, b string, strong bool) bool {
+	a, b = strings.TrimSpace(a), strings.TrimSpace(b)
+	aWeak, bWeak := strings.HasPrefix(a, "W/"), strings.HasPrefix(b, "W/")
+	if strong {
+		return !aWeak && !bWeak && a == b
+	}
+	return strings.TrimPrefix(a, "W/") == strings.TrimPrefix(b, "W/")
+}
+
+// parseETags splits an If-None-Match style list into entity tags. Commas are
+// valid inside quoted tags, so the list is scanned rather than split.
+func parseETags(value string) []string {
+	var tags []string
+	for {
+		value = strings.TrimLeft(value, " \t,")
+		if value == "" {
+			return tags
+		}
+		if value[0] == '*' {
+			tags = append(tags, "*")
+			value = value[1:]
+			continue
+		}
+		prefix := ""
+		if strings.HasPrefix(value, "W/") {
+			prefix, value = "W/", value[2:]
+		}
+		if value == "" || value[0] != '"' {
+			return tags
+		}
+		end := strings.IndexByte(value[1:], '"')
+		if end < 0 {
+			return tags
+		}
+		tags = append(tags, prefix+value[:end+2])
+		value = value[end+2:]
+	}
+}
+
+var validatorHeaders = []string{"Cache-Control", "Content-Location", "ETag", "Expires", "Last-Modified", "Vary"}
+
+// copyValidatorHeaders copies the headers a 304 response carries.
+func copyValidatorHeaders(dst, src http.Header) {
+	for _, name := range validatorHeaders {
+		if v := src.Values(name); len(v) > 0 {
+			dst[http.CanonicalHeaderKey(name)] = append([]string(nil), v...)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/joeychilson/s3-proxy/internal/cache"
+	"github.com/joeychilson/s3-proxy/internal/config"
+)
+
+func TestNotModified(t *testing.T) {
+	lm := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	entry := &cache.Entry{Status: http.StatusOK, ETag: `"abc,1"`, LastModified: lm}
+	tests := []struct {
+		name   string
+		header http.Header
+		want   bool
+	}{
+		{"list match", http.Header{"If-None-Match": {`"x", "abc,1"`}}, true},
+		{"weak match", http.Header{"If-None-Match": {`W/"abc,1"`}}, true},
+		{"star", http.Header{"If-None-Match": {`*`}}, true},
+		{"no match", http.Header{"If-None-Match": {`"abc"`, `"1"`}}, false},
+		{"inm wins over ims", http.Header{"If-None-Match": {`"x"`}, "If-Modified-Since": {lm.Format(http.TimeFormat)}}, false},
+		{"ims not modified", http.Header{"If-Modified-Since": {lm.Format(http.TimeFormat)}}, true},
+		{"ims modified", http.Header{"If-Modified-Since": {lm.Add(-time.Hour).Format(http.TimeFormat)}}, false},
+		{"none", http.Header{}, false},
+	}
+	for _, tt := range tests {
+		r, _ := http.NewRequest(http.MethodGet, "/a", nil)
+		r.Header = tt.header
+		if got := notModified(r, entry); got != tt.want {
+			t.Fatalf("%s: notModified = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	if etagListMatch([]string{`W/"abc,1"`}, `"abc,1"`, true) {
+		t.Fatalf("weak tags must not match under strong comparison")
+	}
+}
+
+func TestStrongValidators(t *testing.T) {
+	lm := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		ifRange string
+		etag    string
+		want    bool
+	}{
+		{"absent", "", `"abc"`, true},
+		{"strong match", `"abc"`, `"abc"`, true},
+		{"weak validator", `W/"abc"`, `"abc"`, false},
+		{"weak object", `"abc"`, `W/"abc"`, false},
+		{"changed", `"abc"`, `"def"`, false},
+		{"date match", lm.Format(http.TimeFormat), `"abc"`, true},
+		{"date changed", lm.Add(-time.Hour).Format(http.TimeFormat), `"abc"`, false},
+	}
+	for _, tt := range tests {
+		r, _ := http.NewRequest(http.MethodGet, "/a", nil)
+		if tt.ifRange != "" {
+			r.Header.Set("If-Range", tt.ifRange)
+		}
+		if got := ifRangeMatch(r, tt.etag, lm); got != tt.want {
+			t.Fatalf("%s: ifRangeMatch = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	r, _ := http.NewRequest(http.MethodGet, "/a", nil)
+	r.Header.Set("If-Match", `"x", "abc"`)
+	if !ifMatch(r, &cache.Entry{ETag: `"abc"`}) {
+		t.Fatalf("expected If-Match to match a strong tag in the list")
+	}
+	if ifMatch(r, &cache.Entry{ETag: `W/"abc"`}) {
+		t.Fatalf("expected If-Match not to match a weak tag")
+	}
+	if strongETag(`W/"abc"`) || !strongETag(`"abc"`) || weakETag(`"abc"`) != `W/"abc"` {
+		t.Fatalf("unexpected weak/strong classification")
+	}
+}
+
+func TestPreconditions(t *testing.T) {
+	s := &Server{cfg: &config.Config{}, metrics: newMetrics(prometheus.NewRegistry()), layers: []string{layerMemory}}
+	lm := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	entry := &cache.Entry{
+		Body:         []byte("hello"),
+		Header:       http.Header{"Etag": {`"v1"`}},
+		ETag:         `"v1"`,
+		LastModified: lm,
+		Status:       http.StatusOK,
+		StoredAt:     time.Now(),
+		TTL:          time.Minute,
+		Size:         5,
+	}
+	tests := []struct {
+		ifMatch, ifUnmodifiedSince string
+		want                       int
+	}{
+		{"", lm.Format(http.TimeFormat), http.StatusOK},
+		{"", lm.Add(-time.Hour).Format(http.TimeFormat), http.StatusPreconditionFailed},
+		{"", "not a date", http.StatusOK},
+		{`"v1"`, lm.Add(-time.Hour).Format(http.TimeFormat), http.StatusOK},
+		{`"v2"`, lm.Format(http.TimeFormat), http.StatusPreconditionFailed},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/a.txt", nil)
+		if tt.ifMatch != "" {
+			r.Header.Set("If-Match", tt.ifMatch)
+		}
+		r.Header.Set("If-Unmodified-Since", tt.ifUnmodifiedSince)
+		w := httptest.NewRecorder()
+		s.writeCacheEntry(w, r, cacheKey("a.txt"), entry, time.Now(), "HIT")
+		if w.Code != tt.want {
+			t.Fatalf("If-Match %q, If-Unmodified-Since %q: got %d, want %d", tt.ifMatch, tt.ifUnmodifiedSince, w.Code, tt.want)
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/a.txt", nil)
+	r.Header.Set("If-Unmodified-Since", lm.Format(http.TimeFormat))
+	if cond := buildConditional(r); cond.IfUnmodifiedSince == nil || !cond.IfUnmodifiedSince.Equal(lm) {
+		t.Fatalf("expected If-Unmodified-Since to be forwarded, got %+v", cond)
+	}
+	r.Header.Set("If-Match", `"v1"`)
+	if cond := buildConditional(r); cond.IfUnmodifiedSince != nil {
+		t.Fatalf("expected If-Match to take the place of If-Unmodified-Since, got %+v", cond)
+	}
+}
+
+func TestNotModifiedRange(t *testing.T) {
+	store, err := cache.New(16, 1<<20, time.Minute, time.Minute)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	reg := prometheus.NewRegistry()
+	s := &Server{cfg: &config.Config{ChunkSize: 4, MaxObjectSize: 1 << 20}, cache: store, metrics: newMetrics(reg), layers: []string{layerMemory}}
+	store.Set(chunkMetaKey("a.bin"), &cache.Entry{
+		Header:   http.Header{"Etag": {`"v1"`}, objectSizeHeader: {"10"}},
+		ETag:     `"v1"`,
+		Status:   http.StatusOK,
+		StoredAt: time.Now(),
+		TTL:      time.Minute,
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/a.bin", nil)
+	r.Header.Set("Range", "bytes=0-3")
+	r.Header.Set("If-None-Match", `W/"v1"`)
+	w := httptest.NewRecorder()
+	if !s.serveChunkedRange(w, r, "a.bin") {
+		t.Fatalf("expected the range to be answered from the cached validators")
+	}
+	if w.Code != http.StatusNotModified || w.Body.Len() != 0 || w.Header().Get("Etag") != `"v1"` {
+		t.Fatalf("got %d %q %v", w.Code, w.Body.String(), w.Header())
+	}
+	if got := testutil.ToFloat64(s.metrics.notModified); got != 1 {
+		t.Fatalf("expected one 304 to be counted, got %v", got)
+	}
+}
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/joeychilson/s3-proxy/internal/config"
+)
+
+// corsPolicy answers cross-origin requests for objects as configured by the
+// CORS_* settings.
+type corsPolicy struct {
+	origins     []string
+	methods     []string
+	headers     []string
+	exposed     string
+	maxAge      string
+	credentials bool
+}
+
+func newCORSPolicy(cfg *config.Config) *corsPolicy {
+	if len(cfg.CORSAllowedOrigins) == 0 {
+		return nil
+	}
+	return &corsPolicy{
+		origins:     cfg.CORSAllowedOrigins,
+		methods:     cfg.CORSAllowedMethods,
+		headers:     cfg.CORSAllowedHeaders,
+		exposed:     strings.Join(cfg.CORSExposedHeaders, ", "),
+		maxAge:      strconv.Itoa(int(cfg.CORSMaxAge.Seconds())),
+		credentials: cfg.CORSAllowCredentials,
+	}
+}
+
+// allowOrigin returns the Access-Control-Allow-Origin value for origin, or
+// "" when it is not allowed. A wildcard is echoed back as the origin itself
+// when credentials are allowed, since browsers refuse "*" with them.
+func (c *corsPolicy) allowOrigin(origin string) string {
+	if origin == "" {
+		return ""
+	}
+	for _, allowed := range c.origins {
+		if allowed == "*" {
+			if c.credentials {
+				return origin
+			}
+			return "*"
+		}
+		if prefix, suffix, ok := strings.Cut(allowed, "*"); ok {
+			if len(origin) > len(prefix)+len(suffix) && strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix) {
+				return origin
+			}
+		} else if strings.EqualFold(allowed, origin) {
+			return origin
+		}
+	}
+	return ""
+}
+
+func (c *corsPolicy) allowHeaders(requested string) bool {
+	if slices.Contains(c.headers, "*") {
+		return true
+	}
+	for name := range strings.SplitSeq(requested, ",") {
+		name = strings.TrimSpace(name)
+		if name != "" && !slices.ContainsFunc(c.headers, func(h string) bool { return strings.EqualFold(h, name) }) {
+			return false
+		}
+	}
+	return true
+}
+
+// setOrigin adds the headers shared by preflight and actual responses.
+func (c *corsPolicy) setOrigin(h http.Header, allowed string) {
+	h.Set("Access-Control-Allow-Origin", allowed)
+	if c.variesByOrigin() {
+		h.Add("Vary", "Origin")
+	}
+	if c.credentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// variesByOrigin reports whether responses depend on the request's Origin,
+// which is the case unless every origin gets "*".
+func (c *corsPolicy) variesByOrigin() bool {
+	return c.credentials || !slices.Contains(c.origins, "*")
+}
+
+// corsMiddleware adds CORS headers to responses for allowed origins. They are
+// set as the response is written, so that the object's own headers, copied
+// in by the handler, cannot replace them.
+func (s *Server) corsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		allowed := s.cors.allowOrigin(r.Header.Get("Origin"))
+		next.ServeHTTP(&corsWriter{ResponseWriter: w, cors: s.cors, allowed: allowed}, r)
+	})
+}
+
+// preflightHandler answers OPTIONS requests for object paths, which
+// browsers send before cross-origin requests that are not simple.
+func (s *Server) preflightHandler(w http.ResponseWriter, r *http.Request) {
+	h := w.Header()
+	h.Set("Allow", strings.Join(append(slices.Clone(s.cors.methods), http.MethodOptions), ", "))
+	method := r.Header.Get("Access-Control-Request-Method")
+	if method == "" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	h.Add("Vary", "Access-Control-Request-Method")
+	h.Add("Vary", "Access-Control-Request-Headers")
+	allowed := s.cors.allowOrigin(r.Header.Get("Origin"))
+	requested := r.Header.Get("Access-Control-Request-Headers")
+	if allowed == "" || !slices.Contains(s.cors.methods, method) || !s.cors.allowHeaders(requested) {
+		if s.cors.variesByOrigin() {
+			h.Add("Vary", "Origin")
+		}
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	s.cors.setOrigin(h, allowed)
+	h.Set("Access-Control-Allow-Methods", strings.Join(s.cors.methods, ", "))
+	if requested != "" {
+		h.Set("Access-Control-Allow-Headers", requested)
+	}
+	h.Set("Access-Control-Max-Age", s.cors.maxAge)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type corsWriter struct {
+	http.ResponseWriter
+	cors        *corsPolicy
+	allowed     string
+	wroteHeader bool
+}
+
+func (w *corsWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		switch {
+		case w.allowed != "":
+			w.cors.setOrigin(w.Header(), w.allowed)
+			if w.cors.exposed != "" {
+				w.Header().Set("Access-Control-Expose-Headers", w.cors.exposed)
+			}
+		case w.cors.variesByOrigin():
+			w.Header().Add("Vary", "Origin")
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *corsWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *corsWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
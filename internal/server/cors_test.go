@@ -0,0 +1,68 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/joeychilson/s3-proxy/internal/config"
+)
+
+func TestCORS(t *testing.T) {
+	s := &Server{cors: newCORSPolicy(&config.Config{
+		CORSAllowedOrigins: []string{"https://app.example.com", "https://*.preview.example.com"},
+		CORSAllowedMethods: []string{"GET", "HEAD"},
+		CORSAllowedHeaders: []string{"Range", "Authorization"},
+		CORSExposedHeaders: []string{"ETag", "X-Cache"},
+		CORSMaxAge:         10 * time.Minute,
+	})}
+	r := chi.NewRouter()
+	r.Options("/*", s.preflightHandler)
+	r.With(s.corsMiddleware).Get("/*", func(w http.ResponseWriter, r *http.Request) {
+		// Object responses copy their stored headers over the writer's.
+		w.Header()["Vary"] = []string{"Accept-Encoding"}
+		w.Write([]byte("ok"))
+	})
+
+	tests := []struct {
+		method  string
+		origin  string
+		reqHdrs string
+		status  int
+		allowed string
+	}{
+		{http.MethodOptions, "https://app.example.com", "range", http.StatusNoContent, "https://app.example.com"},
+		{http.MethodOptions, "https://pr-12.preview.example.com", "", http.StatusNoContent, "https://pr-12.preview.example.com"},
+		{http.MethodOptions, "https://evil.example.com", "", http.StatusForbidden, ""},
+		{http.MethodOptions, "https://app.example.com", "X-Custom", http.StatusForbidden, ""},
+		{http.MethodGet, "https://app.example.com", "", http.StatusOK, "https://app.example.com"},
+		{http.MethodGet, "https://evil.example.com", "", http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, "/data/file.json", nil)
+		req.Header.Set("Origin", tt.origin)
+		if tt.method == http.MethodOptions {
+			req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+			req.Header.Set("Access-Control-Request-Headers", tt.reqHdrs)
+		}
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		h := rec.Header()
+		if rec.Code != tt.status || h.Get("Access-Control-Allow-Origin") != tt.allowed {
+			t.Errorf("%s from %s: expected %d allowing %q, got %d allowing %q", tt.method, tt.origin, tt.status, tt.allowed, rec.Code, h.Get("Access-Control-Allow-Origin"))
+		}
+		if !slices.Contains(h.Values("Vary"), "Origin") {
+			t.Errorf("%s from %s: expected Vary: Origin, got %v", tt.method, tt.origin, h.Values("Vary"))
+		}
+		if tt.method == http.MethodGet && tt.allowed != "" && (h.Get("Access-Control-Expose-Headers") != "ETag, X-Cache" || !slices.Contains(h.Values("Vary"), "Accept-Encoding")) {
+			t.Errorf("expected exposed headers alongside the object's Vary, got %v", h)
+		}
+		if tt.method == http.MethodOptions && tt.status == http.StatusNoContent && h.Get("Access-Control-Max-Age") != "600" {
+			t.Errorf("expected a max age of 600, got %q", h.Get("Access-Control-Max-Age"))
+		}
+	}
+}
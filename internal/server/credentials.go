@@ -0,0 +1,81 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/joeychilson/s3-proxy/internal/config"
+	"github.com/joeychilson/s3-proxy/internal/origin"
+)
+
+// originRead names the read replica's client, beside origin.Primary and
+// origin.Secondary.
+const originRead = "read"
+
+type credentialsRequest struct {
+	Origin    string `json:"origin"`
+	AccessKey string `json:"access_key"`
+	SecretKey string `json:"secret_key"`
+}
+
+// credentialsHandler replaces the S3 keys of an origin client without a
+// restart. The keys must reach the client's bucket before they are used.
+func (s *Server) credentialsHandler(w http.ResponseWriter, r *http.Request) {
+	var payload credentialsRequest
+	if !decodeJSON(w, r, &payload) {
+		return
+	}
+	if payload.Origin == "" {
+		payload.Origin = origin.Primary
+	}
+	client, ok := s.clients[payload.Origin]
+	if !ok || payload.AccessKey == "" || payload.SecretKey == "" {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	if !client.HasStaticKeys() {
+		http.Error(w, origin.ErrDefaultChain.Error(), http.StatusConflict)
+		return
+	}
+	if err := client.CheckCredentials(r.Context(), payload.AccessKey, payload.SecretKey); err != nil {
+		s.logger.Warn("origin credentials rejected", "origin", payload.Origin, "access_key", payload.AccessKey, "error", err)
+		http.Error(w, "credentials cannot reach the bucket", http.StatusUnprocessableEntity)
+		return
+	}
+	if s.cfg.AdminDryRun {
+		s.dryRun(w, r, "credentials", "origin", payload.Origin, "access_key", payload.AccessKey)
+		return
+	}
+	client.SetCredentials(payload.AccessKey, payload.SecretKey)
+	s.logger.Info("origin credentials rotated", "origin", payload.Origin, "access_key", payload.AccessKey, "client", clientHost(r))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RotateCredentials switches the origin clients to the static keys in cfg,
+// as read again on SIGHUP. Keys that cannot reach their bucket are logged
+// and the old ones kept.
+func (s *Server) RotateCredentials(ctx context.Context, cfg *config.Config) {
+	keys := map[string][2]string{
+		origin.Primary:   {cfg.AccessKey, cfg.SecretKey},
+		origin.Secondary: {cfg.SecondaryAccessKey, cfg.SecondarySecretKey},
+		originRead:       {cfg.ReadAccessKey, cfg.ReadSecretKey},
+	}
+	for name, client := range s.clients {
+		accessKey, secretKey := keys[name][0], keys[name][1]
+		if accessKey == "" || !client.HasStaticKeys() {
+			continue
+		}
+		err := client.CheckCredentials(ctx, accessKey, secretKey)
+		if err == nil {
+			err = client.SetCredentials(accessKey, secretKey)
+		}
+		if err != nil {
+			if !errors.Is(err, context.Canceled) {
+				s.logger.Error("origin credentials reload failed", "origin", name, "access_key", accessKey, "error", err)
+			}
+			continue
+		}
+		s.logger.Info("origin credentials reloaded", "origin", name, "access_key", accessKey)
+	}
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joeychilson/s3-proxy/internal/config"
+	"github.com/joeychilson/s3-proxy/internal/origin"
+)
+
+func TestCredentialsHandler(t *testing.T) {
+	var signedWith string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if !strings.Contains(auth, "Credential=AKIAOLD/") && !strings.Contains(auth, "Credential=AKIANEW/") {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`<Error><Code>AccessDenied</Code></Error>`))
+			return
+		}
+		signedWith = auth
+	}))
+	defer upstream.Close()
+	client, err := origin.New(context.Background(), upstream.URL, "us-east-1", "AKIAOLD", "secret", "b", time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s := &Server{
+		cfg:     &config.Config{},
+		clients: map[string]*origin.Client{origin.Primary: client},
+		logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	post := func(body string) int {
+		rec := httptest.NewRecorder()
+		s.credentialsHandler(rec, httptest.NewRequest(http.MethodPost, "/admin/credentials", strings.NewReader(body)))
+		return rec.Code
+	}
+	signedBy := func(key string) bool {
+		client.HeadObject(context.Background(), "k", nil)
+		return strings.Contains(signedWith, "Credential="+key+"/")
+	}
+
+	if code := post(`{"origin":"elsewhere","access_key":"AKIANEW","secret_key":"s"}`); code != http.StatusBadRequest {
+		t.Fatalf("expected an unknown origin to be rejected, got %d", code)
+	}
+	if code := post(`{"access_key":"AKIABAD","secret_key":"s"}`); code != http.StatusUnprocessableEntity || !signedBy("AKIAOLD") {
+		t.Fatalf("expected keys that cannot reach the bucket to be refused, got %d", code)
+	}
+	s.cfg.AdminDryRun = true
+	if code := post(`{"access_key":"AKIANEW","secret_key":"s"}`); code != http.StatusOK || !signedBy("AKIAOLD") {
+		t.Fatalf("expected a dry run to keep the old keys, got %d", code)
+	}
+	s.cfg.AdminDryRun = false
+	if code := post(`{"access_key":"AKIANEW","secret_key":"s"}`); code != http.StatusNoContent || !signedBy("AKIANEW") {
+		t.Fatalf("expected requests to be signed with the new keys, got %d", code)
+	}
+
+	s.RotateCredentials(context.Background(), &config.Config{AccessKey: "AKIAOLD", SecretKey: "secret"})
+	if !signedBy("AKIAOLD") {
+		t.Fatalf("expected a reload to switch back to the configured keys")
+	}
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"net/http"
+	"strings"
+)
+
+// contentSHA256Header carries the hex SHA-256 of an object's body: as a
+// header on responses from the cache, and as a trailer on responses streamed
+// from the origin, whose digest is only known once the body has been sent.
+const contentSHA256Header = "X-Content-SHA256"
+
+func contentSHA256(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// digestTrailer prepares a streamed response to end with the digest trailer
+// and returns the hash to feed the body to, or nil when no trailer will be
+// sent. Only complete bodies get one, and only for clients that can read
+// trailers: HTTP/2 clients and HTTP/1.1 clients sending "TE: trailers", whose
+// response is then chunked rather than sent with a Content-Length.
+func (s *Server) digestTrailer(w http.ResponseWriter, r *http.Request, status int) hash.Hash {
+	if !s.cfg.ContentDigest || status != http.StatusOK || r.Method == http.MethodHead || !acceptsTrailers(r) {
+		return nil
+	}
+	w.Header().Set("Trailer", contentSHA256Header)
+	if r.ProtoMajor < 2 {
+		w.Header().Del("Content-Length")
+	}
+	return sha256.New()
+}
+
+// finishDigest sends the digest trailer for a body fed to h in full.
+func finishDigest(w http.ResponseWriter, h hash.Hash) {
+	if h != nil {
+		w.Header().Set(contentSHA256Header, hex.EncodeToString(h.Sum(nil)))
+	}
+}
+
+func acceptsTrailers(r *http.Request) bool {
+	if r.ProtoMajor >= 2 {
+		return true
+	}
+	for _, value := range r.Header.Values("TE") {
+		for part := range strings.SplitSeq(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), "trailers") {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/joeychilson/s3-proxy/internal/config"
+	"github.com/joeychilson/s3-proxy/internal/origin"
+)
+
+func TestContentDigest(t *testing.T) {
+	const body = "artifact bytes"
+	want := contentSHA256([]byte(body))
+	s := &Server{
+		cfg:     &config.Config{ContentDigest: true, CacheTTL: time.Minute},
+		metrics: newMetrics(prometheus.NewRegistry()),
+		logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.streamObject(w, r, "artifact", &origin.Object{
+			Body:          io.NopCloser(strings.NewReader(body)),
+			Headers:       http.Header{},
+			StatusCode:    http.StatusOK,
+			ContentLength: int64(len(body)),
+		})
+	}))
+	defer srv.Close()
+
+	for _, te := range []string{"trailers", ""} {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+		if te != "" {
+			req.Header.Set("TE", te)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		data, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		got := resp.Trailer.Get(contentSHA256Header)
+		switch {
+		case string(data) != body:
+			t.Fatalf("unexpected body %q", data)
+		case te != "" && got != want:
+			t.Fatalf("expected digest trailer %q, got %q", want, got)
+		case te == "" && (got != "" || resp.ContentLength != int64(len(body))):
+			t.Fatalf("expected no trailer and a Content-Length without TE: trailers, got %q and %d", got, resp.ContentLength)
+		}
+	}
+
+	entry := s.newEntry("artifact", &origin.Object{Headers: http.Header{}, StatusCode: http.StatusOK}, []byte(body), time.Now())
+	if entry.Header.Get(contentSHA256Header) != want {
+		t.Fatalf("expected cached entries to carry the digest header, got %v", entry.Header)
+	}
+}
@@ -0,0 +1,22 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// dryRun answers a mutating admin request under ADMIN_DRY_RUN, logging and
+// returning what it would have done. Handlers call it once the request has
+// been validated, so automation sees the same errors it would in earnest.
+// details are alternating names and values, as for slog.
+func (s *Server) dryRun(w http.ResponseWriter, r *http.Request, action string, details ...any) {
+	s.logger.Info("admin dry run", append([]any{"action", action, "client", clientHost(r)}, details...)...)
+	body := map[string]any{"dry_run": true, "action": action}
+	for i := 0; i+1 < len(details); i += 2 {
+		if name, ok := details[i].(string); ok {
+			body[name] = details[i+1]
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/joeychilson/s3-proxy/internal/cache"
+	"github.com/joeychilson/s3-proxy/internal/config"
+)
+
+func TestAdminDryRun(t *testing.T) {
+	store, err := cache.New(16, 1<<20, time.Minute, time.Minute)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s := &Server{
+		cfg:      &config.Config{AdminDryRun: true, PrefetchMaxBytes: 1 << 20, PrefetchConcurrency: 2},
+		cache:    store,
+		metrics:  newMetrics(prometheus.NewRegistry()),
+		logger:   slog.New(slog.NewTextHandler(io.Discard, nil)),
+		prefetch: newPrefetchJobs(),
+		lockouts: newLockouts(1, time.Minute, time.Minute, time.Hour),
+	}
+	for _, key := range []string{"images/a.png", "images/b.png", "docs/c.txt"} {
+		store.Set(cacheKey(key), &cache.Entry{Body: []byte("x"), Status: http.StatusOK, StoredAt: time.Now(), TTL: time.Minute})
+	}
+
+	rec := httptest.NewRecorder()
+	s.purgeHandler(rec, httptest.NewRequest(http.MethodPost, "/cache/purge", strings.NewReader(`{"keys":["docs/c.txt"],"prefixes":["images/"]}`)))
+	var purge struct {
+		DryRun bool     `json:"dry_run"`
+		Keys   []string `json:"keys"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &purge); err != nil || !purge.DryRun {
+		t.Fatalf("expected a dry-run report, got %d %q", rec.Code, rec.Body.String())
+	}
+	slices.Sort(purge.Keys)
+	if fmt.Sprint(purge.Keys) != "[docs/c.txt images/a.png images/b.png]" {
+		t.Fatalf("unexpected keys %v", purge.Keys)
+	}
+	if len(store.Keys()) != 3 {
+		t.Fatalf("expected a dry-run purge to leave the cache alone")
+	}
+	rec = httptest.NewRecorder()
+	s.purgeHandler(rec, httptest.NewRequest(http.MethodPost, "/cache/purge", strings.NewReader(`{"keys":`)))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected a dry run to validate like a real purge, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	s.prefetchHandler(rec, httptest.NewRequest(http.MethodPost, "/cache/prefetch", strings.NewReader(`{"keys":[{"key":"docs/d.txt"}]}`)))
+	if rec.Code != http.StatusOK || len(s.prefetch.jobs) != 0 {
+		t.Fatalf("expected a dry-run prefetch to start no job, got %d", rec.Code)
+	}
+
+	s.lockouts.fail("203.0.113.9", time.Now())
+	rec = httptest.NewRecorder()
+	s.unbanHandler(rec, httptest.NewRequest(http.MethodPost, "/admin/unban", strings.NewReader(`{"client":"203.0.113.9"}`)))
+	if rec.Code != http.StatusOK || s.lockouts.banned("203.0.113.9", time.Now()) == 0 {
+		t.Fatalf("expected a dry-run unban to keep the ban, got %d", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	s.unbanHandler(rec, httptest.NewRequest(http.MethodPost, "/admin/unban", strings.NewReader(`{"client":"198.51.100.1"}`)))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected an unknown client to give 404 in a dry run too, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/joeychilson/s3-proxy/internal/cache"
+)
+
+// errorPageMiddleware replaces the body of error responses with the page
+// configured for their status, when that page is cached, or else with
+// ERROR_TEMPLATE.
+func (s *Server) errorPageMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(&errorPageWriter{ResponseWriter: w, s: s, r: r}, r)
+	})
+}
+
+// errorPageKey returns the page for status, preferring an exact match over
+// its status class.
+func (s *Server) errorPageKey(status int) string {
+	if key := s.cfg.ErrorPages[strconv.Itoa(status)]; key != "" {
+		return key
+	}
+	return s.cfg.ErrorPages[strconv.Itoa(status/100)+"xx"]
+}
+
+// errorPage returns the cached page for status. Pages are never fetched while
+// a response waits: a missing or stale page is filled in the background and
+// the response goes out with its own body or the stale page meanwhile.
+func (s *Server) errorPage(status int) *cache.Entry {
+	key := s.errorPageKey(status)
+	if key == "" {
+		return nil
+	}
+	entry, ok := s.cache.Get(cacheKey(key))
+	if ok && entry.Status == http.StatusOK {
+		if !entry.Fresh(time.Now()) {
+			s.fillErrorPage(key)
+		}
+		return entry
+	}
+	s.fillErrorPage(key)
+	return nil
+}
+
+// fillErrorPages loads every configured page into the cache.
+func (s *Server) fillErrorPages() {
+	for _, key := range s.cfg.ErrorPages {
+		s.fillErrorPage(key)
+	}
+}
+
+// fillErrorPage fetches a page into the cache in the background, once at a
+// time and with backoff after failures.
+func (s *Server) fillErrorPage(key string) {
+	cKey := cacheKey(key)
+	if !s.reval.begin(cKey, time.Now()) {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), s.cfg.RequestTimeout)
+		defer cancel()
+		_, err := s.warmKey(ctx, key, "error-page", nil)
+		if delay := s.reval.end(cKey, time.Now(), err != nil); err != nil {
+			s.logger.Warn("error page fetch failed", "error", err, "key", key, "retry_in", delay.String())
+		}
+	}()
+}
+
+type errorPageWriter struct {
+	http.ResponseWriter
+	s        *Server
+	r        *http.Request
+	replaced bool
+}
+
+func (w *errorPageWriter) WriteHeader(code int) {
+	if code < 400 {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	var body []byte
+	contentType := "text/html; charset=utf-8"
+	if page := w.s.errorPage(code); page != nil {
+		body, contentType = page.Body, page.Header.Get("Content-Type")
+	} else if body = w.s.renderErrorPage(w.r, code); body == nil {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	w.replaced = true
+	h := w.Header()
+	h.Del("Content-Encoding")
+	h.Del("Content-Range")
+	h.Del("ETag")
+	h.Del("Last-Modified")
+	h.Set("Content-Type", contentType)
+	h.Set("Content-Length", strconv.Itoa(len(body)))
+	w.ResponseWriter.WriteHeader(code)
+	if w.r.Method != http.MethodHead {
+		w.ResponseWriter.Write(body)
+	}
+}
+
+// Write discards the handler's own body once a page has replaced it.
+func (w *errorPageWriter) Write(b []byte) (int, error) {
+	if w.replaced {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *errorPageWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joeychilson/s3-proxy/internal/cache"
+	"github.com/joeychilson/s3-proxy/internal/config"
+)
+
+func TestErrorPageMiddleware(t *testing.T) {
+	store, err := cache.New(16, 1<<20, time.Minute, time.Minute)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s := &Server{cfg: &config.Config{ErrorPages: map[string]string{"404": "errors/404.html"}}, cache: store}
+	store.Set(cacheKey("errors/404.html"), &cache.Entry{
+		Body:     []byte("<h1>Not here</h1>"),
+		Header:   http.Header{"Content-Type": {"text/html"}},
+		Status:   http.StatusOK,
+		StoredAt: time.Now(),
+		TTL:      time.Minute,
+	})
+	h := s.errorPageMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, "bad gateway", http.StatusBadGateway)
+	}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/missing", nil))
+	if w.Code != http.StatusNotFound || w.Body.String() != "<h1>Not here</h1>" || w.Header().Get("Content-Type") != "text/html" {
+		t.Fatalf("unexpected 404 response %d %q", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/other", nil))
+	if w.Code != http.StatusBadGateway || !strings.Contains(w.Body.String(), "bad gateway") {
+		t.Fatalf("expected the original body without a configured page, got %d %q", w.Code, w.Body.String())
+	}
+
+	path := filepath.Join(t.TempDir(), "error.html")
+	if err := os.WriteFile(path, []byte("<h1>{{.Status}} {{.StatusText}}</h1><p>{{.Path}}</p>"), 0o644); err != nil {
+		t.Fatalf("write template: %v", err)
+	}
+	if s.pageTmpl, err = s.loadTemplate(context.Background(), path); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/other", nil))
+	if w.Code != http.StatusBadGateway || w.Body.String() != "<h1>502 Bad Gateway</h1><p>/other</p>" {
+		t.Fatalf("expected the rendered template, got %d %q", w.Code, w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/missing", nil))
+	if w.Body.String() != "<h1>Not here</h1>" {
+		t.Fatalf("expected a configured page to take precedence over the template, got %q", w.Body.String())
+	}
+}
@@ -0,0 +1,152 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Cache event types.
+const (
+	eventFill       = "fill"
+	eventEvict      = "evict"
+	eventPurge      = "purge"
+	eventRevalidate = "revalidate"
+)
+
+const eventHeartbeat = 15 * time.Second
+
+type cacheEvent struct {
+	Time   time.Time `json:"time"`
+	Type   string    `json:"type"`
+	Key    string    `json:"key"`
+	Size   int64     `json:"size,omitempty"`
+	Reason string    `json:"reason,omitempty"`
+}
+
+// eventLog writes cache events as NDJSON to an optional sink and fans them
+// out to live subscribers. Subscribers that fall behind miss events rather
+// than slowing the cache down.
+type eventLog struct {
+	mu   sync.Mutex
+	out  io.WriteCloser
+	enc  *json.Encoder
+	subs map[chan cacheEvent]struct{}
+}
+
+// newEventLog opens the sink named by dest: "" for none, "stdout", or a file
+// path that is appended to.
+func newEventLog(dest string) (*eventLog, error) {
+	l := &eventLog{subs: make(map[chan cacheEvent]struct{})}
+	switch dest {
+	case "":
+		return l, nil
+	case "stdout":
+		l.out = nopCloser{os.Stdout}
+	default:
+		f, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("open event log: %w", err)
+		}
+		l.out = f
+	}
+	l.enc = json.NewEncoder(l.out)
+	return l, nil
+}
+
+func (l *eventLog) emit(typ, key string, size int64, reason string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.enc == nil && len(l.subs) == 0 {
+		return
+	}
+	e := cacheEvent{Time: time.Now().UTC(), Type: typ, Key: key, Size: size, Reason: reason}
+	if l.enc != nil {
+		l.enc.Encode(e)
+	}
+	for ch := range l.subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+func (l *eventLog) subscribe() (<-chan cacheEvent, func()) {
+	ch := make(chan cacheEvent, 256)
+	l.mu.Lock()
+	l.subs[ch] = struct{}{}
+	l.mu.Unlock()
+	return ch, func() {
+		l.mu.Lock()
+		delete(l.subs, ch)
+		l.mu.Unlock()
+	}
+}
+
+func (l *eventLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.out == nil {
+		return nil
+	}
+	err := l.out.Close()
+	l.out, l.enc = nil, nil
+	return err
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
+
+// cacheEventsHandler streams cache events to the client over Server-Sent
+// Events until it disconnects.
+func (s *Server) cacheEventsHandler(w http.ResponseWriter, r *http.Request) {
+	events, unsubscribe := s.events.subscribe()
+	defer unsubscribe()
+
+	rc := startEventStream(w)
+	heartbeat := time.NewTicker(eventHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		var err error
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			_, err = io.WriteString(w, ": keep-alive\n\n")
+		case e := <-events:
+			err = writeEvent(w, e.Type, e)
+		}
+		if err != nil || rc.Flush() != nil {
+			return
+		}
+	}
+}
+
+// startEventStream sends the headers of a Server-Sent Events response and
+// lifts the server's write timeout for the long-lived stream.
+func startEventStream(w http.ResponseWriter) *http.ResponseController {
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+	return rc
+}
+
+func writeEvent(w io.Writer, name string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, data)
+	return err
+}
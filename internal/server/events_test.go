@@ -0,0 +1,37 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEventLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.ndjson")
+	l, err := newEventLog(path)
+	if err != nil {
+		t.Fatalf("new event log: %v", err)
+	}
+	events, unsubscribe := l.subscribe()
+	l.emit(eventFill, "a.css", 12, "miss")
+	unsubscribe()
+	l.emit(eventPurge, "a.css", 12, "key")
+	if err := l.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	if e := <-events; e.Type != eventFill || e.Key != "a.css" || e.Size != 12 || e.Reason != "miss" {
+		t.Fatalf("unexpected event %+v", e)
+	}
+	if len(events) != 0 {
+		t.Fatalf("expected no events after unsubscribing")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read log: %v", err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(data)), "\n"); len(lines) != 2 || !strings.Contains(lines[1], `"type":"purge"`) {
+		t.Fatalf("unexpected log contents %q", data)
+	}
+}
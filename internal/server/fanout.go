@@ -0,0 +1,287 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/joeychilson/s3-proxy/internal/origin"
+)
+
+// errDetached ends a fan-out client that fell FANOUT_BUFFER_SIZE behind the
+// others; it continues on a stream of its own.
+var errDetached = errors.New("fell behind the shared stream")
+
+// errAbandoned ends a shared stream that every client has left.
+var errAbandoned = errors.New("every client left the shared stream")
+
+// fanouts shares the origin streams of objects too large to cache among the
+// clients that ask for them at the same time, so that each is fetched once.
+type fanouts struct {
+	mu      sync.Mutex
+	streams map[string]*fanout
+	limit   int64
+}
+
+func newFanouts(limit int64) *fanouts {
+	return &fanouts{streams: make(map[string]*fanout), limit: limit}
+}
+
+// fanout is one origin stream and the clients reading it. It keeps a window
+// of the body from the slowest client's position to the newest byte read,
+// of at most limit bytes. When the window is full and a client is waiting
+// for more, the clients holding it back are detached.
+type fanout struct {
+	obj     *origin.Object
+	mu      sync.Mutex
+	cond    *sync.Cond
+	buf     []byte
+	base    int64
+	done    bool
+	err     error
+	limit   int64
+	readers map[*fanoutReader]struct{}
+}
+
+// fanoutReader is a client's position in a shared stream.
+type fanoutReader struct {
+	f        *fanout
+	off      int64
+	detached bool
+	stop     func() bool
+}
+
+// start shares obj among the requests for cKey, returning the first
+// client's reader. The stream is pumped in the background and closed once
+// read or once every client has left.
+func (g *fanouts) start(ctx context.Context, cKey string, obj *origin.Object) *fanoutReader {
+	f := &fanout{obj: obj, limit: g.limit, readers: make(map[*fanoutReader]struct{})}
+	f.cond = sync.NewCond(&f.mu)
+	rd := f.add(ctx)
+	g.mu.Lock()
+	g.streams[cKey] = f
+	g.mu.Unlock()
+	go func() {
+		f.pump()
+		g.mu.Lock()
+		if g.streams[cKey] == f {
+			delete(g.streams, cKey)
+		}
+		g.mu.Unlock()
+	}()
+	return rd
+}
+
+// join returns a reader of the stream shared for cKey, or nil when there is
+// none or it has moved past its first bytes.
+func (g *fanouts) join(ctx context.Context, cKey string) *fanoutReader {
+	g.mu.Lock()
+	f := g.streams[cKey]
+	g.mu.Unlock()
+	if f == nil {
+		return nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.base > 0 || len(f.readers) == 0 || f.err != nil {
+		return nil
+	}
+	return f.addLocked(ctx)
+}
+
+func (f *fanout) add(ctx context.Context) *fanoutReader {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.addLocked(ctx)
+}
+
+func (f *fanout) addLocked(ctx context.Context) *fanoutReader {
+	rd := &fanoutReader{f: f}
+	f.readers[rd] = struct{}{}
+	// A client that goes away while waiting for bytes must not hold the
+	// stream back.
+	rd.stop = context.AfterFunc(ctx, rd.close)
+	return rd
+}
+
+// pump reads the origin body into the window until it ends, fails or has
+// no clients left.
+func (f *fanout) pump() {
+	defer f.obj.Body.Close()
+	chunk := make([]byte, 32*1024)
+	for {
+		if !f.waitRoom() {
+			return
+		}
+		n, err := f.obj.Body.Read(chunk)
+		f.mu.Lock()
+		f.buf = append(f.buf, chunk[:n]...)
+		if err != nil {
+			f.done = true
+			if !errors.Is(err, io.EOF) {
+				f.err = err
+			}
+		}
+		f.cond.Broadcast()
+		f.mu.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// waitRoom blocks until the window can take more of the body, detaching
+// the slowest clients when a faster one is waiting. It reports false once
+// every client has left.
+func (f *fanout) waitRoom() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for {
+		if len(f.readers) == 0 {
+			f.done, f.err = true, errAbandoned
+			return false
+		}
+		f.trim()
+		if int64(len(f.buf)) < f.limit {
+			return true
+		}
+		end := f.base + int64(len(f.buf))
+		waiting := false
+		for rd := range f.readers {
+			waiting = waiting || rd.off == end
+		}
+		if !waiting {
+			f.cond.Wait()
+			continue
+		}
+		for rd := range f.readers {
+			if rd.off == f.base {
+				rd.detached = true
+				delete(f.readers, rd)
+			}
+		}
+		f.cond.Broadcast()
+	}
+}
+
+// trim drops the bytes every client has read.
+func (f *fanout) trim() {
+	low := f.base + int64(len(f.buf))
+	for rd := range f.readers {
+		low = min(low, rd.off)
+	}
+	f.buf = f.buf[low-f.base:]
+	f.base = low
+}
+
+func (rd *fanoutReader) Read(p []byte) (int, error) {
+	f := rd.f
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for !rd.detached && !f.done && rd.off == f.base+int64(len(f.buf)) {
+		f.cond.Wait()
+	}
+	if rd.detached {
+		return 0, errDetached
+	}
+	if rd.off < f.base+int64(len(f.buf)) {
+		n := copy(p, f.buf[rd.off-f.base:])
+		rd.off += int64(n)
+		f.cond.Broadcast()
+		return n, nil
+	}
+	if f.err != nil {
+		return 0, f.err
+	}
+	return 0, io.EOF
+}
+
+// close leaves the stream.
+func (rd *fanoutReader) close() {
+	f := rd.f
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.readers, rd)
+	rd.detached = true
+	f.cond.Broadcast()
+}
+
+// fanoutEligible reports whether obj may be shared: a whole object that is
+// too large to cache but would otherwise be.
+func (s *Server) fanoutEligible(obj *origin.Object) bool {
+	return s.fanouts != nil && obj.StatusCode == http.StatusOK && obj.ContentLength > s.cfg.MaxObjectSize &&
+		s.varyKeyed(obj.Headers) && !hasNoStore(obj.Headers)
+}
+
+// joinFanout serves a GET from the stream already shared for cKey, if it
+// can still be joined.
+func (s *Server) joinFanout(w http.ResponseWriter, r *http.Request, key, cKey string) bool {
+	if s.fanouts == nil {
+		return false
+	}
+	rd := s.fanouts.join(r.Context(), cKey)
+	if rd == nil {
+		return false
+	}
+	s.metrics.fanoutClients.WithLabelValues("joined").Inc()
+	s.metrics.coalesced.Inc()
+	s.serveFanout(w, r, key, rd, "COALESCED")
+	return true
+}
+
+// serveFanout sends a shared stream to one client. A client detached for
+// falling behind gets the rest of the object from the origin, by range.
+func (s *Server) serveFanout(w http.ResponseWriter, r *http.Request, key string, rd *fanoutReader, state string) {
+	defer rd.stop()
+	defer rd.close()
+	obj := rd.f.obj
+	copyHeaders(w.Header(), obj.Headers)
+	s.setEncodingVary(w, obj.StatusCode, obj.ContentLength, obj.Headers)
+	s.setCacheStatus(w, layerOrigin, state)
+	w.Header().Set("Content-Length", strconv.FormatInt(obj.ContentLength, 10))
+	if state == "MISS" {
+		s.metrics.cacheMisses.Inc()
+	}
+	digest := s.digestTrailer(w, r, obj.StatusCode)
+	w.WriteHeader(obj.StatusCode)
+	var dst io.Writer = w
+	if digest != nil {
+		dst = io.MultiWriter(w, digest)
+	}
+	_, err := s.copyBody(dst, rd)
+	if r.Context().Err() != nil {
+		return
+	}
+	if errors.Is(err, errDetached) {
+		s.metrics.fanoutClients.WithLabelValues("detached").Inc()
+		err = s.resumeFanout(r.Context(), dst, key, obj, rd.off)
+	}
+	if err != nil {
+		s.logger.Error("stream response", "error", err, "key", key)
+		return
+	}
+	finishDigest(w, digest)
+}
+
+// resumeFanout copies the object from offset on to dst with a request of
+// its own, provided the object has not changed.
+func (s *Server) resumeFanout(ctx context.Context, dst io.Writer, key string, obj *origin.Object, offset int64) error {
+	if !strongETag(obj.ETag) {
+		return fmt.Errorf("resume shared stream: %w", errDetached)
+	}
+	cond := &origin.Conditional{IfMatch: obj.ETag, Range: fmt.Sprintf("bytes=%d-", offset)}
+	rest, err := s.fetchFromOrigin(ctx, key, cond, http.MethodGet)
+	if err != nil {
+		return fmt.Errorf("resume shared stream: %w", err)
+	}
+	defer rest.Body.Close()
+	if rest.StatusCode != http.StatusPartialContent {
+		return fmt.Errorf("resume shared stream: unexpected status %d", rest.StatusCode)
+	}
+	_, err = io.Copy(dst, rest.Body)
+	return err
+}
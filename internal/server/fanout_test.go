@@ -0,0 +1,51 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/joeychilson/s3-proxy/internal/origin"
+)
+
+func TestFanout(t *testing.T) {
+	body := strings.Repeat("0123456789", 10)
+	share := func(limit int64) (*fanoutReader, *fanoutReader) {
+		g := newFanouts(limit)
+		obj := &origin.Object{
+			Body:          io.NopCloser(iotest.OneByteReader(strings.NewReader(body))),
+			StatusCode:    http.StatusOK,
+			ContentLength: int64(len(body)),
+		}
+		lead := g.start(context.Background(), "k", obj)
+		follower := g.join(context.Background(), "k")
+		if follower == nil {
+			t.Fatalf("expected the stream to be joinable before it is read")
+		}
+		return lead, follower
+	}
+
+	lead, follower := share(1024)
+	for _, rd := range []*fanoutReader{lead, follower} {
+		got, err := io.ReadAll(rd)
+		if err != nil || string(got) != body {
+			t.Fatalf("expected the whole body, got %q, %v", got, err)
+		}
+	}
+
+	lead, follower = share(16)
+	got, err := io.ReadAll(lead)
+	if err != nil || string(got) != body {
+		t.Fatalf("expected the whole body for the reader keeping up, got %q, %v", got, err)
+	}
+	if _, err := follower.Read(make([]byte, 8)); !errors.Is(err, errDetached) {
+		t.Fatalf("expected the reader left behind to be detached, got %v", err)
+	}
+	if follower.off != 0 {
+		t.Fatalf("expected the detached reader to resume from 0, got %d", follower.off)
+	}
+}
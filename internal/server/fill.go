@@ -0,0 +1,68 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// fillQueue holds keys waiting to be cached by the background fill workers
+// in read-aside mode. Each key is queued at most once at a time.
+type fillQueue struct {
+	mu      sync.Mutex
+	pending map[string]struct{}
+	keys    chan string
+}
+
+func newFillQueue(size int) *fillQueue {
+	return &fillQueue{pending: make(map[string]struct{}), keys: make(chan string, size)}
+}
+
+// enqueue schedules a fill for key. It reports false when the key is already
+// queued or the queue is full.
+func (q *fillQueue) enqueue(key string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if _, ok := q.pending[key]; ok {
+		return false
+	}
+	select {
+	case q.keys <- key:
+		q.pending[key] = struct{}{}
+		return true
+	default:
+		return false
+	}
+}
+
+func (q *fillQueue) done(key string) {
+	q.mu.Lock()
+	delete(q.pending, key)
+	q.mu.Unlock()
+}
+
+func (s *Server) startFillWorkers(ctx context.Context) {
+	for range s.cfg.FillWorkers {
+		go s.runFillWorker(ctx)
+	}
+}
+
+func (s *Server) runFillWorker(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case key := <-s.fills.keys:
+			s.fillKey(ctx, key)
+			s.fills.done(key)
+		}
+	}
+}
+
+func (s *Server) fillKey(ctx context.Context, key string) {
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.RequestTimeout)
+	defer cancel()
+	if _, err := s.warmKey(ctx, key, "background", nil); err != nil && !errors.Is(err, errNotCacheable) {
+		s.logger.Warn("background fill failed", "error", err, "key", key)
+	}
+}
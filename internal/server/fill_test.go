@@ -0,0 +1,20 @@
+package server
+
+import (
+	"testing"
+)
+
+func TestFillQueueDedupes(t *testing.T) {
+	q := newFillQueue(2)
+	if !q.enqueue("a") || q.enqueue("a") {
+		t.Fatalf("expected a key to be queued once")
+	}
+	if !q.enqueue("b") || q.enqueue("c") {
+		t.Fatalf("expected the queue to reject keys when full")
+	}
+	<-q.keys
+	q.done("a")
+	if !q.enqueue("a") {
+		t.Fatalf("expected a finished key to be queued again")
+	}
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"context"
+	"sync"
+
+	"github.com/joeychilson/s3-proxy/internal/cache"
+)
+
+type flightResult struct {
+	entry *cache.Entry
+	state string
+	layer string
+	err   error
+}
+
+type flightCall struct {
+	done chan struct{}
+	res  flightResult
+}
+
+// flightGroup coalesces concurrent origin fills for the same cache key so
+// only one request per key reaches the origin at a time.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+func newFlightGroup() *flightGroup {
+	return &flightGroup{calls: make(map[string]*flightCall)}
+}
+
+func (g *flightGroup) inFlight(key string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	_, ok := g.calls[key]
+	return ok
+}
+
+// do runs fn once per key among concurrent callers. Followers wait for the
+// leader's result or until ctx is done. leader reports whether fn ran in this
+// call.
+func (g *flightGroup) do(ctx context.Context, key string, fn func() flightResult) (res flightResult, leader bool, err error) {
+	g.mu.Lock()
+	if call, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		select {
+		case <-call.done:
+			return call.res, false, nil
+		case <-ctx.Done():
+			return flightResult{}, false, ctx.Err()
+		}
+	}
+	call := &flightCall{done: make(chan struct{})}
+	g.calls[key] = call
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		close(call.done)
+	}()
+	call.res = fn()
+	return call.res, true, nil
+}
@@ -0,0 +1,42 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/joeychilson/s3-proxy/internal/cache"
+)
+
+func TestFlightGroupCoalesces(t *testing.T) {
+	g := newFlightGroup()
+	release := make(chan struct{})
+	var calls atomic.Int32
+	var leaders atomic.Int32
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, leader, err := g.do(context.Background(), "key", func() flightResult {
+				calls.Add(1)
+				<-release
+				return flightResult{entry: &cache.Entry{Body: []byte("shared")}}
+			})
+			if err != nil || string(res.entry.Body) != "shared" {
+				t.Errorf("unexpected result %v %v", res, err)
+			}
+			if leader {
+				leaders.Add(1)
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if calls.Load() != 1 || leaders.Load() != 1 {
+		t.Fatalf("expected a single fill, got %d calls and %d leaders", calls.Load(), leaders.Load())
+	}
+}
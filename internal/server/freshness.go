@@ -0,0 +1,88 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/joeychilson/s3-proxy/internal/config"
+	"github.com/joeychilson/s3-proxy/internal/origin"
+)
+
+// originTTL returns how long an origin response stays fresh: Cache-Control
+// max-age wins, then Expires, then a heuristic lifetime derived from
+// Last-Modified (RFC 9111, section 4.2.2), and finally CACHE_TTL.
+func (s *Server) originTTL(obj *origin.Object, now time.Time) time.Duration {
+	if ttl := ttlFromHeaders(obj.Headers, -1); ttl >= 0 {
+		return ttl
+	}
+	date := originDate(obj, now)
+	if ttl, ok := expiresTTL(obj.Headers, date); ok {
+		return ttl
+	}
+	if ttl := heuristicTTL(valueOrZero(obj.LastModified), date, s.cfg.HeuristicFreshness, s.cfg.HeuristicMaxTTL); ttl > 0 {
+		return ttl
+	}
+	return s.cfg.CacheTTL
+}
+
+// overrideCacheControl replaces the origin's Cache-Control on a successful
+// read of an artifact or of a key under a CACHE_CONTROL_RULES prefix, so
+// that it sets both the cache TTL and what clients are told. The longest
+// matching prefix wins.
+func (s *Server) overrideCacheControl(key string, obj *origin.Object) {
+	if s.immutable(key) {
+		markImmutable(obj)
+		return
+	}
+	if obj.StatusCode != http.StatusOK && obj.StatusCode != http.StatusPartialContent {
+		return
+	}
+	var match *config.CacheControlRule
+	for i, rule := range s.cfg.CacheControlRules {
+		if strings.HasPrefix(key, rule.Prefix) && (match == nil || len(rule.Prefix) > len(match.Prefix)) {
+			match = &s.cfg.CacheControlRules[i]
+		}
+	}
+	if match == nil {
+		return
+	}
+	if obj.Headers == nil {
+		obj.Headers = make(http.Header)
+	}
+	obj.Headers.Set("Cache-Control", match.Value)
+	obj.Headers.Del("Expires")
+}
+
+// originDate returns the origin's Date, or now if it is missing or ahead of
+// the local clock. Entries are stored as of this time so that Age and
+// freshness include the time the response spent reaching the proxy.
+func originDate(obj *origin.Object, now time.Time) time.Time {
+	if obj.Date.IsZero() || obj.Date.After(now) {
+		return now
+	}
+	return obj.Date
+}
+
+func expiresTTL(h http.Header, date time.Time) (time.Duration, bool) {
+	value := h.Get("Expires")
+	if value == "" {
+		return 0, false
+	}
+	expires, err := http.ParseTime(value)
+	if err != nil {
+		// An invalid Expires means already expired.
+		return 0, true
+	}
+	return max(expires.Sub(date), 0), true
+}
+
+// heuristicTTL treats an object as fresh for fraction of the time since it
+// was last modified, capped at limit.
+func heuristicTTL(lastModified, now time.Time, fraction float64, limit time.Duration) time.Duration {
+	if fraction <= 0 || lastModified.IsZero() || !lastModified.Before(now) {
+		return 0
+	}
+	ttl := time.Duration(float64(now.Sub(lastModified)) * fraction)
+	return max(min(ttl, limit).Truncate(time.Second), time.Second)
+}
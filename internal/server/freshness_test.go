@@ -0,0 +1,107 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/joeychilson/s3-proxy/internal/config"
+	"github.com/joeychilson/s3-proxy/internal/origin"
+)
+
+func TestCacheControlRules(t *testing.T) {
+	s := &Server{cfg: &config.Config{CacheControlRules: []config.CacheControlRule{
+		{Prefix: "", Value: "public, max-age=60"},
+		{Prefix: "assets/", Value: "public, max-age=31536000, immutable"},
+		{Prefix: "assets/drafts/", Value: "no-store"},
+	}}}
+	tests := map[string]string{
+		"index.html":          "public, max-age=60",
+		"assets/app.js":       "public, max-age=31536000, immutable",
+		"assets/drafts/a.css": "no-store",
+	}
+	for key, want := range tests {
+		obj := &origin.Object{StatusCode: http.StatusOK, Headers: http.Header{"Cache-Control": {"max-age=5"}, "Expires": {"Thu, 01 Jan 2026 00:00:00 GMT"}}}
+		s.overrideCacheControl(key, obj)
+		if got := obj.Headers.Get("Cache-Control"); got != want || obj.Headers.Get("Expires") != "" {
+			t.Fatalf("%s: got Cache-Control %q, Expires %q, want %q", key, got, obj.Headers.Get("Expires"), want)
+		}
+	}
+
+	obj := &origin.Object{StatusCode: http.StatusOK, Headers: http.Header{"Cache-Control": {"no-cache"}}}
+	s.overrideCacheControl("index.html", obj)
+	if ttl := s.originTTL(obj, time.Now()); ttl != time.Minute {
+		t.Fatalf("expected the override to set the TTL, got %v", ttl)
+	}
+
+	obj = &origin.Object{StatusCode: http.StatusNotFound, Headers: http.Header{"Cache-Control": {"max-age=5"}}}
+	s.overrideCacheControl("assets/missing.js", obj)
+	if got := obj.Headers.Get("Cache-Control"); got != "max-age=5" {
+		t.Fatalf("expected errors to keep their Cache-Control, got %q", got)
+	}
+}
+
+func TestHeuristicTTL(t *testing.T) {
+	now := time.Date(2025, 1, 11, 0, 0, 0, 0, time.UTC)
+	if ttl := heuristicTTL(now.Add(-10*24*time.Hour), now, 0.1, 48*time.Hour); ttl != 24*time.Hour {
+		t.Fatalf("expected 10%% of age, got %v", ttl)
+	}
+	if ttl := heuristicTTL(now.Add(-365*24*time.Hour), now, 0.1, 48*time.Hour); ttl != 48*time.Hour {
+		t.Fatalf("expected capped ttl, got %v", ttl)
+	}
+	if ttl := heuristicTTL(time.Time{}, now, 0.1, time.Hour); ttl != 0 {
+		t.Fatalf("expected no heuristic without Last-Modified, got %v", ttl)
+	}
+
+	h := http.Header{}
+	h.Set("Expires", now.Add(time.Hour).Format(http.TimeFormat))
+	if ttl, ok := expiresTTL(h, now); !ok || ttl != time.Hour {
+		t.Fatalf("expected ttl from Expires, got %v %v", ttl, ok)
+	}
+	h.Set("Expires", "0")
+	if ttl, ok := expiresTTL(h, now); !ok || ttl != 0 {
+		t.Fatalf("expected invalid Expires to be treated as expired, got %v %v", ttl, ok)
+	}
+}
+
+func TestOriginDate(t *testing.T) {
+	now := time.Now()
+	if got := originDate(&origin.Object{}, now); !got.Equal(now) {
+		t.Fatalf("expected now without an origin Date, got %v", got)
+	}
+	date := now.Add(-30 * time.Second)
+	if got := originDate(&origin.Object{Date: date}, now); !got.Equal(date) {
+		t.Fatalf("expected origin Date, got %v", got)
+	}
+	if got := originDate(&origin.Object{Date: now.Add(time.Minute)}, now); !got.Equal(now) {
+		t.Fatalf("expected future Date to be ignored, got %v", got)
+	}
+}
+
+func TestCacheControlRulesRevalidate(t *testing.T) {
+	bucket := newFakeBucket(t)
+	bucket.put("assets/app.js", "console.log(1)").header.Set("Cache-Control", "max-age=5")
+	s := newBucketServer(t, bucket, &config.Config{CacheControlRules: []config.CacheControlRule{
+		{Prefix: "assets/", Value: "public, max-age=3600"},
+	}})
+	if w := getObject(s, "/assets/app.js", nil); w.Header().Get("X-Cache") != "MISS" {
+		t.Fatalf("expected a miss, got %q", w.Header().Get("X-Cache"))
+	}
+	entry, ok := s.cache.Get(cacheKey("assets/app.js"))
+	if !ok || entry.TTL != time.Hour {
+		t.Fatalf("expected the entry cached for the rule's max-age, got %v %v", ok, entry)
+	}
+
+	// A revalidation that gets a new 200 keeps the override.
+	fresh, err := s.refreshEntry("assets/app.js", cacheKey("assets/app.js"), entry)
+	if err != nil || fresh == nil {
+		t.Fatalf("unexpected result %v %v", fresh, err)
+	}
+	if bucket.requests("assets/app.js") != 2 {
+		t.Fatalf("expected the revalidation to reach the origin, got %d requests", bucket.requests("assets/app.js"))
+	}
+	stored, _ := s.cache.Get(cacheKey("assets/app.js"))
+	if got := stored.Header.Get("Cache-Control"); got != "public, max-age=3600" || stored.TTL != time.Hour {
+		t.Fatalf("expected the override after revalidation, got %q for %v", got, stored.TTL)
+	}
+}
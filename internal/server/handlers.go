@@ -5,70 +5,101 @@ import (
 	"encoding/json"
 	"errors"
 	"io"
+	"maps"
 	"net/http"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/joeychilson/s3-proxy/internal/cache"
+	"github.com/joeychilson/s3-proxy/internal/config"
 	"github.com/joeychilson/s3-proxy/internal/origin"
 )
 
 func (s *Server) objectHandler(w http.ResponseWriter, r *http.Request) {
-	key := strings.TrimPrefix(r.URL.Path, "/")
-	if key == "" {
-		http.NotFound(w, r)
-		return
-	}
-	if strings.Contains(key, "..") {
-		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+	r = s.indexRequest(r)
+	key, allowed := s.requestKey(w, r)
+	if !allowed || !s.checkAccess(w, r, key) || !s.checkUserAgent(w, r) {
 		return
 	}
+	w = s.withSurrogate(w, r, key)
 
 	method := r.Method
 	if method != http.MethodGet && method != http.MethodHead {
 		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
 		return
 	}
+	r = s.strictRequest(r)
+
+	s.setVary(w)
+	if s.cacheOnly(r) {
+		s.serveCacheOnly(w, r, key)
+		return
+	}
+	if s.serveCachedRange(w, r, key) {
+		return
+	}
+	if version := requestVersion(r); version != "" {
+		s.serveVersion(w, r, key, version)
+		return
+	}
+	if s.serveVideoRange(w, r, key) || s.serveChunkedRange(w, r, key) {
+		return
+	}
 
 	ctx := r.Context()
 	now := time.Now()
 	useCache := shouldUseCache(r)
 	lookupCache := useCache || method == http.MethodHead
-	cKey := cacheKey(key)
+	cKey := s.requestCacheKey(key, r)
 	var entry *cache.Entry
 	var ok bool
 	if lookupCache {
 		if entry, ok = s.cache.Get(cKey); ok {
 			if entry.Fresh(now) {
 				s.metrics.cacheHits.Inc()
-				s.writeCacheEntry(w, r, entry, now, "HIT")
+				s.writeCacheEntry(w, r, cKey, entry, now, "HIT")
 				return
 			}
 			if useCache && entry.StaleButValid(now) && method == http.MethodGet {
 				s.metrics.cacheStales.Inc()
-				s.writeCacheEntry(w, r, entry, now, "STALE")
-				go s.revalidate(key, entry)
+				s.writeCacheEntry(w, r, cKey, entry, now, "STALE")
+				if s.reval.begin(cKey, now) {
+					go s.revalidate(key, cKey, entry)
+				}
 				return
 			}
 		}
 	}
 
+	if s.serveCachedError(w, r, cKey, entry, now) {
+		return
+	}
+
+	// With a cached copy, revalidate it using its own validators and answer
+	// the client's conditionals locally; without one, pass the client's
+	// validators through to the origin unchanged. If-Match and
+	// If-Unmodified-Since always go to the origin so that they are checked
+	// against the current object.
 	cond := buildConditional(r)
+	clientConditional := cond.IfNoneMatch != "" || cond.IfModifiedSince != nil
 	if entry != nil {
-		if entry.ETag != "" && cond.IfNoneMatch == "" {
-			cond.IfNoneMatch = entry.ETag
-		}
-		if !entry.LastModified.IsZero() && cond.IfModifiedSince == nil {
-			lm := entry.LastModified
-			cond.IfModifiedSince = &lm
-		}
+		ifMatch, ifUnmodifiedSince := cond.IfMatch, cond.IfUnmodifiedSince
+		cond = entryConditional(entry)
+		cond.IfMatch, cond.IfUnmodifiedSince = ifMatch, ifUnmodifiedSince
+		clientConditional = false
 	}
 	if method == http.MethodGet {
 		cond.Range = r.Header.Get("Range")
 	}
+	clientConditional = clientConditional || cond.IfMatch != "" || cond.IfUnmodifiedSince != nil || r.Header.Get("If-Range") != ""
+
+	if useCache && !clientConditional && s.fills == nil && s.serveCoalesced(w, r, key, cond, entry, now) {
+		return
+	}
 
-	obj, err := s.fetchFromOrigin(ctx, key, cond, method)
+	obj, err := s.fetchRange(ctx, r, key, cond, method)
 	if err != nil {
 		s.handleOriginError(w, r, err, entry, now, cKey)
 		return
@@ -77,53 +108,244 @@ func (s *Server) objectHandler(w http.ResponseWriter, r *http.Request) {
 		defer obj.Body.Close()
 	}
 
-	shouldStore := useCache && method == http.MethodGet && cond.Range == "" && obj.StatusCode == http.StatusOK && obj.ContentLength > 0 && obj.ContentLength <= s.cfg.MaxObjectSize && !hasNoStore(obj.Headers)
-	if shouldStore {
-		body, readErr := io.ReadAll(io.LimitReader(obj.Body, s.cfg.MaxObjectSize+1))
-		if readErr != nil {
-			s.logger.Error("read origin body", "error", readErr, "key", key)
-			http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+	if useCache && method == http.MethodGet && cond.Range == "" && s.cacheable(key, obj) {
+		if s.fills != nil {
+			if !s.fills.enqueue(key) {
+				s.metrics.fillsSkipped.Inc()
+			}
+			s.streamObject(w, r, key, obj)
 			return
 		}
-		if int64(len(body)) > s.cfg.MaxObjectSize {
-			shouldStore = false
-		} else {
-			s.metrics.cacheMisses.Inc()
-			e := &cache.Entry{
-				Body:         append([]byte(nil), body...),
-				Header:       cloneHeader(obj.Headers),
-				Status:       obj.StatusCode,
-				StoredAt:     now,
-				TTL:          ttlFromHeaders(obj.Headers, s.cfg.CacheTTL),
-				StaleTTL:     s.cfg.CacheStaleTTL,
-				Size:         int64(len(body)),
-				ETag:         obj.ETag,
-				LastModified: valueOrZero(obj.LastModified),
+		if body := s.streamAndStore(w, r, key, obj); body != nil {
+			fresh := s.newEntry(key, obj, body, now)
+			s.checkValidators("origin", entry, fresh)
+			s.cache.Set(cKey, fresh)
+			s.events.emit(eventFill, cKey, int64(len(body)), "miss")
+		}
+		return
+	}
+
+	s.streamObject(w, r, key, obj)
+}
+
+// requestKey returns the object key named by the request path after any
+// rewrite rule is applied, answering the request itself when the path does
+// not name a servable key.
+func (s *Server) requestKey(w http.ResponseWriter, r *http.Request) (string, bool) {
+	path := r.URL.Path
+	if !isPeerRequest(r.Context()) {
+		path = s.currentRules().rewrite(path)
+	}
+	key := strings.TrimPrefix(path, "/")
+	if key == "" {
+		http.NotFound(w, r)
+		return "", false
+	}
+	if strings.Contains(key, "..") {
+		s.securityEvent(r, securityTraversal, "")
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return "", false
+	}
+	key, allowed := s.hostKey(r, key)
+	if !allowed {
+		http.NotFound(w, r)
+		return "", false
+	}
+	return key, true
+}
+
+// serveCoalesced fills the cache for key through the flight group so that
+// concurrent misses share a single origin request. It reports false when the
+// leader's response could not be cached and the caller must fetch on its own.
+func (s *Server) serveCoalesced(w http.ResponseWriter, r *http.Request, key string, cond *origin.Conditional, entry *cache.Entry, now time.Time) bool {
+	cKey := s.requestCacheKey(key, r)
+	if entry != nil && entry.Status == http.StatusOK && s.flights.inFlight(cKey) {
+		// Another request is already filling this key; an expired copy is
+		// better than queueing behind it.
+		s.metrics.cacheStales.Inc()
+		s.writeCacheEntry(w, r, cKey, entry, now, "STALE")
+		return true
+	}
+
+	if s.joinFanout(w, r, key, cKey) {
+		return true
+	}
+
+	waitCtx, cancel := context.WithTimeout(r.Context(), s.cfg.FillWaitTimeout)
+	defer cancel()
+	var uncached *origin.Object
+	var shared *fanoutReader
+	var streamed bool
+	res, leader, err := s.flights.do(waitCtx, cKey, func() flightResult {
+		ctx := context.WithoutCancel(r.Context())
+		if e := s.fetchFromPeer(ctx, key, s.varyHeader(r), now); e != nil {
+			s.checkValidators("peer", entry, e)
+			return flightResult{entry: e, state: "HIT", layer: layerPeer}
+		}
+		obj, err := s.fetchFromOrigin(ctx, key, cond, http.MethodGet)
+		if err != nil {
+			if errors.Is(err, origin.ErrNotModified) && entry != nil {
+				entry.StoredAt = now
+				s.cache.Set(cKey, entry)
+				s.events.emit(eventRevalidate, cKey, entry.Size, "not-modified")
+				return flightResult{entry: entry, state: "REVALIDATED"}
 			}
-			if e.TTL <= 0 {
-				e.TTL = s.cfg.CacheTTL
+			return flightResult{err: err}
+		}
+		if !s.cacheable(key, obj) {
+			uncached = obj
+			if s.fanoutEligible(obj) {
+				// Registered before the flight ends, so that the
+				// followers join it rather than fetch on their own.
+				shared = s.fanouts.start(r.Context(), cKey, obj)
 			}
-			s.cache.Set(cKey, e)
-			s.writeCacheEntry(w, r, e, now, "MISS")
-			return
+			return flightResult{}
 		}
+		defer obj.Body.Close()
+		streamed = true
+		body := s.streamAndStore(w, r, key, obj)
+		if body == nil {
+			return flightResult{}
+		}
+		e := s.newEntry(key, obj, body, now)
+		s.checkValidators("origin", entry, e)
+		s.cache.Set(cKey, e)
+		s.events.emit(eventFill, cKey, e.Size, "miss")
+		return flightResult{entry: e, state: "MISS"}
+	})
+	if errors.Is(err, context.DeadlineExceeded) && r.Context().Err() == nil {
+		s.metrics.fillWaitTimeouts.Inc()
+		s.logger.Warn("fill wait timed out", "key", key, "timeout", s.cfg.FillWaitTimeout.String())
+		http.Error(w, http.StatusText(http.StatusGatewayTimeout), http.StatusGatewayTimeout)
+		return true
+	}
+	if err != nil || streamed {
+		return true
+	}
+	if !leader && (res.entry != nil || res.err != nil) {
+		s.metrics.coalesced.Inc()
 	}
 
+	switch {
+	case res.err != nil:
+		s.handleOriginError(w, r, res.err, entry, now, cKey)
+	case res.entry != nil:
+		state := res.state
+		if !leader {
+			state = "COALESCED"
+		}
+		layer := res.layer
+		if layer == "" {
+			layer = stateLayer(state)
+		}
+		if state == "REVALIDATED" || layer == layerPeer {
+			s.metrics.cacheHits.Inc()
+		} else {
+			s.metrics.cacheMisses.Inc()
+		}
+		s.writeEntry(w, r, cKey, res.entry, now, layer, state)
+	case shared != nil:
+		s.serveFanout(w, r, key, shared, "MISS")
+	case uncached != nil:
+		defer uncached.Body.Close()
+		s.streamObject(w, r, key, uncached)
+	default:
+		return s.joinFanout(w, r, key, cKey)
+	}
+	return true
+}
+
+func (s *Server) streamObject(w http.ResponseWriter, r *http.Request, key string, obj *origin.Object) {
 	copyHeaders(w.Header(), obj.Headers)
-	w.Header().Set("X-Cache", "MISS")
+	s.setEncodingVary(w, obj.StatusCode, obj.ContentLength, obj.Headers)
+	s.setCacheStatus(w, layerOrigin, "MISS")
 	if obj.ContentLength > 0 {
 		w.Header().Set("Content-Length", strconv.FormatInt(obj.ContentLength, 10))
 	}
 	s.metrics.cacheMisses.Inc()
+	digest := s.digestTrailer(w, r, obj.StatusCode)
 	w.WriteHeader(obj.StatusCode)
-	if method == http.MethodHead {
+	if r.Method == http.MethodHead {
 		return
 	}
-	bytes, copyErr := io.Copy(w, obj.Body)
-	if copyErr != nil {
-		s.logger.Error("stream response", "error", copyErr, "key", key)
+	var dst io.Writer = w
+	if digest != nil {
+		dst = io.MultiWriter(w, digest)
+	}
+	if _, err := s.copyBody(dst, obj.Body); err != nil {
+		s.logger.Error("stream response", "error", err, "key", key)
+		return
+	}
+	finishDigest(w, digest)
+}
+
+// streamAndStore sends a cacheable object to the client while keeping a copy
+// of the body. The copy is returned only if the full body was read and sent
+// and fits within MaxObjectSize; a fill whose client went away is dropped.
+func (s *Server) streamAndStore(w http.ResponseWriter, r *http.Request, key string, obj *origin.Object) []byte {
+	copyHeaders(w.Header(), obj.Headers)
+	s.setEncodingVary(w, obj.StatusCode, obj.ContentLength, obj.Headers)
+	now := time.Now()
+	w.Header().Set("Age", strconv.Itoa(int(now.Sub(originDate(obj, now)).Seconds())))
+	s.setCacheStatus(w, layerOrigin, "MISS")
+	w.Header().Set("Content-Length", strconv.FormatInt(obj.ContentLength, 10))
+	s.metrics.cacheMisses.Inc()
+	digest := s.digestTrailer(w, r, obj.StatusCode)
+	w.WriteHeader(obj.StatusCode)
+
+	body := make([]byte, 0, obj.ContentLength)
+	overflow := false
+	buf := s.responseBuffer()
+	for {
+		n, err := obj.Body.Read(buf)
+		if n > 0 {
+			if !overflow && int64(len(body)+n) <= s.cfg.MaxObjectSize {
+				body = append(body, buf[:n]...)
+			} else {
+				overflow = true
+				body = nil
+			}
+			if _, writeErr := w.Write(buf[:n]); writeErr != nil {
+				return nil
+			}
+			if digest != nil {
+				digest.Write(buf[:n])
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			s.logger.Error("stream response", "error", err, "key", key)
+			return nil
+		}
+	}
+	finishDigest(w, digest)
+	if overflow || int64(len(body)) != obj.ContentLength {
+		return nil
+	}
+	return body
+}
+
+func (s *Server) cacheable(key string, obj *origin.Object) bool {
+	if obj.StatusCode != http.StatusOK || obj.ContentLength <= 0 || obj.ContentLength > s.cfg.MaxObjectSize {
+		return false
+	}
+	if !s.varyKeyed(obj.Headers) {
+		return false
+	}
+	return s.microCached(key) || !hasNoStore(obj.Headers)
+}
+
+// microCached reports whether key falls under a micro-cache prefix. Such
+// objects are cached for MicroCacheTTL regardless of origin Cache-Control.
+func (s *Server) microCached(key string) bool {
+	for _, prefix := range s.currentRules().microCache {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
 	}
-	s.metrics.bytesServed.Add(float64(bytes))
+	return false
 }
 
 func (s *Server) fetchFromOrigin(ctx context.Context, key string, cond *origin.Conditional, method string) (*origin.Object, error) {
@@ -131,13 +353,15 @@ func (s *Server) fetchFromOrigin(ctx context.Context, key string, cond *origin.C
 	if method == http.MethodHead {
 		obj, err := s.origin.HeadObject(ctx, key, cond)
 		if err == nil {
-			s.metrics.originLatency.Observe(time.Since(start).Seconds())
+			observe(ctx, s.metrics.originLatency, time.Since(start).Seconds())
+			s.overrideCacheControl(key, obj)
 		}
 		return obj, err
 	}
 	obj, err := s.origin.GetObject(ctx, key, cond)
 	if err == nil {
-		s.metrics.originLatency.Observe(time.Since(start).Seconds())
+		observe(ctx, s.metrics.originLatency, time.Since(start).Seconds())
+		s.overrideCacheControl(key, obj)
 	}
 	return obj, err
 }
@@ -146,16 +370,23 @@ func (s *Server) handleOriginError(w http.ResponseWriter, r *http.Request, err e
 	if errors.Is(err, origin.ErrNotModified) && entry != nil {
 		entry.StoredAt = now
 		s.cache.Set(cacheKey, entry)
+		s.events.emit(eventRevalidate, cacheKey, entry.Size, "not-modified")
 		s.metrics.cacheHits.Inc()
-		s.writeCacheEntry(w, r, entry, now, "REVALIDATED")
+		s.writeCacheEntry(w, r, cacheKey, entry, now, "REVALIDATED")
 		return
 	}
 	if errors.Is(err, origin.ErrNotModified) {
 		w.WriteHeader(http.StatusNotModified)
 		return
 	}
+	if s.cfg.OpaqueErrors && errors.Is(err, origin.ErrAccessDenied) {
+		s.logger.Warn("origin access denied", "error", err, "path", r.URL.Path)
+		err = origin.ErrNotFound
+	}
 	if errors.Is(err, origin.ErrNotFound) {
-		http.NotFound(w, r)
+		if !s.serveFallback(w, r) {
+			http.NotFound(w, r)
+		}
 		return
 	}
 	if errors.Is(err, origin.ErrPrecondition) {
@@ -164,73 +395,221 @@ func (s *Server) handleOriginError(w http.ResponseWriter, r *http.Request, err e
 	}
 	s.metrics.originErrors.Inc()
 	s.logger.Error("origin fetch failed", "error", err, "path", r.URL.Path)
-	http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+	status := s.originFailureStatus()
+	if errors.Is(err, context.Canceled) {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	if s.cfg.ErrorCacheTTL > 0 && cacheKey != "" {
+		s.cache.Set(errorCacheKey(cacheKey), &cache.Entry{
+			Body:     []byte(http.StatusText(status) + "\n"),
+			Header:   http.Header{"Content-Type": {"text/plain; charset=utf-8"}, "X-Content-Type-Options": {"nosniff"}},
+			Status:   status,
+			StoredAt: now,
+			TTL:      s.cfg.ErrorCacheTTL,
+			Size:     int64(len(http.StatusText(status)) + 1),
+		})
+	}
+	if s.serveStaleOnError(w, r, cacheKey, entry, now) {
+		return
+	}
+	http.Error(w, http.StatusText(status), status)
+}
+
+// originFailureStatus is the status for an origin that failed outright:
+// 502, or a generic 503 under OPAQUE_ERRORS.
+func (s *Server) originFailureStatus() int {
+	if s.cfg.OpaqueErrors {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusBadGateway
+}
+
+// serveStaleOnError answers with an expired entry while the origin is failing,
+// as long as it is within the CACHE_STALE_IF_ERROR window.
+func (s *Server) serveStaleOnError(w http.ResponseWriter, r *http.Request, cKey string, entry *cache.Entry, now time.Time) bool {
+	if entry == nil || entry.Status != http.StatusOK || !entry.StaleIfError(now, s.cfg.CacheStaleIfError) {
+		return false
+	}
+	s.metrics.staleErrors.Inc()
+	s.writeCacheEntry(w, r, cKey, entry, now, "STALE-ERROR")
+	return true
+}
+
+// serveCachedError replays a recent origin failure for key instead of sending
+// another request to an origin that is already failing. A stale copy of the
+// object is preferred over the error when one is available.
+func (s *Server) serveCachedError(w http.ResponseWriter, r *http.Request, cKey string, entry *cache.Entry, now time.Time) bool {
+	if s.cfg.ErrorCacheTTL <= 0 {
+		return false
+	}
+	errEntry, ok := s.cache.Get(errorCacheKey(cKey))
+	if !ok || !errEntry.Fresh(now) {
+		return false
+	}
+	if s.serveStaleOnError(w, r, cKey, entry, now) {
+		return true
+	}
+	s.metrics.errorCacheHits.Inc()
+	s.writeCacheEntry(w, r, errorCacheKey(cKey), errEntry, now, "ERROR")
+	return true
 }
 
-func (s *Server) writeCacheEntry(w http.ResponseWriter, r *http.Request, entry *cache.Entry, now time.Time, state string) {
+func (s *Server) writeCacheEntry(w http.ResponseWriter, r *http.Request, cKey string, entry *cache.Entry, now time.Time, state string) {
+	s.writeEntry(w, r, cKey, entry, now, stateLayer(state), state)
+}
+
+// writeEntry answers with entry, which is cached under cKey.
+func (s *Server) writeEntry(w http.ResponseWriter, r *http.Request, cKey string, entry *cache.Entry, now time.Time, layer, state string) {
+	entry = s.negotiateEncoding(w, r, cKey, entry)
+	if entry.Status == http.StatusOK && (!ifMatch(r, entry) || !ifUnmodifiedSince(r, entry)) {
+		http.Error(w, http.StatusText(http.StatusPreconditionFailed), http.StatusPreconditionFailed)
+		return
+	}
+	if entry.Status == http.StatusOK && s.writeNotModified(w, r, entry, now, layer, state) {
+		return
+	}
+	vary := w.Header().Values("Vary")
 	copyHeaders(w.Header(), entry.Header)
+	for _, name := range vary {
+		addVary(w.Header(), name)
+	}
 	w.Header().Set("Age", strconv.Itoa(entry.Age(now)))
-	w.Header().Set("X-Cache", state)
+	s.setCacheStatus(w, layer, state)
 	w.WriteHeader(entry.Status)
 	if r.Method == http.MethodHead {
 		return
 	}
-	bytes, _ := w.Write(entry.Body)
-	s.metrics.bytesServed.Add(float64(bytes))
+	w.Write(entry.Body)
 }
 
-func (s *Server) revalidate(key string, entry *cache.Entry) {
+func (s *Server) revalidate(key, cKey string, entry *cache.Entry) {
+	var err error
+	var fresh *cache.Entry
+	s.flights.do(context.Background(), cKey, func() flightResult {
+		if fresh, err = s.refreshEntry(key, cKey, entry); err != nil {
+			return flightResult{err: err}
+		}
+		return flightResult{entry: fresh, state: "REVALIDATED"}
+	})
+	switch {
+	case err != nil:
+		s.events.emit(eventRevalidate, cKey, entry.Size, "error")
+	case fresh == nil:
+		s.events.emit(eventRevalidate, cKey, entry.Size, "uncacheable")
+	case fresh == entry:
+		s.events.emit(eventRevalidate, cKey, entry.Size, "not-modified")
+	default:
+		s.events.emit(eventRevalidate, cKey, fresh.Size, "modified")
+	}
+	delay := s.reval.end(cKey, time.Now(), err != nil)
+	if err != nil {
+		s.metrics.revalidateFailures.Inc()
+		s.logger.Warn("revalidate failed", "error", err, "key", key, "retry_in", delay.String())
+	}
+}
+
+// refreshEntry revalidates entry against the origin and stores the result. It
+// returns the refreshed entry, or nil if the object is no longer cacheable.
+func (s *Server) refreshEntry(key, cKey string, entry *cache.Entry) (*cache.Entry, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), s.cfg.RequestTimeout)
 	defer cancel()
-	cond := &origin.Conditional{}
-	if entry.ETag != "" {
-		cond.IfNoneMatch = entry.ETag
-	}
-	if !entry.LastModified.IsZero() {
-		lm := entry.LastModified
-		cond.IfModifiedSince = &lm
-	}
-	obj, err := s.origin.GetObject(ctx, key, cond)
+	obj, err := s.origin.GetObject(ctx, key, entryConditional(entry))
 	if err != nil {
 		if errors.Is(err, origin.ErrNotModified) {
 			entry.StoredAt = time.Now()
-			s.cache.Set(cacheKey(key), entry)
+			s.cache.Set(cKey, entry)
+			return entry, nil
 		}
-		return
+		return nil, err
 	}
 	if obj.Body != nil {
 		defer obj.Body.Close()
 	}
-	if obj.ContentLength <= 0 || obj.ContentLength > s.cfg.MaxObjectSize {
-		return
+	s.overrideCacheControl(key, obj)
+	if !s.cacheable(key, obj) {
+		return nil, nil
 	}
 	body, err := io.ReadAll(io.LimitReader(obj.Body, s.cfg.MaxObjectSize+1))
 	if err != nil {
-		return
+		return nil, err
 	}
 	if int64(len(body)) > s.cfg.MaxObjectSize {
-		return
+		return nil, nil
 	}
-	updated := &cache.Entry{
+	fresh := s.newEntry(key, obj, body, time.Now())
+	s.checkValidators("origin", entry, fresh)
+	s.cache.Set(cKey, fresh)
+	return fresh, nil
+}
+
+func (s *Server) newEntry(key string, obj *origin.Object, body []byte, now time.Time) *cache.Entry {
+	e := &cache.Entry{
 		Body:         append([]byte(nil), body...),
 		Header:       cloneHeader(obj.Headers),
 		Status:       obj.StatusCode,
-		StoredAt:     time.Now(),
-		TTL:          ttlFromHeaders(obj.Headers, s.cfg.CacheTTL),
+		StoredAt:     originDate(obj, now),
+		TTL:          s.originTTL(obj, now),
 		StaleTTL:     s.cfg.CacheStaleTTL,
 		Size:         int64(len(body)),
 		ETag:         obj.ETag,
 		LastModified: valueOrZero(obj.LastModified),
 	}
-	s.cache.Set(cacheKey(key), updated)
+	if e.TTL <= 0 {
+		e.TTL = s.cfg.CacheTTL
+	}
+	if s.cfg.ContentDigest && e.Status == http.StatusOK {
+		e.Header.Set(contentSHA256Header, contentSHA256(body))
+	}
+	if s.microCached(key) {
+		e.TTL = s.cfg.MicroCacheTTL
+		e.StaleTTL = s.cfg.MicroCacheTTL
+	}
+	s.indexTags(key, e)
+	return e
+}
+
+type purgeRequest struct {
+	Keys     []string `json:"keys"`
+	Prefixes []string `json:"prefixes,omitempty"`
+	Patterns []string `json:"patterns,omitempty"`
+	Tags     []string `json:"tags,omitempty"`
 }
 
 func (s *Server) purgeHandler(w http.ResponseWriter, r *http.Request) {
-	var payload struct {
-		Keys []string `json:"keys"`
+	var payload purgeRequest
+	if !decodeJSON(w, r, &payload) {
+		return
 	}
-	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
-		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+	var matcher *purgeMatcher
+	if len(payload.Prefixes) > 0 || len(payload.Patterns) > 0 {
+		var err error
+		if matcher, err = newPurgeMatcher(payload.Prefixes, payload.Patterns); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	notice := payload
+	if len(payload.Tags) > 0 {
+		// CDNs that purge by path get the keys the tags stand for.
+		notice.Keys = append(slices.Clone(payload.Keys), s.taggedKeys(payload.Tags)...)
+	}
+	if s.cfg.AdminDryRun {
+		keys := []string{}
+		for _, key := range payload.Keys {
+			if k := strings.TrimSpace(key); k != "" {
+				keys = append(keys, k)
+			}
+		}
+		if matcher != nil {
+			keys = append(keys, s.matchingKeys(matcher)...)
+		}
+		keys = append(keys, s.taggedKeys(payload.Tags)...)
+		details := []any{"keys", keys}
+		if len(s.notify) > 0 && !isPeerRequest(r.Context()) {
+			details = append(details, "cdn", s.purgeTargets(notice))
+		}
+		s.dryRun(w, r, "purge", details...)
 		return
 	}
 	for _, key := range payload.Keys {
@@ -238,11 +617,53 @@ func (s *Server) purgeHandler(w http.ResponseWriter, r *http.Request) {
 		if k == "" {
 			continue
 		}
-		s.cache.Delete(cacheKey(k))
+		s.purgeKey(k, "key")
+	}
+	if matcher != nil {
+		purged := s.purgeMatching(matcher)
+		s.logger.Info("cache purge", "prefixes", payload.Prefixes, "patterns", payload.Patterns, "purged", purged)
+	}
+	if len(payload.Tags) > 0 {
+		purged := s.purgeTags(payload.Tags)
+		s.logger.Info("cache purge", "tags", payload.Tags, "purged", purged)
+	}
+	if s.peers != nil && !isPeerRequest(r.Context()) {
+		s.broadcastPurge(r.Context(), payload)
+	}
+	if len(s.notify) > 0 && !isPeerRequest(r.Context()) {
+		if err := s.notifyPurge(r.Context(), notice); err != nil {
+			s.logger.Warn("cdn purge failed", "error", err)
+			http.Error(w, "cache purged, but not every CDN: "+err.Error(), http.StatusBadGateway)
+			return
+		}
 	}
 	w.WriteHeader(http.StatusNoContent)
 }
 
+func (s *Server) purgeKey(key, reason string) {
+	cKey := cacheKey(key)
+	var size int64
+	if entry, ok := s.cache.Get(cKey); ok {
+		size = entry.Size
+	}
+	s.events.emit(eventPurge, cKey, size, reason)
+	s.cache.Delete(cKey)
+	s.cache.Delete(errorCacheKey(cKey))
+	s.cache.Delete(cKey + gzipVariantSuffix)
+	s.purgeVariants(cKey)
+	s.purgeVersions(cKey)
+	s.cache.Delete(videoSegmentKey(key, "head"))
+	s.cache.Delete(videoSegmentKey(key, "tail"))
+	s.purgeChunks(key)
+}
+
+// configSchemaHandler describes the settings the proxy reads, for tooling
+// that validates deploy manifests.
+func (s *Server) configSchemaHandler(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/schema+json")
+	json.NewEncoder(w).Encode(config.Schema(s.cfg.Settings))
+}
+
 func (s *Server) healthHandler(w http.ResponseWriter, _ *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("ok"))
@@ -255,32 +676,76 @@ func shouldUseCache(r *http.Request) bool {
 	if r.Header.Get("Range") != "" {
 		return false
 	}
+	return !noCacheRequested(r)
+}
+
+func noCacheRequested(r *http.Request) bool {
 	cc := strings.ToLower(r.Header.Get("Cache-Control"))
 	if strings.Contains(cc, "no-cache") || strings.Contains(cc, "max-age=0") {
-		return false
+		return true
 	}
 	pragma := strings.ToLower(r.Header.Get("Pragma"))
-	if strings.Contains(pragma, "no-cache") {
-		return false
-	}
-	return true
+	return strings.Contains(pragma, "no-cache")
 }
 
 func cacheKey(key string) string {
 	return key
 }
 
+func errorCacheKey(cKey string) string {
+	return cKey + "#error"
+}
+
+// cloneHeader and copyHeaders leave out hop-by-hop headers, which describe
+// the connection a message arrived on (RFC 9110, section 7.6.1) and would be
+// wrong on any other.
 func cloneHeader(h http.Header) http.Header {
+	hop := hopByHop(h)
 	dup := make(http.Header, len(h))
 	for k, v := range h {
-		dup[k] = append([]string(nil), v...)
+		if !hop[k] {
+			dup[k] = append([]string(nil), v...)
+		}
 	}
 	return dup
 }
 
+var hopByHopHeaders = map[string]bool{
+	"Connection":          true,
+	"Keep-Alive":          true,
+	"Proxy-Connection":    true,
+	"Proxy-Authenticate":  true,
+	"Proxy-Authorization": true,
+	"Te":                  true,
+	"Trailer":             true,
+	"Transfer-Encoding":   true,
+	"Upgrade":             true,
+}
+
+// hopByHop returns the hop-by-hop headers of h: the standard ones and those
+// its Connection header names.
+func hopByHop(h http.Header) map[string]bool {
+	names := h.Values("Connection")
+	if len(names) == 0 {
+		return hopByHopHeaders
+	}
+	hop := maps.Clone(hopByHopHeaders)
+	for _, value := range names {
+		for name := range strings.SplitSeq(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				hop[http.CanonicalHeaderKey(name)] = true
+			}
+		}
+	}
+	return hop
+}
+
 func copyHeaders(dst, src http.Header) {
+	hop := hopByHop(src)
 	for k, v := range src {
-		dst[k] = append([]string(nil), v...)
+		if !hop[k] {
+			dst[k] = append([]string(nil), v...)
+		}
 	}
 }
 
@@ -315,13 +780,21 @@ func valueOrZero(t *time.Time) time.Time {
 
 func buildConditional(r *http.Request) *origin.Conditional {
 	cond := &origin.Conditional{}
-	if inm := r.Header.Get("If-None-Match"); inm != "" {
-		cond.IfNoneMatch = inm
+	if im := r.Header.Values("If-Match"); len(im) > 0 {
+		cond.IfMatch = strings.Join(im, ", ")
+	}
+	if inm := r.Header.Values("If-None-Match"); len(inm) > 0 {
+		cond.IfNoneMatch = strings.Join(inm, ", ")
 	}
 	if ims := r.Header.Get("If-Modified-Since"); ims != "" {
 		if t, err := time.Parse(http.TimeFormat, ims); err == nil {
 			cond.IfModifiedSince = &t
 		}
 	}
+	if ius := r.Header.Get("If-Unmodified-Since"); ius != "" && cond.IfMatch == "" {
+		if t, err := time.Parse(http.TimeFormat, ius); err == nil {
+			cond.IfUnmodifiedSince = &t
+		}
+	}
 	return cond
 }
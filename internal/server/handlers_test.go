@@ -0,0 +1,309 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/joeychilson/s3-proxy/internal/cache"
+	"github.com/joeychilson/s3-proxy/internal/config"
+	"github.com/joeychilson/s3-proxy/internal/origin"
+)
+
+func TestOpaqueErrors(t *testing.T) {
+	store, err := cache.New(16, 1<<20, time.Minute, time.Minute)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s := &Server{
+		cfg:     &config.Config{OpaqueErrors: true, ErrorCacheTTL: time.Second},
+		cache:   store,
+		metrics: newMetrics(prometheus.NewRegistry()),
+		logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	denied := fmt.Errorf("%w: s3 api: AccessDenied", origin.ErrAccessDenied)
+	fetch := func(err error) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		s.handleOriginError(rec, httptest.NewRequest(http.MethodGet, "/private/x", nil), err, nil, time.Now(), cacheKey("private/x"))
+		return rec
+	}
+	missing, forbidden := fetch(origin.ErrNotFound), fetch(denied)
+	if forbidden.Code != http.StatusNotFound || forbidden.Body.String() != missing.Body.String() {
+		t.Fatalf("expected access denied to look like a missing key, got %d %q", forbidden.Code, forbidden.Body.String())
+	}
+	if rec := fetch(fmt.Errorf("s3: connection refused")); rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected origin failures to map to 503, got %d", rec.Code)
+	}
+	if entry, ok := store.Get(errorCacheKey(cacheKey("private/x"))); !ok || entry.Status != http.StatusServiceUnavailable {
+		t.Fatalf("expected the cached error to keep the opaque status")
+	}
+	rec := httptest.NewRecorder()
+	s.writeFailed(rec, httptest.NewRequest(http.MethodPut, "/private/x", nil), "private/x", denied)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected a denied write to map to 404, got %d", rec.Code)
+	}
+
+	s.cfg.OpaqueErrors = false
+	if rec := fetch(denied); rec.Code != http.StatusBadGateway {
+		t.Fatalf("expected access denied to stay a 502 without OPAQUE_ERRORS, got %d", rec.Code)
+	}
+}
+
+func TestHopByHopHeaders(t *testing.T) {
+	h := http.Header{}
+	h.Set("Connection", "keep-alive, X-Internal")
+	h.Set("X-Internal", "1")
+	h.Set("Transfer-Encoding", "chunked")
+	h.Set("Keep-Alive", "timeout=5")
+	h.Set("Content-Type", "image/png")
+	h.Set("x-amz-meta-owner", "team")
+
+	if dup := cloneHeader(h); len(dup) != 2 || dup.Get("Content-Type") != "image/png" || dup.Get("x-amz-meta-owner") != "team" {
+		t.Fatalf("expected only end-to-end headers to be cloned, got %v", dup)
+	}
+	w := httptest.NewRecorder()
+	copyHeaders(w.Header(), h)
+	if len(w.Header()) != 2 || w.Header().Get("Transfer-Encoding") != "" {
+		t.Fatalf("expected only end-to-end headers to be copied, got %v", w.Header())
+	}
+}
+
+func TestErrorCache(t *testing.T) {
+	bucket := newFakeBucket(t)
+	for _, key := range []string{"broken.txt", "denied.txt", "guarded.txt"} {
+		bucket.put(key, "body")
+	}
+	bucket.fail("broken.txt", http.StatusInternalServerError)
+	bucket.fail("denied.txt", http.StatusForbidden)
+	bucket.fail("guarded.txt", http.StatusPreconditionFailed)
+	s := newBucketServer(t, bucket, &config.Config{ErrorCacheTTL: 100 * time.Millisecond})
+
+	tests := []struct {
+		path   string
+		header http.Header
+		status int
+		cached bool
+	}{
+		{"/broken.txt", nil, http.StatusBadGateway, true},
+		{"/denied.txt", nil, http.StatusBadGateway, true},
+		{"/missing.txt", nil, http.StatusNotFound, false},
+		{"/guarded.txt", http.Header{"If-Match": {`"other"`}}, http.StatusPreconditionFailed, false},
+	}
+	for _, tt := range tests {
+		for range 2 {
+			if w := getObject(s, tt.path, tt.header); w.Code != tt.status {
+				t.Fatalf("%s: got %d, want %d", tt.path, w.Code, tt.status)
+			}
+		}
+		want := 2
+		if tt.cached {
+			want = 1
+		}
+		if got := bucket.requests(tt.path[1:]); got != want {
+			t.Fatalf("%s: expected %d origin requests, got %d", tt.path, want, got)
+		}
+	}
+
+	w := getObject(s, "/broken.txt", nil)
+	if w.Header().Get("X-Cache") != "ERROR" {
+		t.Fatalf("expected the failure replayed from the cache, got X-Cache %q", w.Header().Get("X-Cache"))
+	}
+	time.Sleep(150 * time.Millisecond)
+	bucket.fail("broken.txt", 0)
+	if w := getObject(s, "/broken.txt", nil); w.Code != http.StatusOK || w.Body.String() != "body" {
+		t.Fatalf("expected the origin asked again once the failure expired, got %d %q", w.Code, w.Body.String())
+	}
+	if got := bucket.requests("broken.txt"); got != 2 {
+		t.Fatalf("expected a second origin request after expiry, got %d", got)
+	}
+}
+
+// brokenWriter is a client that goes away after limit bytes.
+type brokenWriter struct {
+	*httptest.ResponseRecorder
+	limit int
+}
+
+func (w *brokenWriter) Write(p []byte) (int, error) {
+	if w.Body.Len()+len(p) > w.limit {
+		return 0, io.ErrClosedPipe
+	}
+	return w.ResponseRecorder.Write(p)
+}
+
+func TestStreamAndStore(t *testing.T) {
+	bucket := newFakeBucket(t)
+	bucket.put("whole.txt", strings.Repeat("a", 100))
+	bucket.put("left.txt", strings.Repeat("b", 100))
+	bucket.put("short.txt", strings.Repeat("c", 100))
+	bucket.intercept(func(w http.ResponseWriter, r *http.Request, key string) bool {
+		if key != "short.txt" {
+			return false
+		}
+		w.Header().Set("Content-Length", "100")
+		io.WriteString(w, strings.Repeat("c", 40))
+		return true
+	})
+	s := newBucketServer(t, bucket, &config.Config{MaxObjectSize: 200, ResponseBufferSize: 16})
+
+	if w := getObject(s, "/whole.txt", nil); w.Body.Len() != 100 {
+		t.Fatalf("expected the whole body, got %d bytes", w.Body.Len())
+	}
+	if _, ok := s.cache.Get(cacheKey("whole.txt")); !ok {
+		t.Fatalf("expected a complete stream to be cached")
+	}
+
+	w := &brokenWriter{ResponseRecorder: httptest.NewRecorder(), limit: 32}
+	s.objectHandler(w, httptest.NewRequest(http.MethodGet, "/left.txt", nil))
+	if _, ok := s.cache.Get(cacheKey("left.txt")); ok {
+		t.Fatalf("expected the fill to be dropped when the client went away")
+	}
+
+	if w := getObject(s, "/short.txt", nil); w.Body.Len() != 40 {
+		t.Fatalf("expected the short body passed on, got %d bytes", w.Body.Len())
+	}
+	if _, ok := s.cache.Get(cacheKey("short.txt")); ok {
+		t.Fatalf("expected a body shorter than its Content-Length not to be cached")
+	}
+
+	// An origin sending more than it announced stops filling the cache at
+	// MAX_OBJECT_SIZE, but the client still gets every byte.
+	s.cfg.MaxObjectSize = 64
+	obj := &origin.Object{
+		Body:          io.NopCloser(strings.NewReader(strings.Repeat("d", 100))),
+		Headers:       http.Header{},
+		StatusCode:    http.StatusOK,
+		ContentLength: 50,
+	}
+	rec := httptest.NewRecorder()
+	if body := s.streamAndStore(rec, httptest.NewRequest(http.MethodGet, "/long.txt", nil), "long.txt", obj); body != nil {
+		t.Fatalf("expected no copy past the size limit, got %d bytes", len(body))
+	}
+	if rec.Body.Len() != 100 {
+		t.Fatalf("expected the client to get the whole stream, got %d bytes", rec.Body.Len())
+	}
+}
+
+func TestMicroCache(t *testing.T) {
+	bucket := newFakeBucket(t)
+	for _, key := range []string{"exports/daily.json", "private/daily.json"} {
+		bucket.put(key, `{"n": 1}`).header.Set("Cache-Control", "no-store")
+	}
+	s := newBucketServer(t, bucket, &config.Config{
+		CacheTTL:           time.Hour,
+		MicroCachePrefixes: []string{"exports/"},
+		MicroCacheTTL:      2 * time.Second,
+	})
+
+	for i, want := range []string{"MISS", "HIT"} {
+		w := getObject(s, "/exports/daily.json", nil)
+		if w.Code != http.StatusOK || w.Header().Get("X-Cache") != want {
+			t.Fatalf("request %d: got %d %q, want %s", i, w.Code, w.Header().Get("X-Cache"), want)
+		}
+		if w.Header().Get("Cache-Control") != "no-store" {
+			t.Fatalf("expected the origin Cache-Control to be forwarded, got %q", w.Header().Get("Cache-Control"))
+		}
+	}
+	if n := bucket.requests("exports/daily.json"); n != 1 {
+		t.Fatalf("expected one origin request, got %d", n)
+	}
+	entry, ok := s.cache.Get(cacheKey("exports/daily.json"))
+	if !ok || entry.TTL != 2*time.Second || entry.StaleTTL != 2*time.Second {
+		t.Fatalf("expected a micro-cache entry, got %v", entry)
+	}
+
+	// Outside the prefixes no-store is honoured.
+	for range 2 {
+		getObject(s, "/private/daily.json", nil)
+	}
+	if n := bucket.requests("private/daily.json"); n != 2 {
+		t.Fatalf("expected no-store objects not to be cached, got %d origin requests", n)
+	}
+}
+
+func TestServeCoalesced(t *testing.T) {
+	bucket := newFakeBucket(t)
+	bucket.put("slow.bin", "fresh")
+	release := make(chan struct{})
+	bucket.intercept(func(w http.ResponseWriter, r *http.Request, key string) bool {
+		<-release
+		return false
+	})
+	s := newBucketServer(t, bucket, &config.Config{FillWaitTimeout: 50 * time.Millisecond})
+
+	// leader issues a GET in the background and returns once it has reached
+	// the origin.
+	leader := func() <-chan *httptest.ResponseRecorder {
+		done := make(chan *httptest.ResponseRecorder, 1)
+		before := bucket.requests("slow.bin")
+		go func() { done <- getObject(s, "/slow.bin", nil) }()
+		deadline := time.Now().Add(5 * time.Second)
+		for bucket.requests("slow.bin") == before {
+			if time.Now().After(deadline) {
+				t.Fatalf("leader did not reach the origin")
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		return done
+	}
+	waiters := func() []*httptest.ResponseRecorder {
+		responses := make([]*httptest.ResponseRecorder, 3)
+		var wg sync.WaitGroup
+		for i := range responses {
+			wg.Go(func() { responses[i] = getObject(s, "/slow.bin", nil) })
+		}
+		wg.Wait()
+		return responses
+	}
+
+	// Without a cached copy, waiters give up after FillWaitTimeout while
+	// the leader's fill carries on.
+	done := leader()
+	for _, w := range waiters() {
+		if w.Code != http.StatusGatewayTimeout {
+			t.Fatalf("expected 504 for a waiter, got %d", w.Code)
+		}
+	}
+	if got := testutil.ToFloat64(s.metrics.fillWaitTimeouts); got != 3 {
+		t.Fatalf("expected 3 fill wait timeouts, got %v", got)
+	}
+	release <- struct{}{}
+	if w := <-done; w.Code != http.StatusOK || w.Body.String() != "fresh" || w.Header().Get("X-Cache") != "MISS" {
+		t.Fatalf("unexpected leader response %d %q %q", w.Code, w.Body.String(), w.Header().Get("X-Cache"))
+	}
+	if n := bucket.requests("slow.bin"); n != 1 {
+		t.Fatalf("expected one origin request, got %d", n)
+	}
+
+	// With an expired copy, waiters are answered from it straight away.
+	s.cache.Set(cacheKey("slow.bin"), &cache.Entry{
+		Body:     []byte("old"),
+		Header:   http.Header{},
+		Status:   http.StatusOK,
+		StoredAt: time.Now().Add(-time.Hour),
+		TTL:      time.Minute,
+		Size:     3,
+	})
+	done = leader()
+	for _, w := range waiters() {
+		if w.Code != http.StatusOK || w.Body.String() != "old" || w.Header().Get("X-Cache") != "STALE" {
+			t.Fatalf("expected the stale copy, got %d %q %q", w.Code, w.Body.String(), w.Header().Get("X-Cache"))
+		}
+	}
+	close(release)
+	if w := <-done; w.Body.String() != "fresh" {
+		t.Fatalf("unexpected leader response %q", w.Body.String())
+	}
+	if n := bucket.requests("slow.bin"); n != 2 {
+		t.Fatalf("expected the waiters not to reach the origin, got %d requests", n)
+	}
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"io"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/joeychilson/s3-proxy/internal/cache"
+	"github.com/joeychilson/s3-proxy/internal/peer"
+)
+
+const handoffPath = "/_peer/handoff"
+
+type handoffEntry struct {
+	Key   string
+	Entry *cache.Entry
+}
+
+// handoff pushes the most recently used entries to the peers that take over
+// this node's keys, so that a rolling restart does not send their traffic to
+// the origin. Only the memory backend is handed off; Redis outlives the node.
+func (s *Server) handoff() {
+	memory, ok := s.cache.(*cache.Cache)
+	if s.peers == nil || !ok || s.cfg.HandoffEntries <= 0 {
+		return
+	}
+	keys := memory.Keys()
+	keys = keys[max(0, len(keys)-s.cfg.HandoffEntries):]
+	ctx, cancel := context.WithTimeout(context.Background(), s.cfg.HandoffTimeout)
+	defer cancel()
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for addr, keys := range s.handoffTargets(keys) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sent, err := s.sendHandoff(ctx, addr, keys)
+			if err != nil {
+				s.logger.Warn("cache handoff failed", "error", err, "peer", addr, "sent", sent)
+				return
+			}
+			s.logger.Info("cache handoff sent", "peer", addr, "sent", sent, "duration", time.Since(start).String())
+		}()
+	}
+	wg.Wait()
+}
+
+// handoffTargets assigns keys to HANDOFF_PEER, or otherwise to the peer that
+// owns each object once this node has left the ring.
+func (s *Server) handoffTargets(keys []string) map[string][]string {
+	if s.cfg.HandoffPeer != "" {
+		return map[string][]string{s.cfg.HandoffPeer: keys}
+	}
+	others := slices.DeleteFunc(s.peers.Members(), func(addr string) bool { return addr == s.peers.Self() })
+	if len(others) == 0 {
+		return nil
+	}
+	ring := peer.NewRing(others)
+	targets := make(map[string][]string)
+	for _, key := range keys {
+		owner := ring.Owner(objectKey(key))
+		targets[owner] = append(targets[owner], key)
+	}
+	return targets
+}
+
+// sendHandoff streams the entries for keys to addr and returns how many were
+// written.
+func (s *Server) sendHandoff(ctx context.Context, addr string, keys []string) (int, error) {
+	pr, pw := io.Pipe()
+	sent := make(chan int, 1)
+	go func() {
+		n := 0
+		defer func() { sent <- n }()
+		enc := gob.NewEncoder(pw)
+		for _, key := range keys {
+			entry, ok := s.cache.Get(key)
+			if !ok {
+				continue
+			}
+			if err := enc.Encode(handoffEntry{Key: key, Entry: entry}); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			n++
+		}
+		pw.Close()
+	}()
+	err := s.peers.Post(ctx, addr, handoffPath, "application/octet-stream", pr)
+	pr.CloseWithError(err)
+	return <-sent, err
+}
+
+// peerHandoffHandler stores the entries handed off by a replica that is
+// shutting down. Entries already cached here, or no longer servable, are
+// skipped.
+func (s *Server) peerHandoffHandler(w http.ResponseWriter, r *http.Request) {
+	http.NewResponseController(w).SetReadDeadline(time.Now().Add(s.cfg.HandoffTimeout))
+	dec := gob.NewDecoder(r.Body)
+	now := time.Now()
+	received := 0
+	for {
+		var he handoffEntry
+		if err := dec.Decode(&he); err != nil {
+			if !errors.Is(err, io.EOF) {
+				s.logger.Warn("cache handoff interrupted", "error", err, "received", received)
+			}
+			break
+		}
+		if he.Entry == nil || !he.Entry.StaleButValid(now) {
+			continue
+		}
+		if _, ok := s.cache.Get(he.Key); ok {
+			continue
+		}
+		s.cache.Set(he.Key, he.Entry)
+		s.events.emit(eventFill, he.Key, he.Entry.Size, "handoff")
+		received++
+	}
+	s.logger.Info("cache handoff received", "received", received)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/joeychilson/s3-proxy/internal/cache"
+	"github.com/joeychilson/s3-proxy/internal/config"
+	"github.com/joeychilson/s3-proxy/internal/peer"
+)
+
+func TestCacheHandoff(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	newStore := func() *cache.Cache {
+		store, err := cache.New(16, 1<<20, time.Minute, time.Minute)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return store
+	}
+	events, _ := newEventLog("")
+	receiver := &Server{cfg: &config.Config{HandoffTimeout: time.Second}, cache: newStore(), events: events, logger: logger}
+	srv := httptest.NewServer(http.HandlerFunc(receiver.peerHandoffHandler))
+	defer srv.Close()
+
+	sender := &Server{
+		cfg:    &config.Config{HandoffEntries: 2, HandoffPeer: srv.URL, HandoffTimeout: time.Second},
+		cache:  newStore(),
+		peers:  peer.NewCluster("http://self:8080", nil, "token", time.Second, logger),
+		logger: logger,
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		sender.cache.Set(cacheKey(key), &cache.Entry{Body: []byte(key), Status: http.StatusOK, StoredAt: time.Now(), TTL: time.Minute, Size: 1})
+	}
+	sender.handoff()
+
+	if _, ok := receiver.cache.Get(cacheKey("a")); ok {
+		t.Fatalf("expected only the most recently used entries to be handed off")
+	}
+	for _, key := range []string{"b", "c"} {
+		entry, ok := receiver.cache.Get(cacheKey(key))
+		if !ok || string(entry.Body) != key {
+			t.Fatalf("expected %q to be handed off", key)
+		}
+	}
+}
@@ -1,8 +1,24 @@
 package server
 
 import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
 	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/joeychilson/s3-proxy/internal/cache"
+	"github.com/joeychilson/s3-proxy/internal/config"
+	"github.com/joeychilson/s3-proxy/internal/origin"
 )
 
 func TestShouldUseCache(t *testing.T) {
@@ -53,3 +69,223 @@ func TestCloneHeader(t *testing.T) {
 		t.Fatalf("expected deep copy to leave original intact")
 	}
 }
+
+// fakeBucket is an in-memory S3 bucket named "bucket". It answers the
+// GetObject, HeadObject, PutObject, DeleteObject and ListObjectsV2 requests
+// of a path-style origin client and counts the GETs for each key.
+type fakeBucket struct {
+	*httptest.Server
+	mu      sync.Mutex
+	objects map[string]*fakeObject
+	gets    map[string]int
+	before  func(w http.ResponseWriter, r *http.Request, key string) bool
+}
+
+type fakeObject struct {
+	body   string
+	etag   string
+	header http.Header
+	// status, when set, fails every request for the object with an S3 error.
+	status int
+	// ignoreRange sends the whole object in answer to ranged requests.
+	ignoreRange bool
+}
+
+func newFakeBucket(t *testing.T) *fakeBucket {
+	t.Helper()
+	// Failures are answered once; the SDK would otherwise retry 5xx errors
+	// with backoff.
+	t.Setenv("AWS_MAX_ATTEMPTS", "1")
+	b := &fakeBucket{objects: make(map[string]*fakeObject), gets: make(map[string]int)}
+	b.Server = httptest.NewServer(http.HandlerFunc(b.serve))
+	t.Cleanup(b.Close)
+	return b
+}
+
+func (b *fakeBucket) put(key, body string) *fakeObject {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	obj := &fakeObject{body: body, etag: fmt.Sprintf(`"%s-%d"`, key, len(body)), header: http.Header{}}
+	b.objects[key] = obj
+	return obj
+}
+
+// fail makes requests for key fail with status, or succeed again with 0.
+func (b *fakeBucket) fail(key string, status int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.objects[key].status = status
+}
+
+// intercept has fn see every object request first; it reports whether it
+// answered the request. A nil fn removes it.
+func (b *fakeBucket) intercept(fn func(w http.ResponseWriter, r *http.Request, key string) bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.before = fn
+}
+
+func (b *fakeBucket) requests(key string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.gets[key]
+}
+
+func (b *fakeBucket) serve(w http.ResponseWriter, r *http.Request) {
+	key, ok := strings.CutPrefix(r.URL.Path, "/bucket/")
+	if !ok || key == "" {
+		b.list(w, r.URL.Query().Get("prefix"))
+		return
+	}
+	b.mu.Lock()
+	if r.Method == http.MethodGet {
+		b.gets[key]++
+	}
+	before := b.before
+	b.mu.Unlock()
+	if before != nil && before(w, r, key) {
+		return
+	}
+	switch r.Method {
+	case http.MethodPut:
+		body, _ := io.ReadAll(r.Body)
+		obj := b.put(key, string(body))
+		obj.header.Set("Content-Type", r.Header.Get("Content-Type"))
+		w.Header().Set("ETag", obj.etag)
+		return
+	case http.MethodDelete:
+		b.mu.Lock()
+		delete(b.objects, key)
+		b.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	b.mu.Lock()
+	obj, found := b.objects[key]
+	var o fakeObject
+	if found {
+		o = *obj
+		o.header = obj.header.Clone()
+	}
+	b.mu.Unlock()
+	switch {
+	case !found:
+		s3Error(w, http.StatusNotFound)
+		return
+	case o.status != 0:
+		s3Error(w, o.status)
+		return
+	}
+
+	for name, values := range o.header {
+		w.Header()[name] = values
+	}
+	w.Header().Set("ETag", o.etag)
+	w.Header().Set("Last-Modified", time.Unix(0, 0).UTC().Format(http.TimeFormat))
+	w.Header().Set("Accept-Ranges", "bytes")
+	body, status := o.body, http.StatusOK
+	if start, end, ok := parseByteRange(r.Header.Get("Range"), int64(len(o.body))); ok && !o.ignoreRange {
+		body, status = o.body[start:end+1], http.StatusPartialContent
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(o.body)))
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(status)
+	if r.Method == http.MethodGet {
+		io.WriteString(w, body)
+	}
+}
+
+func (b *fakeBucket) list(w http.ResponseWriter, prefix string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var keys []string
+	for key := range b.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	w.Header().Set("Content-Type", "application/xml")
+	fmt.Fprintf(w, "<ListBucketResult><Name>bucket</Name><KeyCount>%d</KeyCount><IsTruncated>false</IsTruncated>", len(keys))
+	for _, key := range keys {
+		obj := b.objects[key]
+		fmt.Fprintf(w, "<Contents><Key>%s</Key><Size>%d</Size><ETag>%s</ETag><LastModified>1970-01-01T00:00:00.000Z</LastModified></Contents>",
+			key, len(obj.body), obj.etag)
+	}
+	io.WriteString(w, "</ListBucketResult>")
+}
+
+var s3ErrorCodes = map[int]string{
+	http.StatusForbidden:          "AccessDenied",
+	http.StatusNotFound:           "NoSuchKey",
+	http.StatusPreconditionFailed: "PreconditionFailed",
+}
+
+func s3Error(w http.ResponseWriter, status int) {
+	code, ok := s3ErrorCodes[status]
+	if !ok {
+		code = "InternalError"
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, http.StatusText(status))
+}
+
+// newBucketServer returns a server reading from bucket through an in-memory
+// cache. Settings cfg leaves unset that a zero value would break get the
+// defaults the proxy starts with.
+func newBucketServer(t *testing.T, bucket *fakeBucket, cfg *config.Config) *Server {
+	t.Helper()
+	client, err := origin.New(context.Background(), bucket.URL, "us-east-1", "key", "secret", "bucket", 5*time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.MaxObjectSize == 0 {
+		cfg.MaxObjectSize = 1 << 20
+	}
+	if cfg.CacheTTL == 0 {
+		cfg.CacheTTL = time.Minute
+	}
+	if cfg.FillWaitTimeout == 0 {
+		cfg.FillWaitTimeout = 5 * time.Second
+	}
+	if cfg.RequestTimeout == 0 {
+		cfg.RequestTimeout = 5 * time.Second
+	}
+	store, err := cache.New(256, 4<<20, cfg.CacheTTL, cfg.CacheStaleTTL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	events, _ := newEventLog("")
+	s := &Server{
+		cfg:      cfg,
+		origin:   origin.NewRouter(client, nil),
+		cache:    store,
+		metrics:  newMetrics(prometheus.NewRegistry()),
+		logger:   slog.New(slog.DiscardHandler),
+		events:   events,
+		activity: newActivity(),
+		prefetch: newPrefetchJobs(),
+		reval:    newRevalidations(time.Second, time.Minute),
+		flights:  newFlightGroup(),
+		layers:   []string{layerMemory},
+	}
+	if policy := newAccessPolicy(cfg.AccessPolicy); !policy.public() {
+		s.access = policy
+	}
+	if err := s.applyRules(cfg.Rules()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return s
+}
+
+// getObject sends a GET for path to the object handler of s.
+func getObject(s *Server, path string, header http.Header) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, path, nil)
+	for name, values := range header {
+		r.Header[name] = values
+	}
+	w := httptest.NewRecorder()
+	s.objectHandler(w, r)
+	return w
+}
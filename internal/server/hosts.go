@@ -0,0 +1,35 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// hostKey moves key into the namespace of the request's virtual host, so that
+// each host has its own bucket and cache entries. Keys inside a host
+// namespace are only reachable through that host; peers forward keys that
+// are already namespaced.
+func (s *Server) hostKey(r *http.Request, key string) (string, bool) {
+	if len(s.hosts) == 0 || isPeerRequest(r.Context()) {
+		return key, true
+	}
+	if host := requestHost(r); s.hosts[host] {
+		return hostPrefix(host) + key, true
+	}
+	first, _, _ := strings.Cut(key, "/")
+	return key, !s.hosts[strings.ToLower(first)]
+}
+
+// hostPrefix is the key prefix of a virtual host's namespace.
+func hostPrefix(host string) string {
+	return host + "/"
+}
+
+func requestHost(r *http.Request) string {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
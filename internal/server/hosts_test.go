@@ -0,0 +1,24 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestHostKey(t *testing.T) {
+	s := &Server{hosts: map[string]bool{"cdn.example.com": true}}
+	req := func(host string) *http.Request {
+		r, _ := http.NewRequest(http.MethodGet, "/", nil)
+		r.Host = host
+		return r
+	}
+	if key, ok := s.hostKey(req("CDN.example.com:443"), "logo.png"); !ok || key != "cdn.example.com/logo.png" {
+		t.Fatalf("expected namespaced key, got %q %v", key, ok)
+	}
+	if key, ok := s.hostKey(req("other.example.com"), "logo.png"); !ok || key != "logo.png" {
+		t.Fatalf("expected unmapped host to keep its key, got %q %v", key, ok)
+	}
+	if _, ok := s.hostKey(req("other.example.com"), "cdn.example.com/logo.png"); ok {
+		t.Fatalf("expected a host namespace to be unreachable from other hosts")
+	}
+}
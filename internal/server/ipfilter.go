@@ -0,0 +1,107 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/joeychilson/s3-proxy/internal/config"
+)
+
+// ipFilter admits clients by address. DENY_CIDRS wins over ALLOW_CIDRS, and
+// an empty allow list admits everyone not denied.
+type ipFilter struct {
+	allow   []netip.Prefix
+	deny    []netip.Prefix
+	trusted []netip.Prefix
+}
+
+func newIPFilter(cfg *config.Config) *ipFilter {
+	if len(cfg.AllowCIDRs) == 0 && len(cfg.DenyCIDRs) == 0 {
+		return nil
+	}
+	return &ipFilter{allow: cfg.AllowCIDRs, deny: cfg.DenyCIDRs, trusted: cfg.TrustedProxies}
+}
+
+// clientAddr returns the address of the client. Forwarding headers are only
+// believed when the connection comes from one of the trusted proxies, and
+// X-Forwarded-For is read from the right, skipping trusted proxies, so a
+// client cannot pick its own address by sending the header itself.
+func clientAddr(r *http.Request, trusted []netip.Prefix) (netip.Addr, bool) {
+	host := r.RemoteAddr
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	addr = addr.Unmap()
+	if !containsAddr(trusted, addr) {
+		return addr, true
+	}
+	var hops []string
+	for _, value := range r.Header.Values("X-Forwarded-For") {
+		for part := range strings.SplitSeq(value, ",") {
+			hops = append(hops, strings.TrimSpace(part))
+		}
+	}
+	if len(hops) == 0 {
+		if xr := r.Header.Get("X-Real-IP"); xr != "" {
+			hops = []string{strings.TrimSpace(xr)}
+		}
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(hops[i])
+		if err != nil {
+			return netip.Addr{}, false
+		}
+		addr = hop.Unmap()
+		if !containsAddr(trusted, addr) {
+			break
+		}
+	}
+	return addr, true
+}
+
+// check returns "" when addr is admitted, or the list that refused it:
+// "deny" or "allow".
+func (f *ipFilter) check(addr netip.Addr) string {
+	if containsAddr(f.deny, addr) {
+		return "deny"
+	}
+	if len(f.allow) > 0 && !containsAddr(f.allow, addr) {
+		return "allow"
+	}
+	return ""
+}
+
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ipFilterMiddleware refuses clients outside ALLOW_CIDRS or inside
+// DENY_CIDRS. It runs ahead of realIPMiddleware so that a client whose
+// forwarded address does not parse is refused rather than let in under the
+// address of its proxy.
+func (s *Server) ipFilterMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		addr, ok := clientAddr(r, s.ipFilter.trusted)
+		list := "allow"
+		if ok {
+			list = s.ipFilter.check(addr)
+		}
+		if list != "" {
+			s.metrics.ipRejected.WithLabelValues(list).Inc()
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/joeychilson/s3-proxy/internal/config"
+)
+
+func TestIPFilter(t *testing.T) {
+	s := &Server{
+		ipFilter: newIPFilter(&config.Config{
+			AllowCIDRs:     []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+			DenyCIDRs:      []netip.Prefix{netip.MustParsePrefix("10.9.0.0/16")},
+			TrustedProxies: []netip.Prefix{netip.MustParsePrefix("192.168.1.0/24")},
+		}),
+		metrics: newMetrics(prometheus.NewRegistry()),
+	}
+	handler := s.ipFilterMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	tests := []struct {
+		remote string
+		xff    string
+		status int
+	}{
+		{"10.1.2.3:4000", "", http.StatusOK},
+		{"10.9.2.3:4000", "", http.StatusForbidden},
+		{"203.0.113.7:4000", "", http.StatusForbidden},
+		{"[::ffff:10.1.2.3]:4000", "", http.StatusOK},
+		// Forwarding headers from untrusted peers are ignored.
+		{"203.0.113.7:4000", "10.1.2.3", http.StatusForbidden},
+		// Behind a trusted proxy the rightmost untrusted hop is the client,
+		// whatever the client prepended.
+		{"192.168.1.5:4000", "10.1.2.3, 203.0.113.7", http.StatusForbidden},
+		{"192.168.1.5:4000", "203.0.113.7, 10.1.2.3, 192.168.1.6", http.StatusOK},
+		{"192.168.1.5:4000", "not-an-ip", http.StatusForbidden},
+		{"192.168.1.5:4000", "", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/file.txt", nil)
+		req.RemoteAddr = tt.remote
+		if tt.xff != "" {
+			req.Header.Set("X-Forwarded-For", tt.xff)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tt.status {
+			t.Errorf("%s via %q: expected status %d, got %d", tt.remote, tt.xff, tt.status, rec.Code)
+		}
+	}
+	if newIPFilter(&config.Config{TrustedProxies: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}}) != nil {
+		t.Fatalf("expected no filter without ALLOW_CIDRS or DENY_CIDRS")
+	}
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/joeychilson/s3-proxy/internal/jwt"
+)
+
+// checkJWT returns the status to refuse the request with and why: "missing",
+// "invalid", "expired" or "path". The reason is "" for a valid token.
+func (s *Server) checkJWT(r *http.Request, key string) (int, string) {
+	token := bearerToken(r)
+	if token == "" && s.cfg.JWTCookie != "" {
+		if c, err := r.Cookie(s.cfg.JWTCookie); err == nil {
+			token = c.Value
+		}
+	}
+	if token == "" {
+		return http.StatusUnauthorized, "missing"
+	}
+	claims, err := s.verifier.Verify(token, time.Now())
+	if errors.Is(err, jwt.ErrExpired) {
+		return http.StatusUnauthorized, "expired"
+	}
+	if err != nil {
+		return http.StatusUnauthorized, "invalid"
+	}
+	if s.cfg.JWTPathClaim == "" {
+		return http.StatusOK, ""
+	}
+	for _, prefix := range claims.Strings(s.cfg.JWTPathClaim) {
+		if strings.HasPrefix(key, strings.TrimLeft(prefix, "/")) {
+			return http.StatusOK, ""
+		}
+	}
+	return http.StatusForbidden, "path"
+}
+
+// bearerToken returns the token of a bearer Authorization header, keeping
+// its case.
+func bearerToken(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return ""
+}
+
+// refreshJWKS keeps the JWKS current until ctx is done.
+func (s *Server) refreshJWKS(ctx context.Context) {
+	if err := s.jwks.Refresh(ctx); err != nil {
+		s.logger.Warn("jwks refresh", "error", err, "url", s.cfg.JWTJWKSURL)
+	}
+	s.jwks.Run(ctx, s.cfg.JWTJWKSRefresh, func(err error) {
+		s.logger.Warn("jwks refresh", "error", err, "url", s.cfg.JWTJWKSURL)
+	})
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/joeychilson/s3-proxy/internal/config"
+	"github.com/joeychilson/s3-proxy/internal/jwt"
+)
+
+func TestJWTMiddleware(t *testing.T) {
+	s := &Server{
+		cfg:      &config.Config{JWTCookie: "session", JWTPathClaim: "paths"},
+		access:   newAccessPolicy(config.AccessPolicy{Prefixes: map[string]string{"users/": config.AccessJWT}}),
+		authTok:  "admin",
+		verifier: jwt.NewVerifier([]byte("secret"), nil),
+		metrics:  newMetrics(prometheus.NewRegistry()),
+		logger:   slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	sign := func(claims string) string {
+		signed := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256"}`)) + "." + base64.RawURLEncoding.EncodeToString([]byte(claims))
+		mac := hmac.New(sha256.New, []byte("secret"))
+		mac.Write([]byte(signed))
+		return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+	}
+	exp := time.Now().Add(time.Minute).Unix()
+	ada := sign(fmt.Sprintf(`{"sub":"ada","paths":["/users/ada/"],"exp":%d}`, exp))
+	expired := sign(fmt.Sprintf(`{"paths":"users/","exp":%d}`, time.Now().Add(-time.Hour).Unix()))
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.checkAccess(w, r, strings.TrimPrefix(r.URL.Path, "/"))
+	})
+	tests := []struct {
+		path   string
+		bearer string
+		cookie string
+		status int
+	}{
+		{"/users/ada/notes.txt", ada, "", http.StatusOK},
+		{"/users/ada/notes.txt", "", ada, http.StatusOK},
+		{"/users/bob/notes.txt", ada, "", http.StatusForbidden},
+		{"/users/ada/notes.txt", "", "", http.StatusUnauthorized},
+		{"/users/ada/notes.txt", expired, "", http.StatusUnauthorized},
+		{"/users/ada/notes.txt", ada + "x", "", http.StatusUnauthorized},
+		{"/users/bob/notes.txt", "admin", "", http.StatusOK},
+		{"/public/logo.png", "", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		if tt.bearer != "" {
+			req.Header.Set("Authorization", "Bearer "+tt.bearer)
+		}
+		if tt.cookie != "" {
+			req.AddCookie(&http.Cookie{Name: "session", Value: tt.cookie})
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tt.status {
+			t.Errorf("%s (bearer %t, cookie %t): expected status %d, got %d", tt.path, tt.bearer != "", tt.cookie != "", tt.status, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+)
+
+const (
+	layerMemory = "MEM"
+	layerDisk   = "DISK"
+	layerPeer   = "PEER"
+	layerOrigin = "ORIGIN"
+)
+
+// setCacheStatus reports how a response was produced. With a single cache
+// tier X-Cache carries the bare state (HIT, STALE, MISS, ...); once further
+// tiers are configured it is qualified with the layer that answered, e.g.
+// MEM-HIT or PEER-HIT.
+func (s *Server) setCacheStatus(w http.ResponseWriter, layer, state string) {
+	value := state
+	if len(s.layers) > 1 && layer != layerOrigin {
+		value = layer + "-" + state
+	}
+	w.Header().Set("X-Cache", value)
+	s.metrics.cacheResponses.WithLabelValues(strings.ToLower(layer), strings.ToLower(state)).Inc()
+}
+
+func stateLayer(state string) string {
+	if state == "MISS" || state == "BYPASS" {
+		return layerOrigin
+	}
+	return layerMemory
+}
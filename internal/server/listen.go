@@ -0,0 +1,102 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+)
+
+// defaultResponseBuffer is the copy buffer size when RESPONSE_BUFFER_SIZE is
+// unset, the same as io.Copy's.
+const defaultResponseBuffer = 32 * 1024
+
+// listen opens SERVER_LISTENERS listeners on the server address. More than
+// one share the port through SO_REUSEPORT, so that the kernel spreads new
+// connections across several accept loops.
+func (s *Server) listen(ctx context.Context) ([]net.Listener, error) {
+	lc := net.ListenConfig{}
+	if s.cfg.Listeners > 1 {
+		lc.Control = reusePort
+	}
+	addr := s.cfg.Addr
+	if addr == "" {
+		addr = ":http"
+	}
+	listeners := make([]net.Listener, 0, s.cfg.Listeners)
+	for range s.cfg.Listeners {
+		ln, err := lc.Listen(ctx, "tcp", addr)
+		if err != nil {
+			for _, open := range listeners {
+				open.Close()
+			}
+			return nil, err
+		}
+		// The rest bind the port the first was given, should it be 0.
+		addr = ln.Addr().String()
+		listeners = append(listeners, &tunedListener{Listener: ln, noDelay: s.cfg.TCPNoDelay, writeBuffer: s.cfg.TCPWriteBuffer})
+	}
+	return listeners, nil
+}
+
+// serve runs the HTTP server on every listener until one of them stops,
+// returning its error.
+func (s *Server) serve(listeners []net.Listener) error {
+	errs := make(chan error, len(listeners))
+	for _, ln := range listeners {
+		go func() {
+			if s.certs != nil {
+				errs <- s.httpSrv.ServeTLS(ln, "", "")
+			} else {
+				errs <- s.httpSrv.Serve(ln)
+			}
+		}()
+	}
+	err := <-errs
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		s.httpSrv.Close()
+	}
+	return err
+}
+
+// tunedListener applies TCP_NODELAY and TCP_WRITE_BUFFER to accepted
+// connections.
+type tunedListener struct {
+	net.Listener
+	noDelay     bool
+	writeBuffer int
+}
+
+func (l *tunedListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		// Go enables TCP_NODELAY on every connection by default.
+		if !l.noDelay {
+			tcp.SetNoDelay(false)
+		}
+		if l.writeBuffer > 0 {
+			tcp.SetWriteBuffer(l.writeBuffer)
+		}
+	}
+	return conn, nil
+}
+
+// responseBuffer returns a buffer of RESPONSE_BUFFER_SIZE bytes for
+// streaming a body to a client.
+func (s *Server) responseBuffer() []byte {
+	if s.cfg.ResponseBufferSize <= 0 {
+		return make([]byte, defaultResponseBuffer)
+	}
+	return make([]byte, s.cfg.ResponseBufferSize)
+}
+
+// copyBody streams src to a client through a RESPONSE_BUFFER_SIZE buffer.
+// dst is wrapped so that the ResponseWriter's ReadFrom, which would pick a
+// buffer of its own, is not used.
+func (s *Server) copyBody(dst io.Writer, src io.Reader) (int64, error) {
+	return io.CopyBuffer(struct{ io.Writer }{dst}, src, s.responseBuffer())
+}
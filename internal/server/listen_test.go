@@ -0,0 +1,102 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/joeychilson/s3-proxy/internal/config"
+)
+
+func TestListeners(t *testing.T) {
+	s := &Server{cfg: &config.Config{Addr: "127.0.0.1:0", Listeners: 2, TCPWriteBuffer: 1 << 16}}
+	listeners, err := s.listen(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() {
+		for _, ln := range listeners {
+			ln.Close()
+		}
+	}()
+	if len(listeners) != 2 || listeners[0].Addr().String() != listeners[1].Addr().String() {
+		t.Fatalf("expected two listeners sharing a port, got %v", listeners)
+	}
+
+	conn, err := net.Dial("tcp", listeners[0].Addr().String())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	conn.Close()
+	accepted := make(chan error, len(listeners))
+	for _, ln := range listeners {
+		go func() {
+			conn, err := ln.Accept()
+			if err == nil {
+				conn.Close()
+			}
+			accepted <- err
+		}()
+	}
+	if err := <-accepted; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestH2C(t *testing.T) {
+	for _, h2c := range []bool{true, false} {
+		cfg := &config.Config{Addr: "127.0.0.1:0", Listeners: 1, H2C: h2c}
+		s := &Server{cfg: cfg, httpSrv: &http.Server{
+			Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				io.WriteString(w, r.Proto)
+			}),
+			Protocols: serverProtocols(cfg),
+		}}
+		listeners, err := s.listen(context.Background())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		done := make(chan error, 1)
+		go func() { done <- s.serve(listeners) }()
+		url := "http://" + listeners[0].Addr().String() + "/"
+
+		// A client with prior knowledge speaks HTTP/2 from the first byte.
+		protocols := new(http.Protocols)
+		protocols.SetUnencryptedHTTP2(true)
+		client := &http.Client{Transport: &http.Transport{Protocols: protocols}}
+		resp, err := client.Get(url)
+		if h2c {
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.ProtoMajor != 2 || string(body) != "HTTP/2.0" {
+				t.Fatalf("expected an HTTP/2 exchange, got %s serving %q", resp.Proto, body)
+			}
+		} else if err == nil {
+			resp.Body.Close()
+			t.Fatalf("expected cleartext HTTP/2 to be refused without H2C, got %s", resp.Proto)
+		}
+		client.CloseIdleConnections()
+
+		// HTTP/1.1 clients are served either way.
+		resp, err = http.Get(url)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != "HTTP/1.1" {
+			t.Fatalf("expected HTTP/1.1, got %q", body)
+		}
+
+		s.httpSrv.Close()
+		if err := <-done; !errors.Is(err, http.ErrServerClosed) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/joeychilson/s3-proxy/internal/config"
+)
+
+const maxLockoutClients = 10000
+
+type lockoutState struct {
+	failures    int
+	windowStart time.Time
+	strikes     int
+	until       time.Time
+	lastFailure time.Time
+}
+
+// lockouts bans clients that keep presenting wrong admin tokens. Each ban
+// lasts twice as long as the one before, up to max; a client's record is
+// forgotten once it has gone max without failing.
+type lockouts struct {
+	threshold int
+	window    time.Duration
+	base      time.Duration
+	max       time.Duration
+
+	mu      sync.Mutex
+	clients map[string]*lockoutState
+}
+
+func newLockouts(threshold int, window, base, max time.Duration) *lockouts {
+	return &lockouts{threshold: threshold, window: window, base: base, max: max, clients: make(map[string]*lockoutState)}
+}
+
+// banned returns how long client remains banned, or 0.
+func (l *lockouts) banned(client string, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if st, ok := l.clients[client]; ok && now.Before(st.until) {
+		return st.until.Sub(now)
+	}
+	return 0
+}
+
+// fail records a failed attempt and returns the length of the ban it
+// triggers, or 0.
+func (l *lockouts) fail(client string, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	st, ok := l.clients[client]
+	if !ok {
+		if len(l.clients) >= maxLockoutClients {
+			l.prune(now)
+		}
+		st = &lockoutState{}
+		l.clients[client] = st
+	} else if now.Sub(st.lastFailure) > l.max {
+		*st = lockoutState{}
+	}
+	st.lastFailure = now
+	if now.Sub(st.windowStart) > l.window {
+		st.failures, st.windowStart = 0, now
+	}
+	st.failures++
+	if st.failures < l.threshold {
+		return 0
+	}
+	st.failures, st.windowStart = 0, now
+	st.strikes++
+	ban := l.base << min(st.strikes-1, 30)
+	if ban <= 0 || ban > l.max {
+		ban = l.max
+	}
+	st.until = now.Add(ban)
+	return ban
+}
+
+// unban lifts client's ban and forgets its failures. It reports whether the
+// client had a record.
+func (l *lockouts) unban(client string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, ok := l.clients[client]
+	delete(l.clients, client)
+	return ok
+}
+
+func (l *lockouts) known(client string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, ok := l.clients[client]
+	return ok
+}
+
+func (l *lockouts) active(now time.Time) []lockoutEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var out []lockoutEntry
+	for client, st := range l.clients {
+		if now.Before(st.until) {
+			out = append(out, lockoutEntry{Client: client, Until: st.until.UTC(), Strikes: st.strikes})
+		}
+	}
+	slices.SortFunc(out, func(a, b lockoutEntry) int { return a.Until.Compare(b.Until) })
+	return out
+}
+
+func (l *lockouts) prune(now time.Time) {
+	for client, st := range l.clients {
+		if now.After(st.until) && now.Sub(st.lastFailure) > l.max {
+			delete(l.clients, client)
+		}
+	}
+}
+
+// lockedOut answers a request from a banned client with 429 and reports
+// whether it did. Banned clients are refused even with the right token, so
+// that guessing cannot continue through the ban.
+func (s *Server) lockedOut(w http.ResponseWriter, r *http.Request) bool {
+	if s.lockouts == nil {
+		return false
+	}
+	remaining := s.lockouts.banned(clientHost(r), time.Now())
+	if remaining == 0 {
+		return false
+	}
+	s.metrics.lockoutRejections.Inc()
+	w.Header().Set("Retry-After", strconv.Itoa(int(remaining.Round(time.Second).Seconds())))
+	http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+	return true
+}
+
+// adminTokenAttempt reports whether a refused object request presented an
+// admin token. Under signed-url and jwt modes a bearer token is the caller's
+// JWT, so only X-Auth-Token and the token parameter count there.
+func adminTokenAttempt(r *http.Request, mode string) bool {
+	if mode == config.AccessToken {
+		return requestToken(r) != ""
+	}
+	return r.Header.Get("X-Auth-Token") != "" || r.URL.Query().Get("token") != ""
+}
+
+// tokenFailed counts a wrong admin token against the client, banning it once
+// it reaches AUTH_LOCKOUT_THRESHOLD.
+func (s *Server) tokenFailed(r *http.Request) {
+	if s.lockouts == nil {
+		return
+	}
+	if ban := s.lockouts.fail(clientHost(r), time.Now()); ban > 0 {
+		s.metrics.lockouts.Inc()
+		s.securityEvent(r, securityLockout, ban.String())
+	}
+}
+
+type lockoutEntry struct {
+	Client  string    `json:"client"`
+	Until   time.Time `json:"until"`
+	Strikes int       `json:"strikes"`
+}
+
+type unbanRequest struct {
+	Client string `json:"client"`
+}
+
+func (s *Server) lockoutsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"lockouts": s.lockouts.active(time.Now())})
+}
+
+// unbanHandler lifts the ban on a client address before it runs out.
+func (s *Server) unbanHandler(w http.ResponseWriter, r *http.Request) {
+	var payload unbanRequest
+	if !decodeJSON(w, r, &payload) {
+		return
+	}
+	if payload.Client == "" {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	if s.cfg.AdminDryRun {
+		if !s.lockouts.known(payload.Client) {
+			http.NotFound(w, r)
+			return
+		}
+		s.dryRun(w, r, "unban", "client", payload.Client)
+		return
+	}
+	if !s.lockouts.unban(payload.Client) {
+		http.NotFound(w, r)
+		return
+	}
+	s.logger.Info("client unbanned", "client", payload.Client)
+	w.WriteHeader(http.StatusNoContent)
+}
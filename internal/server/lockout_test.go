@@ -0,0 +1,119 @@
+package server
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/joeychilson/s3-proxy/internal/config"
+)
+
+func TestLockouts(t *testing.T) {
+	l := newLockouts(3, time.Minute, time.Minute, 5*time.Minute)
+	now := time.Now()
+	for i := range 2 {
+		if ban := l.fail("203.0.113.7", now.Add(time.Duration(i)*time.Second)); ban != 0 {
+			t.Fatalf("expected no ban before the threshold, got %s", ban)
+		}
+	}
+	if ban := l.fail("203.0.113.7", now.Add(2*time.Second)); ban != time.Minute {
+		t.Fatalf("expected a one minute ban, got %s", ban)
+	}
+	if l.banned("203.0.113.7", now.Add(30*time.Second)) == 0 || l.banned("203.0.113.8", now) != 0 {
+		t.Fatalf("expected only the failing client to be banned")
+	}
+	// Failures spread wider than the window never add up to a ban.
+	for i := range 3 {
+		if ban := l.fail("198.51.100.1", now.Add(time.Duration(i)*2*time.Minute)); ban != 0 {
+			t.Fatalf("expected failures outside the window not to ban, got %s", ban)
+		}
+	}
+
+	later := now.Add(2 * time.Minute)
+	var ban time.Duration
+	for i := range 3 {
+		ban = l.fail("203.0.113.7", later.Add(time.Duration(i)*time.Second))
+	}
+	if ban != 2*time.Minute {
+		t.Fatalf("expected the second ban to double, got %s", ban)
+	}
+	if len(l.active(later)) != 1 || !l.unban("203.0.113.7") || l.banned("203.0.113.7", later) != 0 {
+		t.Fatalf("expected the ban to be listed and then lifted")
+	}
+
+	s := &Server{
+		cfg:      &config.Config{},
+		authTok:  "admin",
+		lockouts: newLockouts(2, time.Minute, time.Minute, time.Hour),
+		metrics:  newMetrics(prometheus.NewRegistry()),
+		logger:   slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	handler := s.authMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	attempt := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		req.RemoteAddr = "203.0.113.9:4000"
+		req.Header.Set("X-Auth-Token", token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	attempt("guess-1")
+	attempt("guess-2")
+	if rec := attempt("admin"); rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "60" {
+		t.Fatalf("expected a banned client to be refused even with the right token, got %d", rec.Code)
+	}
+	rec := httptest.NewRecorder()
+	s.unbanHandler(rec, httptest.NewRequest(http.MethodPost, "/admin/unban", strings.NewReader(`{"client":"203.0.113.9"}`)))
+	if rec.Code != http.StatusNoContent || attempt("admin").Code != http.StatusOK {
+		t.Fatalf("expected the unbanned client to be let back in, got %d", rec.Code)
+	}
+}
+
+func TestLockoutSpoofedClient(t *testing.T) {
+	s := &Server{
+		cfg:      &config.Config{TrustedProxies: []netip.Prefix{netip.MustParsePrefix("192.168.1.0/24")}},
+		authTok:  "admin",
+		lockouts: newLockouts(2, time.Minute, time.Minute, time.Hour),
+		metrics:  newMetrics(prometheus.NewRegistry()),
+		logger:   slog.New(slog.DiscardHandler),
+	}
+	handler := s.realIPMiddleware(s.authMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+	attempt := func(remote, xff, token string) int {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		req.RemoteAddr = remote
+		req.Header.Set("X-Forwarded-For", xff)
+		req.Header.Set("X-Auth-Token", token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// A guesser that names a different address on every attempt is banned
+	// under its own, and cannot get the address it names banned.
+	attempt("203.0.113.9:4000", "198.51.100.1", "guess-1")
+	attempt("203.0.113.9:4001", "198.51.100.2", "guess-2")
+	if code := attempt("203.0.113.9:4002", "198.51.100.3", "admin"); code != http.StatusTooManyRequests {
+		t.Fatalf("expected the spoofing client to stay banned, got %d", code)
+	}
+	if code := attempt("198.51.100.1:4000", "", "admin"); code != http.StatusOK {
+		t.Fatalf("expected the spoofed address not to be banned, got %d", code)
+	}
+
+	// Behind a trusted proxy the forwarded client is the one banned, not
+	// the proxy.
+	attempt("192.168.1.5:4000", "203.0.113.20, 203.0.113.21", "guess-1")
+	attempt("192.168.1.5:4000", "203.0.113.21", "guess-2")
+	if code := attempt("192.168.1.5:4000", "203.0.113.21", "admin"); code != http.StatusTooManyRequests {
+		t.Fatalf("expected the forwarded client to be banned, got %d", code)
+	}
+	if code := attempt("192.168.1.5:4000", "203.0.113.22", "admin"); code != http.StatusOK {
+		t.Fatalf("expected other clients behind the proxy to be let in, got %d", code)
+	}
+}
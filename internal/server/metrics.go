@@ -2,15 +2,48 @@ package server
 
 import (
 	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/joeychilson/s3-proxy/internal/cache"
+	"github.com/joeychilson/s3-proxy/internal/origin"
+	"github.com/joeychilson/s3-proxy/internal/peer"
 )
 
 type metrics struct {
-	cacheHits     prometheus.Counter
-	cacheMisses   prometheus.Counter
-	cacheStales   prometheus.Counter
-	originErrors  prometheus.Counter
-	originLatency prometheus.Histogram
-	bytesServed   prometheus.Counter
+	cacheHits      prometheus.Counter
+	cacheMisses    prometheus.Counter
+	cacheStales    prometheus.Counter
+	originErrors   prometheus.Counter
+	originLatency  prometheus.Histogram
+	requestLatency prometheus.Histogram
+	bytesServed    *prometheus.CounterVec
+
+	revalidateFailures prometheus.Counter
+	coalesced          prometheus.Counter
+	errorCacheHits     prometheus.Counter
+	cacheResponses     *prometheus.CounterVec
+	peerFetches        *prometheus.CounterVec
+	staleErrors        prometheus.Counter
+	fillsSkipped       prometheus.Counter
+	fillWaitTimeouts   prometheus.Counter
+	compressions       prometheus.Counter
+	validatorMismatch  *prometheus.CounterVec
+	fanoutClients      *prometheus.CounterVec
+	notModified        prometheus.Counter
+	originServed       *prometheus.CounterVec
+	inflightRejected   *prometheus.CounterVec
+	slowClients        prometheus.Counter
+	connsExpired       prometheus.Counter
+	writes             *prometheus.CounterVec
+	agentsBlocked      *prometheus.CounterVec
+	classRequests      *prometheus.CounterVec
+	crawlerMisses      prometheus.Counter
+	rulesReloads       *prometheus.CounterVec
+	accessRejected     *prometheus.CounterVec
+	ipRejected         *prometheus.CounterVec
+	securityEvents     *prometheus.CounterVec
+	tlsReloads         *prometheus.CounterVec
+	lockouts           prometheus.Counter
+	lockoutRejections  prometheus.Counter
 }
 
 func newMetrics(reg prometheus.Registerer) *metrics {
@@ -41,13 +74,198 @@ func newMetrics(reg prometheus.Registerer) *metrics {
 			Help:      "Latency of origin fetches",
 			Buckets:   prometheus.DefBuckets,
 		}),
-		bytesServed: prometheus.NewCounter(prometheus.CounterOpts{
+		requestLatency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: "proxy",
+			Name:      "request_duration_seconds",
+			Help:      "Time taken to serve client requests",
+			Buckets:   prometheus.DefBuckets,
+		}),
+		bytesServed: prometheus.NewCounterVec(prometheus.CounterOpts{
 			Namespace: "proxy",
 			Name:      "bytes_served_total",
-			Help:      "Total bytes served to clients",
+			Help:      "Total bytes served to clients, by client network group",
+		}, []string{"network"}),
+		revalidateFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "proxy",
+			Name:      "revalidate_failures_total",
+			Help:      "Number of failed background revalidations",
+		}),
+		coalesced: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "proxy",
+			Name:      "coalesced_requests_total",
+			Help:      "Number of requests served from another request's origin fetch",
+		}),
+		errorCacheHits: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "proxy",
+			Name:      "error_cache_hits_total",
+			Help:      "Number of origin errors replayed from the error cache",
+		}),
+		cacheResponses: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "proxy",
+			Name:      "cache_responses_total",
+			Help:      "Number of object responses by the cache layer that produced them and cache state",
+		}, []string{"layer", "state"}),
+		peerFetches: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "proxy",
+			Name:      "peer_fetches_total",
+			Help:      "Number of objects requested from the owning peer by result",
+		}, []string{"result"}),
+		notModified: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "proxy",
+			Name:      "not_modified_total",
+			Help:      "Number of conditional requests answered 304 from a cached copy",
+		}),
+		staleErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "proxy",
+			Name:      "stale_if_error_total",
+			Help:      "Number of stale entries served because the origin failed",
+		}),
+		fillsSkipped: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "proxy",
+			Name:      "background_fills_skipped_total",
+			Help:      "Number of background cache fills not queued because the key was already queued or the queue was full",
+		}),
+		fillWaitTimeouts: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "proxy",
+			Name:      "fill_wait_timeouts_total",
+			Help:      "Number of requests that gave up waiting for another request's cache fill",
+		}),
+		compressions: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "proxy",
+			Name:      "compressions_total",
+			Help:      "Number of cached objects compressed into a gzip variant",
+		}),
+		validatorMismatch: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "proxy",
+			Name:      "validator_mismatches_total",
+			Help:      "Number of cached copies replaced by the same object version with a different ETag or length, by where the replacement came from",
+		}, []string{"source"}),
+		fanoutClients: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "proxy",
+			Name:      "fanout_clients_total",
+			Help:      "Number of clients that joined a shared origin stream, or were detached from one for falling behind, by result",
+		}, []string{"result"}),
+		originServed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "proxy",
+			Name:      "origin_requests_total",
+			Help:      "Number of origin requests by the origin that answered them, when failover is configured",
+		}, []string{"origin"}),
+		inflightRejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "proxy",
+			Name:      "concurrency_rejections_total",
+			Help:      "Number of requests rejected because the client had too many requests in flight",
+		}, []string{"scope"}),
+		slowClients: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "proxy",
+			Name:      "slow_clients_total",
+			Help:      "Number of responses aborted because the client read slower than the minimum throughput",
+		}),
+		connsExpired: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "proxy",
+			Name:      "connections_expired_total",
+			Help:      "Number of connections closed for exceeding the maximum connection lifetime",
+		}),
+		writes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "proxy",
+			Name:      "writes_total",
+			Help:      "Number of writes proxied to the origin",
+		}, []string{"method", "status"}),
+		agentsBlocked: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "proxy",
+			Name:      "user_agent_blocked_total",
+			Help:      "Number of requests rejected by the user agent rules",
+		}, []string{"rule"}),
+		classRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "proxy",
+			Name:      "class_requests_total",
+			Help:      "Number of requests by traffic class",
+		}, []string{"class"}),
+		crawlerMisses: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "proxy",
+			Name:      "crawler_cache_misses_total",
+			Help:      "Number of crawler requests refused because the object was not cached",
+		}),
+		rulesReloads: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "proxy",
+			Name:      "rules_reloads_total",
+			Help:      "Number of checks of RULES_URL by result",
+		}, []string{"result"}),
+		accessRejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "proxy",
+			Name:      "access_rejected_total",
+			Help:      "Number of object requests refused by the access policy",
+		}, []string{"mode", "reason"}),
+		ipRejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "proxy",
+			Name:      "ip_rejected_total",
+			Help:      "Number of requests refused by ALLOW_CIDRS or DENY_CIDRS",
+		}, []string{"list"}),
+		securityEvents: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "proxy",
+			Name:      "security_events_total",
+			Help:      "Number of rejected requests logged as security events by type",
+		}, []string{"event"}),
+		tlsReloads: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "proxy",
+			Name:      "tls_reloads_total",
+			Help:      "Number of TLS certificate reloads on SIGHUP by result",
+		}, []string{"result"}),
+		lockouts: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "proxy",
+			Name:      "auth_lockouts_total",
+			Help:      "Number of clients banned for repeated wrong tokens",
+		}),
+		lockoutRejections: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "proxy",
+			Name:      "auth_lockout_rejections_total",
+			Help:      "Number of requests refused because the client was banned",
 		}),
 	}
 
-	reg.MustRegister(m.cacheHits, m.cacheMisses, m.cacheStales, m.originErrors, m.originLatency, m.bytesServed)
+	reg.MustRegister(m.cacheHits, m.cacheMisses, m.cacheStales, m.originErrors, m.originLatency, m.requestLatency, m.bytesServed, m.revalidateFailures, m.coalesced, m.errorCacheHits, m.cacheResponses, m.peerFetches, m.staleErrors, m.fillsSkipped, m.fillWaitTimeouts, m.compressions, m.validatorMismatch, m.fanoutClients, m.notModified, m.originServed, m.inflightRejected, m.slowClients, m.connsExpired, m.writes, m.agentsBlocked, m.classRequests, m.crawlerMisses, m.rulesReloads, m.accessRejected, m.ipRejected, m.securityEvents, m.tlsReloads, m.lockouts, m.lockoutRejections)
 	return m
 }
+
+func registerCacheMetrics(reg prometheus.Registerer, c cache.Store) {
+	reg.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: "proxy",
+			Name:      "cache_entries",
+			Help:      "Number of entries in the cache",
+		}, func() float64 {
+			size, _, _ := c.Stats()
+			return float64(size)
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: "proxy",
+			Name:      "cache_bytes",
+			Help:      "Total size of cached bodies in bytes",
+		}, func() float64 {
+			_, _, bytes := c.Stats()
+			return float64(bytes)
+		}),
+	)
+}
+
+func registerPeerMetrics(reg prometheus.Registerer, cluster *peer.Cluster) {
+	reg.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: "proxy",
+		Name:      "peer_members",
+		Help:      "Number of healthy members in the peer ring, including this node",
+	}, func() float64 {
+		return float64(len(cluster.Members()))
+	}))
+}
+
+func registerFailoverMetrics(reg prometheus.Registerer, failover *origin.Failover) {
+	reg.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: "proxy",
+		Name:      "origin_primary_up",
+		Help:      "Whether requests are sent to the primary origin (1) or failed over to the secondary (0)",
+	}, func() float64 {
+		if failover.PrimaryHealthy() {
+			return 1
+		}
+		return 0
+	}))
+}
@@ -2,6 +2,8 @@ package server
 
 import (
 	"crypto/subtle"
+	"encoding/json"
+	"errors"
 	"net/http"
 	"strings"
 	"sync"
@@ -10,28 +12,71 @@ import (
 	"golang.org/x/time/rate"
 )
 
+// pathPrefixMiddleware strips PATH_PREFIX from request paths and answers 404
+// for paths outside it. Health checks are also served unprefixed, since
+// probes usually reach the proxy directly rather than through the router
+// that mounts it.
+func (s *Server) pathPrefixMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rest, ok := strings.CutPrefix(r.URL.Path, s.cfg.PathPrefix)
+		switch {
+		case ok && rest == "":
+			next.ServeHTTP(w, withPath(r, "/"))
+		case ok && strings.HasPrefix(rest, "/"):
+			next.ServeHTTP(w, withPath(r, rest))
+		case r.URL.Path == "/healthz" || r.URL.Path == "/readyz":
+			next.ServeHTTP(w, r)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
+
 func (s *Server) logMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 		rw := &responseWriter{ResponseWriter: w}
 		next.ServeHTTP(rw, r)
 		duration := time.Since(start)
-		s.logger.Info("request",
+		observe(r.Context(), s.metrics.requestLatency, duration.Seconds())
+		s.metrics.bytesServed.WithLabelValues(s.networkGroup(r)).Add(float64(rw.bytes))
+		if xcache := rw.Header().Get("X-Cache"); xcache != "" {
+			s.activity.record(requestEvent{
+				Time:       start,
+				Key:        strings.TrimPrefix(r.URL.Path, "/"),
+				Cache:      xcache,
+				Status:     rw.status,
+				Bytes:      rw.bytes,
+				DurationMs: float64(duration.Microseconds()) / 1000,
+			})
+		}
+		attrs := []any{
 			"method", r.Method,
 			"path", r.URL.Path,
 			"status", rw.status,
 			"size", rw.bytes,
 			"duration", duration.String(),
 			"remote", r.RemoteAddr,
-		)
+		}
+		if id := traceID(r.Context()); id != "" {
+			attrs = append(attrs, "trace_id", id)
+		}
+		s.logger.Info("request", attrs...)
 	})
 }
 
 func (s *Server) rateLimitMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ip := realIP(r)
-		limiter := s.limiter.get(ip)
-		if !limiter.Allow() {
+		limiter := s.limiter
+		if s.crawlers != nil && s.crawlers.limiter != nil && requestClass(r.Context()) == classCrawler {
+			limiter = s.crawlers.limiter
+		}
+		if limiter == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !limiter.get(clientHost(r)).Allow() {
+			s.securityEvent(r, securityRateLimited, "")
 			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
 			return
 		}
@@ -39,12 +84,82 @@ func (s *Server) rateLimitMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// bodyLimitMiddleware rejects request bodies over their bodyLimit and any
+// body at all on object reads, which never take one.
+func (s *Server) bodyLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hasBody := r.ContentLength > 0 || (r.ContentLength < 0 && r.Body != nil && r.Body != http.NoBody)
+		if hasBody && (r.Method == http.MethodGet || r.Method == http.MethodHead) {
+			http.Error(w, "request body not allowed", http.StatusBadRequest)
+			return
+		}
+		limit := s.bodyLimit(r)
+		if r.ContentLength > limit {
+			http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if r.Body != nil {
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// bodyLimit returns the largest body accepted for r. A cache handoff never
+// carries more than the cache can hold.
+func (s *Server) bodyLimit(r *http.Request) int64 {
+	switch {
+	case r.Method == http.MethodPut:
+		return s.cfg.MaxUploadSize
+	case r.URL.Path == handoffPath:
+		return max(s.cfg.CacheMaxBytes, s.cfg.MaxRequestBody)
+	}
+	return s.cfg.MaxRequestBody
+}
+
+// decodeJSON decodes the request body into v, answering 413 when the body
+// exceeds the size limit and 400 when it is not valid JSON.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	err := json.NewDecoder(r.Body).Decode(v)
+	var tooLarge *http.MaxBytesError
+	switch {
+	case err == nil:
+		return true
+	case errors.As(err, &tooLarge):
+		http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+	default:
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+	}
+	return false
+}
+
 func (s *Server) authMiddleware(next http.Handler) http.Handler {
+	return s.authenticate(next, false)
+}
+
+// certAuthMiddleware is authMiddleware that also lets in a client certificate
+// from TLS_CLIENT_CA_FILE. Only purges and metrics take it, so that such a
+// certificate cannot write to the bucket or rotate its keys.
+func (s *Server) certAuthMiddleware(next http.Handler) http.Handler {
+	return s.authenticate(next, true)
+}
+
+func (s *Server) authenticate(next http.Handler, acceptCert bool) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if checkToken(r, s.authTok) {
+		if s.lockedOut(w, r) {
+			return
+		}
+		if (acceptCert && clientCertified(r)) || (s.authTok != "" && checkToken(r, s.authTok)) {
 			next.ServeHTTP(w, r)
 			return
 		}
+		reason := "invalid"
+		if requestToken(r) == "" {
+			reason = "missing"
+		} else {
+			s.tokenFailed(r)
+		}
+		s.securityEvent(r, securityAuthFailure, reason)
 		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
 	})
 }
@@ -53,6 +168,12 @@ func checkToken(r *http.Request, expected string) bool {
 	if expected == "" {
 		return true
 	}
+	return subtleConstantTimeEquals(requestToken(r), expected)
+}
+
+// requestToken returns the token a request presents, from X-Auth-Token, a
+// bearer Authorization header or the token query parameter.
+func requestToken(r *http.Request) string {
 	token := r.Header.Get("X-Auth-Token")
 	if token == "" {
 		auth := r.Header.Get("Authorization")
@@ -63,7 +184,7 @@ func checkToken(r *http.Request, expected string) bool {
 	if token == "" {
 		token = r.URL.Query().Get("token")
 	}
-	return subtleConstantTimeEquals(token, expected)
+	return token
 }
 
 func subtleConstantTimeEquals(a, b string) bool {
@@ -76,6 +197,69 @@ func subtleConstantTimeEquals(a, b string) bool {
 	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
 }
 
+// concurrencyMiddleware caps in-flight requests per client IP and, for
+// requests presenting a token, per token, so one client cannot tie up the
+// server with many slow parallel downloads.
+func (s *Server) concurrencyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := "ip:" + clientHost(r)
+		if !s.inflight.acquire(ip, s.cfg.MaxConcurrentPerIP) {
+			s.rejectConcurrent(w, "ip")
+			return
+		}
+		defer s.inflight.release(ip)
+		if token := requestToken(r); token != "" {
+			key := "token:" + token
+			if !s.inflight.acquire(key, s.cfg.MaxConcurrentPerToken) {
+				s.rejectConcurrent(w, "token")
+				return
+			}
+			defer s.inflight.release(key)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) rejectConcurrent(w http.ResponseWriter, scope string) {
+	s.metrics.inflightRejected.WithLabelValues(scope).Inc()
+	w.Header().Set("Retry-After", "1")
+	http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+}
+
+type concurrencyLimiter struct {
+	mu       sync.Mutex
+	inflight map[string]int
+}
+
+func newConcurrencyLimiter() *concurrencyLimiter {
+	return &concurrencyLimiter{inflight: make(map[string]int)}
+}
+
+// acquire takes a slot for key unless limit slots are already taken. A limit
+// of zero or less means unlimited.
+func (c *concurrencyLimiter) acquire(key string, limit int) bool {
+	if limit <= 0 {
+		return true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.inflight[key] >= limit {
+		return false
+	}
+	c.inflight[key]++
+	return true
+}
+
+func (c *concurrencyLimiter) release(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.inflight[key] <= 1 {
+		delete(c.inflight, key)
+		return
+	}
+	c.inflight[key]--
+}
+
 type rateLimiter struct {
 	limit float64
 	burst float64
@@ -101,16 +285,18 @@ func (r *rateLimiter) get(key string) *rate.Limiter {
 	return limiter
 }
 
-func realIP(r *http.Request) string {
-	if xf := r.Header.Get("X-Forwarded-For"); xf != "" {
-		for part := range strings.SplitSeq(xf, ",") {
-			return strings.TrimSpace(part)
+// realIPMiddleware replaces the request's RemoteAddr with the client address
+// resolved through TRUSTED_PROXIES, so that rate limits, concurrency limits,
+// lockouts, network groups and logs all name the same client. A connection
+// from outside TRUSTED_PROXIES keeps its own address whatever its forwarding
+// headers say.
+func (s *Server) realIPMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if addr, ok := clientAddr(r, s.cfg.TrustedProxies); ok {
+			r.RemoteAddr = addr.String()
 		}
-	}
-	if xr := r.Header.Get("X-Real-IP"); xr != "" {
-		return xr
-	}
-	return r.RemoteAddr
+		next.ServeHTTP(w, r)
+	})
 }
 
 type responseWriter struct {
@@ -124,6 +310,10 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.ResponseWriter.WriteHeader(code)
 }
 
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
 func (rw *responseWriter) Write(b []byte) (int, error) {
 	if rw.status == 0 {
 		rw.status = http.StatusOK
@@ -0,0 +1,160 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/joeychilson/s3-proxy/internal/config"
+)
+
+func TestConcurrencyLimiter(t *testing.T) {
+	c := newConcurrencyLimiter()
+	if !c.acquire("ip:a", 2) || !c.acquire("ip:a", 2) {
+		t.Fatalf("expected slots up to the limit")
+	}
+	if c.acquire("ip:a", 2) {
+		t.Fatalf("expected the limit to be enforced")
+	}
+	if !c.acquire("ip:b", 2) {
+		t.Fatalf("expected clients to be limited independently")
+	}
+	c.release("ip:a")
+	if !c.acquire("ip:a", 2) {
+		t.Fatalf("expected a released slot to be reusable")
+	}
+	c.release("ip:a")
+	c.release("ip:a")
+	c.release("ip:b")
+	if len(c.inflight) != 0 {
+		t.Fatalf("expected idle clients to be forgotten, got %v", c.inflight)
+	}
+}
+
+func TestBodyLimitMiddleware(t *testing.T) {
+	s := &Server{cfg: &config.Config{MaxRequestBody: 16, MaxUploadSize: 32}}
+	h := s.bodyLimitMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var v map[string]any
+		if decodeJSON(w, r, &v) {
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	tests := []struct {
+		method string
+		body   string
+		chunk  bool
+		want   int
+	}{
+		{http.MethodPost, `{"keys":[]}`, false, http.StatusNoContent},
+		{http.MethodPost, `{"keys":["a","b","c"]}`, false, http.StatusRequestEntityTooLarge},
+		{http.MethodPost, `{"keys":["a","b","c"]}`, true, http.StatusRequestEntityTooLarge},
+		{http.MethodPost, `not json`, false, http.StatusBadRequest},
+		{http.MethodGet, `{}`, false, http.StatusBadRequest},
+		{http.MethodPut, `{"keys":["a","b","c"]}`, false, http.StatusNoContent},
+		{http.MethodPut, `{"keys":["a","b","c","d","e","f"]}`, false, http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, "/cache/purge", strings.NewReader(tt.body))
+		if tt.chunk {
+			r.ContentLength = -1
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tt.want {
+			t.Fatalf("%s %q: got status %d, want %d", tt.method, tt.body, w.Code, tt.want)
+		}
+	}
+}
+
+func TestPathPrefixMiddleware(t *testing.T) {
+	s := &Server{cfg: &config.Config{PathPrefix: "/files"}}
+	handler := s.pathPrefixMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.URL.Path)
+	}))
+	tests := []struct {
+		path   string
+		status int
+		seen   string
+	}{
+		{"/files/docs/a.txt", http.StatusOK, "/docs/a.txt"},
+		{"/files", http.StatusOK, "/"},
+		{"/files/", http.StatusOK, "/"},
+		{"/healthz", http.StatusOK, "/healthz"},
+		{"/filesystem/a.txt", http.StatusNotFound, ""},
+		{"/docs/a.txt", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if rec.Code != tt.status {
+			t.Errorf("%s: expected status %d, got %d", tt.path, tt.status, rec.Code)
+		}
+		if tt.status == http.StatusOK && rec.Body.String() != tt.seen {
+			t.Errorf("%s: expected path %q, got %q", tt.path, tt.seen, rec.Body.String())
+		}
+	}
+}
+
+func TestRealIPMiddleware(t *testing.T) {
+	s := &Server{
+		cfg:     &config.Config{TrustedProxies: []netip.Prefix{netip.MustParsePrefix("192.168.1.0/24")}},
+		limiter: newRateLimiter(1, 1),
+		metrics: newMetrics(prometheus.NewRegistry()),
+		logger:  slog.New(slog.DiscardHandler),
+	}
+	var client string
+	handler := s.realIPMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client = clientHost(r)
+	}))
+	tests := []struct {
+		remote string
+		xff    string
+		realIP string
+		want   string
+	}{
+		{"203.0.113.7:4000", "", "", "203.0.113.7"},
+		// Forwarding headers from untrusted peers are ignored.
+		{"203.0.113.7:4000", "10.1.2.3", "", "203.0.113.7"},
+		{"203.0.113.7:4000", "", "10.1.2.3", "203.0.113.7"},
+		// Behind a trusted proxy the rightmost untrusted hop is the client.
+		{"192.168.1.5:4000", "10.1.2.3, 203.0.113.7", "", "203.0.113.7"},
+		{"192.168.1.5:4000", "203.0.113.7, 192.168.1.6", "", "203.0.113.7"},
+		{"192.168.1.5:4000", "", "203.0.113.8", "203.0.113.8"},
+		{"192.168.1.5:4000", "not-an-ip", "", "192.168.1.5"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/a", nil)
+		req.RemoteAddr = tt.remote
+		if tt.xff != "" {
+			req.Header.Set("X-Forwarded-For", tt.xff)
+		}
+		if tt.realIP != "" {
+			req.Header.Set("X-Real-IP", tt.realIP)
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		if client != tt.want {
+			t.Errorf("%s via %q/%q: got client %q, want %q", tt.remote, tt.xff, tt.realIP, client, tt.want)
+		}
+	}
+
+	// A client rotating spoofed headers still shares one rate limit, across
+	// connections from different ports.
+	limited := s.realIPMiddleware(s.rateLimitMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+	for i, want := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		req := httptest.NewRequest(http.MethodGet, "/a", nil)
+		req.RemoteAddr = fmt.Sprintf("198.51.100.4:%d", 4000+i)
+		req.Header.Set("X-Forwarded-For", fmt.Sprintf("10.0.0.%d", i))
+		rec := httptest.NewRecorder()
+		limited.ServeHTTP(rec, req)
+		if rec.Code != want {
+			t.Fatalf("request %d: got %d, want %d", i, rec.Code, want)
+		}
+	}
+}
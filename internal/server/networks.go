@@ -0,0 +1,41 @@
+package server
+
+import (
+	"cmp"
+	"net/http"
+	"net/netip"
+	"slices"
+
+	"github.com/joeychilson/s3-proxy/internal/config"
+)
+
+// networkOther labels clients outside every configured network group.
+const networkOther = "other"
+
+// newNetworkGroups orders groups so that the most specific prefix matches
+// first.
+func newNetworkGroups(groups []config.NetworkGroup) []config.NetworkGroup {
+	groups = slices.Clone(groups)
+	slices.SortStableFunc(groups, func(a, b config.NetworkGroup) int {
+		return cmp.Compare(b.Prefix.Bits(), a.Prefix.Bits())
+	})
+	return groups
+}
+
+// networkGroup returns the label of the network the client is in.
+func (s *Server) networkGroup(r *http.Request) string {
+	if len(s.networks) == 0 {
+		return networkOther
+	}
+	addr, err := netip.ParseAddr(clientHost(r))
+	if err != nil {
+		return networkOther
+	}
+	addr = addr.Unmap()
+	for _, g := range s.networks {
+		if g.Prefix.Contains(addr) {
+			return g.Label
+		}
+	}
+	return networkOther
+}
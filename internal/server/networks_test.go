@@ -0,0 +1,31 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/joeychilson/s3-proxy/internal/config"
+)
+
+func TestNetworkGroup(t *testing.T) {
+	s := &Server{networks: newNetworkGroups([]config.NetworkGroup{
+		{Prefix: netip.MustParsePrefix("10.0.0.0/8"), Label: "internal"},
+		{Prefix: netip.MustParsePrefix("10.9.0.0/16"), Label: "cdn"},
+	})}
+	tests := map[string]string{
+		"10.1.2.3:4567":       "internal",
+		"10.9.8.7:4567":       "cdn",
+		"[::ffff:10.1.2.3]:1": "internal",
+		"198.51.100.1:80":     networkOther,
+		"not-an-ip":           networkOther,
+	}
+	for remote, want := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/a", nil)
+		r.RemoteAddr = remote
+		if got := s.networkGroup(r); got != want {
+			t.Fatalf("%s: got %q, want %q", remote, got, want)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/joeychilson/s3-proxy/internal/cdn"
+	"github.com/joeychilson/s3-proxy/internal/config"
+)
+
+// purgeNotifier passes purges on to a cache in front of the proxy, so that
+// one call to /cache/purge clears the whole delivery chain.
+type purgeNotifier struct {
+	name string
+	// targets describes what a purge drops there, for dry runs.
+	targets func(purgeRequest) any
+	purge   func(context.Context, purgeRequest) error
+}
+
+// newPurgeNotifiers returns a notifier for each CDN and webhook configured.
+func newPurgeNotifiers(ctx context.Context, cfg *config.Config) ([]purgeNotifier, error) {
+	var notifiers []purgeNotifier
+	if cfg.SpacesCDNEndpointID != "" {
+		spaces := cdn.NewSpaces(cfg.SpacesCDNEndpointID, cfg.SpacesCDNToken, cfg.RequestTimeout)
+		notifiers = append(notifiers, purgeNotifier{
+			name:    "spaces",
+			targets: func(p purgeRequest) any { return cdnFiles(p) },
+			purge: func(ctx context.Context, p purgeRequest) error {
+				return spaces.Purge(ctx, cdnFiles(p))
+			},
+		})
+	}
+	if cfg.CloudFrontDistributionID != "" {
+		cloudFront, err := cdn.NewCloudFront(ctx, cfg.CloudFrontDistributionID, cfg.RequestTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("cloudfront: %w", err)
+		}
+		notifiers = append(notifiers, purgeNotifier{
+			name:    "cloudfront",
+			targets: func(p purgeRequest) any { return cloudFrontPaths(p) },
+			purge: func(ctx context.Context, p purgeRequest) error {
+				return cloudFront.Invalidate(ctx, cloudFrontPaths(p))
+			},
+		})
+	}
+	if cfg.FastlyServiceID != "" {
+		fastly := cdn.NewFastly(cfg.FastlyServiceID, cfg.FastlyAPIToken, cfg.RequestTimeout)
+		notifiers = append(notifiers, purgeNotifier{
+			name: "fastly",
+			targets: func(p purgeRequest) any {
+				if keys, all := fastlyKeys(p); !all {
+					return keys
+				}
+				return "all"
+			},
+			purge: func(ctx context.Context, p purgeRequest) error {
+				if keys, all := fastlyKeys(p); !all {
+					return fastly.PurgeKeys(ctx, keys)
+				}
+				return fastly.PurgeAll(ctx)
+			},
+		})
+	}
+	if cfg.PurgeWebhookURL != "" {
+		webhook := cdn.NewWebhook(cfg.PurgeWebhookURL, cfg.PurgeWebhookSecret, cfg.RequestTimeout)
+		notifiers = append(notifiers, purgeNotifier{
+			name:    "webhook",
+			targets: func(p purgeRequest) any { return p },
+			purge: func(ctx context.Context, p purgeRequest) error {
+				return webhook.Send(ctx, p)
+			},
+		})
+	}
+	return notifiers, nil
+}
+
+// notifyPurge passes a purge on to every notifier at once, returning the
+// failures by notifier name.
+func (s *Server) notifyPurge(ctx context.Context, payload purgeRequest) error {
+	errs := make([]error, len(s.notify))
+	var wg sync.WaitGroup
+	for i, n := range s.notify {
+		wg.Go(func() {
+			if err := n.purge(ctx, payload); err != nil {
+				errs[i] = fmt.Errorf("%s: %w", n.name, err)
+			}
+		})
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// purgeTargets describes, by notifier name, what a purge would drop in the
+// caches in front of the proxy.
+func (s *Server) purgeTargets(payload purgeRequest) map[string]any {
+	targets := make(map[string]any, len(s.notify))
+	for _, n := range s.notify {
+		targets[n.name] = n.targets(payload)
+	}
+	return targets
+}
+
+// cloudFrontPaths maps a purge to CloudFront invalidation paths, which are
+// cdnFiles with a leading "/".
+func cloudFrontPaths(payload purgeRequest) []string {
+	files := cdnFiles(payload)
+	for i, f := range files {
+		files[i] = "/" + f
+	}
+	return files
+}
+
+// fastlyKeys maps a purge to the surrogate keys withSurrogate tags objects
+// with. Tags are passed on as they are. Prefixes and patterns are widened to the deepest directory holding
+// their literal part, since only keys and directories are tagged; all
+// reports that nothing narrower than the whole service covers the purge.
+func fastlyKeys(payload purgeRequest) (keys []string, all bool) {
+	for _, key := range payload.Keys {
+		if k := strings.TrimSpace(key); k != "" {
+			keys = append(keys, surrogateTag(k))
+		}
+	}
+	for _, tag := range payload.Tags {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			keys = append(keys, surrogateTag(tag))
+		}
+	}
+	var literals []string
+	for _, prefix := range payload.Prefixes {
+		if prefix = strings.TrimSpace(prefix); prefix != "" {
+			literals = append(literals, prefix)
+		}
+	}
+	for _, pattern := range payload.Patterns {
+		if pattern = strings.TrimSpace(pattern); pattern == "" {
+			continue
+		}
+		if i := strings.IndexAny(pattern, "*?"); i >= 0 {
+			pattern = pattern[:i]
+		}
+		literals = append(literals, pattern)
+	}
+	for _, literal := range literals {
+		i := strings.LastIndexByte(literal, '/')
+		if i < 0 {
+			return nil, true
+		}
+		keys = append(keys, surrogateTag(literal[:i+1]))
+	}
+	return keys, false
+}
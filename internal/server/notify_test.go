@@ -0,0 +1,19 @@
+package server
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestFastlyKeys(t *testing.T) {
+	keys, all := fastlyKeys(purgeRequest{Keys: []string{"a b.jpg"}, Prefixes: []string{"images/", "docs/v1"}, Patterns: []string{"css/*.css"}, Tags: []string{"release-42"}})
+	if all || !slices.Equal(keys, []string{"a%20b.jpg", "release-42", "images/", "docs/", "css/"}) {
+		t.Fatalf("unexpected surrogate keys %q %v", keys, all)
+	}
+	if _, all := fastlyKeys(purgeRequest{Patterns: []string{"*.css"}}); !all {
+		t.Fatal("expected a pattern outside any directory to purge everything")
+	}
+	if got := cloudFrontPaths(purgeRequest{Keys: []string{"a.jpg"}, Prefixes: []string{"images/"}}); !slices.Equal(got, []string{"/a.jpg", "/images/*"}) {
+		t.Fatalf("unexpected CloudFront paths %q", got)
+	}
+}
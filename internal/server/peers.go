@@ -0,0 +1,117 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joeychilson/s3-proxy/internal/cache"
+)
+
+type peerRequestKey struct{}
+
+// peerObjectHandler serves an object on behalf of another replica. The
+// request is answered from the local cache or filled from the origin, but is
+// never forwarded again.
+func (s *Server) peerObjectHandler(w http.ResponseWriter, r *http.Request) {
+	u := *r.URL
+	u.Path = "/" + strings.TrimPrefix(r.URL.Path, "/_peer/objects/")
+	u.RawPath = ""
+	r = r.WithContext(context.WithValue(r.Context(), peerRequestKey{}, true))
+	r.URL = &u
+	s.objectHandler(w, r)
+}
+
+// peerPurgeHandler applies a purge forwarded by another replica without
+// broadcasting it again.
+func (s *Server) peerPurgeHandler(w http.ResponseWriter, r *http.Request) {
+	r = r.WithContext(context.WithValue(r.Context(), peerRequestKey{}, true))
+	s.purgeHandler(w, r)
+}
+
+// broadcastPurge forwards a purge to every other replica, since any of them
+// may hold copies of the purged objects.
+func (s *Server) broadcastPurge(ctx context.Context, payload purgeRequest) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.RequestTimeout)
+	defer cancel()
+	if err := s.peers.Broadcast(ctx, "/_peer/purge", body); err != nil {
+		s.logger.Warn("purge broadcast failed", "error", err)
+	}
+}
+
+func isPeerRequest(ctx context.Context) bool {
+	forwarded, _ := ctx.Value(peerRequestKey{}).(bool)
+	return forwarded
+}
+
+// fetchFromPeer asks the replica that owns key for the object. It returns nil
+// when this node owns the key or the owner could not serve it, in which case
+// the caller goes to the origin.
+func (s *Server) fetchFromPeer(ctx context.Context, key string, vary http.Header, now time.Time) *cache.Entry {
+	if s.peers == nil || isPeerRequest(ctx) {
+		return nil
+	}
+	owner, ok := s.peers.Owner(key)
+	if !ok {
+		return nil
+	}
+	// The object itself is wanted, not the owner's gzip variant, so that
+	// every replica serves and compresses the same bytes.
+	if vary.Get("Accept-Encoding") == "" {
+		vary.Set("Accept-Encoding", "identity")
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.RequestTimeout)
+	defer cancel()
+	resp, err := s.peers.Get(ctx, owner, "/_peer/objects/"+escapeKey(key), vary)
+	if err != nil {
+		s.metrics.peerFetches.WithLabelValues("error").Inc()
+		s.logger.Warn("peer fetch failed", "error", err, "peer", owner, "key", key)
+		return nil
+	}
+	defer resp.Body.Close()
+	compressed := resp.Header.Get("Content-Encoding") != "" && strings.HasPrefix(resp.Header.Get("ETag"), "W/")
+	if resp.StatusCode != http.StatusOK || resp.ContentLength <= 0 || resp.ContentLength > s.cfg.MaxObjectSize || compressed {
+		s.metrics.peerFetches.WithLabelValues("skipped").Inc()
+		return nil
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil || int64(len(body)) != resp.ContentLength {
+		s.metrics.peerFetches.WithLabelValues("error").Inc()
+		s.logger.Warn("peer fetch failed", "error", err, "peer", owner, "key", key)
+		return nil
+	}
+	s.metrics.peerFetches.WithLabelValues("ok").Inc()
+
+	header := cloneHeader(resp.Header)
+	age, _ := strconv.Atoi(header.Get("Age"))
+	header.Del("Age")
+	header.Del("X-Cache")
+	header.Del("Date")
+	lastModified, _ := http.ParseTime(header.Get("Last-Modified"))
+	return &cache.Entry{
+		Body:         body,
+		Header:       header,
+		Status:       http.StatusOK,
+		StoredAt:     now.Add(-time.Duration(age) * time.Second),
+		Size:         int64(len(body)),
+		ETag:         header.Get("ETag"),
+		LastModified: lastModified,
+	}
+}
+
+func escapeKey(key string) string {
+	parts := strings.Split(key, "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+	return strings.Join(parts, "/")
+}
@@ -0,0 +1,257 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/joeychilson/s3-proxy/internal/origin"
+)
+
+var (
+	errOverBudget   = errors.New("prefetch byte budget exhausted")
+	errNotCacheable = errors.New("object not cacheable")
+)
+
+const prefetchJobRetention = time.Hour
+
+type prefetchItem struct {
+	Key      string `json:"key"`
+	Priority int    `json:"priority"`
+}
+
+type prefetchStatus struct {
+	ID          string    `json:"id"`
+	Status      string    `json:"status"`
+	Total       int       `json:"total"`
+	Completed   int64     `json:"completed"`
+	Failed      int64     `json:"failed"`
+	Skipped     int64     `json:"skipped"`
+	Bytes       int64     `json:"bytes"`
+	MaxBytes    int64     `json:"max_bytes"`
+	Concurrency int       `json:"concurrency"`
+	CreatedAt   time.Time `json:"created_at"`
+	FinishedAt  time.Time `json:"finished_at,omitzero"`
+}
+
+type prefetchJob struct {
+	id          string
+	total       int
+	maxBytes    int64
+	concurrency int
+	createdAt   time.Time
+
+	completed atomic.Int64
+	failed    atomic.Int64
+	skipped   atomic.Int64
+	bytes     atomic.Int64
+
+	mu         sync.Mutex
+	finishedAt time.Time
+}
+
+func (j *prefetchJob) finished() (time.Time, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.finishedAt, !j.finishedAt.IsZero()
+}
+
+func (j *prefetchJob) status() prefetchStatus {
+	finishedAt, done := j.finished()
+	status := "running"
+	if done {
+		status = "done"
+	}
+	return prefetchStatus{
+		ID:          j.id,
+		Status:      status,
+		Total:       j.total,
+		Completed:   j.completed.Load(),
+		Failed:      j.failed.Load(),
+		Skipped:     j.skipped.Load(),
+		Bytes:       j.bytes.Load(),
+		MaxBytes:    j.maxBytes,
+		Concurrency: j.concurrency,
+		CreatedAt:   j.createdAt,
+		FinishedAt:  finishedAt,
+	}
+}
+
+type prefetchJobs struct {
+	mu   sync.Mutex
+	jobs map[string]*prefetchJob
+}
+
+func newPrefetchJobs() *prefetchJobs {
+	return &prefetchJobs{jobs: make(map[string]*prefetchJob)}
+}
+
+func (p *prefetchJobs) add(job *prefetchJob) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	cutoff := time.Now().Add(-prefetchJobRetention)
+	for id, existing := range p.jobs {
+		if finishedAt, done := existing.finished(); done && finishedAt.Before(cutoff) {
+			delete(p.jobs, id)
+		}
+	}
+	p.jobs[job.id] = job
+}
+
+func (p *prefetchJobs) get(id string) (*prefetchJob, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	job, ok := p.jobs[id]
+	return job, ok
+}
+
+func (s *Server) prefetchHandler(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Keys        []prefetchItem `json:"keys"`
+		MaxBytes    int64          `json:"max_bytes"`
+		Concurrency int            `json:"concurrency"`
+	}
+	if !decodeJSON(w, r, &payload) {
+		return
+	}
+
+	items := make([]prefetchItem, 0, len(payload.Keys))
+	for _, item := range payload.Keys {
+		item.Key = strings.TrimPrefix(strings.TrimSpace(item.Key), "/")
+		if item.Key == "" || strings.Contains(item.Key, "..") {
+			continue
+		}
+		items = append(items, item)
+	}
+	if len(items) == 0 {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	sort.SliceStable(items, func(i, j int) bool { return items[i].Priority > items[j].Priority })
+
+	maxBytes := s.cfg.PrefetchMaxBytes
+	if payload.MaxBytes > 0 && payload.MaxBytes < maxBytes {
+		maxBytes = payload.MaxBytes
+	}
+	concurrency := s.cfg.PrefetchConcurrency
+	if payload.Concurrency > 0 && payload.Concurrency < concurrency {
+		concurrency = payload.Concurrency
+	}
+
+	if s.cfg.AdminDryRun {
+		keys := make([]string, len(items))
+		for i, item := range items {
+			keys[i] = item.Key
+		}
+		s.dryRun(w, r, "prefetch", "keys", keys, "max_bytes", maxBytes, "concurrency", concurrency)
+		return
+	}
+
+	job := &prefetchJob{
+		id:          newJobID(),
+		total:       len(items),
+		maxBytes:    maxBytes,
+		concurrency: concurrency,
+		createdAt:   time.Now(),
+	}
+	s.prefetch.add(job)
+	go s.runPrefetch(job, items)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", s.cfg.PathPrefix+"/cache/prefetch/"+job.id)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job.status())
+}
+
+func (s *Server) prefetchStatusHandler(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.prefetch.get(chi.URLParam(r, "id"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job.status())
+}
+
+func (s *Server) runPrefetch(job *prefetchJob, items []prefetchItem) {
+	ctx := context.Background()
+	sem := make(chan struct{}, job.concurrency)
+	var wg sync.WaitGroup
+	for _, item := range items {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			size, err := s.warmKey(ctx, item.Key, "prefetch", func(size int64) bool {
+				if job.bytes.Add(size) > job.maxBytes {
+					job.bytes.Add(-size)
+					return false
+				}
+				return true
+			})
+			switch {
+			case errors.Is(err, errOverBudget), errors.Is(err, errNotCacheable):
+				job.skipped.Add(1)
+			case err != nil:
+				job.failed.Add(1)
+				s.logger.Warn("prefetch failed", "error", err, "key", item.Key, "job", job.id)
+			default:
+				job.completed.Add(1)
+				s.logger.Debug("prefetched", "key", item.Key, "size", size, "job", job.id)
+			}
+		}()
+	}
+	wg.Wait()
+
+	job.mu.Lock()
+	job.finishedAt = time.Now()
+	job.mu.Unlock()
+	s.logger.Info("prefetch job finished", "job", job.id, "total", job.total, "bytes", job.bytes.Load())
+}
+
+// warmKey fetches key from the origin and stores it in the cache, reporting
+// the fill with reason. reserve is consulted with the object size before the
+// body is read.
+func (s *Server) warmKey(ctx context.Context, key, reason string, reserve func(size int64) bool) (int64, error) {
+	obj, err := s.fetchFromOrigin(ctx, key, &origin.Conditional{}, http.MethodGet)
+	if err != nil {
+		return 0, err
+	}
+	defer obj.Body.Close()
+	if !s.cacheable(key, obj) {
+		return 0, errNotCacheable
+	}
+	if reserve != nil && !reserve(obj.ContentLength) {
+		return 0, errOverBudget
+	}
+	body, err := io.ReadAll(io.LimitReader(obj.Body, s.cfg.MaxObjectSize+1))
+	if err != nil {
+		return 0, err
+	}
+	if int64(len(body)) > s.cfg.MaxObjectSize {
+		return 0, errNotCacheable
+	}
+	s.cache.Set(cacheKey(key), s.newEntry(key, obj, body, time.Now()))
+	s.events.emit(eventFill, cacheKey(key), int64(len(body)), reason)
+	return int64(len(body)), nil
+}
+
+func newJobID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/joeychilson/s3-proxy/internal/config"
+)
+
+func TestPrefetchJob(t *testing.T) {
+	bucket := newFakeBucket(t)
+	for _, key := range []string{"low.bin", "high.bin", "mid.bin"} {
+		bucket.put(key, strings.Repeat("x", 40))
+	}
+	var mu sync.Mutex
+	var order []string
+	bucket.intercept(func(w http.ResponseWriter, r *http.Request, key string) bool {
+		mu.Lock()
+		order = append(order, key)
+		mu.Unlock()
+		return false
+	})
+	s := newBucketServer(t, bucket, &config.Config{PrefetchMaxBytes: 1000, PrefetchConcurrency: 4})
+	router := chi.NewRouter()
+	router.Post("/cache/prefetch", s.prefetchHandler)
+	router.Get("/cache/prefetch/{id}", s.prefetchStatusHandler)
+
+	// One at a time, so that the fetch order is the priority order, and a
+	// budget that fits two of the three objects.
+	body := `{"keys": [{"key": "low.bin", "priority": 1}, {"key": "/high.bin", "priority": 5},
+		{"key": "mid.bin", "priority": 3}, {"key": "missing.bin"}], "max_bytes": 100, "concurrency": 1}`
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/cache/prefetch", strings.NewReader(body)))
+	var status prefetchStatus
+	if err := json.NewDecoder(w.Body).Decode(&status); err != nil || w.Code != http.StatusAccepted {
+		t.Fatalf("unexpected response %d: %v", w.Code, err)
+	}
+	if w.Header().Get("Location") != "/cache/prefetch/"+status.ID || status.Total != 4 || status.MaxBytes != 100 || status.Concurrency != 1 {
+		t.Fatalf("unexpected job %+v at %q", status, w.Header().Get("Location"))
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for status.Status != "done" {
+		if time.Now().After(deadline) {
+			t.Fatalf("job did not finish: %+v", status)
+		}
+		time.Sleep(10 * time.Millisecond)
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/cache/prefetch/"+status.ID, nil))
+		if err := json.NewDecoder(w.Body).Decode(&status); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if status.Completed != 2 || status.Skipped != 1 || status.Failed != 1 || status.Bytes != 80 || status.FinishedAt.IsZero() {
+		t.Fatalf("unexpected status %+v", status)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if !slices.Equal(order, []string{"high.bin", "mid.bin", "low.bin", "missing.bin"}) {
+		t.Fatalf("expected keys fetched by priority, got %v", order)
+	}
+	for key, want := range map[string]bool{"high.bin": true, "mid.bin": true, "low.bin": false} {
+		if _, ok := s.cache.Get(cacheKey(key)); ok != want {
+			t.Fatalf("%s: cached %v, want %v", key, ok, want)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/cache/prefetch/unknown", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for an unknown job, got %d", w.Code)
+	}
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// purgeMatcher selects object keys by prefix or glob pattern. In patterns,
+// "*" matches any run of characters including "/", and "?" matches one.
+type purgeMatcher struct {
+	prefixes []string
+	patterns []*regexp.Regexp
+}
+
+func newPurgeMatcher(prefixes, patterns []string) (*purgeMatcher, error) {
+	m := &purgeMatcher{}
+	for _, prefix := range prefixes {
+		if prefix = strings.TrimSpace(prefix); prefix != "" {
+			m.prefixes = append(m.prefixes, prefix)
+		}
+	}
+	for _, pattern := range patterns {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		re, err := compileGlob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		m.patterns = append(m.patterns, re)
+	}
+	return m, nil
+}
+
+// compileGlob turns a glob pattern into an anchored regular expression.
+func compileGlob(pattern string) (*regexp.Regexp, error) {
+	expr := regexp.QuoteMeta(pattern)
+	expr = strings.ReplaceAll(expr, `\*`, `.*`)
+	expr = strings.ReplaceAll(expr, `\?`, `.`)
+	re, err := regexp.Compile("^" + expr + "$")
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern %q", pattern)
+	}
+	return re, nil
+}
+
+func (m *purgeMatcher) match(key string) bool {
+	for _, prefix := range m.prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	for _, re := range m.patterns {
+		if re.MatchString(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// cdnFiles maps a purge to the paths of a CDN in front of the proxy.
+// Prefixes become trailing wildcards, and patterns are widened to the
+// literal part before their first wildcard, since the CDN takes wildcards
+// only at the end of a path.
+func cdnFiles(payload purgeRequest) []string {
+	var files []string
+	for _, key := range payload.Keys {
+		if k := strings.TrimSpace(key); k != "" {
+			files = append(files, k)
+		}
+	}
+	for _, prefix := range payload.Prefixes {
+		if prefix = strings.TrimSpace(prefix); prefix != "" {
+			files = append(files, prefix+"*")
+		}
+	}
+	for _, pattern := range payload.Patterns {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		if i := strings.IndexAny(pattern, "*?"); i >= 0 {
+			pattern = pattern[:i] + "*"
+		}
+		files = append(files, pattern)
+	}
+	return files
+}
+
+// purgeMatching removes every cached object whose key matches, along with
+// the entries derived from it.
+func (s *Server) purgeMatching(m *purgeMatcher) int {
+	keys := s.matchingKeys(m)
+	for _, key := range keys {
+		s.purgeKey(key, "match")
+	}
+	return len(keys)
+}
+
+// matchingKeys returns the cached objects whose keys match, once each.
+func (s *Server) matchingKeys(m *purgeMatcher) []string {
+	seen := make(map[string]bool)
+	var keys []string
+	for _, cKey := range s.cache.Keys() {
+		if strings.HasPrefix(cKey, tagIndexPrefix) {
+			continue
+		}
+		key := objectKey(cKey)
+		if seen[key] || !m.match(key) {
+			continue
+		}
+		seen[key] = true
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// objectKey maps a cache key back to the object it was derived from.
+func objectKey(cKey string) string {
+	for {
+		i := strings.LastIndexByte(cKey, '#')
+		if i < 0 {
+			return cKey
+		}
+		suffix := cKey[i+1:]
+		if suffix != "error" && !strings.HasPrefix(suffix, "video-") && !strings.HasPrefix(suffix, "chunk-") && suffix != "segments" && !strings.HasPrefix(suffix, "segment-") && !strings.HasPrefix(suffix, "vary-") && !strings.HasPrefix(suffix, "enc-") && !strings.HasPrefix(suffix, "version-") {
+			return cKey
+		}
+		cKey = cKey[:i]
+	}
+}
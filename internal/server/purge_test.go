@@ -0,0 +1,54 @@
+package server
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestPurgeMatcher(t *testing.T) {
+	m, err := newPurgeMatcher([]string{"images/"}, []string{"*.css", "docs/v?/index.html"})
+	if err != nil {
+		t.Fatalf("new matcher: %v", err)
+	}
+	tests := map[string]bool{
+		"images/a.jpg":        true,
+		"static/site.css":     true,
+		"site.css":            true,
+		"docs/v1/index.html":  true,
+		"docs/v10/index.html": false,
+		"static/site.css.map": false,
+		"videos/images/a.jpg": false,
+	}
+	for key, want := range tests {
+		if got := m.match(key); got != want {
+			t.Fatalf("match(%q) = %v, want %v", key, got, want)
+		}
+	}
+}
+
+func TestCDNFiles(t *testing.T) {
+	got := cdnFiles(purgeRequest{Keys: []string{" a.jpg", ""}, Prefixes: []string{"images/"}, Patterns: []string{"*.css", "docs/v?/index.html", "robots.txt"}})
+	want := []string{"a.jpg", "images/*", "*", "docs/v*", "robots.txt"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("cdnFiles = %q, want %q", got, want)
+	}
+}
+
+func TestObjectKey(t *testing.T) {
+	tests := map[string]string{
+		"a/b.jpg":            "a/b.jpg",
+		"a/b.jpg#error":      "a/b.jpg",
+		"a/b.mp4#video-head": "a/b.mp4",
+		"a/b.bin#chunk-12":   "a/b.bin",
+		"a/b.bin#chunk-meta": "a/b.bin",
+		"a/b.bin#segments":   "a/b.bin",
+		"a.bin#segment-0-9":  "a.bin",
+		"a/b.txt#version-v1": "a/b.txt",
+		"notes#1.txt":        "notes#1.txt",
+	}
+	for cKey, want := range tests {
+		if got := objectKey(cKey); got != want {
+			t.Fatalf("objectKey(%q) = %q, want %q", cKey, got, want)
+		}
+	}
+}
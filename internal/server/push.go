@@ -0,0 +1,47 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+// newPusher returns a Pushgateway client for the metrics registry, grouped by
+// job and instance so that replicas do not overwrite each other.
+func (s *Server) newPusher() *push.Pusher {
+	return push.New(s.cfg.MetricsPushURL, s.cfg.MetricsPushJob).
+		Gatherer(s.registry).
+		Grouping("instance", s.cfg.MetricsPushInstance)
+}
+
+// pushMetrics pushes the registry every MetricsPushInterval until ctx is done.
+func (s *Server) pushMetrics(ctx context.Context) {
+	pusher := s.newPusher()
+	ticker := time.NewTicker(s.cfg.MetricsPushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.pushOnce(ctx, pusher)
+		}
+	}
+}
+
+// pushFinal pushes the registry one last time on shutdown, so the counts of
+// a short-lived instance are not lost between the last tick and exit.
+func (s *Server) pushFinal() {
+	ctx, cancel := context.WithTimeout(context.Background(), s.cfg.RequestTimeout)
+	defer cancel()
+	s.pushOnce(ctx, s.newPusher())
+}
+
+func (s *Server) pushOnce(ctx context.Context, pusher *push.Pusher) {
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.RequestTimeout)
+	defer cancel()
+	if err := pusher.PushContext(ctx); err != nil {
+		s.logger.Warn("metrics push failed", "error", err, "url", s.cfg.MetricsPushURL)
+	}
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+
+	"github.com/joeychilson/s3-proxy/internal/config"
+)
+
+func TestPushMetrics(t *testing.T) {
+	type push struct {
+		method, path string
+		hits         float64
+	}
+	var mu sync.Mutex
+	var pushes []push
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := push{method: r.Method, path: r.URL.Path, hits: -1}
+		dec := expfmt.NewDecoder(r.Body, expfmt.ResponseFormat(r.Header))
+		for {
+			var mf dto.MetricFamily
+			if err := dec.Decode(&mf); err != nil {
+				if !errors.Is(err, io.EOF) {
+					t.Errorf("unexpected error: %v", err)
+				}
+				break
+			}
+			if mf.GetName() == "proxy_cache_hits_total" {
+				p.hits = mf.GetMetric()[0].GetCounter().GetValue()
+			}
+		}
+		mu.Lock()
+		pushes = append(pushes, p)
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer gateway.Close()
+	received := func() []push {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]push(nil), pushes...)
+	}
+
+	registry := prometheus.NewRegistry()
+	s := &Server{
+		cfg: &config.Config{
+			MetricsPushURL:      gateway.URL,
+			MetricsPushInterval: 10 * time.Millisecond,
+			MetricsPushJob:      "s3-proxy",
+			MetricsPushInstance: "node-1",
+			RequestTimeout:      time.Second,
+		},
+		metrics:  newMetrics(registry),
+		registry: registry,
+		logger:   slog.New(slog.DiscardHandler),
+	}
+	s.metrics.cacheHits.Add(3)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.pushMetrics(ctx)
+		close(done)
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(received()) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected periodic pushes, got %v", received())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	s.metrics.cacheHits.Inc()
+	s.pushFinal()
+	got := received()
+	for _, p := range got {
+		if p.method != http.MethodPut || p.path != "/metrics/job/s3-proxy/instance/node-1" {
+			t.Fatalf("expected a PUT grouped by job and instance, got %s %s", p.method, p.path)
+		}
+	}
+	if got[0].hits != 3 || got[len(got)-1].hits != 4 {
+		t.Fatalf("expected the registry in the payload, got %+v", got)
+	}
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/joeychilson/s3-proxy/internal/cache"
+	"github.com/joeychilson/s3-proxy/internal/config"
+)
+
+func TestCachedRange(t *testing.T) {
+	store, err := cache.New(16, 1<<20, time.Minute, time.Minute)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s := &Server{cfg: &config.Config{}, cache: store, metrics: newMetrics(prometheus.NewRegistry()), layers: []string{layerMemory}}
+	store.Set(cacheKey("a.bin"), &cache.Entry{
+		Body:     []byte("hello"),
+		Header:   http.Header{"Etag": {`"v2"`}, "X-Amz-Checksum-Crc32": {"abc="}, "Content-Length": {"5"}},
+		ETag:     `"v2"`,
+		Status:   http.StatusOK,
+		StoredAt: time.Now(),
+		TTL:      time.Minute,
+		Size:     5,
+	})
+
+	tests := []struct {
+		rangeHeader, ifRange string
+		served               bool
+		status               int
+		body, contentRange   string
+	}{
+		{"bytes=1-2", "", true, http.StatusPartialContent, "el", "bytes 1-2/5"},
+		{"bytes=-2", `"v2"`, true, http.StatusPartialContent, "lo", "bytes 3-4/5"},
+		{"bytes=0-1", `"v1"`, true, http.StatusOK, "hello", ""},
+		{"bytes=0-1,3-4", "", false, 0, "", ""},
+		{"bytes=9-", "", false, 0, "", ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/a.bin", nil)
+		r.Header.Set("Range", tt.rangeHeader)
+		if tt.ifRange != "" {
+			r.Header.Set("If-Range", tt.ifRange)
+		}
+		w := httptest.NewRecorder()
+		if got := s.serveCachedRange(w, r, "a.bin"); got != tt.served {
+			t.Fatalf("%s: served = %v, want %v", tt.rangeHeader, got, tt.served)
+		}
+		if !tt.served {
+			continue
+		}
+		if w.Code != tt.status || w.Body.String() != tt.body || w.Header().Get("Content-Range") != tt.contentRange {
+			t.Fatalf("%s: got %d %q %q", tt.rangeHeader, w.Code, w.Body.String(), w.Header().Get("Content-Range"))
+		}
+		if tt.status == http.StatusPartialContent && (w.Header().Get("Content-Length") != strconv.Itoa(len(tt.body)) || w.Header().Get("X-Amz-Checksum-Crc32") != "") {
+			t.Fatalf("%s: unexpected headers %v", tt.rangeHeader, w.Header())
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/joeychilson/s3-proxy/internal/config"
+	"github.com/joeychilson/s3-proxy/internal/origin"
+)
+
+// ruleSet holds the settings that RULES_URL can change while the server
+// runs. It is replaced as a whole, so a request sees one version throughout.
+type ruleSet struct {
+	microCache []string
+	agents     *userAgentRules
+	rewrites   []rewriteRule
+}
+
+type rewriteRule struct {
+	pattern     *regexp.Regexp
+	replacement string
+}
+
+func (s *Server) currentRules() *ruleSet {
+	if rules := s.rules.Load(); rules != nil {
+		return rules
+	}
+	return &ruleSet{}
+}
+
+// applyRules installs rules, routing bucket prefixes alongside the virtual
+// host namespaces, which are not reloadable.
+func (s *Server) applyRules(rules config.Rules) error {
+	set := &ruleSet{microCache: rules.MicroCachePrefixes}
+	if len(rules.UserAgentDeny) > 0 || len(rules.UserAgentAllow) > 0 {
+		agents, err := newUserAgentRules(rules.UserAgentDeny, rules.UserAgentAllow)
+		if err != nil {
+			return fmt.Errorf("user agent rules: %w", err)
+		}
+		set.agents = agents
+	}
+	for _, rule := range rules.Rewrites {
+		pattern, err := regexp.Compile("^(?:" + rule.Pattern + ")$")
+		if err != nil {
+			return fmt.Errorf("rewrite rule %q: %w", rule.Pattern, err)
+		}
+		set.rewrites = append(set.rewrites, rewriteRule{pattern: pattern, replacement: rule.Replacement})
+	}
+	routes := make([]origin.Route, 0, len(rules.BucketRoutes)+len(s.cfg.HostBuckets))
+	for _, route := range rules.BucketRoutes {
+		routes = append(routes, origin.Route{Prefix: route.Prefix, Bucket: route.Bucket})
+	}
+	for _, hb := range s.cfg.HostBuckets {
+		routes = append(routes, origin.Route{Prefix: hostPrefix(hb.Host), Bucket: hb.Bucket})
+	}
+	s.origin.SetRoutes(routes)
+	s.rules.Store(set)
+	return nil
+}
+
+// rewrite maps path to the key given by the first rewrite rule matching the
+// whole path, or returns path unchanged.
+func (r *ruleSet) rewrite(path string) string {
+	for _, rule := range r.rewrites {
+		if m := rule.pattern.FindStringSubmatchIndex(path); m != nil {
+			return string(rule.pattern.ExpandString(nil, rule.replacement, path, m))
+		}
+	}
+	return path
+}
+
+// watchRules polls RULES_URL and REWRITE_RULES_FILE and applies the rules
+// whenever either changes. A source that fails to load or parse leaves the
+// running rules in place.
+func (s *Server) watchRules(ctx context.Context) {
+	w := &rulesWatcher{s: s, rewrites: s.cfg.Rewrites}
+	w.check(ctx)
+	ticker := time.NewTicker(s.cfg.RulesInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			w.check(ctx)
+		}
+	}
+}
+
+type rulesWatcher struct {
+	s        *Server
+	etag     string
+	body     []byte
+	modTime  time.Time
+	rewrites []config.RewriteRule
+}
+
+func (w *rulesWatcher) check(ctx context.Context) {
+	result := w.reload(ctx)
+	w.s.metrics.rulesReloads.WithLabelValues(result).Inc()
+}
+
+func (w *rulesWatcher) reload(ctx context.Context) string {
+	rewrites, modTime, fileChanged, err := w.loadRewrites()
+	if err != nil {
+		w.s.logger.Warn("load rewrite rules", "error", err, "file", w.s.cfg.RewriteRulesFile)
+		return "error"
+	}
+	body, etag, urlChanged, err := w.fetch(ctx)
+	if err != nil {
+		w.s.logger.Warn("fetch rules", "error", err, "url", w.s.cfg.RulesURL)
+		return "error"
+	}
+	if !fileChanged && !urlChanged {
+		return "unchanged"
+	}
+
+	rules := w.s.cfg.Rules()
+	if w.s.cfg.RewriteRulesFile != "" {
+		rules.Rewrites = rewrites
+	}
+	if body != nil {
+		rules, err = config.ParseRules(body, rules)
+	}
+	if err == nil {
+		err = w.s.applyRules(rules)
+	}
+	if err != nil {
+		w.s.logger.Warn("apply rules", "error", err, "url", w.s.cfg.RulesURL, "file", w.s.cfg.RewriteRulesFile)
+		return "error"
+	}
+	w.rewrites, w.modTime = rewrites, modTime
+	w.etag, w.body = etag, body
+	w.s.logger.Info("rules reloaded", "url", w.s.cfg.RulesURL, "etag", etag, "file", w.s.cfg.RewriteRulesFile)
+	return "applied"
+}
+
+// loadRewrites rereads REWRITE_RULES_FILE when its modification time has
+// changed since the rules were last applied.
+func (w *rulesWatcher) loadRewrites() ([]config.RewriteRule, time.Time, bool, error) {
+	path := w.s.cfg.RewriteRulesFile
+	if path == "" {
+		return nil, time.Time{}, false, nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, time.Time{}, false, err
+	}
+	if info.ModTime().Equal(w.modTime) {
+		return w.rewrites, w.modTime, false, nil
+	}
+	rewrites, err := config.LoadRewriteRules(path)
+	return rewrites, info.ModTime(), true, err
+}
+
+// fetch downloads the RULES_URL document unless its ETag or content is
+// unchanged.
+func (w *rulesWatcher) fetch(ctx context.Context) ([]byte, string, bool, error) {
+	if w.s.cfg.RulesURL == "" {
+		return nil, "", false, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, w.s.cfg.RequestTimeout)
+	defer cancel()
+	body, etag, err := w.s.fetchRules(ctx, w.s.cfg.RulesURL, w.etag)
+	if errors.Is(err, origin.ErrNotModified) || (err == nil && bytes.Equal(body, w.body)) {
+		return w.body, w.etag, false, nil
+	}
+	return body, etag, true, err
+}
+
+// fetchRules reads the rules document at src, either an http(s) URL or an
+// "s3:" key in the bucket. It returns origin.ErrNotModified when the
+// document still has the given ETag.
+func (s *Server) fetchRules(ctx context.Context, src, etag string) ([]byte, string, error) {
+	if key, ok := strings.CutPrefix(src, manifestS3Prefix); ok {
+		obj, err := s.origin.GetObject(ctx, strings.TrimPrefix(key, "/"), &origin.Conditional{IfNoneMatch: etag})
+		if err != nil {
+			return nil, "", err
+		}
+		defer obj.Body.Close()
+		body, err := io.ReadAll(obj.Body)
+		return body, obj.ETag, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, src, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		body, err := io.ReadAll(resp.Body)
+		return body, resp.Header.Get("ETag"), err
+	case http.StatusNotModified:
+		return nil, "", origin.ErrNotModified
+	default:
+		return nil, "", fmt.Errorf("unexpected status %s", resp.Status)
+	}
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/joeychilson/s3-proxy/internal/config"
+	"github.com/joeychilson/s3-proxy/internal/origin"
+)
+
+func TestRulesReload(t *testing.T) {
+	var notModified atomic.Int32
+	body := `{"micro_cache_prefixes": ["api/"]}`
+	rules := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified.Add(1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		io.WriteString(w, body)
+	}))
+	defer rules.Close()
+
+	client, err := origin.New(context.Background(), "http://127.0.0.1:1", "us-east-1", "key", "secret", "bucket", time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cfg := &config.Config{RulesURL: rules.URL, RequestTimeout: time.Second, UserAgentDeny: []string{"*bot*"}}
+	s := &Server{
+		cfg:     cfg,
+		origin:  origin.NewRouter(client, nil),
+		metrics: newMetrics(prometheus.NewRegistry()),
+		logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	if err := s.applyRules(cfg.Rules()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s.microCached("api/users") {
+		t.Fatalf("expected no micro-cache prefixes before the reload")
+	}
+
+	w := &rulesWatcher{s: s}
+	if result := w.reload(context.Background()); result != "applied" {
+		t.Fatalf("expected rules to be applied, got %q", result)
+	}
+	if !s.microCached("api/users") {
+		t.Fatalf("expected the reloaded micro-cache prefix to apply")
+	}
+	if s.currentRules().agents == nil {
+		t.Fatalf("expected settings missing from the document to keep their configured value")
+	}
+	if result := w.reload(context.Background()); result != "unchanged" || notModified.Load() != 1 {
+		t.Fatalf("expected a conditional fetch to report unchanged, got %q", result)
+	}
+
+	body = `{"bucket_routes": ["assets"]}`
+	w.etag = ""
+	if result := w.reload(context.Background()); result != "error" || !s.microCached("api/users") {
+		t.Fatalf("expected an invalid document to keep the running rules, got %q", result)
+	}
+}
+
+func TestRewriteRules(t *testing.T) {
+	s := &Server{cfg: &config.Config{}, origin: origin.NewRouter(nil, nil)}
+	err := s.applyRules(config.Rules{Rewrites: []config.RewriteRule{
+		{Pattern: `/v2/(.*)`, Replacement: "assets/$1"},
+		{Pattern: `/u/(?P<user>[^/]+)/avatar`, Replacement: "avatars/${user}.png"},
+	}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	rules := s.currentRules()
+	tests := map[string]string{
+		"/v2/css/site.css": "assets/css/site.css",
+		"/u/ada/avatar":    "avatars/ada.png",
+		"/u/ada/avatar/x":  "/u/ada/avatar/x",
+		"/api/v2/x":        "/api/v2/x",
+	}
+	for path, want := range tests {
+		if got := rules.rewrite(path); got != want {
+			t.Errorf("rewrite(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Security event types.
+const (
+	securityAuthFailure      = "auth_failure"
+	securitySignatureFailure = "signature_failure"
+	securityAccessDenied     = "access_denied"
+	securityRateLimited      = "rate_limited"
+	securityTraversal        = "traversal"
+	securityLockout          = "lockout"
+)
+
+// securityLog mirrors security events to an optional sink, one line each in
+// a fixed key=value layout that fail2ban filters can match with <HOST>.
+type securityLog struct {
+	mu  sync.Mutex
+	out io.WriteCloser
+}
+
+// newSecurityLog opens the sink named by dest: "" for none, "stdout", or a
+// file path that is appended to.
+func newSecurityLog(dest string) (*securityLog, error) {
+	l := &securityLog{}
+	switch dest {
+	case "":
+	case "stdout":
+		l.out = nopCloser{os.Stdout}
+	default:
+		f, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+		if err != nil {
+			return nil, fmt.Errorf("open security log: %w", err)
+		}
+		l.out = f
+	}
+	return l, nil
+}
+
+// write appends a line such as
+//
+//	2026-01-02T15:04:05Z s3-proxy security: event=auth_failure client=203.0.113.7 method=GET path="/metrics" reason=invalid
+//
+// The path is quoted so that a request cannot forge a line of its own.
+func (l *securityLog) write(now time.Time, event, client, method, path, reason string) {
+	if l == nil || l.out == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fmt.Fprintf(l.out, "%s s3-proxy security: event=%s client=%s method=%s path=%q reason=%s\n",
+		now.UTC().Format(time.RFC3339), event, client, method, path, reason)
+}
+
+// clientHost returns the client's address without its port, as resolved by
+// realIPMiddleware. Logging, rate limiting and lockouts all use it.
+func clientHost(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+func (l *securityLog) Close() error {
+	if l == nil || l.out == nil {
+		return nil
+	}
+	return l.out.Close()
+}
+
+// securityEvent records a rejected request that may be part of an attack.
+func (s *Server) securityEvent(r *http.Request, event, reason string) {
+	client := clientHost(r)
+	s.metrics.securityEvents.WithLabelValues(event).Inc()
+	s.logger.Warn("security event", "event", event, "client", client, "method", r.Method, "path", r.URL.Path, "reason", reason)
+	s.security.write(time.Now(), event, client, r.Method, r.URL.Path, reason)
+}
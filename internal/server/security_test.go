@@ -0,0 +1,59 @@
+package server
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/joeychilson/s3-proxy/internal/config"
+)
+
+func TestSecurityLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "security.log")
+	security, err := newSecurityLog(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s := &Server{
+		cfg:      &config.Config{},
+		authTok:  "admin",
+		security: security,
+		metrics:  newMetrics(prometheus.NewRegistry()),
+		logger:   slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	handler := s.authMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.RemoteAddr = "203.0.113.7:4000"
+	req.Header.Set("X-Auth-Token", "guess")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.URL.Path = "/a/../secret\n2026-01-01T00:00:00Z s3-proxy security: event=auth_failure client=198.51.100.1"
+	req.RemoteAddr = "203.0.113.8:4000"
+	if _, ok := s.requestKey(httptest.NewRecorder(), req); ok {
+		t.Fatalf("expected a traversal attempt to be refused")
+	}
+	security.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected one line per event, got %q", data)
+	}
+	if !strings.Contains(lines[0], ` s3-proxy security: event=auth_failure client=203.0.113.7 method=GET path="/metrics" reason=invalid`) {
+		t.Errorf("unexpected auth failure line %q", lines[0])
+	}
+	if !strings.Contains(lines[1], "event=traversal client=203.0.113.8 ") {
+		t.Errorf("unexpected traversal line %q", lines[1])
+	}
+}
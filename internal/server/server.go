@@ -4,10 +4,13 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"html/template"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-chi/chi/v5"
@@ -18,18 +21,50 @@ import (
 
 	"github.com/joeychilson/s3-proxy/internal/cache"
 	"github.com/joeychilson/s3-proxy/internal/config"
+	"github.com/joeychilson/s3-proxy/internal/jwt"
 	"github.com/joeychilson/s3-proxy/internal/origin"
+	"github.com/joeychilson/s3-proxy/internal/peer"
 )
 
 type Server struct {
 	cfg      *config.Config
-	origin   *origin.Client
-	cache    *cache.Cache
+	origin   *origin.Router
+	cache    cache.Store
 	metrics  *metrics
 	logger   *slog.Logger
 	registry *prometheus.Registry
 	authTok  string
 	limiter  *rateLimiter
+	inflight *concurrencyLimiter
+	prefetch *prefetchJobs
+	reval    *revalidations
+	flights  *flightGroup
+	fanouts  *fanouts
+	tagsMu   sync.Mutex
+	fills    *fillQueue
+	peers    *peer.Cluster
+	hosts    map[string]bool
+	networks []config.NetworkGroup
+	rules    atomic.Pointer[ruleSet]
+	pageTmpl *template.Template
+	access   *accessPolicy
+	ipFilter *ipFilter
+	keyed    map[string]bool
+	cors     *corsPolicy
+	verifier *jwt.Verifier
+	jwks     *jwt.JWKS
+	notify   []purgeNotifier
+	crawlers *crawlerClass
+	events   *eventLog
+	security *securityLog
+	certs    *certReloader
+	s3Certs  originCerts
+	clients  map[string]*origin.Client
+	lockouts *lockouts
+	activity *activity
+	layers   []string
+	versions atomic.Bool
+	ready    atomic.Bool
 	httpSrv  *http.Server
 	once     sync.Once
 }
@@ -39,51 +74,259 @@ func New(ctx context.Context, cfg *config.Config) (*Server, error) {
 	if err != nil {
 		return nil, fmt.Errorf("create origin client: %w", err)
 	}
+	hosts := make(map[string]bool, len(cfg.HostBuckets))
+	for _, hb := range cfg.HostBuckets {
+		hosts[hb.Host] = true
+	}
+
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelInfo}))
 
-	cacheStore, err := cache.New(cfg.CacheCapacity, cfg.CacheTTL, cfg.CacheStaleTTL)
+	cacheStore, err := newCacheStore(cfg, logger)
 	if err != nil {
 		return nil, fmt.Errorf("create cache: %w", err)
 	}
 
+	events, err := newEventLog(cfg.EventLog)
+	if err != nil {
+		return nil, err
+	}
+	security, err := newSecurityLog(cfg.SecurityLog)
+	if err != nil {
+		return nil, err
+	}
+	if memory, ok := cacheStore.(*cache.Cache); ok {
+		memory.OnEvict(func(key string, size int64) {
+			events.emit(eventEvict, key, size, "capacity")
+		})
+	}
+
 	registry := prometheus.NewRegistry()
 	registry.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
 	m := newMetrics(registry)
+	registerCacheMetrics(registry, cacheStore)
 
-	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	if cfg.ChecksumHeaders {
+		originClient.EnableChecksums()
+	}
+	tagging := originTagging(cfg)
+	limits := origin.HeaderLimits{MetaCount: cfg.OriginMetaHeaders, MetaBytes: cfg.OriginMetaBytes}
+	originClient.SetTagging(tagging)
+	originClient.SetHeaderLimits(limits)
+	originClient.SetCompat(origin.Compat(cfg.OriginCompat))
+	var s3Certs originCerts
+	if err := s3Certs.apply(originClient, cfg.OriginTLS); err != nil {
+		return nil, err
+	}
+	router := origin.NewRouter(originClient, nil)
+	clients := map[string]*origin.Client{origin.Primary: originClient}
+	if cfg.SecondaryBucket != "" {
+		secondary, err := origin.New(ctx, cfg.SecondaryEndpoint, cfg.SecondaryRegion, cfg.SecondaryAccessKey, cfg.SecondarySecretKey, cfg.SecondaryBucket, cfg.RequestTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("create secondary origin client: %w", err)
+		}
+		if cfg.ChecksumHeaders {
+			secondary.EnableChecksums()
+		}
+		secondary.SetTagging(tagging)
+		secondary.SetHeaderLimits(limits)
+		secondary.SetCompat(origin.Compat(cfg.OriginCompat))
+		if err := s3Certs.apply(secondary, cfg.SecondaryTLS); err != nil {
+			return nil, err
+		}
+		clients[origin.Secondary] = secondary
+		failover := origin.NewFailover(originClient, secondary, cfg.FailoverThreshold, cfg.FailoverCooldown)
+		failover.OnServe(func(name string) {
+			m.originServed.WithLabelValues(name).Inc()
+		})
+		router.Failover(failover)
+		registerFailoverMetrics(registry, failover)
+	}
+	if cfg.ReadBucket != "" {
+		replica, err := origin.New(ctx, cfg.ReadEndpoint, cfg.ReadRegion, cfg.ReadAccessKey, cfg.ReadSecretKey, cfg.ReadBucket, cfg.RequestTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("create read replica origin client: %w", err)
+		}
+		if cfg.ChecksumHeaders {
+			replica.EnableChecksums()
+		}
+		replica.SetTagging(tagging)
+		replica.SetHeaderLimits(limits)
+		replica.SetCompat(origin.Compat(cfg.OriginCompat))
+		if err := s3Certs.apply(replica, cfg.ReadTLS); err != nil {
+			return nil, err
+		}
+		clients[originRead] = replica
+		router.ReadReplica(replica, cfg.ReadReplicaLag)
+	}
 
 	srv := &Server{
 		cfg:      cfg,
-		origin:   originClient,
+		origin:   router,
+		hosts:    hosts,
+		networks: newNetworkGroups(cfg.NetworkGroups),
+		ipFilter: newIPFilter(cfg),
+		keyed:    newKeyedHeaders(cfg),
+		cors:     newCORSPolicy(cfg),
+		events:   events,
+		security: security,
+		activity: newActivity(),
 		cache:    cacheStore,
 		metrics:  m,
 		logger:   logger,
 		registry: registry,
+		s3Certs:  s3Certs,
+		clients:  clients,
 		authTok:  cfg.AuthToken,
+		prefetch: newPrefetchJobs(),
+		reval:    newRevalidations(cfg.RevalidateBackoffBase, cfg.RevalidateBackoffMax),
+		flights:  newFlightGroup(),
+		layers:   []string{layerMemory},
+	}
+
+	if policy := newAccessPolicy(cfg.AccessPolicy); !policy.public() {
+		srv.access = policy
+	}
+
+	if cfg.FanoutBufferSize > 0 {
+		srv.fanouts = newFanouts(cfg.FanoutBufferSize)
 	}
 
+	if cfg.CacheFillMode == config.FillModeBackground {
+		srv.fills = newFillQueue(cfg.FillQueueSize)
+	}
+
+	if cfg.PeersEnabled() {
+		srv.peers = peer.NewCluster(cfg.PeerSelfURL, cfg.Peers, cfg.AuthToken, cfg.PeerGossipInterval, logger)
+		srv.layers = append(srv.layers, layerPeer)
+		registerPeerMetrics(registry, srv.peers)
+	}
+
+	if err := srv.applyRules(cfg.Rules()); err != nil {
+		return nil, err
+	}
+
+	if cfg.JWTEnabled() {
+		if cfg.JWTJWKSURL != "" {
+			srv.jwks = jwt.NewJWKS(cfg.JWTJWKSURL, cfg.RequestTimeout)
+		}
+		srv.verifier = jwt.NewVerifier([]byte(cfg.JWTSecret), srv.jwks)
+	}
+
+	if srv.notify, err = newPurgeNotifiers(ctx, cfg); err != nil {
+		return nil, err
+	}
+
+	if cfg.ErrorTemplate != "" {
+		if srv.pageTmpl, err = srv.loadTemplate(ctx, cfg.ErrorTemplate); err != nil {
+			return nil, fmt.Errorf("error template: %w", err)
+		}
+	}
+
+	if cfg.TLSCertFile != "" {
+		if srv.certs, err = newCertReloader(cfg.TLSCertFile, cfg.TLSKeyFile); err != nil {
+			return nil, err
+		}
+		if cfg.TLSClientCA != "" {
+			if srv.certs.clientCAs, err = loadClientCAs(cfg.TLSClientCA); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if cfg.AuthLockoutThreshold > 0 {
+		srv.lockouts = newLockouts(cfg.AuthLockoutThreshold, cfg.AuthLockoutWindow, cfg.AuthLockoutDuration, cfg.AuthLockoutMax)
+	}
 	if cfg.RateLimitRPS > 0 {
 		srv.limiter = newRateLimiter(cfg.RateLimitRPS, cfg.RateLimitRPS)
 	}
+	if len(cfg.CrawlerUserAgents) > 0 {
+		if srv.crawlers, err = newCrawlerClass(cfg.CrawlerUserAgents, cfg.CrawlerRateLimitRPS, cfg.CrawlerCacheOnly); err != nil {
+			return nil, fmt.Errorf("crawler user agents: %w", err)
+		}
+	}
+	if cfg.MaxConcurrentPerIP > 0 || cfg.MaxConcurrentPerToken > 0 {
+		srv.inflight = newConcurrencyLimiter()
+	}
 
 	r := chi.NewRouter()
 	r.Use(middleware.RequestID)
-	r.Use(middleware.RealIP)
+	if srv.ipFilter != nil {
+		r.Use(srv.ipFilterMiddleware)
+	}
+	r.Use(srv.realIPMiddleware)
 	r.Use(middleware.Recoverer)
+	if cfg.PathPrefix != "" {
+		r.Use(srv.pathPrefixMiddleware)
+	}
+	if cfg.Tracing {
+		r.Use(srv.traceMiddleware)
+	}
 	r.Use(srv.logMiddleware)
-	if srv.limiter != nil {
+	r.Use(srv.bodyLimitMiddleware)
+	if srv.crawlers != nil {
+		r.Use(srv.classMiddleware)
+	}
+	if srv.limiter != nil || (srv.crawlers != nil && srv.crawlers.limiter != nil) {
 		r.Use(srv.rateLimitMiddleware)
 	}
+	if srv.inflight != nil {
+		r.Use(srv.concurrencyMiddleware)
+	}
+	if cfg.MinClientThroughput > 0 {
+		r.Use(srv.throughputMiddleware)
+	}
 
 	// Main endpoints
-	r.Method(http.MethodGet, "/*", http.HandlerFunc(srv.objectHandler))
-	r.Method(http.MethodHead, "/*", http.HandlerFunc(srv.objectHandler))
+	if err := srv.staticRoutes(r); err != nil {
+		return nil, err
+	}
+	var objects http.Handler = http.HandlerFunc(srv.objectHandler)
+	if len(cfg.ErrorPages) > 0 || srv.pageTmpl != nil {
+		objects = srv.errorPageMiddleware(objects)
+	}
+	writes := chi.Chain(srv.authMiddleware)
+	if srv.cors != nil {
+		objects = srv.corsMiddleware(objects)
+		writes = chi.Chain(srv.corsMiddleware, srv.authMiddleware)
+		r.Options("/*", srv.preflightHandler)
+	}
+	srv.shimRoutes(r, objects)
+	r.Method(http.MethodGet, "/*", objects)
+	r.Method(http.MethodHead, "/*", objects)
+	if cfg.WriteThrough {
+		r.With(writes...).Put("/*", srv.putHandler)
+		r.With(writes...).Delete("/*", srv.deleteHandler)
+	}
 
 	// Admin endpoints
-	r.With(srv.authMiddleware).Post("/cache/purge", srv.purgeHandler)
-	r.With(srv.authMiddleware).Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	r.With(srv.certAuthMiddleware).Post("/cache/purge", srv.purgeHandler)
+	r.With(srv.authMiddleware).Post("/cache/prefetch", srv.prefetchHandler)
+	r.With(srv.authMiddleware).Get("/cache/prefetch/{id}", srv.prefetchStatusHandler)
+	r.With(srv.authMiddleware).Get("/cache/events", srv.cacheEventsHandler)
+	r.With(srv.authMiddleware).Get("/admin/events", srv.adminEventsHandler)
+	r.With(srv.authMiddleware).Get("/admin/config/schema", srv.configSchemaHandler)
+	r.With(srv.authMiddleware).Post("/admin/credentials", srv.credentialsHandler)
+	if cfg.SigningSecret != "" {
+		r.With(srv.authMiddleware).Post("/admin/sign", srv.signHandler)
+	}
+	if srv.lockouts != nil {
+		r.With(srv.authMiddleware).Get("/admin/lockouts", srv.lockoutsHandler)
+		r.With(srv.authMiddleware).Post("/admin/unban", srv.unbanHandler)
+	}
+	r.With(srv.certAuthMiddleware).Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{EnableOpenMetrics: cfg.Tracing}))
+	r.With(srv.authMiddleware).Get("/_tar/*", srv.tarHandler)
+
+	// Peer endpoints
+	if srv.peers != nil {
+		r.With(srv.authMiddleware).Post("/_peer/gossip", srv.peers.HandleGossip)
+		r.With(srv.authMiddleware).Get("/_peer/objects/*", srv.peerObjectHandler)
+		r.With(srv.authMiddleware).Post("/_peer/purge", srv.peerPurgeHandler)
+		r.With(srv.authMiddleware).Post(handoffPath, srv.peerHandoffHandler)
+	}
 
 	// Health check endpoint
 	r.Get("/healthz", srv.healthHandler)
+	r.Get("/readyz", srv.readyHandler)
 
 	srv.httpSrv = &http.Server{
 		Addr:              cfg.Addr,
@@ -92,11 +335,63 @@ func New(ctx context.Context, cfg *config.Config) (*Server, error) {
 		WriteTimeout:      cfg.WriteTimeout,
 		IdleTimeout:       cfg.IdleTimeout,
 		ReadHeaderTimeout: 5 * time.Second,
+		Protocols:         serverProtocols(cfg),
+	}
+	if cfg.MaxConnLifetime > 0 {
+		lifetimes := newConnLifetimes(cfg.MaxConnLifetime, m.connsExpired.Inc)
+		srv.httpSrv.ConnContext = lifetimes.connContext
+		srv.httpSrv.ConnState = lifetimes.connState
 	}
 
 	return srv, nil
 }
 
+// serverProtocols returns the protocols the server accepts, or nil for the
+// defaults of HTTP/1.1 and, over TLS, HTTP/2. HTTP/3 is not offered, as the
+// standard library has no QUIC implementation.
+func serverProtocols(cfg *config.Config) *http.Protocols {
+	if !cfg.H2C {
+		return nil
+	}
+	// Cleartext HTTP/2 needs prior knowledge; Go does not implement the
+	// Upgrade: h2c handshake.
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	protocols.SetUnencryptedHTTP2(true)
+	return protocols
+}
+
+func originTagging(cfg *config.Config) origin.Tagging {
+	t := origin.Tagging{UserAgent: cfg.OriginUserAgent, RequestPayer: cfg.OriginRequestPayer}
+	for _, tag := range cfg.OriginTags {
+		t.Tags = append(t.Tags, origin.Tag{Key: tag.Key, Value: tag.Value})
+	}
+	return t
+}
+
+func newCacheStore(cfg *config.Config, logger *slog.Logger) (cache.Store, error) {
+	if cfg.CacheBackend != config.BackendRedis {
+		return cache.New(cfg.CacheCapacity, cfg.CacheMaxBytes, cfg.CacheTTL, cfg.CacheStaleTTL)
+	}
+	store, err := cache.NewRedis(cache.RedisOptions{
+		Addr:     cfg.RedisAddr,
+		Password: cfg.RedisPassword,
+		DB:       cfg.RedisDB,
+		Prefix:   cfg.RedisPrefix,
+		PoolSize: cfg.RedisPoolSize,
+		TTL:      cfg.CacheTTL,
+		StaleTTL: cfg.CacheStaleTTL,
+		Retain:   cfg.CacheStaleIfError,
+	})
+	if err != nil {
+		return nil, err
+	}
+	store.OnError(func(err error) {
+		logger.Warn("redis cache", "error", err)
+	})
+	return store, nil
+}
+
 func (s *Server) ListenAndServe(ctx context.Context) error {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
@@ -112,9 +407,51 @@ func (s *Server) ListenAndServe(ctx context.Context) error {
 		})
 	}()
 
-	s.logger.Info("server starting", "addr", s.cfg.Addr)
-	if err := s.httpSrv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+	s.startWarmJobs(ctx)
+	s.fillErrorPages()
+	if s.jwks != nil {
+		go s.refreshJWKS(ctx)
+	}
+	if s.cfg.RulesURL != "" || s.cfg.RewriteRulesFile != "" {
+		go s.watchRules(ctx)
+	}
+	if s.cfg.CacheWarmupManifest != "" {
+		go s.warmFromManifest(ctx)
+	} else {
+		s.ready.Store(true)
+	}
+	if s.fills != nil {
+		s.startFillWorkers(ctx)
+	}
+	if s.peers != nil {
+		go s.peers.Run(ctx)
+		if s.cfg.PeerDiscoveryDNS != "" {
+			go s.peers.Discover(ctx, net.DefaultResolver, s.cfg.PeerDiscoveryDNS, s.cfg.PeerDiscoveryInterval)
+		}
+	}
+
+	if s.cfg.MetricsPushURL != "" {
+		go s.pushMetrics(ctx)
+		defer s.pushFinal()
+	}
+	defer s.events.Close()
+	defer s.security.Close()
+
+	listeners, err := s.listen(ctx)
+	if err != nil {
+		return err
+	}
+	s.logger.Info("server starting", "addr", s.cfg.Addr, "tls", s.certs != nil, "listeners", len(listeners))
+	if s.certs != nil || len(s.s3Certs) > 0 {
+		go s.reloadCertificates(ctx)
+	}
+	if s.certs != nil {
+		s.httpSrv.TLSConfig = s.certs.tlsConfig()
+	}
+	err = s.serve(listeners)
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
 		return err
 	}
+	s.handoff()
 	return nil
 }
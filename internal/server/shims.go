@@ -0,0 +1,112 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/joeychilson/s3-proxy/internal/config"
+)
+
+// shimRoutes serves each configured package protocol under its mount,
+// mapping protocol paths onto keys and fixing up their content types. The
+// resolved key goes through objects, so access policies and error pages
+// apply to it as to any other read.
+func (s *Server) shimRoutes(r chi.Router, objects http.Handler) {
+	for _, shim := range s.cfg.ProtocolShims {
+		resolve := resolveGoModule
+		if shim.Kind == config.ShimNPM {
+			resolve = resolveNPM
+		}
+		handleRead(r, shim.Mount+"*", func(w http.ResponseWriter, r *http.Request) {
+			key, contentType, ok := resolve(strings.TrimPrefix(r.URL.Path, shim.Mount))
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			objects.ServeHTTP(&contentTypeWriter{ResponseWriter: w, contentType: contentType}, withPath(r, "/"+shim.Prefix+key))
+		})
+	}
+}
+
+// resolveGoModule maps a Go module proxy path (GOPROXY protocol) onto the
+// same layout in the bucket, which is the one `go mod download` writes to
+// GOMODCACHE/cache/download. Module paths arrive already case-encoded.
+func resolveGoModule(p string) (string, string, bool) {
+	if strings.HasSuffix(p, "/@latest") {
+		return p, "application/json", true
+	}
+	module, file, ok := strings.Cut(p, "/@v/")
+	if !ok || module == "" || strings.Contains(file, "/") {
+		return "", "", false
+	}
+	switch {
+	case file == "list":
+		return p, "text/plain; charset=utf-8", true
+	case strings.HasSuffix(file, ".info"):
+		return p, "application/json", true
+	case strings.HasSuffix(file, ".mod"):
+		return p, "text/plain; charset=utf-8", true
+	case strings.HasSuffix(file, ".zip"):
+		return p, "application/zip", true
+	}
+	return "", "", false
+}
+
+// resolveNPM maps npm registry paths onto keys: tarballs
+// (name/-/name-1.0.0.tgz) are stored as is, and a package's metadata
+// document under name/index.json. Scoped names (@scope/name) are two
+// segments.
+func resolveNPM(p string) (string, string, bool) {
+	if name, file, ok := strings.Cut(p, "/-/"); ok {
+		if !validNPMName(name) || file == "" || strings.Contains(file, "/") || !strings.HasSuffix(file, ".tgz") {
+			return "", "", false
+		}
+		return p, "application/octet-stream", true
+	}
+	name := strings.TrimSuffix(p, "/")
+	if !validNPMName(name) {
+		return "", "", false
+	}
+	return name + "/index.json", "application/json", true
+}
+
+func validNPMName(name string) bool {
+	segments := strings.Split(name, "/")
+	switch {
+	case len(segments) == 1:
+		return segments[0] != "" && !strings.HasPrefix(segments[0], "@")
+	case len(segments) == 2:
+		return len(segments[0]) > 1 && strings.HasPrefix(segments[0], "@") && segments[1] != ""
+	}
+	return false
+}
+
+// contentTypeWriter replaces the stored Content-Type of successful
+// responses, since objects mirrored into S3 rarely carry the one the
+// protocol's clients expect.
+type contentTypeWriter struct {
+	http.ResponseWriter
+	contentType string
+	wroteHeader bool
+}
+
+func (w *contentTypeWriter) WriteHeader(code int) {
+	if !w.wroteHeader && code < 300 {
+		w.Header().Set("Content-Type", w.contentType)
+	}
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *contentTypeWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *contentTypeWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
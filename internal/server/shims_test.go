@@ -0,0 +1,54 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/joeychilson/s3-proxy/internal/config"
+)
+
+func TestProtocolShims(t *testing.T) {
+	tests := []struct {
+		resolve     func(string) (string, string, bool)
+		path        string
+		key         string
+		contentType string
+	}{
+		{resolveGoModule, "github.com/!burnt!sushi/toml/@v/list", "github.com/!burnt!sushi/toml/@v/list", "text/plain; charset=utf-8"},
+		{resolveGoModule, "golang.org/x/text/@v/v0.3.0.info", "golang.org/x/text/@v/v0.3.0.info", "application/json"},
+		{resolveGoModule, "golang.org/x/text/@v/v0.3.0.mod", "golang.org/x/text/@v/v0.3.0.mod", "text/plain; charset=utf-8"},
+		{resolveGoModule, "golang.org/x/text/@v/v0.3.0.zip", "golang.org/x/text/@v/v0.3.0.zip", "application/zip"},
+		{resolveGoModule, "golang.org/x/text/@latest", "golang.org/x/text/@latest", "application/json"},
+		{resolveGoModule, "golang.org/x/text/@v/v0.3.0.tar", "", ""},
+		{resolveGoModule, "golang.org/x/text", "", ""},
+		{resolveNPM, "lodash", "lodash/index.json", "application/json"},
+		{resolveNPM, "@types/node", "@types/node/index.json", "application/json"},
+		{resolveNPM, "lodash/-/lodash-4.17.21.tgz", "lodash/-/lodash-4.17.21.tgz", "application/octet-stream"},
+		{resolveNPM, "@types/node/-/node-20.1.0.tgz", "@types/node/-/node-20.1.0.tgz", "application/octet-stream"},
+		{resolveNPM, "lodash/4.17.21", "", ""},
+		{resolveNPM, "@types", "", ""},
+	}
+	for _, tt := range tests {
+		key, contentType, ok := tt.resolve(tt.path)
+		if ok != (tt.key != "") || key != tt.key || contentType != tt.contentType {
+			t.Errorf("%s: expected %q (%s), got %q (%s, %v)", tt.path, tt.key, tt.contentType, key, contentType, ok)
+		}
+	}
+
+	s := &Server{cfg: &config.Config{ProtocolShims: []config.ProtocolShim{{Kind: config.ShimNPM, Mount: "/npm/", Prefix: "mirrors/npm/"}}}}
+	r := chi.NewRouter()
+	var gotPath string
+	s.shimRoutes(r, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		w.Header().Set("Content-Type", "binary/octet-stream")
+		w.Write([]byte("{}"))
+	}))
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/npm/@types%2fnode", nil))
+	if gotPath != "/mirrors/npm/@types/node/index.json" || rec.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("unexpected key %q served as %q", gotPath, rec.Header().Get("Content-Type"))
+	}
+}
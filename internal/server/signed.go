@@ -0,0 +1,83 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	expiresParam   = "expires"
+	signatureParam = "signature"
+
+	defaultSignedTTL = time.Hour
+)
+
+// signKey returns the hex HMAC-SHA256 of key and expires, a Unix time, under
+// secret. Applications signing URLs themselves must produce the same value.
+func signKey(secret, key string, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(key + "\n" + strconv.FormatInt(expires, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifySignature checks the expires and signature query parameters of a
+// request for key. It returns "" when they are valid, or why they are not:
+// "missing", "invalid" or "expired".
+func verifySignature(secret, key string, query url.Values, now time.Time) string {
+	expiresValue, signature := query.Get(expiresParam), query.Get(signatureParam)
+	if expiresValue == "" || signature == "" {
+		return "missing"
+	}
+	expires, err := strconv.ParseInt(expiresValue, 10, 64)
+	if err != nil || !hmac.Equal([]byte(signature), []byte(signKey(secret, key, expires))) {
+		return "invalid"
+	}
+	if now.Unix() > expires {
+		return "expired"
+	}
+	return ""
+}
+
+type signRequest struct {
+	Key string `json:"key"`
+	// ExpiresIn is the lifetime of the URL in seconds.
+	ExpiresIn int64 `json:"expires_in,omitempty"`
+}
+
+type signResponse struct {
+	URL     string `json:"url"`
+	Expires int64  `json:"expires"`
+}
+
+// signHandler mints a signed URL for a key, for applications that would
+// rather not compute signatures themselves.
+func (s *Server) signHandler(w http.ResponseWriter, r *http.Request) {
+	var payload signRequest
+	if !decodeJSON(w, r, &payload) {
+		return
+	}
+	key := strings.TrimLeft(payload.Key, "/")
+	if key == "" || payload.ExpiresIn < 0 {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	ttl := defaultSignedTTL
+	if payload.ExpiresIn > 0 {
+		ttl = time.Duration(payload.ExpiresIn) * time.Second
+	}
+	expires := time.Now().Add(ttl).Unix()
+	query := url.Values{}
+	query.Set(expiresParam, strconv.FormatInt(expires, 10))
+	query.Set(signatureParam, signKey(s.cfg.SigningSecret, key, expires))
+	u := url.URL{Path: s.cfg.PathPrefix + "/" + key, RawQuery: query.Encode()}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(signResponse{URL: u.String(), Expires: expires})
+}
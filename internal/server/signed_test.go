@@ -0,0 +1,66 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/joeychilson/s3-proxy/internal/config"
+)
+
+func TestSignedURLs(t *testing.T) {
+	s := &Server{
+		cfg:     &config.Config{SigningSecret: "secret", PathPrefix: "/files"},
+		access:  newAccessPolicy(config.AccessPolicy{Prefixes: map[string]string{"private/": config.AccessSigned}}),
+		authTok: "admin",
+		metrics: newMetrics(prometheus.NewRegistry()),
+		logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	rec := httptest.NewRecorder()
+	s.signHandler(rec, httptest.NewRequest(http.MethodPost, "/admin/sign", strings.NewReader(`{"key": "private/report.pdf", "expires_in": 60}`)))
+	var signed signResponse
+	if err := json.NewDecoder(rec.Body).Decode(&signed); err != nil {
+		t.Fatalf("decode sign response: %v", err)
+	}
+	u, err := url.Parse(signed.URL)
+	if err != nil || u.Path != "/files/private/report.pdf" {
+		t.Fatalf("unexpected signed URL %q", signed.URL)
+	}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.checkAccess(w, r, strings.TrimPrefix(r.URL.Path, "/"))
+	})
+	expired := strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10)
+	tests := []struct {
+		target string
+		header string
+		status int
+	}{
+		{"/private/report.pdf?" + u.RawQuery, "", http.StatusOK},
+		{"/private/other.pdf?" + u.RawQuery, "", http.StatusForbidden},
+		{"/private/report.pdf", "", http.StatusForbidden},
+		{"/private/report.pdf?expires=" + expired + "&signature=" + signKey("secret", "private/report.pdf", time.Now().Add(-time.Minute).Unix()), "", http.StatusForbidden},
+		{"/private/report.pdf", "admin", http.StatusOK},
+		{"/public/logo.png", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+		if tt.header != "" {
+			req.Header.Set("X-Auth-Token", tt.header)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tt.status {
+			t.Errorf("%s: expected status %d, got %d", tt.target, tt.status, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// throughputMiddleware aborts responses to clients that read slower than
+// MinClientThroughput once MinThroughputGrace has passed, so slow readers
+// cannot pin large cached bodies in memory indefinitely.
+func (s *Server) throughputMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tw := &throughputWriter{
+			ResponseWriter: w,
+			rc:             http.NewResponseController(w),
+			start:          time.Now(),
+			grace:          s.cfg.MinThroughputGrace,
+			rate:           float64(s.cfg.MinClientThroughput),
+			onSlow:         s.metrics.slowClients.Inc,
+		}
+		next.ServeHTTP(tw, r)
+	})
+}
+
+// throughputWriter gives every write a deadline by which the client must
+// have received it at the minimum average rate. Handlers see a timeout error
+// from Write and the connection is closed.
+type throughputWriter struct {
+	http.ResponseWriter
+	rc     *http.ResponseController
+	start  time.Time
+	grace  time.Duration
+	rate   float64
+	sent   int64
+	slow   bool
+	onSlow func()
+}
+
+func (tw *throughputWriter) Write(b []byte) (int, error) {
+	now := time.Now()
+	deadline := tw.start.Add(tw.grace + tw.duration(tw.sent+int64(len(b))))
+	// A handler that is itself waiting on the origin should not eat into
+	// the client's allowance, so each write gets at least its own share.
+	if floor := now.Add(time.Second + tw.duration(int64(len(b)))); deadline.Before(floor) {
+		deadline = floor
+	}
+	tw.rc.SetWriteDeadline(deadline)
+	n, err := tw.ResponseWriter.Write(b)
+	tw.sent += int64(n)
+	if err != nil && errors.Is(err, os.ErrDeadlineExceeded) && !tw.slow {
+		tw.slow = true
+		tw.onSlow()
+	}
+	return n, err
+}
+
+func (tw *throughputWriter) duration(bytes int64) time.Duration {
+	return time.Duration(float64(bytes) / tw.rate * float64(time.Second))
+}
+
+func (tw *throughputWriter) Unwrap() http.ResponseWriter {
+	return tw.ResponseWriter
+}
+
+// connLifetimes closes connections that have been open longer than the
+// configured lifetime, whether idle or mid-response.
+type connLifetimes struct {
+	lifetime time.Duration
+	onExpire func()
+
+	mu     sync.Mutex
+	timers map[net.Conn]*time.Timer
+}
+
+func newConnLifetimes(lifetime time.Duration, onExpire func()) *connLifetimes {
+	return &connLifetimes{lifetime: lifetime, onExpire: onExpire, timers: make(map[net.Conn]*time.Timer)}
+}
+
+// connContext is installed as http.Server.ConnContext to start the clock on
+// each accepted connection.
+func (l *connLifetimes) connContext(ctx context.Context, c net.Conn) context.Context {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.timers[c] = time.AfterFunc(l.lifetime, func() {
+		l.onExpire()
+		c.Close()
+	})
+	return ctx
+}
+
+// connState is installed as http.Server.ConnState to stop the clock once a
+// connection is gone.
+func (l *connLifetimes) connState(c net.Conn, state http.ConnState) {
+	if state != http.StateClosed && state != http.StateHijacked {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if t, ok := l.timers[c]; ok {
+		t.Stop()
+		delete(l.timers, c)
+	}
+}
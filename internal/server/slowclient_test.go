@@ -0,0 +1,31 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestConnLifetimes(t *testing.T) {
+	var expired atomic.Int32
+	l := newConnLifetimes(10*time.Millisecond, func() { expired.Add(1) })
+
+	old, peer := net.Pipe()
+	defer peer.Close()
+	l.connContext(context.Background(), old)
+	if _, err := peer.Read(make([]byte, 1)); err == nil {
+		t.Fatalf("expected the connection to be closed after its lifetime")
+	}
+
+	closed, peer2 := net.Pipe()
+	defer peer2.Close()
+	l.connContext(context.Background(), closed)
+	l.connState(closed, http.StateClosed)
+	time.Sleep(30 * time.Millisecond)
+	if expired.Load() != 1 || len(l.timers) != 1 {
+		t.Fatalf("expected only the open connection to expire, got %d expirations", expired.Load())
+	}
+}
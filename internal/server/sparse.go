@@ -0,0 +1,248 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joeychilson/s3-proxy/internal/cache"
+	"github.com/joeychilson/s3-proxy/internal/origin"
+)
+
+// maxSparseSegments bounds the segment index of one object. Ranges that
+// would grow it further are served but not cached.
+const maxSparseSegments = 1024
+
+// segment is a cached byte range of an object, both ends inclusive.
+type segment struct {
+	start, end int64
+}
+
+// serveSparseRange answers the range start-end from the segments cached for
+// key, fetching only the gaps between them from the origin. A range pieced
+// together from several segments is stored again as one, so that scrubbing
+// back and forth through a video settles into a few large segments.
+func (s *Server) serveSparseRange(w http.ResponseWriter, r *http.Request, key string, meta *cache.Entry, start, end int64, now time.Time) bool {
+	ctx := r.Context()
+	total := segmentTotal(meta)
+	segs := s.sparseSegments(key, meta, now)
+	var pieces [][]byte
+	var first *cache.Entry
+	fetched, noStore := false, false
+	for cursor := start; cursor <= end; {
+		seg, entry := s.sparseCovering(key, meta, &segs, cursor, now)
+		if entry == nil {
+			// Fetch up to the next cached segment, reading at least
+			// CHUNK_SIZE ahead so that sequential reads take few requests.
+			next := total
+			for _, other := range segs {
+				if other.start > cursor {
+					next = min(next, other.start)
+				}
+			}
+			seg = segment{cursor, min(max(end, cursor+s.cfg.ChunkSize-1), next-1)}
+			var err error
+			var stored bool
+			entry, stored, err = s.fetchSegment(ctx, key, meta, seg, len(segs) < maxSparseSegments, now)
+			if err != nil {
+				s.cache.Delete(chunkMetaKey(key))
+				s.logger.Warn("segment fetch", "error", err, "key", key)
+				return false
+			}
+			if stored {
+				segs = append(segs, seg)
+			} else {
+				noStore = true
+			}
+			fetched = true
+		}
+		if first == nil {
+			first = entry
+		}
+		hi := min(seg.end, end)
+		pieces = append(pieces, entry.Body[cursor-seg.start:hi-seg.start+1])
+		cursor = hi + 1
+	}
+
+	merged := len(pieces) > 1 && !noStore
+	if merged {
+		body := make([]byte, 0, end-start+1)
+		for _, piece := range pieces {
+			body = append(body, piece...)
+		}
+		segs = slices.DeleteFunc(segs, func(seg segment) bool {
+			if seg.start >= start && seg.end <= end {
+				s.cache.Delete(sparseSegmentKey(key, seg))
+				return true
+			}
+			return false
+		})
+		whole := segment{start, end}
+		s.cache.Set(sparseSegmentKey(key, whole), sparseEntry(meta, body, now))
+		segs = append(segs, whole)
+	}
+	if fetched || merged {
+		s.storeSparseIndex(key, meta, segs, now)
+	}
+	state := "HIT"
+	if fetched {
+		state = "MISS"
+		s.metrics.cacheMisses.Inc()
+	} else {
+		s.metrics.cacheHits.Inc()
+	}
+
+	copyHeaders(w.Header(), meta.Header)
+	w.Header().Del(objectSizeHeader)
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, total))
+	w.Header().Set("Content-Length", strconv.FormatInt(end-start+1, 10))
+	w.Header().Set("Age", strconv.Itoa(first.Age(now)))
+	s.setCacheStatus(w, stateLayer(state), state)
+	w.WriteHeader(http.StatusPartialContent)
+	for _, piece := range pieces {
+		if _, err := w.Write(piece); err != nil {
+			break
+		}
+	}
+	return true
+}
+
+// sparseCovering returns the cached segment reaching furthest past cursor
+// among those holding it. Segments that have left the cache or changed are
+// dropped from segs.
+func (s *Server) sparseCovering(key string, meta *cache.Entry, segs *[]segment, cursor int64, now time.Time) (segment, *cache.Entry) {
+	for {
+		best := -1
+		for i, seg := range *segs {
+			if seg.start <= cursor && cursor <= seg.end && (best < 0 || seg.end > (*segs)[best].end) {
+				best = i
+			}
+		}
+		if best < 0 {
+			return segment{}, nil
+		}
+		seg := (*segs)[best]
+		entry, ok := s.cache.Get(sparseSegmentKey(key, seg))
+		if ok && entry.Fresh(now) && entry.ETag == meta.ETag && int64(len(entry.Body)) == seg.end-seg.start+1 {
+			return seg, entry
+		}
+		*segs = slices.Delete(*segs, best, best+1)
+	}
+}
+
+// fetchSegment fetches seg from the origin, storing it when store is set and
+// the origin allows. It reports whether the segment was stored.
+func (s *Server) fetchSegment(ctx context.Context, key string, meta *cache.Entry, seg segment, store bool, now time.Time) (*cache.Entry, bool, error) {
+	cond := &origin.Conditional{Range: fmt.Sprintf("bytes=%d-%d", seg.start, seg.end)}
+	obj, err := s.fetchFromOrigin(ctx, key, cond, http.MethodGet)
+	if err != nil {
+		return nil, false, err
+	}
+	defer obj.Body.Close()
+	if obj.StatusCode != http.StatusPartialContent && seg.start > 0 {
+		return nil, false, errRangeIgnored
+	}
+	if !etagsEqual(obj.ETag, meta.ETag, true) || objectTotal(obj) != segmentTotal(meta) {
+		return nil, false, fmt.Errorf("object changed during sparse read")
+	}
+
+	body := make([]byte, seg.end-seg.start+1)
+	if _, err := io.ReadFull(obj.Body, body); err != nil {
+		return nil, false, err
+	}
+	entry := sparseEntry(meta, body, now)
+	store = store && !hasNoStore(obj.Headers)
+	if store {
+		s.cache.Set(sparseSegmentKey(key, seg), entry)
+	}
+	return entry, store, nil
+}
+
+func sparseEntry(meta *cache.Entry, body []byte, now time.Time) *cache.Entry {
+	return &cache.Entry{
+		Body:         body,
+		Status:       http.StatusPartialContent,
+		StoredAt:     now,
+		TTL:          meta.TTL,
+		StaleTTL:     meta.StaleTTL,
+		Size:         int64(len(body)),
+		ETag:         meta.ETag,
+		LastModified: meta.LastModified,
+	}
+}
+
+// sparseSegments returns the segments recorded for the version of key
+// described by meta. Index updates from concurrent requests may overwrite
+// each other; a segment left out of the index is only fetched again.
+func (s *Server) sparseSegments(key string, meta *cache.Entry, now time.Time) []segment {
+	index, ok := s.cache.Get(sparseIndexKey(key))
+	if !ok || !index.Fresh(now) || index.ETag != meta.ETag {
+		return nil
+	}
+	return parseSegments(string(index.Body))
+}
+
+func (s *Server) storeSparseIndex(key string, meta *cache.Entry, segs []segment, now time.Time) {
+	slices.SortFunc(segs, func(a, b segment) int {
+		return cmp.Compare(a.start, b.start)
+	})
+	parts := make([]string, len(segs))
+	for i, seg := range segs {
+		parts[i] = fmt.Sprintf("%d-%d", seg.start, seg.end)
+	}
+	body := []byte(strings.Join(parts, ","))
+	s.cache.Set(sparseIndexKey(key), &cache.Entry{
+		Body:     body,
+		Status:   http.StatusOK,
+		StoredAt: now,
+		TTL:      meta.TTL,
+		StaleTTL: meta.StaleTTL,
+		Size:     int64(len(body)),
+		ETag:     meta.ETag,
+	})
+}
+
+func (s *Server) purgeSegments(key string) {
+	index, ok := s.cache.Get(sparseIndexKey(key))
+	s.cache.Delete(sparseIndexKey(key))
+	if !ok {
+		return
+	}
+	for _, seg := range parseSegments(string(index.Body)) {
+		s.cache.Delete(sparseSegmentKey(key, seg))
+	}
+}
+
+// parseSegments reads an index written by storeSparseIndex, skipping
+// malformed entries.
+func parseSegments(list string) []segment {
+	var segs []segment
+	for part := range strings.SplitSeq(list, ",") {
+		first, last, found := strings.Cut(part, "-")
+		if !found {
+			continue
+		}
+		start, err1 := strconv.ParseInt(first, 10, 64)
+		end, err2 := strconv.ParseInt(last, 10, 64)
+		if err1 != nil || err2 != nil || start < 0 || end < start {
+			continue
+		}
+		segs = append(segs, segment{start, end})
+	}
+	return segs
+}
+
+func sparseIndexKey(key string) string {
+	return cacheKey(key) + "#segments"
+}
+
+func sparseSegmentKey(key string, seg segment) string {
+	return cacheKey(key) + "#segment-" + strconv.FormatInt(seg.start, 10) + "-" + strconv.FormatInt(seg.end, 10)
+}
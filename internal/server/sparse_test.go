@@ -0,0 +1,55 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/joeychilson/s3-proxy/internal/cache"
+	"github.com/joeychilson/s3-proxy/internal/config"
+)
+
+func TestSparseRange(t *testing.T) {
+	store, err := cache.New(16, 1<<20, time.Minute, time.Minute)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s := &Server{cfg: &config.Config{ChunkSize: 4}, cache: store, metrics: newMetrics(prometheus.NewRegistry()), layers: []string{layerMemory}}
+	now := time.Now()
+	meta := &cache.Entry{
+		Header:   http.Header{objectSizeHeader: {"10"}},
+		Status:   http.StatusOK,
+		StoredAt: now,
+		TTL:      time.Minute,
+		ETag:     `"v1"`,
+	}
+	for _, seg := range []segment{{0, 3}, {4, 9}} {
+		store.Set(sparseSegmentKey("a.bin", seg), sparseEntry(meta, []byte("0123456789"[seg.start:seg.end+1]), now))
+	}
+	s.storeSparseIndex("a.bin", meta, []segment{{4, 9}, {0, 3}}, now)
+
+	for range 2 {
+		w := httptest.NewRecorder()
+		if !s.serveSparseRange(w, httptest.NewRequest(http.MethodGet, "/a.bin", nil), "a.bin", meta, 2, 7, now) {
+			t.Fatalf("expected the range to be served from cached segments")
+		}
+		if w.Code != http.StatusPartialContent || w.Body.String() != "234567" || w.Header().Get("Content-Range") != "bytes 2-7/10" {
+			t.Fatalf("got %d %q %q", w.Code, w.Body.String(), w.Header().Get("Content-Range"))
+		}
+	}
+	if got := s.sparseSegments("a.bin", meta, now); !slices.Equal(got, []segment{{0, 3}, {2, 7}, {4, 9}}) {
+		t.Fatalf("expected the assembled range to be stored as one segment, got %v", got)
+	}
+	if got := parseSegments("0-3,x,5-4,6-9"); !slices.Equal(got, []segment{{0, 3}, {6, 9}}) {
+		t.Fatalf("unexpected segments %v", got)
+	}
+
+	s.purgeSegments("a.bin")
+	if _, ok := store.Get(sparseSegmentKey("a.bin", segment{2, 7})); ok {
+		t.Fatalf("expected purging to drop the segments")
+	}
+}
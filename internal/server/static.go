@@ -0,0 +1,112 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// indexRequest rewrites a request for "/" or "path/" to the index document
+// in that directory, so the bucket can be served as a static site.
+func (s *Server) indexRequest(r *http.Request) *http.Request {
+	if s.cfg.IndexDocument == "" || !strings.HasSuffix(r.URL.Path, "/") {
+		return r
+	}
+	return withPath(r, r.URL.Path+s.cfg.IndexDocument)
+}
+
+// serveFallback answers a GET or HEAD for a missing key with SPA_FALLBACK_KEY,
+// letting a single-page app route paths the bucket does not hold. Paths with
+// an asset extension still get 404, as does the fallback key itself.
+func (s *Server) serveFallback(w http.ResponseWriter, r *http.Request) bool {
+	fallback := s.cfg.SPAFallbackKey
+	if fallback == "" || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+		return false
+	}
+	if rctx := chi.RouteContext(r.Context()); rctx == nil || rctx.RoutePattern() != "/*" {
+		return false
+	}
+	key := strings.TrimPrefix(r.URL.Path, "/")
+	if key == fallback || s.isAssetKey(key) {
+		return false
+	}
+	s.objectHandler(w, withPath(r, "/"+fallback))
+	return true
+}
+
+func (s *Server) isAssetKey(key string) bool {
+	ext := path.Ext(key)
+	for _, candidate := range s.cfg.SPAAssetExtensions {
+		if strings.EqualFold(candidate, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// staticRoutes answers /robots.txt and /favicon.ico from inline content or
+// another key when configured, so that these frequent requests do not miss
+// against the bucket.
+func (s *Server) staticRoutes(r chi.Router) error {
+	switch {
+	case s.cfg.RobotsTxt != "":
+		handleRead(r, "/robots.txt", inlineHandler("robots.txt", "text/plain; charset=utf-8", []byte(s.cfg.RobotsTxt)))
+	case s.cfg.RobotsTxtKey != "":
+		handleRead(r, "/robots.txt", s.keyHandler(s.cfg.RobotsTxtKey))
+	}
+	switch {
+	case s.cfg.FaviconFile != "":
+		body, err := os.ReadFile(s.cfg.FaviconFile)
+		if err != nil {
+			return fmt.Errorf("read FAVICON_FILE: %w", err)
+		}
+		contentType := mime.TypeByExtension(filepath.Ext(s.cfg.FaviconFile))
+		if contentType == "" {
+			contentType = http.DetectContentType(body)
+		}
+		handleRead(r, "/favicon.ico", inlineHandler("favicon.ico", contentType, body))
+	case s.cfg.FaviconKey != "":
+		handleRead(r, "/favicon.ico", s.keyHandler(s.cfg.FaviconKey))
+	}
+	return nil
+}
+
+func handleRead(r chi.Router, pattern string, h http.HandlerFunc) {
+	r.Method(http.MethodGet, pattern, h)
+	r.Method(http.MethodHead, pattern, h)
+}
+
+// inlineHandler serves a fixed body with validators, without touching the
+// cache or the origin.
+func inlineHandler(name, contentType string, body []byte) http.HandlerFunc {
+	modTime := time.Now()
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+		http.ServeContent(w, r, name, modTime, bytes.NewReader(body))
+	}
+}
+
+// keyHandler serves key in place of the requested path.
+func (s *Server) keyHandler(key string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.objectHandler(w, withPath(r, "/"+key))
+	}
+}
+
+func withPath(r *http.Request, path string) *http.Request {
+	u := *r.URL
+	u.Path = path
+	u.RawPath = ""
+	r = r.WithContext(r.Context())
+	r.URL = &u
+	return r
+}
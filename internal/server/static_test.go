@@ -0,0 +1,108 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/joeychilson/s3-proxy/internal/cache"
+	"github.com/joeychilson/s3-proxy/internal/config"
+)
+
+func TestIndexRequest(t *testing.T) {
+	s := &Server{cfg: &config.Config{IndexDocument: "index.html"}}
+	tests := map[string]string{
+		"/":            "/index.html",
+		"/docs/":       "/docs/index.html",
+		"/docs/a.html": "/docs/a.html",
+		"/docs":        "/docs",
+	}
+	for path, want := range tests {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		if got := s.indexRequest(r).URL.Path; got != want {
+			t.Fatalf("%s: got %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestStaticRoutes(t *testing.T) {
+	dir := t.TempDir()
+	favicon := filepath.Join(dir, "favicon.ico")
+	if err := os.WriteFile(favicon, []byte{0, 0, 1, 0}, 0o644); err != nil {
+		t.Fatalf("write favicon: %v", err)
+	}
+	s := &Server{cfg: &config.Config{RobotsTxt: "User-agent: *\nDisallow: /private/\n", FaviconFile: favicon}}
+	r := chi.NewRouter()
+	if err := s.staticRoutes(r); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/robots.txt", nil))
+	if w.Code != http.StatusOK || w.Body.String() != s.cfg.RobotsTxt || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+		t.Fatalf("unexpected robots.txt response %d %q", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodHead, "/favicon.ico", nil))
+	if w.Code != http.StatusOK || w.Header().Get("Content-Length") != "4" {
+		t.Fatalf("unexpected favicon response %d %v", w.Code, w.Header())
+	}
+}
+
+func TestServeFallback(t *testing.T) {
+	store, err := cache.New(16, 1<<20, time.Minute, time.Minute)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s := &Server{
+		cfg:     &config.Config{SPAFallbackKey: "index.html", SPAAssetExtensions: []string{".js", ".png"}},
+		cache:   store,
+		metrics: newMetrics(prometheus.NewRegistry()),
+		layers:  []string{layerMemory},
+	}
+	store.Set(cacheKey("index.html"), &cache.Entry{
+		Body:     []byte("<html>app</html>"),
+		Header:   http.Header{"Content-Type": {"text/html"}},
+		Status:   http.StatusOK,
+		StoredAt: time.Now(),
+		TTL:      time.Minute,
+		Size:     16,
+	})
+	r := chi.NewRouter()
+	r.Get("/*", func(w http.ResponseWriter, r *http.Request) {
+		if !s.serveFallback(w, r) {
+			http.NotFound(w, r)
+		}
+	})
+	r.Get("/_tar/*", func(w http.ResponseWriter, r *http.Request) {
+		if !s.serveFallback(w, r) {
+			http.NotFound(w, r)
+		}
+	})
+
+	tests := map[string]int{
+		"/users/42":     http.StatusOK,
+		"/app.JS":       http.StatusNotFound,
+		"/logo.png":     http.StatusNotFound,
+		"/index.html":   http.StatusNotFound,
+		"/_tar/missing": http.StatusNotFound,
+	}
+	for path, want := range tests {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != want {
+			t.Fatalf("%s: got status %d, want %d", path, w.Code, want)
+		}
+		if want == http.StatusOK && w.Body.String() != "<html>app</html>" {
+			t.Fatalf("%s: unexpected body %q", path, w.Body.String())
+		}
+	}
+}
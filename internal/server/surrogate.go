@@ -0,0 +1,88 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// withSurrogate makes successful responses for key carry SURROGATE_CONTROL
+// and the key's surrogate keys, for a CDN in front of the proxy. Responses
+// to peers are left alone, since they do not reach the CDN.
+func (s *Server) withSurrogate(w http.ResponseWriter, r *http.Request, key string) http.ResponseWriter {
+	if s.cfg.SurrogateControl == "" && s.cfg.SurrogateKeyHeader == "" || isPeerRequest(r.Context()) {
+		return w
+	}
+	return &surrogateWriter{ResponseWriter: w, control: s.cfg.SurrogateControl, keyHeader: s.cfg.SurrogateKeyHeader, key: key}
+}
+
+// surrogateKeys returns the tags of key: the key itself and each directory
+// it is in, deepest first, so that a CDN purge by tag can mirror a purge of
+// the key or of a prefix ending in "/", followed by the object's own tags.
+// Surrogate-Key separates tags with spaces; Cache-Tag and Edge-Cache-Tag,
+// with commas.
+func surrogateKeys(key, header string, own []string) string {
+	tags := []string{surrogateTag(key)}
+	for dir := key; ; {
+		i := strings.LastIndexByte(strings.TrimSuffix(dir, "/"), '/')
+		if i < 0 {
+			break
+		}
+		dir = dir[:i+1]
+		tags = append(tags, surrogateTag(dir))
+	}
+	for _, tag := range own {
+		tags = append(tags, surrogateTag(tag))
+	}
+	if strings.EqualFold(header, "Surrogate-Key") {
+		return strings.Join(tags, " ")
+	}
+	return strings.Join(tags, ",")
+}
+
+// surrogateTag percent-encodes the bytes of s that cannot appear in a tag:
+// separators, "%" itself and anything outside printable ASCII.
+func surrogateTag(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c <= ' ' || c > '~' || c == ',' || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+type surrogateWriter struct {
+	http.ResponseWriter
+	control     string
+	keyHeader   string
+	key         string
+	wroteHeader bool
+}
+
+func (w *surrogateWriter) WriteHeader(code int) {
+	if !w.wroteHeader && code < 400 {
+		if w.control != "" {
+			w.Header().Set("Surrogate-Control", w.control)
+		}
+		if w.keyHeader != "" {
+			w.Header().Set(w.keyHeader, surrogateKeys(w.key, w.keyHeader, objectTags(w.Header())))
+		}
+	}
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *surrogateWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *surrogateWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
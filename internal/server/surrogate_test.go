@@ -0,0 +1,31 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/joeychilson/s3-proxy/internal/config"
+)
+
+func TestSurrogate(t *testing.T) {
+	if got := surrogateKeys("docs/v1/read me.pdf", "Surrogate-Key", nil); got != "docs/v1/read%20me.pdf docs/v1/ docs/" {
+		t.Fatalf("unexpected surrogate keys %q", got)
+	}
+	if got := surrogateKeys("a,b.txt", "Cache-Tag", []string{"release-42"}); got != "a%2Cb.txt,release-42" {
+		t.Fatalf("unexpected cache tags %q", got)
+	}
+
+	s := &Server{cfg: &config.Config{SurrogateControl: "max-age=86400", SurrogateKeyHeader: "Surrogate-Key"}}
+	r := httptest.NewRequest(http.MethodGet, "/images/a.jpg", nil)
+	rec := httptest.NewRecorder()
+	s.withSurrogate(rec, r, "images/a.jpg").WriteHeader(http.StatusOK)
+	if rec.Header().Get("Surrogate-Control") != "max-age=86400" || rec.Header().Get("Surrogate-Key") != "images/a.jpg images/" {
+		t.Fatalf("expected surrogate headers, got %v", rec.Header())
+	}
+	rec = httptest.NewRecorder()
+	http.NotFound(s.withSurrogate(rec, r, "images/a.jpg"), r)
+	if rec.Header().Get("Surrogate-Control") != "" || rec.Header().Get("Surrogate-Key") != "" {
+		t.Fatalf("expected no surrogate headers on errors, got %v", rec.Header())
+	}
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/joeychilson/s3-proxy/internal/cache"
+)
+
+// surrogateKeyMeta is the object metadata listing an object's own tags,
+// separated by spaces or commas.
+const surrogateKeyMeta = "X-Amz-Meta-Surrogate-Key"
+
+// maxTagKeys bounds the keys recorded per tag. Objects tagged beyond it are
+// still cached but not purged by the tag.
+const maxTagKeys = 4096
+
+// tagIndexPrefix starts the cache keys of tag indexes. Object keys holding
+// ".." are refused, so no object is cached under it.
+const tagIndexPrefix = "../tag/"
+
+// objectTags returns the tags an object declares in its metadata.
+func objectTags(h http.Header) []string {
+	var tags []string
+	for _, tag := range strings.FieldsFunc(h.Get(surrogateKeyMeta), func(r rune) bool { return r == ' ' || r == ',' }) {
+		if !slices.Contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// indexTags records key under each tag its entry declares. The index of a
+// tag lives as long as the longest-lived entry recorded in it. Updates are
+// serialized on this node only; replicas sharing Redis may overwrite each
+// other's additions.
+func (s *Server) indexTags(key string, entry *cache.Entry) {
+	tags := objectTags(entry.Header)
+	if len(tags) == 0 {
+		return
+	}
+	now := time.Now()
+	s.tagsMu.Lock()
+	defer s.tagsMu.Unlock()
+	for _, tag := range tags {
+		keys, index := s.tagIndex(tag)
+		expires := entry.StoredAt.Add(entry.TTL + entry.StaleTTL)
+		if !expires.After(now) {
+			continue
+		}
+		if index != nil {
+			indexExpires := index.StoredAt.Add(index.TTL)
+			if slices.Contains(keys, key) && !expires.After(indexExpires) {
+				continue
+			}
+			if indexExpires.After(expires) {
+				expires = indexExpires
+			}
+		}
+		if !slices.Contains(keys, key) && len(keys) < maxTagKeys {
+			keys = append(keys, key)
+		}
+		body, err := json.Marshal(keys)
+		if err != nil {
+			continue
+		}
+		s.cache.Set(tagIndexKey(tag), &cache.Entry{
+			Body:     body,
+			Status:   http.StatusOK,
+			StoredAt: now,
+			TTL:      expires.Sub(now),
+			Size:     int64(len(body)),
+		})
+	}
+}
+
+// tagIndex returns the keys recorded under tag and the entry holding them.
+func (s *Server) tagIndex(tag string) ([]string, *cache.Entry) {
+	index, ok := s.cache.Get(tagIndexKey(tag))
+	if !ok {
+		return nil, nil
+	}
+	var keys []string
+	if err := json.Unmarshal(index.Body, &keys); err != nil {
+		return nil, nil
+	}
+	return keys, index
+}
+
+// taggedKeys returns the keys recorded under any of tags.
+func (s *Server) taggedKeys(tags []string) []string {
+	var keys []string
+	for _, tag := range tags {
+		if tag = strings.TrimSpace(tag); tag == "" {
+			continue
+		}
+		tagged, _ := s.tagIndex(tag)
+		for _, key := range tagged {
+			if !slices.Contains(keys, key) {
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys
+}
+
+// purgeTags removes every cached object recorded under tags, and the tags'
+// indexes, returning how many objects were purged.
+func (s *Server) purgeTags(tags []string) int {
+	keys := s.taggedKeys(tags)
+	for _, key := range keys {
+		s.purgeKey(key, "tag")
+	}
+	for _, tag := range tags {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			s.cache.Delete(tagIndexKey(tag))
+		}
+	}
+	return len(keys)
+}
+
+func tagIndexKey(tag string) string {
+	return tagIndexPrefix + tag
+}
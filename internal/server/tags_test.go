@@ -0,0 +1,66 @@
+package server
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/joeychilson/s3-proxy/internal/cache"
+	"github.com/joeychilson/s3-proxy/internal/config"
+	"github.com/joeychilson/s3-proxy/internal/origin"
+)
+
+func TestTagPurge(t *testing.T) {
+	store, err := cache.New(16, 1<<20, time.Minute, time.Minute)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	events, _ := newEventLog("")
+	s := &Server{
+		cfg:     &config.Config{CacheTTL: time.Minute},
+		cache:   store,
+		metrics: newMetrics(prometheus.NewRegistry()),
+		logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+		events:  events,
+	}
+	for key, tags := range map[string]string{"a.html": "release-42 landing", "b.css": "release-42,release-42", "c.js": "", "d.js": "landing"} {
+		obj := &origin.Object{Headers: http.Header{}, StatusCode: http.StatusOK}
+		if tags != "" {
+			obj.Headers.Set("x-amz-meta-surrogate-key", tags)
+		}
+		store.Set(cacheKey(key), s.newEntry(key, obj, []byte("x"), time.Now()))
+	}
+	keys := s.taggedKeys([]string{"release-42"})
+	slices.Sort(keys)
+	if !slices.Equal(keys, []string{"a.html", "b.css"}) {
+		t.Fatalf("unexpected keys for the tag %q", keys)
+	}
+
+	rec := httptest.NewRecorder()
+	s.purgeHandler(rec, httptest.NewRequest(http.MethodPost, "/cache/purge", strings.NewReader(`{"tags":["release-42"]}`)))
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("unexpected status %d", rec.Code)
+	}
+	for key, want := range map[string]bool{"a.html": false, "b.css": false, "c.js": true, "d.js": true} {
+		if _, ok := store.Get(cacheKey(key)); ok != want {
+			t.Fatalf("%s: cached = %v, want %v", key, ok, want)
+		}
+	}
+	if keys := s.taggedKeys([]string{"release-42"}); len(keys) != 0 {
+		t.Fatalf("expected the purged tag's index to go, got %q", keys)
+	}
+	if keys := s.taggedKeys([]string{"landing"}); len(keys) != 2 {
+		t.Fatalf("expected other tags to keep their index, got %q", keys)
+	}
+	s.purgeMatching(&purgeMatcher{prefixes: []string{""}})
+	if _, ok := store.Get(tagIndexKey("landing")); !ok {
+		t.Fatalf("expected a prefix purge to leave tag indexes alone")
+	}
+}
@@ -0,0 +1,166 @@
+package server
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/joeychilson/s3-proxy/internal/origin"
+)
+
+type tarResult struct {
+	info origin.ObjectInfo
+	obj  *origin.Object
+	err  error
+}
+
+func (s *Server) tarHandler(w http.ResponseWriter, r *http.Request) {
+	prefix := chi.URLParam(r, "*")
+	if strings.Contains(prefix, "..") {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	listed, err := s.origin.ListObjects(ctx, prefix)
+	if err != nil {
+		s.handleOriginError(w, r, err, nil, time.Now(), "")
+		return
+	}
+	// Denied keys are left out, since the admin token does not open them.
+	objects := listed[:0]
+	for _, info := range listed {
+		if !strings.HasSuffix(info.Key, "/") && !s.denied(info.Key) {
+			objects = append(objects, info)
+		}
+	}
+	if len(objects) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	results := s.fetchTarObjects(ctx, objects)
+	next := 0
+	defer func() {
+		cancel()
+		for _, ch := range results[next:] {
+			if res := <-ch; res.obj != nil {
+				res.obj.Body.Close()
+			}
+		}
+	}()
+
+	// The first object is opened before the status is sent, so that an
+	// origin refusing it still gets an error response.
+	res := <-results[0]
+	next++
+	if res.err != nil {
+		s.handleOriginError(w, r, res.err, nil, time.Now(), "")
+		return
+	}
+
+	name := path.Base(strings.TrimSuffix(prefix, "/"))
+	if name == "." || name == "/" || name == "" {
+		name = "bucket"
+	}
+	w.Header().Set("Content-Type", "application/x-tar")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`.tar"`)
+	s.setCacheStatus(w, layerOrigin, "BYPASS")
+	w.WriteHeader(http.StatusOK)
+
+	// Past the status line, breaking the connection is the only way left to
+	// tell the client that the archive is incomplete.
+	tw := tar.NewWriter(w)
+	for {
+		if res.err != nil {
+			s.logger.Error("tar fetch", "error", res.err, "key", res.info.Key)
+			panic(http.ErrAbortHandler)
+		}
+		_, err := writeTarEntry(tw, res.info, res.obj)
+		res.obj.Body.Close()
+		if err != nil {
+			s.logger.Error("tar write", "error", err, "key", res.info.Key)
+			panic(http.ErrAbortHandler)
+		}
+		if next == len(results) {
+			break
+		}
+		res = <-results[next]
+		next++
+	}
+	if err := tw.Close(); err != nil {
+		s.logger.Error("tar close", "error", err, "prefix", prefix)
+	}
+}
+
+// fetchTarObjects opens object bodies ahead of the writer, keeping at most
+// TarConcurrency bodies open at once. Results are delivered in listing order.
+func (s *Server) fetchTarObjects(ctx context.Context, objects []origin.ObjectInfo) []chan tarResult {
+	results := make([]chan tarResult, len(objects))
+	for i := range results {
+		results[i] = make(chan tarResult, 1)
+	}
+	go func() {
+		sem := make(chan struct{}, s.cfg.TarConcurrency)
+		for i, info := range objects {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				for _, ch := range results[i:] {
+					ch <- tarResult{info: info, err: ctx.Err()}
+				}
+				return
+			}
+			go func() {
+				obj, err := s.fetchFromOrigin(ctx, info.Key, &origin.Conditional{}, http.MethodGet)
+				if err == nil {
+					obj.Body = &releaseReadCloser{ReadCloser: obj.Body, release: func() { <-sem }}
+				} else {
+					<-sem
+				}
+				results[i] <- tarResult{info: info, obj: obj, err: err}
+			}()
+		}
+	}()
+	return results
+}
+
+func writeTarEntry(tw *tar.Writer, info origin.ObjectInfo, obj *origin.Object) (int64, error) {
+	size := obj.ContentLength
+	if size <= 0 {
+		size = info.Size
+	}
+	hdr := &tar.Header{
+		Name:     info.Key,
+		Mode:     0o644,
+		Size:     size,
+		ModTime:  info.LastModified,
+		Typeflag: tar.TypeReg,
+		Format:   tar.FormatPAX,
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return 0, err
+	}
+	return io.CopyN(tw, obj.Body, size)
+}
+
+type releaseReadCloser struct {
+	io.ReadCloser
+	release func()
+}
+
+func (r *releaseReadCloser) Close() error {
+	if r.release != nil {
+		r.release()
+		r.release = nil
+	}
+	return r.ReadCloser.Close()
+}
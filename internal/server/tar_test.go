@@ -0,0 +1,86 @@
+package server
+
+import (
+	"archive/tar"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/joeychilson/s3-proxy/internal/config"
+)
+
+func TestTarHandler(t *testing.T) {
+	bucket := newFakeBucket(t)
+	bucket.put("logs/a.txt", "first")
+	bucket.put("logs/b.txt", "second")
+	bucket.put("logs/c.txt", "third")
+	s := newBucketServer(t, bucket, &config.Config{TarConcurrency: 2})
+	router := chi.NewRouter()
+	router.Get("/_tar/*", s.tarHandler)
+	proxy := httptest.NewServer(router)
+	defer proxy.Close()
+
+	resp, err := http.Get(proxy.URL + "/_tar/logs/")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tr := tar.NewReader(resp.Body)
+	var got []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		body, _ := io.ReadAll(tr)
+		got = append(got, hdr.Name+"="+string(body))
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || len(got) != 3 || got[0] != "logs/a.txt=first" || got[2] != "logs/c.txt=third" {
+		t.Fatalf("unexpected archive %d %v", resp.StatusCode, got)
+	}
+
+	// A first object that cannot be opened is reported with a status.
+	bucket.fail("logs/a.txt", http.StatusForbidden)
+	resp, err = http.Get(proxy.URL + "/_tar/logs/")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Fatalf("expected 502 for an unreadable first object, got %d", resp.StatusCode)
+	}
+	bucket.fail("logs/a.txt", 0)
+
+	// Later failures break the connection instead of ending the archive.
+	for name, fail := range map[string]func(){
+		"failed fetch": func() { bucket.fail("logs/c.txt", http.StatusInternalServerError) },
+		"short body": func() {
+			bucket.intercept(func(w http.ResponseWriter, r *http.Request, key string) bool {
+				if key != "logs/b.txt" {
+					return false
+				}
+				w.Header().Set("Content-Length", "6")
+				io.WriteString(w, "sec")
+				return true
+			})
+		},
+	} {
+		fail()
+		resp, err := http.Get(proxy.URL + "/_tar/logs/")
+		if err == nil {
+			_, err = io.ReadAll(resp.Body)
+			resp.Body.Close()
+		}
+		if err == nil {
+			t.Fatalf("%s: expected the connection to break, got a complete archive", name)
+		}
+		bucket.fail("logs/c.txt", 0)
+		bucket.intercept(nil)
+	}
+}
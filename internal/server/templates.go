@@ -0,0 +1,72 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/go-chi/chi/v5/middleware"
+
+	"github.com/joeychilson/s3-proxy/internal/origin"
+)
+
+// errorPageData is what ERROR_TEMPLATE is executed with.
+type errorPageData struct {
+	Status     int
+	StatusText string
+	Path       string
+	RequestID  string
+}
+
+// loadTemplate parses the html/template at src, a file path or "s3:" and a
+// key in the bucket.
+func (s *Server) loadTemplate(ctx context.Context, src string) (*template.Template, error) {
+	var data []byte
+	if key, ok := strings.CutPrefix(src, manifestS3Prefix); ok {
+		ctx, cancel := context.WithTimeout(ctx, s.cfg.RequestTimeout)
+		defer cancel()
+		obj, err := s.origin.GetObject(ctx, strings.TrimPrefix(key, "/"), &origin.Conditional{})
+		if err != nil {
+			return nil, fmt.Errorf("fetch template %s: %w", src, err)
+		}
+		defer obj.Body.Close()
+		if data, err = io.ReadAll(obj.Body); err != nil {
+			return nil, fmt.Errorf("fetch template %s: %w", src, err)
+		}
+	} else {
+		var err error
+		if data, err = os.ReadFile(src); err != nil {
+			return nil, fmt.Errorf("read template: %w", err)
+		}
+	}
+	tmpl, err := template.New(src).Parse(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("parse template %s: %w", src, err)
+	}
+	return tmpl, nil
+}
+
+// renderErrorPage executes ERROR_TEMPLATE for status. It returns nil when no
+// template is configured or it fails, leaving the handler's own body.
+func (s *Server) renderErrorPage(r *http.Request, status int) []byte {
+	if s.pageTmpl == nil {
+		return nil
+	}
+	var buf bytes.Buffer
+	err := s.pageTmpl.Execute(&buf, errorPageData{
+		Status:     status,
+		StatusText: http.StatusText(status),
+		Path:       r.URL.Path,
+		RequestID:  middleware.GetReqID(r.Context()),
+	})
+	if err != nil {
+		s.logger.Warn("render error template", "error", err, "status", status)
+		return nil
+	}
+	return buf.Bytes()
+}
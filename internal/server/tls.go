@@ -0,0 +1,145 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/joeychilson/s3-proxy/internal/config"
+	"github.com/joeychilson/s3-proxy/internal/origin"
+)
+
+// certReloader serves the certificate in TLS_CERT_FILE and TLS_KEY_FILE,
+// reading them again on SIGHUP so that renewed certificates are picked up
+// without dropping connections.
+type certReloader struct {
+	certFile  string
+	keyFile   string
+	cert      atomic.Pointer[tls.Certificate]
+	clientCAs *x509.CertPool
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	c := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// load replaces the served certificate. On error the previous one stays in
+// use.
+func (c *certReloader) load() error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("load TLS certificate: %w", err)
+	}
+	c.cert.Store(&cert)
+	return nil
+}
+
+func (c *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return c.cert.Load(), nil
+}
+
+// tlsConfig asks for a client certificate when TLS_CLIENT_CA_FILE is set but
+// does not require one: object requests go without, and admin requests can
+// still use the token.
+func (c *certReloader) tlsConfig() *tls.Config {
+	cfg := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: c.getCertificate,
+	}
+	if c.clientCAs != nil {
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+		cfg.ClientCAs = c.clientCAs
+	}
+	return cfg
+}
+
+func loadClientCAs(file string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("load client CAs: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("load client CAs: no certificates in %s", file)
+	}
+	return pool, nil
+}
+
+// clientCertified reports whether the request came over a connection whose
+// client certificate chains to TLS_CLIENT_CA_FILE.
+func clientCertified(r *http.Request) bool {
+	return r.TLS != nil && len(r.TLS.VerifiedChains) > 0
+}
+
+// originCerts are the client certificates presented to origins, one per
+// pair of files.
+type originCerts []*origin.ClientCert
+
+// apply sets up client for the origin TLS settings t.
+func (certs *originCerts) apply(client *origin.Client, t config.OriginTLS) error {
+	var cert *origin.ClientCert
+	if t.CertFile != "" {
+		for _, c := range *certs {
+			if c.CertFile() == t.CertFile {
+				cert = c
+			}
+		}
+		if cert == nil {
+			var err error
+			if cert, err = origin.LoadClientCert(t.CertFile, t.KeyFile); err != nil {
+				return err
+			}
+			*certs = append(*certs, cert)
+		}
+	}
+	var rootCAs *x509.CertPool
+	if t.CAFile != "" {
+		var err error
+		if rootCAs, err = origin.LoadRootCAs(t.CAFile); err != nil {
+			return err
+		}
+	}
+	client.SetClientTLS(cert, rootCAs)
+	return nil
+}
+
+// reloadCertificates reloads the server certificate and the origin client
+// certificates on every SIGHUP until ctx is done.
+func (s *Server) reloadCertificates(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			if s.certs != nil {
+				s.certReloaded(s.certs.certFile, s.certs.load())
+			}
+			for _, c := range s.s3Certs {
+				s.certReloaded(c.CertFile(), c.Reload())
+			}
+		}
+	}
+}
+
+func (s *Server) certReloaded(certFile string, err error) {
+	if err != nil {
+		s.metrics.tlsReloads.WithLabelValues("error").Inc()
+		s.logger.Error("tls reload failed", "error", err, "cert", certFile)
+		return
+	}
+	s.metrics.tlsReloads.WithLabelValues("applied").Inc()
+	s.logger.Info("tls certificate reloaded", "cert", certFile)
+}
@@ -0,0 +1,184 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/joeychilson/s3-proxy/internal/config"
+)
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeCert := func(name string) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		tmpl := &x509.Certificate{
+			SerialNumber: big.NewInt(1),
+			Subject:      pkix.Name{CommonName: name},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		keyDER, _ := x509.MarshalECPrivateKey(key)
+		os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+		os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+	}
+	commonName := func(c *certReloader) string {
+		cert, _ := c.getCertificate(nil)
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return leaf.Subject.CommonName
+	}
+
+	if _, err := newCertReloader(certFile, keyFile); err == nil {
+		t.Fatalf("expected an error for missing certificate files")
+	}
+	writeCert("first")
+	certs, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	writeCert("second")
+	if err := certs.load(); err != nil || commonName(certs) != "second" {
+		t.Fatalf("expected the renewed certificate after a reload, got %q (%v)", commonName(certs), err)
+	}
+	os.WriteFile(keyFile, []byte("garbage"), 0o600)
+	if err := certs.load(); err == nil || commonName(certs) != "second" {
+		t.Fatalf("expected a failed reload to keep the previous certificate, got %q (%v)", commonName(certs), err)
+	}
+}
+
+func TestClientCertAuth(t *testing.T) {
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.pem")
+	if _, err := loadClientCAs(caFile); err == nil {
+		t.Fatalf("expected an error for a missing CA file")
+	}
+	os.WriteFile(caFile, []byte("not a certificate"), 0o600)
+	if _, err := loadClientCAs(caFile); err == nil {
+		t.Fatalf("expected an error for a CA file without certificates")
+	}
+
+	s := &Server{
+		cfg:     &config.Config{},
+		metrics: newMetrics(prometheus.NewRegistry()),
+		logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	handler := s.certAuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	attempt := func(state *tls.ConnectionState, token string) int {
+		req := httptest.NewRequest(http.MethodPost, "/cache/purge", nil)
+		req.TLS = state
+		if token != "" {
+			req.Header.Set("X-Auth-Token", token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	verified := &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{}}}}
+	if code := attempt(verified, ""); code != http.StatusOK {
+		t.Fatalf("expected a verified client certificate to be let in, got %d", code)
+	}
+	if code := attempt(&tls.ConnectionState{}, ""); code != http.StatusUnauthorized {
+		t.Fatalf("expected a connection without a certificate to be refused without AUTH_TOKEN, got %d", code)
+	}
+	if code := attempt(nil, "anything"); code != http.StatusUnauthorized {
+		t.Fatalf("expected tokens to be refused when AUTH_TOKEN is unset, got %d", code)
+	}
+	s.authTok = "secret"
+	if code := attempt(nil, "secret"); code != http.StatusOK {
+		t.Fatalf("expected the token to keep working alongside certificates, got %d", code)
+	}
+}
+
+func TestClientCertRoutes(t *testing.T) {
+	bucket := newFakeBucket(t)
+	bucket.put("logo.png", "png")
+	for name, value := range map[string]string{
+		"S3_ENDPOINT":            bucket.URL,
+		"S3_BUCKET":              "bucket",
+		"S3_ACCESS_KEY":          "key",
+		"S3_SECRET_KEY":          "secret",
+		"AUTH_TOKEN":             "admin",
+		"WRITE_THROUGH":          "true",
+		"SIGNING_SECRET":         "signing",
+		"AUTH_LOCKOUT_THRESHOLD": "100",
+		"PEERS":                  "http://127.0.0.1:1",
+		"PEER_SELF_URL":          "http://127.0.0.1:2",
+	} {
+		t.Setenv(name, value)
+	}
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s, err := New(t.Context(), cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s.logger = slog.New(slog.DiscardHandler)
+	verified := &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{}}}}
+	attempt := func(method, path string) int {
+		var body io.Reader
+		if method == http.MethodPost || method == http.MethodPut {
+			body = strings.NewReader("{}")
+		}
+		req := httptest.NewRequest(method, path, body)
+		req.TLS = verified
+		rec := httptest.NewRecorder()
+		s.httpSrv.Handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	for _, route := range []string{"GET /metrics", "POST /cache/purge"} {
+		method, path, _ := strings.Cut(route, " ")
+		if code := attempt(method, path); code == http.StatusUnauthorized {
+			t.Errorf("%s: expected a client certificate to be let in", route)
+		}
+	}
+	// Everything else still takes the token.
+	for _, route := range []string{
+		"PUT /logo.png",
+		"DELETE /logo.png",
+		"POST /cache/prefetch",
+		"GET /admin/config/schema",
+		"POST /admin/credentials",
+		"POST /admin/sign",
+		"GET /admin/lockouts",
+		"POST /admin/unban",
+		"GET /_tar/",
+		"GET /_peer/objects/logo.png",
+		"POST /_peer/purge",
+		"POST /_peer/gossip",
+	} {
+		method, path, _ := strings.Cut(route, " ")
+		if code := attempt(method, path); code != http.StatusUnauthorized {
+			t.Errorf("%s: expected a client certificate alone to be refused, got %d", route, code)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"context"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type traceIDKey struct{}
+
+// traceMiddleware records the trace ID of sampled requests that carry a W3C
+// traceparent header, so latency samples can point at the trace as an
+// exemplar and request logs can be joined with it.
+func (s *Server) traceMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if traceID := parseTraceparent(r.Header.Get("traceparent")); traceID != "" {
+			r = r.WithContext(context.WithValue(r.Context(), traceIDKey{}, traceID))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func traceID(ctx context.Context) string {
+	id, _ := ctx.Value(traceIDKey{}).(string)
+	return id
+}
+
+// observe records v in h, attaching the request's trace ID as an exemplar
+// when there is one.
+func observe(ctx context.Context, h prometheus.Histogram, v float64) {
+	if id := traceID(ctx); id != "" {
+		if eo, ok := h.(prometheus.ExemplarObserver); ok {
+			eo.ObserveWithExemplar(v, prometheus.Labels{"trace_id": id})
+			return
+		}
+	}
+	h.Observe(v)
+}
+
+// parseTraceparent returns the trace ID of a version 00 traceparent header
+// whose sampled flag is set, and "" otherwise.
+func parseTraceparent(value string) string {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || parts[0] != "00" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return ""
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil || flags[0]&0x01 == 0 || !isHexID(parts[1]) || !isHexID(parts[2]) {
+		return ""
+	}
+	return parts[1]
+}
+
+// isHexID reports whether id is lowercase hex and not all zeros, which the
+// trace context spec treats as invalid.
+func isHexID(id string) bool {
+	zero := true
+	for _, c := range id {
+		switch {
+		case c == '0':
+		case c >= '1' && c <= '9', c >= 'a' && c <= 'f':
+			zero = false
+		default:
+			return false
+		}
+	}
+	return !zero
+}
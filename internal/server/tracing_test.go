@@ -0,0 +1,26 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	const id = "4bf92f3577b34da6a3ce929d0e0e4736"
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"00-" + id + "-00f067aa0ba902b7-01", id},
+		{"00-" + id + "-00f067aa0ba902b7-00", ""},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", ""},
+		{"00-" + strings.ToUpper(id) + "-00f067aa0ba902b7-01", ""},
+		{"ff-" + id + "-00f067aa0ba902b7-01", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := parseTraceparent(tt.header); got != tt.want {
+			t.Fatalf("parseTraceparent(%q) = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
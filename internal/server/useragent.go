@@ -0,0 +1,97 @@
+package server
+
+import (
+	"cmp"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/joeychilson/s3-proxy/internal/config"
+)
+
+// userAgentRules decides which user agents may read which keys. Deny
+// patterns apply everywhere; allow patterns restrict the keys under their
+// prefix to matching agents, with the longest prefix taking precedence.
+// Patterns are globs matched case-insensitively.
+type userAgentRules struct {
+	deny  []*regexp.Regexp
+	allow []userAgentAllow
+}
+
+type userAgentAllow struct {
+	prefix   string
+	patterns []*regexp.Regexp
+}
+
+func newUserAgentRules(deny []string, allow []config.UserAgentRule) (*userAgentRules, error) {
+	u := &userAgentRules{}
+	for _, pattern := range deny {
+		re, err := compileGlob(strings.ToLower(pattern))
+		if err != nil {
+			return nil, err
+		}
+		u.deny = append(u.deny, re)
+	}
+	for _, rule := range allow {
+		re, err := compileGlob(strings.ToLower(rule.Pattern))
+		if err != nil {
+			return nil, err
+		}
+		i := slices.IndexFunc(u.allow, func(a userAgentAllow) bool { return a.prefix == rule.Prefix })
+		if i < 0 {
+			u.allow = append(u.allow, userAgentAllow{prefix: rule.Prefix})
+			i = len(u.allow) - 1
+		}
+		u.allow[i].patterns = append(u.allow[i].patterns, re)
+	}
+	slices.SortFunc(u.allow, func(a, b userAgentAllow) int {
+		return cmp.Compare(len(b.prefix), len(a.prefix))
+	})
+	return u, nil
+}
+
+// checkUserAgent answers 403 when the request's user agent may not read the
+// requested path. Paths are matched as the client sent them, before any
+// virtual host namespace is applied. Peer requests carry another replica's
+// agent and are not checked.
+func (s *Server) checkUserAgent(w http.ResponseWriter, r *http.Request) bool {
+	agents := s.currentRules().agents
+	if agents == nil || isPeerRequest(r.Context()) {
+		return true
+	}
+	rule := agents.blocked(strings.TrimPrefix(r.URL.Path, "/"), r.UserAgent())
+	if rule == "" {
+		return true
+	}
+	s.metrics.agentsBlocked.WithLabelValues(rule).Inc()
+	http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+	return false
+}
+
+// blocked returns the rule that rejects ua for key, "deny" or "allow", or ""
+// when the request may proceed.
+func (u *userAgentRules) blocked(key, ua string) string {
+	ua = strings.ToLower(ua)
+	if matchAny(u.deny, ua) {
+		return "deny"
+	}
+	for _, a := range u.allow {
+		if strings.HasPrefix(key, a.prefix) {
+			if matchAny(a.patterns, ua) {
+				return ""
+			}
+			return "allow"
+		}
+	}
+	return ""
+}
+
+func matchAny(patterns []*regexp.Regexp, s string) bool {
+	for _, re := range patterns {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
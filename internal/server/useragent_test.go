@@ -0,0 +1,33 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/joeychilson/s3-proxy/internal/config"
+)
+
+func TestUserAgentRules(t *testing.T) {
+	rules, err := newUserAgentRules([]string{"*AhrefsBot*"}, []config.UserAgentRule{
+		{Prefix: "internal/", Pattern: "my-service/*"},
+		{Prefix: "internal/", Pattern: "curl/*"},
+		{Prefix: "internal/public/", Pattern: "*"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tests := []struct {
+		key, ua, want string
+	}{
+		{"images/a.png", "Mozilla/5.0", ""},
+		{"images/a.png", "Mozilla/5.0 (compatible; ahrefsbot/7.0)", "deny"},
+		{"internal/report.csv", "My-Service/1.2", ""},
+		{"internal/report.csv", "curl/8.0", ""},
+		{"internal/report.csv", "Mozilla/5.0", "allow"},
+		{"internal/public/a.txt", "Mozilla/5.0", ""},
+	}
+	for _, tt := range tests {
+		if got := rules.blocked(tt.key, tt.ua); got != tt.want {
+			t.Fatalf("blocked(%q, %q) = %q, want %q", tt.key, tt.ua, got, tt.want)
+		}
+	}
+}
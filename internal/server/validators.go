@@ -0,0 +1,34 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/joeychilson/s3-proxy/internal/cache"
+)
+
+// checkValidators counts a cached copy replaced by a copy of the same object
+// version, going by Last-Modified, with a different ETag or length. Every
+// replica must describe a version the same way, or a CDN in front caches
+// and revalidates one copy per replica; a mismatch usually means origin
+// endpoints or replica buckets that disagree. source is "origin" or "peer".
+func (s *Server) checkValidators(source string, old, fresh *cache.Entry) {
+	if old == nil || fresh == nil || old == fresh || old.Status != http.StatusOK || fresh.Status != http.StatusOK {
+		return
+	}
+	if old.LastModified.IsZero() || !old.LastModified.Equal(fresh.LastModified) {
+		return
+	}
+	if old.ETag != fresh.ETag || old.Size != fresh.Size {
+		s.metrics.validatorMismatch.WithLabelValues(source).Inc()
+		s.logger.Warn("validators differ for the same object version", "source", source, "etag", old.ETag, "new_etag", fresh.ETag, "size", old.Size, "new_size", fresh.Size)
+	}
+}
+
+// setEncodingVary marks responses streamed from the origin as varying by
+// Accept-Encoding when the cached copy will be, so that a CDN in front keys
+// a miss the same way as the hits that follow, here or on another replica.
+func (s *Server) setEncodingVary(w http.ResponseWriter, status int, size int64, header http.Header) {
+	if s.compressibleResponse(status, size, header) {
+		addVary(w.Header(), "Accept-Encoding")
+	}
+}
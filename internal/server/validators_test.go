@@ -0,0 +1,77 @@
+package server
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/joeychilson/s3-proxy/internal/cache"
+	"github.com/joeychilson/s3-proxy/internal/config"
+	"github.com/joeychilson/s3-proxy/internal/origin"
+)
+
+func TestStableValidators(t *testing.T) {
+	body := []byte(strings.Repeat("hello ", 100))
+	newReplica := func() *Server {
+		store, err := cache.New(16, 1<<20, time.Minute, time.Minute)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return &Server{
+			cfg:     &config.Config{Compression: true, CompressionMinSize: 1},
+			cache:   store,
+			metrics: newMetrics(prometheus.NewRegistry()),
+			logger:  slog.New(slog.DiscardHandler),
+			layers:  []string{layerMemory},
+		}
+	}
+	header := http.Header{"Content-Type": {"text/plain"}, "Etag": {`"v1"`}, "Content-Length": {strconv.Itoa(len(body))}}
+	newObject := func() *origin.Object {
+		return &origin.Object{Body: io.NopCloser(strings.NewReader(string(body))), Headers: cloneHeader(header), StatusCode: http.StatusOK, ContentLength: int64(len(body)), ETag: `"v1"`}
+	}
+
+	// A miss on one replica and a hit on another must be keyed alike.
+	miss := httptest.NewRecorder()
+	a := newReplica()
+	a.streamAndStore(miss, httptest.NewRequest(http.MethodGet, "/a.txt", nil), "a.txt", newObject())
+	if miss.Header().Get("Vary") != "Accept-Encoding" {
+		t.Fatalf("expected a miss to vary by Accept-Encoding, got %v", miss.Header())
+	}
+
+	var responses []*httptest.ResponseRecorder
+	for range 2 {
+		s := newReplica()
+		s.cache.Set(cacheKey("a.txt"), s.newEntry("a.txt", newObject(), body, time.Now()))
+		entry, _ := s.cache.Get(cacheKey("a.txt"))
+		r := httptest.NewRequest(http.MethodGet, "/a.txt", nil)
+		r.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		s.writeCacheEntry(w, r, cacheKey("a.txt"), entry, time.Now(), "HIT")
+		responses = append(responses, w)
+	}
+	for _, name := range []string{"ETag", "Content-Length", "Vary"} {
+		if x, y := responses[0].Header().Get(name), responses[1].Header().Get(name); x == "" || x != y {
+			t.Fatalf("replicas disagree on %s: %q and %q", name, x, y)
+		}
+	}
+
+	lm := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	old := &cache.Entry{Status: http.StatusOK, ETag: `"v1"`, Size: 5, LastModified: lm}
+	a.checkValidators("peer", old, &cache.Entry{Status: http.StatusOK, ETag: `"v1"`, Size: 5, LastModified: lm})
+	a.checkValidators("origin", old, &cache.Entry{Status: http.StatusOK, ETag: `"v2"`, Size: 5, LastModified: lm.Add(time.Second)})
+	if n := testutil.CollectAndCount(a.metrics.validatorMismatch); n != 0 {
+		t.Fatalf("expected no mismatch, got %v", n)
+	}
+	a.checkValidators("peer", old, &cache.Entry{Status: http.StatusOK, ETag: `"other"`, Size: 5, LastModified: lm})
+	if n := testutil.ToFloat64(a.metrics.validatorMismatch.WithLabelValues("peer")); n != 1 {
+		t.Fatalf("expected a mismatch from the peer, got %v", n)
+	}
+}
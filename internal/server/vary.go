@@ -0,0 +1,154 @@
+package server
+
+import (
+	"hash/fnv"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/joeychilson/s3-proxy/internal/config"
+)
+
+// requestCacheKey returns the cache key for the variant of key selected by
+// the request's CACHE_VARY_HEADERS and ?versionId=. Requests that send
+// neither share the plain object key.
+func (s *Server) requestCacheKey(key string, r *http.Request) string {
+	cKey := cacheKey(key)
+	if version := requestVersion(r); version != "" {
+		cKey += "#version-" + version
+	}
+	if len(s.cfg.VaryHeaders) == 0 {
+		return cKey
+	}
+	var b strings.Builder
+	for _, name := range s.cfg.VaryHeaders {
+		if value := normalizeVaryValue(r.Header.Values(name)); value != "" {
+			b.WriteString(strings.ToLower(name))
+			b.WriteByte('=')
+			b.WriteString(value)
+			b.WriteByte('\n')
+		}
+	}
+	if b.Len() == 0 {
+		return cKey
+	}
+	h := fnv.New64a()
+	h.Write([]byte(b.String()))
+	return cKey + "#vary-" + strconv.FormatUint(h.Sum64(), 16)
+}
+
+// keyedHeaders are the request headers CACHE_KEY_STRICT lets through by
+// default. They select a range, answer conditionals or pick an encoding of
+// the stored copy, and never change what is stored under a key.
+var keyedHeaders = []string{"Accept-Encoding", "If-Match", "If-Modified-Since", "If-None-Match", "If-Range", "If-Unmodified-Since", "Range", "TE"}
+
+// newKeyedHeaders returns the request headers an object request keeps under
+// CACHE_KEY_STRICT, or nil when the mode is off.
+func newKeyedHeaders(cfg *config.Config) map[string]bool {
+	if !cfg.CacheKeyStrict {
+		return nil
+	}
+	keyed := make(map[string]bool)
+	for _, names := range [][]string{keyedHeaders, cfg.VaryHeaders, cfg.CacheKeyHeaders} {
+		for _, name := range names {
+			keyed[http.CanonicalHeaderKey(name)] = true
+		}
+	}
+	return keyed
+}
+
+// strictRequest strips an object request down to what CACHE_KEY_STRICT
+// allows to reach the cache and the origin: the key, its ?versionId=, the
+// allowed headers and nothing else. Access checks and user-agent rules have
+// already run, so credentials and the rest of the query string are no longer
+// needed.
+func (s *Server) strictRequest(r *http.Request) *http.Request {
+	if s.keyed == nil {
+		return r
+	}
+	header := make(http.Header, len(s.keyed))
+	for name, values := range r.Header {
+		if s.keyed[name] {
+			header[name] = values
+		}
+	}
+	u := *r.URL
+	u.RawQuery = ""
+	if version := requestVersion(r); version != "" {
+		u.RawQuery = url.Values{"versionId": {version}}.Encode()
+	}
+	r = r.WithContext(r.Context())
+	r.URL = &u
+	r.Header = header
+	return r
+}
+
+// setVary advertises CACHE_VARY_HEADERS to downstream caches.
+func (s *Server) setVary(w http.ResponseWriter) {
+	if len(s.cfg.VaryHeaders) > 0 {
+		w.Header().Set("Vary", strings.Join(s.cfg.VaryHeaders, ", "))
+	}
+}
+
+// varyHeader returns the request headers that select a variant, so they can
+// be forwarded along with the request.
+func (s *Server) varyHeader(r *http.Request) http.Header {
+	header := http.Header{}
+	for _, name := range s.cfg.VaryHeaders {
+		if hopByHopHeaders[http.CanonicalHeaderKey(name)] {
+			continue
+		}
+		if values := r.Header.Values(name); len(values) > 0 {
+			header[http.CanonicalHeaderKey(name)] = slices.Clone(values)
+		}
+	}
+	return header
+}
+
+// varyKeyed reports whether every header named in the response's Vary is
+// part of the cache key. Responses that vary on anything else, or on "*",
+// cannot be cached safely.
+func (s *Server) varyKeyed(h http.Header) bool {
+	for _, value := range h.Values("Vary") {
+		for name := range strings.SplitSeq(value, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+			if name == "*" || !slices.ContainsFunc(s.cfg.VaryHeaders, func(v string) bool {
+				return strings.EqualFold(v, name)
+			}) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (s *Server) purgeVariants(cKey string) {
+	if len(s.cfg.VaryHeaders) == 0 {
+		return
+	}
+	for _, k := range s.cache.Keys() {
+		if strings.HasPrefix(k, cKey+"#vary-") {
+			s.cache.Delete(k)
+		}
+	}
+}
+
+// normalizeVaryValue canonicalizes a header so that equivalent requests such
+// as "gzip, br" and "br,gzip" select the same variant.
+func normalizeVaryValue(values []string) string {
+	var tokens []string
+	for _, value := range values {
+		for token := range strings.SplitSeq(value, ",") {
+			if token = strings.ToLower(strings.Join(strings.Fields(token), "")); token != "" {
+				tokens = append(tokens, token)
+			}
+		}
+	}
+	slices.Sort(tokens)
+	return strings.Join(slices.Compact(tokens), ",")
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/joeychilson/s3-proxy/internal/config"
+)
+
+func TestRequestCacheKeyVariants(t *testing.T) {
+	s := &Server{cfg: &config.Config{VaryHeaders: []string{"Accept-Encoding"}}}
+	req := func(ae string) *http.Request {
+		r, _ := http.NewRequest(http.MethodGet, "/a.css", nil)
+		if ae != "" {
+			r.Header.Set("Accept-Encoding", ae)
+		}
+		return r
+	}
+	if key := s.requestCacheKey("a.css", req("")); key != "a.css" {
+		t.Fatalf("expected plain key without vary headers, got %q", key)
+	}
+	gzip := s.requestCacheKey("a.css", req("gzip, br"))
+	if gzip == "a.css" || gzip != s.requestCacheKey("a.css", req("br,gzip")) {
+		t.Fatalf("expected equivalent Accept-Encoding values to share a variant, got %q", gzip)
+	}
+	if gzip == s.requestCacheKey("a.css", req("identity")) {
+		t.Fatalf("expected different Accept-Encoding values to use different variants")
+	}
+
+	h := http.Header{}
+	h.Set("Vary", "Accept-Encoding")
+	if !s.varyKeyed(h) {
+		t.Fatalf("expected Vary on a keyed header to be cacheable")
+	}
+	h.Set("Vary", "Accept-Encoding, Cookie")
+	if s.varyKeyed(h) {
+		t.Fatalf("expected Vary on an unkeyed header to be uncacheable")
+	}
+}
+
+func TestStrictRequest(t *testing.T) {
+	s := &Server{cfg: &config.Config{}}
+	req := httptest.NewRequest(http.MethodGet, "/file.txt?v=1", nil)
+	req.Header.Set("Cache-Control", "no-cache")
+	if s.strictRequest(req) != req {
+		t.Fatalf("expected requests to pass through unchanged without CACHE_KEY_STRICT")
+	}
+
+	s.keyed = newKeyedHeaders(&config.Config{CacheKeyStrict: true, VaryHeaders: []string{"x-device"}, CacheKeyHeaders: []string{"x-tenant"}})
+	req.Header.Set("Range", "bytes=0-9")
+	req.Header.Set("X-Device", "mobile")
+	req.Header.Set("X-Tenant", "acme")
+	req.Header.Set("Accept", "text/html")
+	req.Header.Set("X-Forwarded-Host", "evil.example")
+	got := s.strictRequest(req)
+	if got.URL.RawQuery != "" || got.URL.Path != "/file.txt" {
+		t.Fatalf("expected the query string to be dropped, got %s", got.URL)
+	}
+	for _, name := range []string{"Range", "X-Device", "X-Tenant"} {
+		if got.Header.Get(name) == "" {
+			t.Errorf("expected %s to be kept", name)
+		}
+	}
+	for _, name := range []string{"Cache-Control", "Accept", "X-Forwarded-Host"} {
+		if got.Header.Get(name) != "" {
+			t.Errorf("expected %s to be stripped", name)
+		}
+	}
+	if req.Header.Get("Accept") == "" || req.URL.RawQuery == "" {
+		t.Fatalf("expected the original request to be left alone")
+	}
+	if noCacheRequested(got) {
+		t.Fatalf("expected a stripped Cache-Control: no-cache to no longer bypass the cache")
+	}
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// requestVersion returns the object version named by ?versionId=.
+func requestVersion(r *http.Request) string {
+	return r.URL.Query().Get("versionId")
+}
+
+// serveVersion answers a request for a version of key other than the
+// current one. A version never changes once written, so a cached copy is
+// served without revalidation. The chunk, video, peer and background-fill
+// caches hold the current version only and are bypassed.
+func (s *Server) serveVersion(w http.ResponseWriter, r *http.Request, key, version string) {
+	now := time.Now()
+	useCache := shouldUseCache(r)
+	cKey := s.requestCacheKey(key, r)
+	if useCache || r.Method == http.MethodHead {
+		if entry, ok := s.cache.Get(cKey); ok {
+			s.metrics.cacheHits.Inc()
+			s.writeCacheEntry(w, r, cKey, entry, now, "HIT")
+			return
+		}
+	}
+
+	cond := buildConditional(r)
+	cond.VersionID = version
+	if r.Method == http.MethodGet {
+		cond.Range = r.Header.Get("Range")
+	}
+	obj, err := s.fetchRange(r.Context(), r, key, cond, r.Method)
+	if err != nil {
+		s.handleOriginError(w, r, err, nil, now, cKey)
+		return
+	}
+	if obj.Body != nil {
+		defer obj.Body.Close()
+	}
+	if useCache && r.Method == http.MethodGet && cond.Range == "" && s.cacheable(key, obj) {
+		if body := s.streamAndStore(w, r, key, obj); body != nil {
+			s.versions.Store(true)
+			s.cache.Set(cKey, s.newEntry(key, obj, body, now))
+			s.events.emit(eventFill, cKey, int64(len(body)), "miss")
+		}
+		return
+	}
+	s.streamObject(w, r, key, obj)
+}
+
+// purgeVersions removes the cached versions of the object cKey belongs to.
+// Keys are only scanned once a version has been cached.
+func (s *Server) purgeVersions(cKey string) {
+	if !s.versions.Load() {
+		return
+	}
+	for _, k := range s.cache.Keys() {
+		if strings.HasPrefix(k, cKey+"#version-") {
+			s.cache.Delete(k)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/joeychilson/s3-proxy/internal/config"
+)
+
+func TestVersionKeys(t *testing.T) {
+	s := &Server{cfg: &config.Config{}, keyed: map[string]bool{}}
+	r := httptest.NewRequest(http.MethodGet, "/a.txt?versionId=v1&x=1", nil)
+	if got := s.requestCacheKey("a.txt", r); got != "a.txt#version-v1" {
+		t.Fatalf("unexpected cache key %q", got)
+	}
+	if got := s.strictRequest(r).URL.RawQuery; got != "versionId=v1" {
+		t.Fatalf("expected only the version to survive strict mode, got %q", got)
+	}
+	if got := s.requestCacheKey("a.txt", httptest.NewRequest(http.MethodGet, "/a.txt", nil)); got != "a.txt" {
+		t.Fatalf("unexpected cache key %q", got)
+	}
+}
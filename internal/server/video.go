@@ -0,0 +1,206 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joeychilson/s3-proxy/internal/cache"
+	"github.com/joeychilson/s3-proxy/internal/origin"
+)
+
+var errRangeIgnored = errors.New("origin ignored range request")
+
+// objectSizeHeader records the full object size on cached partial entries.
+// It is stripped before responses are written.
+const objectSizeHeader = "X-Proxy-Object-Size"
+
+// serveVideoRange serves ranges inside the cached head/tail window of a video
+// (where the moov atom lives). It reports false when the request should be proxied.
+func (s *Server) serveVideoRange(w http.ResponseWriter, r *http.Request, key string) bool {
+	if s.cfg.VideoProbeBytes <= 0 || r.Method != http.MethodGet || !s.isVideoKey(key) || noCacheRequested(r) {
+		return false
+	}
+	rangeHeader := r.Header.Get("Range")
+	if rangeHeader == "" || hasPreconditions(r) {
+		return false
+	}
+
+	ctx := r.Context()
+	now := time.Now()
+	head, state, err := s.videoSegment(ctx, key, "head", 0, now)
+	if err != nil {
+		s.handleOriginError(w, r, err, nil, now, cacheKey(key))
+		return true
+	}
+	if !strongETag(head.ETag) || !ifRangeMatch(r, head.ETag, head.LastModified) {
+		return false
+	}
+	if s.writeNotModified(w, r, head, now, stateLayer(state), state) {
+		return true
+	}
+	total := segmentTotal(head)
+	start, end, ok := parseByteRange(rangeHeader, total)
+	if !ok {
+		return false
+	}
+
+	if end < int64(len(head.Body)) {
+		s.writeSegment(w, head, 0, start, end, total, now, state)
+		return true
+	}
+
+	tailStart := total - s.cfg.VideoProbeBytes
+	if tailStart <= int64(len(head.Body)) || start < tailStart {
+		return false
+	}
+	tail, state, err := s.videoSegment(ctx, key, "tail", tailStart, now)
+	if errors.Is(err, errRangeIgnored) {
+		return false
+	}
+	if err != nil {
+		s.handleOriginError(w, r, err, nil, now, cacheKey(key))
+		return true
+	}
+	if segmentTotal(tail) != total || !etagsEqual(tail.ETag, head.ETag, true) {
+		s.cache.Delete(videoSegmentKey(key, "head"))
+		s.cache.Delete(videoSegmentKey(key, "tail"))
+		return false
+	}
+	s.writeSegment(w, tail, tailStart, start, end, total, now, state)
+	return true
+}
+
+func (s *Server) videoSegment(ctx context.Context, key, part string, offset int64, now time.Time) (*cache.Entry, string, error) {
+	sKey := videoSegmentKey(key, part)
+	if entry, ok := s.cache.Get(sKey); ok && entry.Fresh(now) {
+		s.metrics.cacheHits.Inc()
+		return entry, "HIT", nil
+	}
+
+	cond := &origin.Conditional{Range: fmt.Sprintf("bytes=%d-%d", offset, offset+s.cfg.VideoProbeBytes-1)}
+	obj, err := s.fetchFromOrigin(ctx, key, cond, http.MethodGet)
+	if err != nil {
+		return nil, "", err
+	}
+	defer obj.Body.Close()
+	if offset > 0 && obj.StatusCode != http.StatusPartialContent {
+		return nil, "", errRangeIgnored
+	}
+
+	body, err := io.ReadAll(io.LimitReader(obj.Body, s.cfg.VideoProbeBytes))
+	if err != nil {
+		return nil, "", err
+	}
+
+	header := cloneHeader(obj.Headers)
+	header.Del("Content-Range")
+	header.Del("Content-Length")
+	header.Set(objectSizeHeader, strconv.FormatInt(objectTotal(obj), 10))
+
+	entry := &cache.Entry{
+		Body:         body,
+		Header:       header,
+		Status:       http.StatusPartialContent,
+		StoredAt:     now,
+		TTL:          s.cfg.VideoProbeTTL,
+		StaleTTL:     s.cfg.CacheStaleTTL,
+		Size:         int64(len(body)),
+		ETag:         obj.ETag,
+		LastModified: valueOrZero(obj.LastModified),
+	}
+	if !hasNoStore(obj.Headers) {
+		s.cache.Set(sKey, entry)
+	}
+	s.metrics.cacheMisses.Inc()
+	return entry, "MISS", nil
+}
+
+func (s *Server) writeSegment(w http.ResponseWriter, entry *cache.Entry, offset, start, end, total int64, now time.Time, state string) {
+	copyHeaders(w.Header(), entry.Header)
+	w.Header().Del(objectSizeHeader)
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, total))
+	w.Header().Set("Content-Length", strconv.FormatInt(end-start+1, 10))
+	w.Header().Set("Age", strconv.Itoa(entry.Age(now)))
+	s.setCacheStatus(w, stateLayer(state), state)
+	w.WriteHeader(http.StatusPartialContent)
+	w.Write(entry.Body[start-offset : end-offset+1])
+}
+
+func (s *Server) isVideoKey(key string) bool {
+	ext := strings.ToLower(path.Ext(key))
+	for _, candidate := range s.cfg.VideoExtensions {
+		if strings.EqualFold(candidate, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+func videoSegmentKey(key, part string) string {
+	return cacheKey(key) + "#video-" + part
+}
+
+func segmentTotal(entry *cache.Entry) int64 {
+	total, err := strconv.ParseInt(entry.Header.Get(objectSizeHeader), 10, 64)
+	if err != nil {
+		return int64(len(entry.Body))
+	}
+	return total
+}
+
+func objectTotal(obj *origin.Object) int64 {
+	if obj.ContentRange != "" {
+		if _, total, found := strings.Cut(obj.ContentRange, "/"); found {
+			if n, err := strconv.ParseInt(total, 10, 64); err == nil {
+				return n
+			}
+		}
+	}
+	return obj.ContentLength
+}
+
+// parseByteRange resolves a single "bytes=" range against an object of the
+// given size. Multi-range and unsatisfiable requests report false.
+func parseByteRange(header string, size int64) (start, end int64, ok bool) {
+	spec, found := strings.CutPrefix(strings.TrimSpace(header), "bytes=")
+	if !found || strings.Contains(spec, ",") || size <= 0 {
+		return 0, 0, false
+	}
+	first, last, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return 0, 0, false
+	}
+	if first == "" {
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 {
+			return 0, 0, false
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, size - 1, true
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return 0, 0, false
+	}
+	end = size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return 0, 0, false
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	return start, end, true
+}
@@ -0,0 +1,27 @@
+package server
+
+import (
+	"testing"
+)
+
+func TestParseByteRange(t *testing.T) {
+	cases := []struct {
+		header     string
+		start, end int64
+		ok         bool
+	}{
+		{"bytes=0-99", 0, 99, true},
+		{"bytes=900-", 900, 999, true},
+		{"bytes=-100", 900, 999, true},
+		{"bytes=990-2000", 990, 999, true},
+		{"bytes=1000-", 0, 0, false},
+		{"bytes=0-1,5-6", 0, 0, false},
+		{"items=0-1", 0, 0, false},
+	}
+	for _, tc := range cases {
+		start, end, ok := parseByteRange(tc.header, 1000)
+		if ok != tc.ok || start != tc.start || end != tc.end {
+			t.Fatalf("%s: got (%d, %d, %v)", tc.header, start, end, ok)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/joeychilson/s3-proxy/internal/config"
+	"github.com/joeychilson/s3-proxy/internal/cron"
+)
+
+func (s *Server) startWarmJobs(ctx context.Context) {
+	for _, job := range s.cfg.WarmJobs {
+		sched, err := cron.Parse(job.Schedule)
+		if err != nil {
+			s.logger.Error("warm job schedule", "error", err, "job", job.Name)
+			continue
+		}
+		go s.runWarmSchedule(ctx, job, sched)
+	}
+}
+
+func (s *Server) runWarmSchedule(ctx context.Context, job config.WarmJob, sched cron.Schedule) {
+	for {
+		next := sched.Next(time.Now())
+		if next.IsZero() {
+			return
+		}
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		s.runWarmJob(ctx, job)
+	}
+}
+
+func (s *Server) runWarmJob(ctx context.Context, job config.WarmJob) {
+	keys := append([]string(nil), job.Keys...)
+	if job.Prefix != "" {
+		objects, err := s.origin.ListObjects(ctx, job.Prefix)
+		if err != nil {
+			s.logger.Error("warm job list", "error", err, "job", job.Name, "prefix", job.Prefix)
+			return
+		}
+		for _, obj := range objects {
+			if !strings.HasSuffix(obj.Key, "/") && obj.Size <= s.cfg.MaxObjectSize {
+				keys = append(keys, obj.Key)
+			}
+		}
+	}
+
+	s.warmKeys(ctx, job.Name, keys)
+}
+
+// warmKeys fetches keys into the cache with up to PrefetchConcurrency
+// requests in flight and logs a summary under the given job name.
+func (s *Server) warmKeys(ctx context.Context, job string, keys []string) {
+	start := time.Now()
+	var (
+		mu     sync.Mutex
+		warmed int
+		failed int
+		bytes  int64
+		wg     sync.WaitGroup
+		sem    = make(chan struct{}, s.cfg.PrefetchConcurrency)
+	)
+	for _, key := range keys {
+		key = strings.TrimPrefix(key, "/")
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			size, err := s.warmKey(ctx, key, "warm", nil)
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case errors.Is(err, errNotCacheable):
+			case err != nil:
+				failed++
+				s.logger.Warn("warm job fetch", "error", err, "job", job, "key", key)
+			default:
+				warmed++
+				bytes += size
+			}
+		}()
+	}
+	wg.Wait()
+	s.logger.Info("warm job finished",
+		"job", job,
+		"keys", len(keys),
+		"warmed", warmed,
+		"failed", failed,
+		"bytes", bytes,
+		"duration", time.Since(start).String(),
+	)
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joeychilson/s3-proxy/internal/config"
+)
+
+func TestWarmJobs(t *testing.T) {
+	bucket := newFakeBucket(t)
+	bucket.put("hot/a.js", "a")
+	bucket.put("hot/b.css", "b")
+	bucket.put("hot/big.bin", strings.Repeat("x", 64))
+	bucket.put("cold/c.js", "c")
+	bucket.put("extra.html", "extra")
+	s := newBucketServer(t, bucket, &config.Config{MaxObjectSize: 32, PrefetchConcurrency: 2, WarmJobs: []config.WarmJob{
+		{Name: "hot", Schedule: "@every 20ms", Prefix: "hot/", Keys: []string{"/extra.html"}},
+		{Name: "broken", Schedule: "every day"},
+	}})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s.startWarmJobs(ctx)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for _, key := range []string{"hot/a.js", "hot/b.css", "extra.html"} {
+		for {
+			if _, ok := s.cache.Get(cacheKey(key)); ok {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("expected %s to be warmed", key)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	cancel()
+	// Objects outside the prefix, and listed ones too large to cache, are
+	// never fetched.
+	for _, key := range []string{"cold/c.js", "hot/big.bin"} {
+		if n := bucket.requests(key); n != 0 {
+			t.Fatalf("expected %s not to be fetched, got %d requests", key, n)
+		}
+	}
+}
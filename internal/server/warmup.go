@@ -0,0 +1,73 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/joeychilson/s3-proxy/internal/origin"
+)
+
+// manifestS3Prefix marks a CACHE_WARMUP_MANIFEST stored in the bucket rather
+// than on local disk, e.g. "s3:config/hot-keys.txt".
+const manifestS3Prefix = "s3:"
+
+// warmFromManifest prefetches the keys listed in CACHE_WARMUP_MANIFEST and
+// marks the server ready once it is done, whether or not it succeeded.
+func (s *Server) warmFromManifest(ctx context.Context) {
+	defer s.ready.Store(true)
+	keys, err := s.loadManifest(ctx, s.cfg.CacheWarmupManifest)
+	if err != nil {
+		s.logger.Error("cache warm-up manifest", "error", err, "manifest", s.cfg.CacheWarmupManifest)
+		return
+	}
+	s.warmKeys(ctx, "startup", keys)
+}
+
+func (s *Server) loadManifest(ctx context.Context, src string) ([]string, error) {
+	if key, ok := strings.CutPrefix(src, manifestS3Prefix); ok {
+		obj, err := s.origin.GetObject(ctx, strings.TrimPrefix(key, "/"), &origin.Conditional{})
+		if err != nil {
+			return nil, fmt.Errorf("fetch manifest: %w", err)
+		}
+		defer obj.Body.Close()
+		return parseManifest(obj.Body)
+	}
+	f, err := os.Open(src)
+	if err != nil {
+		return nil, fmt.Errorf("open manifest: %w", err)
+	}
+	defer f.Close()
+	return parseManifest(f)
+}
+
+// parseManifest reads one object key per line, ignoring blank lines and
+// lines starting with "#".
+func parseManifest(r io.Reader) ([]string, error) {
+	var keys []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		keys = append(keys, strings.TrimPrefix(line, "/"))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read manifest: %w", err)
+	}
+	return keys, nil
+}
+
+func (s *Server) readyHandler(w http.ResponseWriter, _ *http.Request) {
+	if !s.ready.Load() {
+		http.Error(w, "warming up", http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("ok"))
+}
@@ -0,0 +1,16 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseManifest(t *testing.T) {
+	keys, err := parseManifest(strings.NewReader("# hot assets\nlogo.png\n\n  /css/site.css  \n"))
+	if err != nil {
+		t.Fatalf("parse manifest: %v", err)
+	}
+	if len(keys) != 2 || keys[0] != "logo.png" || keys[1] != "css/site.css" {
+		t.Fatalf("unexpected keys: %v", keys)
+	}
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/joeychilson/s3-proxy/internal/origin"
+)
+
+// putHandler writes the request body through to the origin and drops the
+// cached copy of the key, here and on every peer. Large or chunked bodies go
+// up as multipart uploads, so memory use is bounded by the part size. Uploads
+// get UPLOAD_TIMEOUT instead of the server's read and write timeouts.
+// Artifacts must carry a SHA-256 checksum and are only ever created: writing
+// one that already exists fails with 409 Conflict.
+func (s *Server) putHandler(w http.ResponseWriter, r *http.Request) {
+	key, ok := s.requestKey(w, r)
+	if !ok {
+		return
+	}
+	in := &origin.PutInput{
+		Body:          r.Body,
+		ContentLength: r.ContentLength,
+		Headers:       cloneHeader(r.Header),
+		PartSize:      s.cfg.UploadPartSize,
+	}
+	if s.immutable(key) {
+		checksum, ok := artifactChecksum(r)
+		if !ok {
+			s.metrics.writes.WithLabelValues(r.Method, strconv.Itoa(http.StatusBadRequest)).Inc()
+			http.Error(w, "artifact uploads require x-amz-checksum-sha256", http.StatusBadRequest)
+			return
+		}
+		in.ChecksumSHA256 = checksum
+		in.IfNoneMatch = "*"
+	}
+
+	deadline := time.Now().Add(s.cfg.UploadTimeout)
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(deadline)
+	rc.SetWriteDeadline(deadline)
+	ctx, cancel := context.WithDeadline(r.Context(), deadline)
+	defer cancel()
+
+	etag, err := s.origin.PutObject(ctx, key, in)
+	if err != nil {
+		if errors.Is(err, origin.ErrPrecondition) && in.IfNoneMatch != "" {
+			err = errConflict
+		}
+		s.writeFailed(w, r, key, err)
+		return
+	}
+	s.invalidate(r.Context(), key)
+	s.metrics.writes.WithLabelValues(r.Method, strconv.Itoa(http.StatusCreated)).Inc()
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *Server) deleteHandler(w http.ResponseWriter, r *http.Request) {
+	key, ok := s.requestKey(w, r)
+	if !ok {
+		return
+	}
+	if s.immutable(key) {
+		s.writeFailed(w, r, key, errConflict)
+		return
+	}
+	if err := s.origin.DeleteObject(r.Context(), key); err != nil {
+		s.writeFailed(w, r, key, err)
+		return
+	}
+	s.invalidate(r.Context(), key)
+	s.metrics.writes.WithLabelValues(r.Method, strconv.Itoa(http.StatusNoContent)).Inc()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// errConflict rejects writes that would replace or remove an artifact.
+var errConflict = errors.New("artifact already exists")
+
+// invalidate purges a key that was just written, here, on every peer and
+// in the caches in front of the proxy.
+func (s *Server) invalidate(ctx context.Context, key string) {
+	s.purgeKey(key, "write")
+	if s.peers != nil {
+		s.broadcastPurge(ctx, purgeRequest{Keys: []string{key}})
+	}
+	if len(s.notify) > 0 {
+		if err := s.notifyPurge(ctx, purgeRequest{Keys: []string{key}}); err != nil {
+			s.logger.Warn("cdn purge failed", "error", err, "key", key)
+		}
+	}
+}
+
+func (s *Server) writeFailed(w http.ResponseWriter, r *http.Request, key string, err error) {
+	status := s.originFailureStatus()
+	var tooLarge *http.MaxBytesError
+	if s.cfg.OpaqueErrors && errors.Is(err, origin.ErrAccessDenied) {
+		s.logger.Warn("origin access denied", "error", err, "method", r.Method, "key", key)
+		err = origin.ErrNotFound
+	}
+	switch {
+	case errors.As(err, &tooLarge):
+		status = http.StatusRequestEntityTooLarge
+	case errors.Is(err, origin.ErrNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, origin.ErrPrecondition):
+		status = http.StatusPreconditionFailed
+	case errors.Is(err, errConflict):
+		status = http.StatusConflict
+	case errors.Is(err, origin.ErrChecksum):
+		status = http.StatusBadRequest
+	default:
+		s.metrics.originErrors.Inc()
+		s.logger.Error("origin write failed", "error", err, "method", r.Method, "key", key)
+	}
+	s.metrics.writes.WithLabelValues(r.Method, strconv.Itoa(status)).Inc()
+	http.Error(w, http.StatusText(status), status)
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/joeychilson/s3-proxy/internal/cache"
+	"github.com/joeychilson/s3-proxy/internal/config"
+)
+
+func TestWriteThrough(t *testing.T) {
+	bucket := newFakeBucket(t)
+	bucket.put("logo.png", "old")
+	s := newBucketServer(t, bucket, &config.Config{WriteThrough: true, UploadTimeout: 5 * time.Second})
+	s.authTok = "admin"
+	router := chi.NewRouter()
+	router.With(s.authMiddleware).Put("/*", s.putHandler)
+	router.With(s.authMiddleware).Delete("/*", s.deleteHandler)
+	write := func(method, body, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/logo.png", strings.NewReader(body))
+		req.Header.Set("Content-Type", "image/png")
+		if token != "" {
+			req.Header.Set("X-Auth-Token", token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	// fill caches the object along with a gzip variant and a video segment.
+	fill := func() {
+		if w := getObject(s, "/logo.png", nil); w.Code != http.StatusOK {
+			t.Fatalf("unexpected status %d", w.Code)
+		}
+		s.cache.Set(cacheKey("logo.png")+gzipVariantSuffix, &cache.Entry{Body: []byte("gz"), TTL: time.Minute, StoredAt: time.Now()})
+		s.cache.Set(videoSegmentKey("logo.png", "head"), &cache.Entry{Body: []byte("old"), TTL: time.Minute, StoredAt: time.Now()})
+	}
+	purged := func() bool {
+		for _, key := range []string{cacheKey("logo.png"), cacheKey("logo.png") + gzipVariantSuffix, videoSegmentKey("logo.png", "head")} {
+			if _, ok := s.cache.Get(key); ok {
+				return false
+			}
+		}
+		return true
+	}
+	fill()
+
+	// Writes without the token reach neither the origin nor the cache.
+	for _, method := range []string{http.MethodPut, http.MethodDelete} {
+		if w := write(method, "new", ""); w.Code != http.StatusUnauthorized {
+			t.Fatalf("%s: expected 401 without a token, got %d", method, w.Code)
+		}
+		if w := write(method, "new", "wrong"); w.Code != http.StatusUnauthorized {
+			t.Fatalf("%s: expected 401 for a wrong token, got %d", method, w.Code)
+		}
+	}
+	if w := getObject(s, "/logo.png", nil); w.Body.String() != "old" || w.Header().Get("X-Cache") != "HIT" || purged() {
+		t.Fatalf("expected the cached object to survive refused writes, got %q %q", w.Body.String(), w.Header().Get("X-Cache"))
+	}
+
+	// A PUT replaces the object at the origin and purges every cached form.
+	w := write(http.MethodPut, "new", "admin")
+	if w.Code != http.StatusCreated || w.Header().Get("ETag") != `"logo.png-3"` {
+		t.Fatalf("unexpected response %d %q", w.Code, w.Header().Get("ETag"))
+	}
+	bucket.mu.Lock()
+	obj := bucket.objects["logo.png"]
+	bucket.mu.Unlock()
+	if obj.body != "new" || obj.header.Get("Content-Type") != "image/png" {
+		t.Fatalf("expected the upload at the origin, got %q %q", obj.body, obj.header.Get("Content-Type"))
+	}
+	if !purged() {
+		t.Fatalf("expected the cached key and its variants to be purged")
+	}
+	if w := getObject(s, "/logo.png", nil); w.Body.String() != "new" || w.Header().Get("X-Cache") != "MISS" {
+		t.Fatalf("expected the new object from the origin, got %q %q", w.Body.String(), w.Header().Get("X-Cache"))
+	}
+
+	// A DELETE removes it at the origin and from the cache.
+	fill()
+	if w := write(http.MethodDelete, "", "admin"); w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", w.Code)
+	}
+	bucket.mu.Lock()
+	_, found := bucket.objects["logo.png"]
+	bucket.mu.Unlock()
+	if found || !purged() {
+		t.Fatalf("expected the object deleted at the origin (%v) and purged (%v)", !found, purged())
+	}
+	if w := getObject(s, "/logo.png", nil); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 after the delete, got %d", w.Code)
+	}
+}